@@ -0,0 +1,158 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/manucher051299/crud/cmd/app/middleware"
+	"github.com/manucher051299/crud/pkg/customers"
+)
+
+// addressesErrWriter maps errors of addresses and preferences to http statuses
+func addressesErrWriter(w http.ResponseWriter, err error) {
+	switch err {
+	case customers.ErrNotFound:
+		errWriter(w, http.StatusNotFound, err)
+	case customers.ErrInvalidAddress:
+		errWriter(w, http.StatusBadRequest, err)
+	default:
+		errWriter(w, http.StatusInternalServerError, err)
+	}
+}
+
+func (s *Server) handleCustomerGetAddresses(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.customersSvc.Addresses(r.Context(), id)
+	if err != nil {
+		addressesErrWriter(w, err)
+		return
+	}
+
+	resJson(w, items)
+}
+
+func (s *Server) handleCustomerSaveAddress(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	address := &customers.Address{}
+	err = json.NewDecoder(r.Body).Decode(&address)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// POST creates, PUT /{id} updates
+	address.ID = 0
+	if value, ok := mux.Vars(r)["id"]; ok {
+		address.ID, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			errWriter(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	item, err := s.customersSvc.SaveAddress(r.Context(), id, address)
+	if err != nil {
+		addressesErrWriter(w, err)
+		return
+	}
+
+	resJson(w, item)
+}
+
+func (s *Server) handleCustomerRemoveAddress(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	addressID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.customersSvc.RemoveAddress(r.Context(), id, addressID)
+	if err != nil {
+		addressesErrWriter(w, err)
+		return
+	}
+
+	resJson(w, map[string]interface{}{"status": "ok"})
+}
+
+func (s *Server) handleCustomerGetPreferences(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	item, err := s.customersSvc.Preferences(r.Context(), id)
+	if err != nil {
+		addressesErrWriter(w, err)
+		return
+	}
+
+	resJson(w, item)
+}
+
+func (s *Server) handleCustomerSavePreferences(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	preferences := &customers.Preferences{}
+	err = json.NewDecoder(r.Body).Decode(&preferences)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err := s.customersSvc.SavePreferences(r.Context(), id, preferences)
+	if err != nil {
+		addressesErrWriter(w, err)
+		return
+	}
+
+	resJson(w, item)
+}
@@ -0,0 +1,242 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/manucher051299/crud/cmd/app/middleware"
+	"github.com/manucher051299/crud/pkg/managers"
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+// crmErrWriter maps errors of notes, tags and segments to http statuses
+func crmErrWriter(w http.ResponseWriter, err error) {
+	switch err {
+	case managers.ErrNotFound:
+		errWriter(w, http.StatusNotFound, err)
+	case managers.ErrInvalidNote, managers.ErrInvalidTag, managers.ErrInvalidSegment, money.ErrInvalidCurrency:
+		errWriter(w, http.StatusBadRequest, err)
+	default:
+		errWriter(w, http.StatusInternalServerError, err)
+	}
+}
+
+type noteRequest struct {
+	Text string `json:"text"`
+}
+
+// customerColumns - columns of customers spreadsheet, spent is in major units
+var customerColumns = []string{"id", "name", "phone", "spent", "currency", "last_purchase", "tags", "created"}
+
+func (s *Server) handleManagerGetNotes(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	customerID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	items, err := s.managerSvc.CustomerNotes(r.Context(), customerID)
+	if err != nil {
+		crmErrWriter(w, err)
+		return
+	}
+
+	resJson(w, items)
+}
+
+func (s *Server) handleManagerAddNote(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	customerID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	request := &noteRequest{}
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err := s.managerSvc.AddCustomerNote(r.Context(), id, customerID, request.Text)
+	if err != nil {
+		crmErrWriter(w, err)
+		return
+	}
+
+	resJson(w, item)
+}
+
+func (s *Server) handleManagerRemoveNote(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	customerID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	noteID, err := strconv.ParseInt(mux.Vars(r)["noteID"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.managerSvc.RemoveCustomerNote(r.Context(), id, customerID, noteID)
+	if err != nil {
+		crmErrWriter(w, err)
+		return
+	}
+
+	resJson(w, map[string]interface{}{"status": "ok"})
+}
+
+func (s *Server) handleManagerSetTags(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	customerID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tags := make([]string, 0)
+	err = json.NewDecoder(r.Body).Decode(&tags)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tags, err = s.managerSvc.SetCustomerTags(r.Context(), customerID, tags)
+	if err != nil {
+		crmErrWriter(w, err)
+		return
+	}
+
+	resJson(w, tags)
+}
+
+func (s *Server) handleManagerGetSegments(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.managerSvc.Segments(r.Context())
+	if err != nil {
+		crmErrWriter(w, err)
+		return
+	}
+
+	resJson(w, items)
+}
+
+func (s *Server) handleManagerSaveSegment(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	segment := &managers.Segment{}
+	err = json.NewDecoder(r.Body).Decode(&segment)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	segment.ManagerID = id
+
+	segment, err = s.managerSvc.SaveSegment(r.Context(), segment)
+	if err != nil {
+		crmErrWriter(w, err)
+		return
+	}
+
+	resJson(w, segment)
+}
+
+func (s *Server) handleManagerRemoveSegment(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	segmentID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.managerSvc.RemoveSegment(r.Context(), segmentID)
+	if err != nil {
+		crmErrWriter(w, err)
+		return
+	}
+
+	resJson(w, map[string]interface{}{"status": "ok"})
+}
@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/manucher051299/crud/cmd/app/middleware"
 	"github.com/manucher051299/crud/pkg/customers"
 	"golang.org/x/crypto/bcrypt"
@@ -28,6 +31,10 @@ func (s *Server) handleCustomerRegistration(w http.ResponseWriter, r *http.Reque
 	item.Password = string(hashed)
 
 	saved, err := s.customersSvc.Register(r.Context(), item)
+	if err == customers.ErrInvalidPhone {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
 	if err != nil {
 		errWriter(w, http.StatusInternalServerError, err)
 		return
@@ -71,7 +78,44 @@ func (s *Server) handleCustomerGetPurchases(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	items, err := s.customersSvc.Purchases(r.Context(), id)
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	filter := customers.PurchasesFilter{}
+	query := r.URL.Query()
+	if value := query.Get("from"); value != "" {
+		filter.From, err = time.Parse(dateLayout, value)
+		if err != nil {
+			errWriter(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		filter.To, err = time.Parse(dateLayout, value)
+		if err != nil {
+			errWriter(w, http.StatusBadRequest, err)
+			return
+		}
+		filter.To = filter.To.AddDate(0, 0, 1)
+	}
+	if value := query.Get("limit"); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil {
+			errWriter(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		filter.Offset, err = strconv.Atoi(value)
+		if err != nil {
+			errWriter(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	items, err := s.customersSvc.Purchases(r.Context(), id, filter)
 
 	if err != nil {
 		log.Print(err)
@@ -82,3 +126,34 @@ func (s *Server) handleCustomerGetPurchases(w http.ResponseWriter, r *http.Reque
 	resJson(w, items)
 
 }
+
+func (s *Server) handleCustomerGetPurchase(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	saleID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err := s.customersSvc.Purchase(r.Context(), id, saleID)
+	if err == customers.ErrNotFound {
+		errWriter(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resJson(w, item)
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/manucher051299/crud/pkg/export"
+)
+
+// formatJSON - lists and reports are json unless a spreadsheet is asked for
+const formatJSON = "json"
+
+// exportFormat reads ?format=json|csv|xlsx, without it the Accept header is used
+func exportFormat(r *http.Request) (string, error) {
+	switch value := r.URL.Query().Get("format"); value {
+	case formatJSON, export.CSV, export.XLSX:
+		return value, nil
+	case "":
+	default:
+		return "", errUnknownFormat
+	}
+
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, export.XLSXContentType):
+		return export.XLSX, nil
+	case strings.Contains(accept, export.CSVContentType):
+		return export.CSV, nil
+	}
+	return formatJSON, nil
+}
+
+// exportOptions reads ?decimal=comma|dot and ?delimiter=comma|semicolon|tab.
+// Decimal comma goes with semicolon delimiter unless the delimiter is given.
+func exportOptions(r *http.Request) (export.Options, error) {
+	options := export.DefaultOptions
+
+	switch r.URL.Query().Get("decimal") {
+	case "", "dot":
+	case "comma":
+		options.Decimal = ","
+		options.Delimiter = ';'
+	default:
+		return options, errUnknownFormat
+	}
+
+	switch r.URL.Query().Get("delimiter") {
+	case "":
+	case "comma":
+		options.Delimiter = ','
+	case "semicolon":
+		options.Delimiter = ';'
+	case "tab":
+		options.Delimiter = '\t'
+	default:
+		return options, errUnknownFormat
+	}
+	if options.Delimiter == ',' && options.Decimal == "," {
+		return options, errUnknownFormat
+	}
+	return options, nil
+}
+
+// exportStream writes spreadsheet rows straight into the response.
+// Headers are sent with the first row, so errors that come before it still get a proper status.
+type exportStream struct {
+	w       http.ResponseWriter
+	format  string
+	name    string
+	columns []string
+	options export.Options
+	writer  export.Writer
+}
+
+// newExportStream checks format options, on error it writes the response itself and returns false
+func newExportStream(w http.ResponseWriter, r *http.Request, format string, name string, columns []string) (*exportStream, bool) {
+	options, err := exportOptions(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	return &exportStream{w: w, format: format, name: name, columns: columns, options: options}, true
+}
+
+func (e *exportStream) start() error {
+	if e.writer != nil {
+		return nil
+	}
+	e.w.Header().Set("Content-Type", export.ContentType(e.format))
+	e.w.Header().Set("Content-Disposition", "attachment; filename=\""+e.name+"."+e.format+"\"")
+
+	writer, err := export.NewWriter(e.w, e.format, e.columns, e.options)
+	if err != nil {
+		return err
+	}
+	e.writer = writer
+	return nil
+}
+
+// Write ...
+func (e *exportStream) Write(row ...interface{}) error {
+	err := e.start()
+	if err != nil {
+		return err
+	}
+	return e.writer.Write(row...)
+}
+
+// finish completes the file. Error of producing rows is written with errWriterFn while nothing is sent yet,
+// later it can only be logged and the file is left incomplete.
+func (e *exportStream) finish(err error, errWriterFn func(http.ResponseWriter, error)) {
+	if err != nil && e.writer == nil {
+		errWriterFn(e.w, err)
+		return
+	}
+	if err == nil {
+		err = e.start()
+	}
+	if err == nil {
+		err = e.writer.Close()
+	}
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// internalErrWriter ...
+func internalErrWriter(w http.ResponseWriter, err error) {
+	errWriter(w, http.StatusInternalServerError, err)
+}
+
+// writeExport writes rows that are already loaded, row returns cells of the i-th of n items
+func writeExport(w http.ResponseWriter, r *http.Request, format string, name string, columns []string, n int, row func(i int) []interface{}) {
+	stream, ok := newExportStream(w, r, format, name, columns)
+	if !ok {
+		return
+	}
+
+	var err error
+	for i := 0; i < n && err == nil; i++ {
+		err = stream.Write(row(i)...)
+	}
+	stream.finish(err, internalErrWriter)
+}
@@ -0,0 +1,150 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/manucher051299/crud/cmd/app/middleware"
+	"github.com/manucher051299/crud/pkg/giftcards"
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+// giftCardsErrWriter maps errors of the gift cards service to http statuses
+func giftCardsErrWriter(w http.ResponseWriter, err error) {
+	switch err {
+	case giftcards.ErrNotFound:
+		errWriter(w, http.StatusNotFound, err)
+	case giftcards.ErrInvalidAmount, giftcards.ErrCreditExceedsSale, money.ErrCurrencyMismatch:
+		errWriter(w, http.StatusBadRequest, err)
+	case giftcards.ErrCodeUsed:
+		errWriter(w, http.StatusConflict, err)
+	default:
+		errWriter(w, http.StatusInternalServerError, err)
+	}
+}
+
+func (s *Server) handleManagerIssueGiftCard(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	card := &giftcards.GiftCard{}
+	err = json.NewDecoder(r.Body).Decode(&card)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	card.ManagerID = id
+
+	card, err = s.giftCardsSvc.Issue(r.Context(), card)
+	if err != nil {
+		giftCardsErrWriter(w, err)
+		return
+	}
+
+	resJson(w, card)
+}
+
+func (s *Server) handleManagerGetGiftCard(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	card, err := s.giftCardsSvc.ByCode(r.Context(), mux.Vars(r)["code"])
+	if err != nil {
+		giftCardsErrWriter(w, err)
+		return
+	}
+
+	resJson(w, card)
+}
+
+func (s *Server) handleManagerGetLiabilities(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.giftCardsSvc.Liabilities(r.Context())
+	if err != nil {
+		giftCardsErrWriter(w, err)
+		return
+	}
+
+	resJson(w, items)
+}
+
+func (s *Server) handleManagerGetStoreCredit(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	customerID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	items, err := s.giftCardsSvc.StoreCredits(r.Context(), customerID)
+	if err != nil {
+		giftCardsErrWriter(w, err)
+		return
+	}
+
+	resJson(w, items)
+}
+
+func (s *Server) handleCustomerGetStoreCredit(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.giftCardsSvc.StoreCredits(r.Context(), id)
+	if err != nil {
+		giftCardsErrWriter(w, err)
+		return
+	}
+
+	resJson(w, items)
+}
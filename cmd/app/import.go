@@ -0,0 +1,102 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/manucher051299/crud/cmd/app/middleware"
+	"github.com/manucher051299/crud/pkg/export"
+	"github.com/manucher051299/crud/pkg/managers"
+)
+
+// maxImportSize - limit of uploaded catalog files
+const maxImportSize = 32 << 20
+
+// importFormat reads ?format=csv|jsonl, without it the Content-Type header is used
+func importFormat(r *http.Request) (string, error) {
+	switch value := r.URL.Query().Get("format"); value {
+	case export.CSV, "jsonl":
+		return value, nil
+	case "":
+	default:
+		return "", errUnknownFormat
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, export.CSVContentType):
+		return export.CSV, nil
+	case strings.Contains(contentType, "jsonl") || strings.Contains(contentType, "ndjson"):
+		return "jsonl", nil
+	}
+	return "", errUnknownFormat
+}
+
+// handleManagerImportProducts takes csv or json lines, ?dry_run=true only reports what would be done,
+// ?batch=N commits every N rows, by default all valid rows go in one transaction
+func (s *Server) handleManagerImportProducts(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	format, err := importFormat(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	options := managers.ImportOptions{DryRun: r.URL.Query().Get("dry_run") == "true"}
+	if value := r.URL.Query().Get("batch"); value != "" {
+		options.BatchSize, err = strconv.Atoi(value)
+		if err != nil || options.BatchSize < 0 {
+			errWriter(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	csvOptions, err := exportOptions(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+	var rows []*managers.ImportRow
+	if format == export.CSV {
+		rows, err = managers.ParseProductsCSV(body, csvOptions.Delimiter)
+	} else {
+		rows, err = managers.ParseProductsJSONLines(body)
+	}
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// cost is set only by admins, others have to leave the column out
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		for _, row := range rows {
+			for _, column := range row.Columns {
+				if column == "cost" {
+					errWriter(w, http.StatusForbidden, managers.ErrCostForbidden)
+					return
+				}
+			}
+		}
+	}
+
+	report, err := s.managerSvc.ImportProducts(r.Context(), rows, options)
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resJson(w, report)
+}
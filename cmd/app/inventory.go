@@ -0,0 +1,263 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/manucher051299/crud/cmd/app/middleware"
+	"github.com/manucher051299/crud/pkg/inventory"
+)
+
+var stockColumns = []string{"product_id", "sku", "name", "currency", "qty", "reorder_point", "price", "cost", "retail_value",
+	"cost_value", "sold", "velocity", "days_of_cover", "low"}
+
+// cost of the stock is seen only by admins
+var stockPublicColumns = []string{"product_id", "sku", "name", "currency", "qty", "reorder_point", "price", "retail_value",
+	"sold", "velocity", "days_of_cover", "low"}
+
+// stockValues - row of the item in stockColumns or stockPublicColumns order
+func stockValues(item *inventory.Item, isAdmin bool) []interface{} {
+	if isAdmin {
+		return []interface{}{item.ProductID, item.SKU, item.Name, item.Price.Currency, item.Qty, item.ReorderPoint, item.Price,
+			item.Cost, item.RetailValue, item.CostValue, item.Sold, item.Velocity, item.DaysOfCover, item.Low}
+	}
+	return []interface{}{item.ProductID, item.SKU, item.Name, item.Price.Currency, item.Qty, item.ReorderPoint, item.Price,
+		item.RetailValue, item.Sold, item.Velocity, item.DaysOfCover, item.Low}
+}
+
+// handleManagerGetStock - stock with value and days of cover, ?low=true leaves products at their reorder point
+func (s *Server) handleManagerGetStock(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	format, err := exportFormat(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	filter := inventory.Filter{Low: r.URL.Query().Get("low") == "true"}
+	isAdmin := s.managerSvc.IsAdmin(r.Context(), id)
+
+	if format != formatJSON {
+		name := "stock"
+		if filter.Low {
+			name = "low-stock"
+		}
+		columns := stockPublicColumns
+		if isAdmin {
+			columns = stockColumns
+		}
+		stream, ok := newExportStream(w, r, format, name, columns)
+		if !ok {
+			return
+		}
+		err = s.inventorySvc.EachItem(r.Context(), filter, func(item *inventory.Item) error {
+			return stream.Write(stockValues(item, isAdmin)...)
+		})
+		stream.finish(err, internalErrWriter)
+		return
+	}
+
+	items, err := s.inventorySvc.Items(r.Context(), filter)
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !isAdmin {
+		for _, item := range items {
+			item.HideCosts()
+		}
+	}
+
+	resJson(w, items)
+}
+
+func (s *Server) handleManagerGetStockValuation(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.inventorySvc.Valuation(r.Context())
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		for _, item := range items {
+			item.HideCosts()
+		}
+	}
+
+	resJson(w, items)
+}
+
+var stockSnapshotColumns = []string{"taken", "currency", "products", "units", "retail_value", "cost_value", "cost_coverage",
+	"low_stock"}
+
+var stockSnapshotPublicColumns = []string{"taken", "currency", "products", "units", "retail_value", "low_stock"}
+
+// handleManagerGetStockSnapshots - valuations written by the scheduled job within ?from and ?to
+func (s *Server) handleManagerGetStockSnapshots(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	format, err := exportFormat(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	from, to, err := parsePeriod(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	items, err := s.inventorySvc.Snapshots(r.Context(), from, to)
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+	isAdmin := s.managerSvc.IsAdmin(r.Context(), id)
+
+	if format != formatJSON {
+		columns := stockSnapshotPublicColumns
+		if isAdmin {
+			columns = stockSnapshotColumns
+		}
+		writeExport(w, r, format, "stock-snapshots", columns, len(items), func(i int) []interface{} {
+			item := items[i]
+			if isAdmin {
+				return []interface{}{item.Taken, item.Currency, item.Products, item.Units, item.RetailValue, item.CostValue,
+					item.CostCoverage, item.LowStock}
+			}
+			return []interface{}{item.Taken, item.Currency, item.Products, item.Units, item.RetailValue, item.LowStock}
+		})
+		return
+	}
+	if !isAdmin {
+		for _, item := range items {
+			item.HideCosts()
+		}
+	}
+
+	resJson(w, items)
+}
+
+var stockSnapshotItemColumns = append([]string{"taken"}, stockColumns...)
+
+var stockSnapshotItemPublicColumns = append([]string{"taken"}, stockPublicColumns...)
+
+// handleManagerGetStockSnapshotItems - stock of products taken by snapshots within ?from and ?to,
+// ?product=id leaves one product, ?low=true leaves products that were at their reorder point
+func (s *Server) handleManagerGetStockSnapshotItems(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	format, err := exportFormat(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	filter := inventory.SnapshotFilter{Low: r.URL.Query().Get("low") == "true"}
+	filter.From, filter.To, err = parsePeriod(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	if product := r.URL.Query().Get("product"); product != "" {
+		filter.ProductID, err = strconv.ParseInt(product, 10, 64)
+		if err != nil {
+			errWriter(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	isAdmin := s.managerSvc.IsAdmin(r.Context(), id)
+
+	if format != formatJSON {
+		columns := stockSnapshotItemPublicColumns
+		if isAdmin {
+			columns = stockSnapshotItemColumns
+		}
+		stream, ok := newExportStream(w, r, format, "stock-snapshot-items", columns)
+		if !ok {
+			return
+		}
+		err = s.inventorySvc.EachSnapshotItem(r.Context(), filter, func(item *inventory.Item) error {
+			return stream.Write(append([]interface{}{item.Taken}, stockValues(item, isAdmin)...)...)
+		})
+		stream.finish(err, internalErrWriter)
+		return
+	}
+
+	items, err := s.inventorySvc.SnapshotItems(r.Context(), filter)
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !isAdmin {
+		for _, item := range items {
+			item.HideCosts()
+		}
+	}
+
+	resJson(w, items)
+}
+
+// handleManagerTakeStockSnapshot writes a snapshot without waiting for the job, only admins can do it
+func (s *Server) handleManagerTakeStockSnapshot(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.inventorySvc.Snapshot(r.Context())
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resJson(w, items)
+}
@@ -0,0 +1,33 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/manucher051299/crud/cmd/app/middleware"
+)
+
+func (s *Server) handleCustomerGetLoyalty(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+
+	account, err := s.loyaltySvc.Account(r.Context(), id, limit, offset)
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resJson(w, account)
+}
@@ -2,16 +2,26 @@ package app
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/manucher051299/crud/cmd/app/middleware"
 	"github.com/manucher051299/crud/pkg/managers"
+	"github.com/manucher051299/crud/pkg/money"
+	"github.com/manucher051299/crud/pkg/taxes"
 )
 
 const ADMIN = "ADMIN"
 
+const dateLayout = "2006-01-02"
+
+var errInvalidPeriod = errors.New("invalid period")
+var errUnknownFormat = errors.New("unknown format")
+
 func (s *Server) handleManagerRegistration(w http.ResponseWriter, r *http.Request) {
 	id, err := middleware.Authentication(r.Context())
 
@@ -58,6 +68,10 @@ func (s *Server) handleManagerRegistration(w http.ResponseWriter, r *http.Reques
 	}
 
 	token, err := s.managerSvc.Create(r.Context(), item)
+	if err == managers.ErrInvalidPhone {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
 	if err != nil {
 		errWriter(w, http.StatusInternalServerError, err)
 		return
@@ -74,6 +88,10 @@ func (s *Server) handleManagerGetToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	token, err := s.managerSvc.Token(r.Context(), manager.Phone, manager.Password)
+	if err == managers.ErrInactive {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
 	if err != nil {
 		errWriter(w, http.StatusBadRequest, err)
 		return
@@ -102,11 +120,78 @@ func (s *Server) handleManagerChangeProducts(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	product, err = s.managerSvc.SaveProduct(r.Context(), product)
+	product, err = s.managerSvc.SaveProduct(r.Context(), id, product)
+	if err == taxes.ErrInvalidRate || err == money.ErrInvalidCurrency || err == managers.ErrInvalidCost || err == managers.ErrInvalidSKU ||
+		err == managers.ErrInvalidReorderPoint {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	if err == managers.ErrSKUUsed {
+		errWriter(w, http.StatusConflict, err)
+		return
+	}
+	if err == managers.ErrCostForbidden {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+	if err == managers.ErrNotFound {
+		errWriter(w, http.StatusNotFound, err)
+		return
+	}
 	if err != nil {
 		errWriter(w, http.StatusForbidden, err)
 		return
 	}
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		product.Cost = nil
+	}
+
+	resJson(w, product)
+}
+
+type stockRequest struct {
+	Qty int `json:"qty"`
+}
+
+func (s *Server) handleManagerReceiveStock(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	productID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	request := &stockRequest{}
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	product, err := s.managerSvc.ReceiveStock(r.Context(), id, productID, request.Qty)
+	if err == managers.ErrInvalidQty {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	if err == managers.ErrNotFound {
+		errWriter(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
 
 	resJson(w, product)
 }
@@ -125,22 +210,29 @@ func (s *Server) handleManagerMakeSales(w http.ResponseWriter, r *http.Request)
 	}
 
 	sale := &managers.Sale{}
-	sale.ManagerID = id
 	err = json.NewDecoder(r.Body).Decode(&sale)
 	if err != nil {
 		errWriter(w, http.StatusInternalServerError, err)
 		return
 	}
+	// sale is made by the signed in manager whatever the body says
+	sale.ManagerID = id
 
 	sale, err = s.managerSvc.MakeSale(r.Context(), sale)
-	if err != nil {
+	if err == managers.ErrInvalidPosition || err == managers.ErrMixedCurrency {
 		errWriter(w, http.StatusBadRequest, err)
 		return
 	}
+	if err != nil {
+		paymentsErrWriter(w, err)
+		return
+	}
 
 	resJson(w, sale)
 }
 
+var salesColumns = []string{"manager_id", "name", "currency", "total", "tax"}
+
 func (s *Server) handleManagerGetSales(w http.ResponseWriter, r *http.Request) {
 	id, err := middleware.Authentication(r.Context())
 
@@ -154,20 +246,242 @@ func (s *Server) handleManagerGetSales(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	total, err := s.managerSvc.GetSales(r.Context(), id)
+	format, err := exportFormat(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// bosses see their team, everyone else only their own sales
+	if r.URL.Query().Get("team") == "true" {
+		items, err := s.managerSvc.TeamSales(r.Context(), id)
+		if err != nil {
+			errWriter(w, http.StatusInternalServerError, err)
+			return
+		}
+		if format != formatJSON {
+			rows := make([][]interface{}, 0, len(items))
+			for _, item := range items {
+				for _, total := range item.Totals {
+					rows = append(rows, []interface{}{item.ManagerID, item.Name, total.Total.Currency, total.Total, total.Tax})
+				}
+			}
+			writeExport(w, r, format, "sales", salesColumns, len(rows), func(i int) []interface{} { return rows[i] })
+			return
+		}
+		resJson(w, map[string]interface{}{"manager_id": id, "team": items})
+		return
+	}
+
+	managerID, ok := s.scopedManagerID(w, r, id)
+	if !ok {
+		return
+	}
+
+	totals, err := s.managerSvc.GetSales(r.Context(), managerID)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	if format != formatJSON {
+		writeExport(w, r, format, "sales", salesColumns, len(totals), func(i int) []interface{} {
+			return []interface{}{managerID, "", totals[i].Total.Currency, totals[i].Total, totals[i].Tax}
+		})
+		return
+	}
+	resJson(w, map[string]interface{}{"manager_id": managerID, "totals": totals})
+}
+
+func (s *Server) handleManagerGetCategories(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.managerSvc.Categories(r.Context())
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	resJson(w, items)
+}
+
+func (s *Server) handleManagerChangeCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	category := &managers.Category{}
+	err = json.NewDecoder(r.Body).Decode(&category)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	category, err = s.managerSvc.SaveCategory(r.Context(), category)
+	if err == taxes.ErrInvalidRate {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	if err == managers.ErrNotFound {
+		errWriter(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resJson(w, category)
+}
+
+var taxReportColumns = []string{"tax_rate", "currency", "net", "tax", "total"}
+
+func (s *Server) handleManagerGetTaxReport(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	format, err := exportFormat(r)
 	if err != nil {
 		errWriter(w, http.StatusBadRequest, err)
 		return
 	}
-	resJson(w, map[string]interface{}{"manager_id": id, "total": total})
+	from, to, err := parsePeriod(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	items, err := s.managerSvc.TaxReport(r.Context(), from, to)
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+	if format != formatJSON {
+		writeExport(w, r, format, "taxes", taxReportColumns, len(items), func(i int) []interface{} {
+			item := items[i]
+			return []interface{}{item.TaxRate, item.Total.Currency, item.Net, item.Tax, item.Total}
+		})
+		return
+	}
+
+	resJson(w, items)
+}
+
+// parsePeriod reads ?from=2006-01-02&to=2006-01-02, "to" day is included.
+// Without parameters the current month is used.
+func parsePeriod(r *http.Request) (from time.Time, to time.Time, err error) {
+	now := time.Now()
+	from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to = from.AddDate(0, 1, 0)
+
+	if value := r.URL.Query().Get("from"); value != "" {
+		from, err = time.Parse(dateLayout, value)
+		if err != nil {
+			return
+		}
+	}
+	if value := r.URL.Query().Get("to"); value != "" {
+		to, err = time.Parse(dateLayout, value)
+		if err != nil {
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	if !from.Before(to) {
+		err = errInvalidPeriod
+	}
+	return
 }
 
+var productColumns = []string{"id", "sku", "name", "price", "currency", "qty", "category_id", "tax_rate", "tax_included", "cost",
+	"reorder_point"}
+
+// purchase cost is seen only by admins
+var productPublicColumns = []string{"id", "sku", "name", "price", "currency", "qty", "category_id", "tax_rate", "tax_included",
+	"reorder_point"}
+
 func (s *Server) handleManagerGetProducts(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	format, err := exportFormat(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	isAdmin := s.managerSvc.IsAdmin(r.Context(), id)
+
+	if format != formatJSON {
+		columns := productPublicColumns
+		if isAdmin {
+			columns = productColumns
+		}
+		stream, ok := newExportStream(w, r, format, "products", columns)
+		if !ok {
+			return
+		}
+		err = s.managerSvc.EachProduct(r.Context(), 0, func(item *managers.Product) error {
+			values := []interface{}{item.ID, item.SKU, item.Name, item.Price, item.Price.Currency, item.Qty, item.CategoryID,
+				item.TaxRate, item.TaxIncluded}
+			if isAdmin {
+				var cost *money.Money
+				if item.Cost != nil {
+					value := money.New(*item.Cost, item.Price.Currency)
+					cost = &value
+				}
+				values = append(values, cost)
+			}
+			return stream.Write(append(values, item.ReorderPoint)...)
+		})
+		stream.finish(err, internalErrWriter)
+		return
+	}
+
 	items, err := s.managerSvc.Products(r.Context())
 	if err != nil {
 		errWriter(w, http.StatusBadRequest, err)
 		return
 	}
+	if !isAdmin {
+		for _, item := range items {
+			item.Cost = nil
+		}
+	}
 
 	resJson(w, items)
 }
@@ -250,13 +564,93 @@ func (s *Server) handleManagerGetCustomers(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	items, err := s.managerSvc.Customers(r.Context())
+	format, err := exportFormat(r)
 	if err != nil {
 		errWriter(w, http.StatusBadRequest, err)
 		return
 	}
 
-	resJson(w, items)
+	filter := managers.CustomersFilter{Tag: r.URL.Query().Get("tag")}
+	if value := r.URL.Query().Get("segment"); value != "" {
+		filter.SegmentID, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			errWriter(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if format != formatJSON {
+		stream, ok := newExportStream(w, r, format, "customers", customerColumns)
+		if !ok {
+			return
+		}
+		err = s.managerSvc.EachCustomer(r.Context(), filter, func(item *managers.Customer) error {
+			return stream.Write(item.ID, item.Name, item.Phone, item.Spent, item.Spent.Currency, item.LastPurchase,
+				strings.Join(item.Tags, " "), item.Created)
+		})
+		stream.finish(err, crmErrWriter)
+		return
+	}
+
+	// export has every customer, json is paged
+	if value := r.URL.Query().Get("limit"); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil {
+			errWriter(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		filter.Offset, err = strconv.Atoi(value)
+		if err != nil {
+			errWriter(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	page, err := s.managerSvc.Customers(r.Context(), filter)
+	if err == managers.ErrNotFound {
+		errWriter(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	resJson(w, page)
+}
+
+func (s *Server) handleManagerGetCustomerByID(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	customerID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err := s.managerSvc.CustomerByID(r.Context(), customerID)
+	if err == managers.ErrNotFound {
+		errWriter(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resJson(w, item)
 }
 
 func (s *Server) handleManagerChangeCustomer(w http.ResponseWriter, r *http.Request) {
@@ -279,6 +673,10 @@ func (s *Server) handleManagerChangeCustomer(w http.ResponseWriter, r *http.Requ
 	}
 
 	customer, err = s.managerSvc.ChangeCustomer(r.Context(), customer)
+	if err == managers.ErrInvalidPhone {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
 	if err != nil {
 		errWriter(w, http.StatusBadRequest, err)
 		return
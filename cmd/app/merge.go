@@ -0,0 +1,89 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/manucher051299/crud/cmd/app/middleware"
+	"github.com/manucher051299/crud/pkg/customers"
+)
+
+type mergeRequest struct {
+	DuplicateID int64 `json:"duplicate_id"`
+}
+
+func (s *Server) handleManagerGetDuplicates(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil {
+			errWriter(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	items, err := s.customersSvc.Duplicates(r.Context(), limit)
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resJson(w, items)
+}
+
+func (s *Server) handleManagerMergeCustomers(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	survivorID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	request := &mergeRequest{}
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err := s.customersSvc.Merge(r.Context(), id, survivorID, request.DuplicateID)
+	if err == customers.ErrNotFound {
+		errWriter(w, http.StatusNotFound, err)
+		return
+	}
+	if err == customers.ErrInvalidMerge {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resJson(w, item)
+}
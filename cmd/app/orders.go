@@ -0,0 +1,335 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/manucher051299/crud/cmd/app/middleware"
+	"github.com/manucher051299/crud/pkg/managers"
+	"github.com/manucher051299/crud/pkg/orders"
+)
+
+type cartItemRequest struct {
+	ProductID int64 `json:"product_id"`
+	Qty       int   `json:"qty"`
+}
+
+type orderStatusRequest struct {
+	Status string `json:"status"`
+}
+
+// ordersErrWriter maps errors of the orders service to http statuses
+func ordersErrWriter(w http.ResponseWriter, err error) {
+	switch err {
+	case orders.ErrNotFound:
+		errWriter(w, http.StatusNotFound, err)
+	case orders.ErrInvalidQty, orders.ErrEmptyCart, orders.ErrMixedCurrency, orders.ErrInvalidTransition,
+		managers.ErrInvalidPosition, managers.ErrMixedCurrency:
+		errWriter(w, http.StatusBadRequest, err)
+	case orders.ErrProductUnavailable:
+		errWriter(w, http.StatusConflict, err)
+	default:
+		errWriter(w, http.StatusInternalServerError, err)
+	}
+}
+
+func (s *Server) handleCustomerGetCart(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	cart, err := s.ordersSvc.Cart(r.Context(), id)
+	if err != nil {
+		ordersErrWriter(w, err)
+		return
+	}
+
+	resJson(w, cart)
+}
+
+func (s *Server) handleCustomerAddToCart(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	item := &cartItemRequest{}
+	err = json.NewDecoder(r.Body).Decode(&item)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cart, err := s.ordersSvc.AddToCart(r.Context(), id, item.ProductID, item.Qty)
+	if err != nil {
+		ordersErrWriter(w, err)
+		return
+	}
+
+	resJson(w, cart)
+}
+
+func (s *Server) handleCustomerUpdateCart(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	productID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item := &cartItemRequest{}
+	err = json.NewDecoder(r.Body).Decode(&item)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cart, err := s.ordersSvc.UpdateCart(r.Context(), id, productID, item.Qty)
+	if err != nil {
+		ordersErrWriter(w, err)
+		return
+	}
+
+	resJson(w, cart)
+}
+
+func (s *Server) handleCustomerRemoveFromCart(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	productID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cart, err := s.ordersSvc.RemoveFromCart(r.Context(), id, productID)
+	if err != nil {
+		ordersErrWriter(w, err)
+		return
+	}
+
+	resJson(w, cart)
+}
+
+func (s *Server) handleCustomerCheckout(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	order, err := s.ordersSvc.Checkout(r.Context(), id)
+	if err != nil {
+		ordersErrWriter(w, err)
+		return
+	}
+
+	resJson(w, order)
+}
+
+func (s *Server) handleCustomerGetOrders(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.ordersSvc.Orders(r.Context(), id, r.URL.Query().Get("status"))
+	if err != nil {
+		ordersErrWriter(w, err)
+		return
+	}
+
+	resJson(w, items)
+}
+
+func (s *Server) handleCustomerGetOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	orderID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	order, err := s.ordersSvc.ByID(r.Context(), orderID, id)
+	if err != nil {
+		ordersErrWriter(w, err)
+		return
+	}
+
+	resJson(w, order)
+}
+
+func (s *Server) handleCustomerCancelOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	orderID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	order, err := s.ordersSvc.ChangeStatus(r.Context(), orderID, id, 0, orders.Cancelled)
+	if err != nil {
+		ordersErrWriter(w, err)
+		return
+	}
+
+	resJson(w, order)
+}
+
+func (s *Server) handleManagerGetOrders(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.ordersSvc.Orders(r.Context(), 0, r.URL.Query().Get("status"))
+	if err != nil {
+		ordersErrWriter(w, err)
+		return
+	}
+
+	resJson(w, items)
+}
+
+func (s *Server) handleManagerGetOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	orderID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	order, err := s.ordersSvc.ByID(r.Context(), orderID, 0)
+	if err != nil {
+		ordersErrWriter(w, err)
+		return
+	}
+
+	resJson(w, order)
+}
+
+func (s *Server) handleManagerChangeOrderStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	orderID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item := &orderStatusRequest{}
+	err = json.NewDecoder(r.Body).Decode(&item)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	order, err := s.ordersSvc.ChangeStatus(r.Context(), orderID, 0, id, item.Status)
+	if err != nil {
+		ordersErrWriter(w, err)
+		return
+	}
+
+	resJson(w, order)
+}
@@ -0,0 +1,160 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/manucher051299/crud/cmd/app/middleware"
+	"github.com/manucher051299/crud/pkg/giftcards"
+	"github.com/manucher051299/crud/pkg/loyalty"
+	"github.com/manucher051299/crud/pkg/money"
+	"github.com/manucher051299/crud/pkg/payments"
+)
+
+// paymentsErrWriter maps errors of taking payments (and tenders behind them) to http statuses
+func paymentsErrWriter(w http.ResponseWriter, err error) {
+	switch err {
+	case payments.ErrNotFound, giftcards.ErrNotFound:
+		errWriter(w, http.StatusNotFound, err)
+	case payments.ErrInvalidMethod, payments.ErrInvalidAmount, payments.ErrOverpayment, payments.ErrAlreadyPaid,
+		money.ErrCurrencyMismatch, loyalty.ErrInvalidAmount, loyalty.ErrInsufficientPoints, loyalty.ErrNoCustomer,
+		giftcards.ErrInvalidAmount, giftcards.ErrCardUnavailable, giftcards.ErrInsufficientBalance, giftcards.ErrNoCustomer:
+		errWriter(w, http.StatusBadRequest, err)
+	default:
+		errWriter(w, http.StatusInternalServerError, err)
+	}
+}
+
+func (s *Server) handleManagerMakePayments(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	saleID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var items []*payments.Payment
+	err = json.NewDecoder(r.Body).Decode(&items)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	summary, err := s.paymentsSvc.Pay(r.Context(), saleID, id, items)
+	if err != nil {
+		paymentsErrWriter(w, err)
+		return
+	}
+
+	resJson(w, summary)
+}
+
+func (s *Server) handleManagerGetPayments(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	saleID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.canSeeSale(w, r, id, saleID) {
+		return
+	}
+
+	summary, err := s.paymentsSvc.BySale(r.Context(), saleID)
+	if err == payments.ErrNotFound {
+		errWriter(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resJson(w, summary)
+}
+
+var cashReportColumns = []string{"manager_id", "currency", "sales", "cash_in", "change_out", "cash", "card", "transfer",
+	"store_credit", "points", "gift_card"}
+
+func (s *Server) handleManagerGetCashReport(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	format, err := exportFormat(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	day := time.Now()
+	if value := r.URL.Query().Get("date"); value != "" {
+		day, err = time.Parse(dateLayout, value)
+		if err != nil {
+			errWriter(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	// managers see their own drawer, bosses the drawers of their team, admins see everyone by default
+	managerID := id
+	if s.managerSvc.IsAdmin(r.Context(), id) && r.URL.Query().Get("manager_id") == "" {
+		managerID = 0
+	} else {
+		var ok bool
+		managerID, ok = s.scopedManagerID(w, r, id)
+		if !ok {
+			return
+		}
+	}
+
+	items, err := s.paymentsSvc.CashReport(r.Context(), day, managerID)
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+	if format != formatJSON {
+		writeExport(w, r, format, "cash", cashReportColumns, len(items), func(i int) []interface{} {
+			item := items[i]
+			return []interface{}{item.ManagerID, item.Currency, item.Sales, item.CashIn, item.ChangeOut, item.Cash, item.Card,
+				item.Transfer, item.StoreCredit, item.Points, item.GiftCard}
+		})
+		return
+	}
+
+	resJson(w, items)
+}
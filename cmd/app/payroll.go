@@ -0,0 +1,241 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/manucher051299/crud/cmd/app/middleware"
+	"github.com/manucher051299/crud/pkg/managers"
+	"github.com/manucher051299/crud/pkg/payroll"
+)
+
+// payrollErrWriter maps errors of commission rules and payroll to http statuses
+func payrollErrWriter(w http.ResponseWriter, err error) {
+	switch err {
+	case payroll.ErrNotFound, managers.ErrNotFound:
+		errWriter(w, http.StatusNotFound, err)
+	case payroll.ErrInvalidRule, managers.ErrInvalidPeriod:
+		errWriter(w, http.StatusBadRequest, err)
+	case payroll.ErrApproved:
+		errWriter(w, http.StatusConflict, err)
+	default:
+		errWriter(w, http.StatusInternalServerError, err)
+	}
+}
+
+type payrollRequest struct {
+	Start string `json:"start"`
+}
+
+func (s *Server) handleManagerGetCommissionRules(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.payrollSvc.Rules(r.Context())
+	if err != nil {
+		payrollErrWriter(w, err)
+		return
+	}
+
+	resJson(w, items)
+}
+
+func (s *Server) handleManagerSaveCommissionRule(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	rule := &payroll.Rule{}
+	err = json.NewDecoder(r.Body).Decode(rule)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rule, err = s.payrollSvc.SaveRule(r.Context(), rule)
+	if err != nil {
+		payrollErrWriter(w, err)
+		return
+	}
+
+	resJson(w, rule)
+}
+
+func (s *Server) handleManagerRemoveCommissionRule(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	ruleID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.payrollSvc.RemoveRule(r.Context(), ruleID)
+	if err != nil {
+		payrollErrWriter(w, err)
+		return
+	}
+
+	resJson(w, map[string]interface{}{"status": "ok"})
+}
+
+func (s *Server) handleManagerCalculatePayroll(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	request := &payrollRequest{}
+	err = json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	day := time.Now()
+	if request.Start != "" {
+		day, err = time.Parse(dateLayout, request.Start)
+		if err != nil {
+			errWriter(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	item, err := s.payrollSvc.Calculate(r.Context(), id, day)
+	if err != nil {
+		payrollErrWriter(w, err)
+		return
+	}
+
+	resJson(w, item)
+}
+
+func (s *Server) handleManagerGetPayrolls(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.payrollSvc.Runs(r.Context())
+	if err != nil {
+		payrollErrWriter(w, err)
+		return
+	}
+
+	resJson(w, items)
+}
+
+var payrollColumns = []string{"manager_id", "name", "currency", "salary", "sales", "returns", "percent", "commission", "total"}
+
+func (s *Server) handleManagerGetPayroll(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	runID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	format, err := exportFormat(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err := s.payrollSvc.Run(r.Context(), runID)
+	if err != nil {
+		payrollErrWriter(w, err)
+		return
+	}
+	if format != formatJSON {
+		name := "payroll-" + item.Start.Format("2006-01")
+		writeExport(w, r, format, name, payrollColumns, len(item.Lines), func(i int) []interface{} {
+			line := item.Lines[i]
+			return []interface{}{line.ManagerID, line.Name, line.Total.Currency, line.Salary, line.Sales, line.Returns,
+				line.Percent, line.Commission, line.Total}
+		})
+		return
+	}
+
+	resJson(w, item)
+}
+
+func (s *Server) handleManagerApprovePayroll(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	runID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err := s.payrollSvc.Approve(r.Context(), id, runID)
+	if err != nil {
+		payrollErrWriter(w, err)
+		return
+	}
+
+	resJson(w, item)
+}
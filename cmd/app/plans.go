@@ -0,0 +1,196 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/manucher051299/crud/cmd/app/middleware"
+	"github.com/manucher051299/crud/pkg/managers"
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+// plansErrWriter maps errors of sales plans to http statuses
+func plansErrWriter(w http.ResponseWriter, err error) {
+	switch err {
+	case managers.ErrNotFound:
+		errWriter(w, http.StatusNotFound, err)
+	case managers.ErrInvalidPeriod, managers.ErrInvalidPlan, money.ErrInvalidCurrency:
+		errWriter(w, http.StatusBadRequest, err)
+	default:
+		errWriter(w, http.StatusInternalServerError, err)
+	}
+}
+
+// parsePlanPeriod reads ?period=month|quarter and ?date=, defaults are the current month
+func parsePlanPeriod(r *http.Request) (period string, day time.Time, err error) {
+	period = r.URL.Query().Get("period")
+	if period == "" {
+		period = managers.Month
+	}
+	day = time.Now()
+	if value := r.URL.Query().Get("date"); value != "" {
+		day, err = time.Parse(dateLayout, value)
+	}
+	return period, day, err
+}
+
+func (s *Server) handleManagerSavePlan(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	var request struct {
+		managers.Plan
+		Start string `json:"start"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	plan := &request.Plan
+	plan.Start = time.Now()
+	if request.Start != "" {
+		plan.Start, err = time.Parse(dateLayout, request.Start)
+		if err != nil {
+			errWriter(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	plan, err = s.managerSvc.SavePlan(r.Context(), plan)
+	if err != nil {
+		plansErrWriter(w, err)
+		return
+	}
+
+	resJson(w, plan)
+}
+
+func (s *Server) handleManagerGetPlans(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	period, day, err := parsePlanPeriod(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	items, err := s.managerSvc.Plans(r.Context(), id, period, day)
+	if err != nil {
+		plansErrWriter(w, err)
+		return
+	}
+
+	resJson(w, items)
+}
+
+func (s *Server) handleManagerGetProgress(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	period, day, err := parsePlanPeriod(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if departament := r.URL.Query().Get("departament"); departament != "" {
+		if !s.managerSvc.IsAdmin(r.Context(), id) {
+			errWriter(w, http.StatusForbidden, err)
+			return
+		}
+		item, err := s.managerSvc.DepartamentProgress(r.Context(), departament, period, day)
+		if err != nil {
+			plansErrWriter(w, err)
+			return
+		}
+		resJson(w, item)
+		return
+	}
+
+	managerID, ok := s.scopedManagerID(w, r, id)
+	if !ok {
+		return
+	}
+
+	item, err := s.managerSvc.Progress(r.Context(), managerID, period, day)
+	if err != nil {
+		plansErrWriter(w, err)
+		return
+	}
+
+	resJson(w, item)
+}
+
+var leaderboardColumns = []string{"rank", "manager_id", "name", "departament", "currency", "target", "achieved", "percent",
+	"projection"}
+
+func (s *Server) handleManagerGetLeaderboard(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	format, err := exportFormat(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	period, day, err := parsePlanPeriod(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	items, err := s.managerSvc.Leaderboard(r.Context(), id, period, day)
+	if err != nil {
+		plansErrWriter(w, err)
+		return
+	}
+	if format != formatJSON {
+		writeExport(w, r, format, "leaderboard", leaderboardColumns, len(items), func(i int) []interface{} {
+			item := items[i]
+			return []interface{}{item.Rank, item.ManagerID, item.Name, item.Departament, item.Target.Currency, item.Target,
+				item.Achieved, item.Percent, item.Projection}
+		})
+		return
+	}
+
+	resJson(w, items)
+}
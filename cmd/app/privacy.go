@@ -0,0 +1,98 @@
+package app
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/manucher051299/crud/cmd/app/middleware"
+	"github.com/manucher051299/crud/pkg/customers"
+)
+
+func (s *Server) handleManagerExportCustomer(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	customerID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	export, err := s.customersSvc.Export(r.Context(), customerID)
+	if err == customers.ErrNotFound {
+		errWriter(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		resJson(w, export)
+	case "zip":
+		buffer := &bytes.Buffer{}
+		err = customers.WriteZip(buffer, export)
+		if err != nil {
+			errWriter(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"customer-"+strconv.FormatInt(customerID, 10)+".zip\"")
+		_, err = w.Write(buffer.Bytes())
+		if err != nil {
+			log.Print(err)
+		}
+	default:
+		errWriter(w, http.StatusBadRequest, errUnknownFormat)
+	}
+}
+
+func (s *Server) handleManagerEraseCustomer(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	customerID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.customersSvc.Erase(r.Context(), customerID)
+	if err == customers.ErrNotFound {
+		errWriter(w, http.StatusNotFound, err)
+		return
+	}
+	if err == customers.ErrOpenOrders {
+		errWriter(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resJson(w, map[string]interface{}{"status": "ok"})
+}
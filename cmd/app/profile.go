@@ -0,0 +1,199 @@
+package app
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/manucher051299/crud/cmd/app/middleware"
+	"github.com/manucher051299/crud/pkg/customers"
+)
+
+// profileErrWriter maps errors of the customer profile to http statuses
+func profileErrWriter(w http.ResponseWriter, err error) {
+	switch err {
+	case customers.ErrNotFound:
+		errWriter(w, http.StatusNotFound, err)
+	case customers.ErrInvalidProfile, customers.ErrInvalidPhone, customers.ErrWeakPassword, customers.ErrInvalidCode, customers.ErrCodeExpired:
+		errWriter(w, http.StatusBadRequest, err)
+	case customers.ErrInvalidPassword:
+		errWriter(w, http.StatusForbidden, err)
+	case customers.ErrPhoneUsed:
+		errWriter(w, http.StatusConflict, err)
+	case customers.ErrTooManyAttempts:
+		errWriter(w, http.StatusTooManyRequests, err)
+	default:
+		errWriter(w, http.StatusInternalServerError, err)
+	}
+}
+
+type phoneChangeRequest struct {
+	Phone string `json:"phone"`
+	Code  string `json:"code"`
+}
+
+type deletionRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (s *Server) handleCustomerGetProfile(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	item, err := s.customersSvc.Profile(r.Context(), id)
+	if err != nil {
+		profileErrWriter(w, err)
+		return
+	}
+
+	resJson(w, item)
+}
+
+func (s *Server) handleCustomerUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	update := &customers.ProfileUpdate{}
+	err = json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err := s.customersSvc.UpdateProfile(r.Context(), id, update)
+	if err != nil {
+		profileErrWriter(w, err)
+		return
+	}
+
+	resJson(w, item)
+}
+
+func (s *Server) handleCustomerChangePhone(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	request := &phoneChangeRequest{}
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.customersSvc.RequestPhoneChange(r.Context(), id, request.Phone)
+	if err != nil {
+		profileErrWriter(w, err)
+		return
+	}
+
+	resJson(w, map[string]interface{}{"status": "code sent"})
+}
+
+func (s *Server) handleCustomerConfirmPhone(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	request := &phoneChangeRequest{}
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err := s.customersSvc.ConfirmPhoneChange(r.Context(), id, request.Code)
+	if err != nil {
+		profileErrWriter(w, err)
+		return
+	}
+
+	resJson(w, item)
+}
+
+func (s *Server) handleCustomerChangePassword(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	change := &customers.PasswordChange{}
+	err = json.NewDecoder(r.Body).Decode(&change)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.customersSvc.ChangePassword(r.Context(), id, change)
+	if err != nil {
+		profileErrWriter(w, err)
+		return
+	}
+
+	resJson(w, map[string]interface{}{"status": "ok"})
+}
+
+func (s *Server) handleCustomerRequestDeletion(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	// reason is optional, so is the body
+	request := &deletionRequest{}
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil && err != io.EOF {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err := s.customersSvc.RequestDeletion(r.Context(), id, request.Reason)
+	if err != nil {
+		profileErrWriter(w, err)
+		return
+	}
+
+	resJson(w, item)
+}
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/manucher051299/crud/cmd/app/middleware"
+	"github.com/manucher051299/crud/pkg/receipts"
+)
+
+func (s *Server) handleManagerGetReceipt(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	saleID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.canSeeSale(w, r, id, saleID) {
+		return
+	}
+
+	receipt, err := s.receiptsSvc.BySale(r.Context(), saleID, 0)
+	if err == receipts.ErrNotFound {
+		errWriter(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeReceipt(w, r, receipt)
+}
+
+func (s *Server) handleCustomerGetReceipt(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	saleID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	receipt, err := s.receiptsSvc.BySale(r.Context(), saleID, id)
+	if err == receipts.ErrNotFound {
+		errWriter(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeReceipt(w, r, receipt)
+}
+
+// writeReceipt picks format from ?format=text|html|pdf|json or the Accept header
+func writeReceipt(w http.ResponseWriter, r *http.Request, receipt *receipts.Receipt) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		accept := r.Header.Get("Accept")
+		switch {
+		case strings.Contains(accept, "application/pdf"):
+			format = "pdf"
+		case strings.Contains(accept, "text/html"):
+			format = "html"
+		case strings.Contains(accept, "application/json"):
+			format = "json"
+		default:
+			format = "text"
+		}
+	}
+
+	switch format {
+	case "json":
+		resJson(w, receipt)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := receipts.HTML(w, receipt)
+		if err != nil {
+			errWriter(w, http.StatusInternalServerError, err)
+		}
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", "inline; filename=receipt-"+strconv.FormatInt(receipt.Number, 10)+".pdf")
+		_, err := w.Write(receipts.PDF(receipt))
+		if err != nil {
+			errWriter(w, http.StatusInternalServerError, err)
+		}
+	case "text":
+		width, _ := strconv.Atoi(r.URL.Query().Get("width"))
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, err := w.Write([]byte(receipts.Text(receipt, width)))
+		if err != nil {
+			errWriter(w, http.StatusInternalServerError, err)
+		}
+	default:
+		errWriter(w, http.StatusBadRequest, errUnknownFormat)
+	}
+}
@@ -0,0 +1,313 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/manucher051299/crud/cmd/app/middleware"
+	"github.com/manucher051299/crud/pkg/managers"
+)
+
+// salesReportErrWriter ...
+func salesReportErrWriter(w http.ResponseWriter, err error) {
+	if err == managers.ErrInvalidGrouping {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	errWriter(w, http.StatusInternalServerError, err)
+}
+
+// parseLocation reads ?tz=Asia/Dushanbe, default is UTC
+func parseLocation(r *http.Request) (*time.Location, error) {
+	value := r.URL.Query().Get("tz")
+	if value == "" || value == "Local" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(value)
+}
+
+// reportManagerIDs - sales a report may include: ?manager_id when visible to the viewer,
+// otherwise everything for admins and the team for other managers.
+func (s *Server) reportManagerIDs(w http.ResponseWriter, r *http.Request, viewerID int64) ([]int64, bool) {
+	if r.URL.Query().Get("manager_id") != "" {
+		managerID, ok := s.scopedManagerID(w, r, viewerID)
+		if !ok {
+			return nil, false
+		}
+		return []int64{managerID}, true
+	}
+	if s.managerSvc.IsAdmin(r.Context(), viewerID) {
+		return nil, true
+	}
+
+	ids, err := s.managerSvc.Team(r.Context(), viewerID)
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	return ids, true
+}
+
+var salesReportColumns = []string{"bucket", "id", "name", "currency", "revenue", "units", "sales", "average_ticket"}
+
+// salesReportCostColumns - columns of admins
+var salesReportCostColumns = append(append([]string{}, salesReportColumns...), "margin", "cost_coverage")
+
+func (s *Server) handleManagerGetSalesReport(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	format, err := exportFormat(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	location, err := parseLocation(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	from, to, err := parsePeriod(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	query := managers.SalesReportQuery{
+		// days of the period are days of the requested time zone
+		From:     time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, location),
+		To:       time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, location),
+		GroupBy:  r.URL.Query().Get("group"),
+		Location: location,
+		Costs:    s.managerSvc.IsAdmin(r.Context(), id),
+	}
+	if query.GroupBy == "" {
+		query.GroupBy = managers.ByDay
+	}
+
+	var ok bool
+	query.ManagerIDs, ok = s.reportManagerIDs(w, r, id)
+	if !ok {
+		return
+	}
+
+	if format != formatJSON {
+		columns := salesReportColumns
+		if query.Costs {
+			columns = salesReportCostColumns
+		}
+		stream, ok := newExportStream(w, r, format, "sales-"+query.GroupBy, columns)
+		if !ok {
+			return
+		}
+		err = s.managerSvc.EachSalesReportRow(r.Context(), query, func(item *managers.SalesReportRow) error {
+			var bucket interface{}
+			if item.Bucket != nil {
+				bucket = item.Bucket.Format(dateLayout)
+			}
+			values := []interface{}{bucket, item.ID, item.Name, item.Revenue.Currency, item.Revenue, item.Units, item.Sales,
+				item.AverageTicket}
+			if query.Costs {
+				values = append(values, item.Margin, item.CostCoverage)
+			}
+			return stream.Write(values...)
+		})
+		stream.finish(err, salesReportErrWriter)
+		return
+	}
+
+	items, err := s.managerSvc.SalesReport(r.Context(), query)
+	if err != nil {
+		salesReportErrWriter(w, err)
+		return
+	}
+
+	resJson(w, items)
+}
+
+// customerAnalyticsErrWriter ...
+func customerAnalyticsErrWriter(w http.ResponseWriter, err error) {
+	if err == managers.ErrInvalidRFMSegment {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	errWriter(w, http.StatusInternalServerError, err)
+}
+
+var customerAnalyticsColumns = []string{"customer_id", "name", "phone", "created", "currency", "spend", "returns", "purchases",
+	"average_order", "frequency", "first_purchase", "last_purchase", "recency_days", "r", "f", "m", "segment"}
+
+// handleManagerGetCustomerAnalytics - lifetime value and RFM segment of every buying customer, ?segment leaves one segment
+func (s *Server) handleManagerGetCustomerAnalytics(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	format, err := exportFormat(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	query := managers.CustomerAnalyticsQuery{Segment: r.URL.Query().Get("segment")}
+	var ok bool
+	query.ManagerIDs, ok = s.reportManagerIDs(w, r, id)
+	if !ok {
+		return
+	}
+
+	if format != formatJSON {
+		stream, ok := newExportStream(w, r, format, "customers-rfm", customerAnalyticsColumns)
+		if !ok {
+			return
+		}
+		err = s.managerSvc.EachCustomerStats(r.Context(), query, func(item *managers.CustomerStats) error {
+			return stream.Write(item.CustomerID, item.Name, item.Phone, item.Created, item.Spend.Currency, item.Spend, item.Returns,
+				item.Purchases, item.AverageOrder, item.Frequency, item.First, item.Last, item.RecencyDays, item.R, item.F, item.M,
+				item.Segment)
+		})
+		stream.finish(err, customerAnalyticsErrWriter)
+		return
+	}
+
+	items, err := s.managerSvc.CustomerAnalytics(r.Context(), query)
+	if err != nil {
+		customerAnalyticsErrWriter(w, err)
+		return
+	}
+
+	resJson(w, items)
+}
+
+func (s *Server) handleManagerGetCustomerSegmentsReport(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	query := managers.CustomerAnalyticsQuery{}
+	var ok bool
+	query.ManagerIDs, ok = s.reportManagerIDs(w, r, id)
+	if !ok {
+		return
+	}
+
+	items, err := s.managerSvc.CustomerSegments(r.Context(), query)
+	if err != nil {
+		customerAnalyticsErrWriter(w, err)
+		return
+	}
+
+	resJson(w, items)
+}
+
+// cohortMonths - cohorts shown when ?from isn't given
+const cohortMonths = 12
+
+// handleManagerGetCohorts - retention of customers registered in the months of ?from and ?to, the last year by default
+func (s *Server) handleManagerGetCohorts(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	format, err := exportFormat(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// whole months: from the month of ?from up to the end of the month of ?to
+	now := time.Now().UTC()
+	query := managers.CohortQuery{To: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0)}
+	if value := r.URL.Query().Get("to"); value != "" {
+		to, err := time.Parse(dateLayout, value)
+		if err != nil {
+			errWriter(w, http.StatusBadRequest, err)
+			return
+		}
+		query.To = time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0)
+	}
+	query.From = query.To.AddDate(0, -cohortMonths, 0)
+	if value := r.URL.Query().Get("from"); value != "" {
+		from, err := time.Parse(dateLayout, value)
+		if err != nil {
+			errWriter(w, http.StatusBadRequest, err)
+			return
+		}
+		query.From = time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	if !query.From.Before(query.To) {
+		errWriter(w, http.StatusBadRequest, errInvalidPeriod)
+		return
+	}
+
+	var ok bool
+	query.ManagerIDs, ok = s.reportManagerIDs(w, r, id)
+	if !ok {
+		return
+	}
+
+	items, err := s.managerSvc.Cohorts(r.Context(), query)
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if format != formatJSON {
+		// a column for every month after registration of the oldest cohort
+		columns := []string{"month", "customers"}
+		months := 0
+		for _, item := range items {
+			if len(item.Retention) > months {
+				months = len(item.Retention)
+			}
+		}
+		for i := 0; i < months; i++ {
+			columns = append(columns, "month_"+strconv.Itoa(i))
+		}
+		writeExport(w, r, format, "cohorts", columns, len(items), func(i int) []interface{} {
+			item := items[i]
+			row := []interface{}{item.Month.Format(dateLayout), item.Customers}
+			for _, value := range item.Retention {
+				row = append(row, value)
+			}
+			return row
+		})
+		return
+	}
+
+	resJson(w, items)
+}
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/manucher051299/crud/cmd/app/middleware"
+	"github.com/manucher051299/crud/pkg/giftcards"
+	"github.com/manucher051299/crud/pkg/managers"
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+// returnsErrWriter ...
+func returnsErrWriter(w http.ResponseWriter, err error) {
+	switch err {
+	case managers.ErrNotFound:
+		errWriter(w, http.StatusNotFound, err)
+	case managers.ErrInvalidReturn, giftcards.ErrNoCustomer, giftcards.ErrCreditExceedsSale, money.ErrCurrencyMismatch:
+		errWriter(w, http.StatusBadRequest, err)
+	default:
+		errWriter(w, http.StatusInternalServerError, err)
+	}
+}
+
+// handleManagerMakeReturn takes positions of the sale back, the customer gets store credit for them
+func (s *Server) handleManagerMakeReturn(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	saleID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item := &managers.Return{}
+	err = json.NewDecoder(r.Body).Decode(&item)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	item.SaleID = saleID
+	item.ManagerID = id
+
+	item, err = s.managerSvc.MakeReturn(r.Context(), item)
+	if err != nil {
+		returnsErrWriter(w, err)
+		return
+	}
+
+	resJson(w, item)
+}
+
+func (s *Server) handleManagerGetReturns(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	saleID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.canSeeSale(w, r, id, saleID) {
+		return
+	}
+
+	items, err := s.managerSvc.Returns(r.Context(), saleID)
+	if err != nil {
+		returnsErrWriter(w, err)
+		return
+	}
+
+	resJson(w, items)
+}
@@ -8,7 +8,16 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/manucher051299/crud/cmd/app/middleware"
 	"github.com/manucher051299/crud/pkg/customers"
+	"github.com/manucher051299/crud/pkg/giftcards"
+	"github.com/manucher051299/crud/pkg/inventory"
+	"github.com/manucher051299/crud/pkg/loyalty"
 	"github.com/manucher051299/crud/pkg/managers"
+	"github.com/manucher051299/crud/pkg/orders"
+	"github.com/manucher051299/crud/pkg/payments"
+	"github.com/manucher051299/crud/pkg/payroll"
+	"github.com/manucher051299/crud/pkg/receipts"
+	"github.com/manucher051299/crud/pkg/shifts"
+	"github.com/manucher051299/crud/pkg/wishlist"
 )
 
 //Server ..............
@@ -16,14 +25,35 @@ type Server struct {
 	mux          *mux.Router
 	customersSvc *customers.Service
 	managerSvc   *managers.Service
+	paymentsSvc  *payments.Service
+	receiptsSvc  *receipts.Service
+	ordersSvc    *orders.Service
+	loyaltySvc   *loyalty.Service
+	giftCardsSvc *giftcards.Service
+	wishlistSvc  *wishlist.Service
+	payrollSvc   *payroll.Service
+	shiftsSvc    *shifts.Service
+	inventorySvc *inventory.Service
 }
 
 //NewServer: Create new Server
-func NewServer(mux *mux.Router, customersSvc *customers.Service, mSvc *managers.Service) *Server {
+func NewServer(mux *mux.Router, customersSvc *customers.Service, mSvc *managers.Service, paymentsSvc *payments.Service,
+	receiptsSvc *receipts.Service, ordersSvc *orders.Service, loyaltySvc *loyalty.Service,
+	giftCardsSvc *giftcards.Service, wishlistSvc *wishlist.Service, payrollSvc *payroll.Service,
+	shiftsSvc *shifts.Service, inventorySvc *inventory.Service) *Server {
 	return &Server{
 		mux:          mux,
 		customersSvc: customersSvc,
 		managerSvc:   mSvc,
+		paymentsSvc:  paymentsSvc,
+		receiptsSvc:  receiptsSvc,
+		ordersSvc:    ordersSvc,
+		loyaltySvc:   loyaltySvc,
+		giftCardsSvc: giftCardsSvc,
+		wishlistSvc:  wishlistSvc,
+		payrollSvc:   payrollSvc,
+		shiftsSvc:    shiftsSvc,
+		inventorySvc: inventorySvc,
 	}
 }
 
@@ -35,6 +65,8 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 const (
 	GET    = "GET"
 	POST   = "POST"
+	PUT    = "PUT"
+	PATCH  = "PATCH"
 	DELETE = "DELETE"
 )
 
@@ -48,7 +80,36 @@ func (s *Server) Init() {
 	customersSubrouter.HandleFunc("", s.handleCustomerRegistration).Methods(POST)
 	customersSubrouter.HandleFunc("/token", s.handleCustomerGetToken).Methods(POST)
 	customersSubrouter.HandleFunc("/products", s.handleCustomerGetProducts).Methods(GET)
+	customersSubrouter.HandleFunc("/products/{id}/notify", s.handleCustomerSubscribe).Methods(POST)
+	customersSubrouter.HandleFunc("/products/{id}/notify", s.handleCustomerUnsubscribe).Methods(DELETE)
+	customersSubrouter.HandleFunc("/wishlist", s.handleCustomerGetWishlist).Methods(GET)
+	customersSubrouter.HandleFunc("/wishlist", s.handleCustomerAddToWishlist).Methods(POST)
+	customersSubrouter.HandleFunc("/wishlist/{id}", s.handleCustomerRemoveFromWishlist).Methods(DELETE)
+	customersSubrouter.HandleFunc("/me", s.handleCustomerGetProfile).Methods(GET)
+	customersSubrouter.HandleFunc("/me", s.handleCustomerUpdateProfile).Methods(PATCH)
+	customersSubrouter.HandleFunc("/me", s.handleCustomerRequestDeletion).Methods(DELETE)
+	customersSubrouter.HandleFunc("/me/phone", s.handleCustomerChangePhone).Methods(POST)
+	customersSubrouter.HandleFunc("/me/phone/confirm", s.handleCustomerConfirmPhone).Methods(POST)
+	customersSubrouter.HandleFunc("/me/password", s.handleCustomerChangePassword).Methods(POST)
+	customersSubrouter.HandleFunc("/me/addresses", s.handleCustomerGetAddresses).Methods(GET)
+	customersSubrouter.HandleFunc("/me/addresses", s.handleCustomerSaveAddress).Methods(POST)
+	customersSubrouter.HandleFunc("/me/addresses/{id}", s.handleCustomerSaveAddress).Methods(PUT)
+	customersSubrouter.HandleFunc("/me/addresses/{id}", s.handleCustomerRemoveAddress).Methods(DELETE)
+	customersSubrouter.HandleFunc("/me/preferences", s.handleCustomerGetPreferences).Methods(GET)
+	customersSubrouter.HandleFunc("/me/preferences", s.handleCustomerSavePreferences).Methods(PUT)
 	customersSubrouter.HandleFunc("/purchases", s.handleCustomerGetPurchases).Methods(GET)
+	customersSubrouter.HandleFunc("/purchases/{id}", s.handleCustomerGetPurchase).Methods(GET)
+	customersSubrouter.HandleFunc("/purchases/{id}/receipt", s.handleCustomerGetReceipt).Methods(GET)
+	customersSubrouter.HandleFunc("/cart", s.handleCustomerGetCart).Methods(GET)
+	customersSubrouter.HandleFunc("/cart", s.handleCustomerAddToCart).Methods(POST)
+	customersSubrouter.HandleFunc("/cart/checkout", s.handleCustomerCheckout).Methods(POST)
+	customersSubrouter.HandleFunc("/cart/{id}", s.handleCustomerUpdateCart).Methods(PUT)
+	customersSubrouter.HandleFunc("/cart/{id}", s.handleCustomerRemoveFromCart).Methods(DELETE)
+	customersSubrouter.HandleFunc("/orders", s.handleCustomerGetOrders).Methods(GET)
+	customersSubrouter.HandleFunc("/orders/{id}", s.handleCustomerGetOrder).Methods(GET)
+	customersSubrouter.HandleFunc("/orders/{id}/cancel", s.handleCustomerCancelOrder).Methods(POST)
+	customersSubrouter.HandleFunc("/loyalty", s.handleCustomerGetLoyalty).Methods(GET)
+	customersSubrouter.HandleFunc("/store-credit", s.handleCustomerGetStoreCredit).Methods(GET)
 
 	managersAuthenticateMd := middleware.Authenticate(s.managerSvc.IDByToken)
 	managersSubRouter := s.mux.PathPrefix("/api/managers").Subrouter()
@@ -58,12 +119,75 @@ func (s *Server) Init() {
 	managersSubRouter.HandleFunc("/token", s.handleManagerGetToken).Methods(POST)
 	managersSubRouter.HandleFunc("/sales", s.handleManagerGetSales).Methods(GET)
 	managersSubRouter.HandleFunc("/sales", s.handleManagerMakeSales).Methods(POST)
+	managersSubRouter.HandleFunc("/sales/{id}/payments", s.handleManagerGetPayments).Methods(GET)
+	managersSubRouter.HandleFunc("/sales/{id}/payments", s.handleManagerMakePayments).Methods(POST)
+	managersSubRouter.HandleFunc("/sales/{id}/receipt", s.handleManagerGetReceipt).Methods(GET)
+	managersSubRouter.HandleFunc("/sales/{id}/returns", s.handleManagerGetReturns).Methods(GET)
+	managersSubRouter.HandleFunc("/sales/{id}/returns", s.handleManagerMakeReturn).Methods(POST)
 	managersSubRouter.HandleFunc("/products", s.handleManagerGetProducts).Methods(GET)
 	managersSubRouter.HandleFunc("/products", s.handleManagerChangeProducts).Methods(POST)
+	managersSubRouter.HandleFunc("/products/import", s.handleManagerImportProducts).Methods(POST)
 	managersSubRouter.HandleFunc("/products/{id}", s.handleManagerRemoveProductByID).Methods(DELETE)
+	managersSubRouter.HandleFunc("/products/{id}/stock", s.handleManagerReceiveStock).Methods(POST)
+	managersSubRouter.HandleFunc("/categories", s.handleManagerGetCategories).Methods(GET)
+	managersSubRouter.HandleFunc("/categories", s.handleManagerChangeCategory).Methods(POST)
+	managersSubRouter.HandleFunc("/reports/taxes", s.handleManagerGetTaxReport).Methods(GET)
+	managersSubRouter.HandleFunc("/reports/cash", s.handleManagerGetCashReport).Methods(GET)
+	managersSubRouter.HandleFunc("/reports/sales", s.handleManagerGetSalesReport).Methods(GET)
+	managersSubRouter.HandleFunc("/reports/customers", s.handleManagerGetCustomerAnalytics).Methods(GET)
+	managersSubRouter.HandleFunc("/reports/customers/segments", s.handleManagerGetCustomerSegmentsReport).Methods(GET)
+	managersSubRouter.HandleFunc("/reports/customers/cohorts", s.handleManagerGetCohorts).Methods(GET)
+	managersSubRouter.HandleFunc("/reports/stock", s.handleManagerGetStock).Methods(GET)
+	managersSubRouter.HandleFunc("/reports/stock/valuation", s.handleManagerGetStockValuation).Methods(GET)
+	managersSubRouter.HandleFunc("/reports/stock/snapshots", s.handleManagerGetStockSnapshots).Methods(GET)
+	managersSubRouter.HandleFunc("/reports/stock/snapshots", s.handleManagerTakeStockSnapshot).Methods(POST)
+	managersSubRouter.HandleFunc("/reports/stock/snapshots/items", s.handleManagerGetStockSnapshotItems).Methods(GET)
+	managersSubRouter.HandleFunc("/orders", s.handleManagerGetOrders).Methods(GET)
+	managersSubRouter.HandleFunc("/orders/{id}", s.handleManagerGetOrder).Methods(GET)
+	managersSubRouter.HandleFunc("/orders/{id}/status", s.handleManagerChangeOrderStatus).Methods(POST)
 	managersSubRouter.HandleFunc("/customers", s.handleManagerGetCustomers).Methods(GET)
 	managersSubRouter.HandleFunc("/customers", s.handleManagerChangeCustomer).Methods(POST)
+	managersSubRouter.HandleFunc("/customers/duplicates", s.handleManagerGetDuplicates).Methods(GET)
+	managersSubRouter.HandleFunc("/customers/segments", s.handleManagerGetSegments).Methods(GET)
+	managersSubRouter.HandleFunc("/customers/segments", s.handleManagerSaveSegment).Methods(POST)
+	managersSubRouter.HandleFunc("/customers/segments/{id}", s.handleManagerRemoveSegment).Methods(DELETE)
+	managersSubRouter.HandleFunc("/customers/{id}", s.handleManagerGetCustomerByID).Methods(GET)
 	managersSubRouter.HandleFunc("/customers/{id}", s.handleManagerRemoveCustomerByID).Methods(DELETE)
+	managersSubRouter.HandleFunc("/customers/{id}/export", s.handleManagerExportCustomer).Methods(GET)
+	managersSubRouter.HandleFunc("/customers/{id}/merge", s.handleManagerMergeCustomers).Methods(POST)
+	managersSubRouter.HandleFunc("/customers/{id}/notes", s.handleManagerGetNotes).Methods(GET)
+	managersSubRouter.HandleFunc("/customers/{id}/notes", s.handleManagerAddNote).Methods(POST)
+	managersSubRouter.HandleFunc("/customers/{id}/notes/{noteID}", s.handleManagerRemoveNote).Methods(DELETE)
+	managersSubRouter.HandleFunc("/customers/{id}/tags", s.handleManagerSetTags).Methods(PUT)
+	managersSubRouter.HandleFunc("/customers/{id}/erase", s.handleManagerEraseCustomer).Methods(POST)
+	managersSubRouter.HandleFunc("/customers/{id}/store-credit", s.handleManagerGetStoreCredit).Methods(GET)
+	managersSubRouter.HandleFunc("/plans", s.handleManagerGetPlans).Methods(GET)
+	managersSubRouter.HandleFunc("/plans", s.handleManagerSavePlan).Methods(POST)
+	managersSubRouter.HandleFunc("/plans/progress", s.handleManagerGetProgress).Methods(GET)
+	managersSubRouter.HandleFunc("/plans/leaderboard", s.handleManagerGetLeaderboard).Methods(GET)
+	managersSubRouter.HandleFunc("/commission-rules", s.handleManagerGetCommissionRules).Methods(GET)
+	managersSubRouter.HandleFunc("/commission-rules", s.handleManagerSaveCommissionRule).Methods(POST)
+	managersSubRouter.HandleFunc("/commission-rules/{id}", s.handleManagerRemoveCommissionRule).Methods(DELETE)
+	managersSubRouter.HandleFunc("/payroll", s.handleManagerGetPayrolls).Methods(GET)
+	managersSubRouter.HandleFunc("/payroll", s.handleManagerCalculatePayroll).Methods(POST)
+	managersSubRouter.HandleFunc("/payroll/{id}", s.handleManagerGetPayroll).Methods(GET)
+	managersSubRouter.HandleFunc("/payroll/{id}/approve", s.handleManagerApprovePayroll).Methods(POST)
+	managersSubRouter.HandleFunc("/shifts", s.handleManagerGetShifts).Methods(GET)
+	managersSubRouter.HandleFunc("/shifts", s.handleManagerOpenShift).Methods(POST)
+	managersSubRouter.HandleFunc("/shifts/current", s.handleManagerGetCurrentShift).Methods(GET)
+	managersSubRouter.HandleFunc("/shifts/current/close", s.handleManagerCloseShift).Methods(POST)
+	managersSubRouter.HandleFunc("/shifts/{id}", s.handleManagerGetShiftReport).Methods(GET)
+	managersSubRouter.HandleFunc("/staff", s.handleManagerGetStaff).Methods(GET)
+	managersSubRouter.HandleFunc("/staff/tree", s.handleManagerGetOrgTree).Methods(GET)
+	managersSubRouter.HandleFunc("/staff/{id}", s.handleManagerGetStaffByID).Methods(GET)
+	managersSubRouter.HandleFunc("/staff/{id}", s.handleManagerUpdateStaff).Methods(PATCH)
+	managersSubRouter.HandleFunc("/staff/{id}/deactivate", s.handleManagerDeactivateStaff).Methods(POST)
+	managersSubRouter.HandleFunc("/staff/{id}/activate", s.handleManagerActivateStaff).Methods(POST)
+	managersSubRouter.HandleFunc("/staff/{id}/boss", s.handleManagerSetBoss).Methods(PUT)
+	managersSubRouter.HandleFunc("/staff/{id}/departament", s.handleManagerSetDepartament).Methods(PUT)
+	managersSubRouter.HandleFunc("/gift-cards", s.handleManagerIssueGiftCard).Methods(POST)
+	managersSubRouter.HandleFunc("/gift-cards/liabilities", s.handleManagerGetLiabilities).Methods(GET)
+	managersSubRouter.HandleFunc("/gift-cards/{code}", s.handleManagerGetGiftCard).Methods(GET)
 
 }
 
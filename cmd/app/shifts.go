@@ -0,0 +1,196 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/manucher051299/crud/cmd/app/middleware"
+	"github.com/manucher051299/crud/pkg/money"
+	"github.com/manucher051299/crud/pkg/shifts"
+)
+
+// shiftsErrWriter maps errors of shifts to http statuses
+func shiftsErrWriter(w http.ResponseWriter, err error) {
+	switch err {
+	case shifts.ErrNotFound, shifts.ErrNoOpenShift:
+		errWriter(w, http.StatusNotFound, err)
+	case shifts.ErrInvalidAmount, money.ErrInvalidCurrency, money.ErrCurrencyMismatch:
+		errWriter(w, http.StatusBadRequest, err)
+	case shifts.ErrShiftOpen:
+		errWriter(w, http.StatusConflict, err)
+	default:
+		errWriter(w, http.StatusInternalServerError, err)
+	}
+}
+
+type openShiftRequest struct {
+	Opening money.Money `json:"opening"`
+}
+
+type closeShiftRequest struct {
+	Counted money.Money `json:"counted"`
+	Note    string      `json:"note"`
+}
+
+func (s *Server) handleManagerOpenShift(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	request := &openShiftRequest{}
+	err = json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err := s.shiftsSvc.Open(r.Context(), id, request.Opening)
+	if err != nil {
+		shiftsErrWriter(w, err)
+		return
+	}
+
+	resJson(w, item)
+}
+
+func (s *Server) handleManagerGetCurrentShift(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	item, err := s.shiftsSvc.Current(r.Context(), id)
+	if err != nil {
+		shiftsErrWriter(w, err)
+		return
+	}
+
+	resJson(w, item)
+}
+
+func (s *Server) handleManagerCloseShift(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	request := &closeShiftRequest{}
+	err = json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err := s.shiftsSvc.Close(r.Context(), id, request.Counted, request.Note)
+	if err != nil {
+		shiftsErrWriter(w, err)
+		return
+	}
+
+	resJson(w, item)
+}
+
+var shiftColumns = []string{"id", "manager_id", "currency", "opening", "counted", "note", "opened", "closed"}
+
+func (s *Server) handleManagerGetShifts(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	format, err := exportFormat(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	managerID, ok := s.scopedManagerID(w, r, id)
+	if !ok {
+		return
+	}
+
+	items, err := s.shiftsSvc.Shifts(r.Context(), managerID)
+	if err != nil {
+		shiftsErrWriter(w, err)
+		return
+	}
+	if format != formatJSON {
+		writeExport(w, r, format, "shifts", shiftColumns, len(items), func(i int) []interface{} {
+			item := items[i]
+			return []interface{}{item.ID, item.ManagerID, item.Opening.Currency, item.Opening, item.Counted, item.Note,
+				item.Opened, item.Closed}
+		})
+		return
+	}
+
+	resJson(w, items)
+}
+
+func (s *Server) handleManagerGetShiftReport(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	shiftID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err := s.shiftsSvc.Report(r.Context(), shiftID)
+	if err != nil {
+		shiftsErrWriter(w, err)
+		return
+	}
+
+	visible, err := s.managerSvc.CanSee(r.Context(), id, item.ManagerID)
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !visible {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	resJson(w, item)
+}
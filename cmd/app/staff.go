@@ -0,0 +1,190 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/manucher051299/crud/cmd/app/middleware"
+	"github.com/manucher051299/crud/pkg/managers"
+)
+
+// staffErrWriter maps errors of managers administration to http statuses
+func staffErrWriter(w http.ResponseWriter, err error) {
+	switch err {
+	case managers.ErrNotFound:
+		errWriter(w, http.StatusNotFound, err)
+	case managers.ErrInvalidManager, managers.ErrInvalidPhone:
+		errWriter(w, http.StatusBadRequest, err)
+	case managers.ErrPhoneUsed, managers.ErrOwnAccount:
+		errWriter(w, http.StatusConflict, err)
+	default:
+		errWriter(w, http.StatusInternalServerError, err)
+	}
+}
+
+type staffUpdateRequest struct {
+	managers.StaffUpdate
+	Roles *[]string `json:"roles"`
+}
+
+var staffColumns = []string{"id", "name", "phone", "departament", "boss_id", "salary", "plan", "is_admin", "active", "created"}
+
+func (s *Server) handleManagerGetStaff(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	format, err := exportFormat(r)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := managers.StaffFilter{
+		Query:       r.URL.Query().Get("q"),
+		Departament: r.URL.Query().Get("departament"),
+	}
+	if value := r.URL.Query().Get("active"); value != "" {
+		active, err := strconv.ParseBool(value)
+		if err != nil {
+			errWriter(w, http.StatusBadRequest, err)
+			return
+		}
+		filter.Active = &active
+	}
+
+	items, err := s.managerSvc.Staff(r.Context(), filter)
+	if err != nil {
+		staffErrWriter(w, err)
+		return
+	}
+	if format != formatJSON {
+		writeExport(w, r, format, "staff", staffColumns, len(items), func(i int) []interface{} {
+			item := items[i]
+			return []interface{}{item.ID, item.Name, item.Phone, item.Departament, item.BossID, item.Salary, item.Plan,
+				item.IsAdmin, item.Active, item.Created}
+		})
+		return
+	}
+
+	resJson(w, items)
+}
+
+func (s *Server) handleManagerGetStaffByID(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	managerID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err := s.managerSvc.ManagerByID(r.Context(), managerID)
+	if err != nil {
+		staffErrWriter(w, err)
+		return
+	}
+
+	resJson(w, item)
+}
+
+func (s *Server) handleManagerUpdateStaff(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	managerID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	request := &staffUpdateRequest{}
+	err = json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+	if request.Roles != nil {
+		isAdmin := false
+		for _, role := range *request.Roles {
+			if role == ADMIN {
+				isAdmin = true
+				break
+			}
+		}
+		request.IsAdmin = &isAdmin
+	}
+
+	item, err := s.managerSvc.UpdateManager(r.Context(), id, managerID, &request.StaffUpdate)
+	if err != nil {
+		staffErrWriter(w, err)
+		return
+	}
+
+	resJson(w, item)
+}
+
+func (s *Server) handleManagerDeactivateStaff(w http.ResponseWriter, r *http.Request) {
+	s.setStaffActive(w, r, false)
+}
+
+func (s *Server) handleManagerActivateStaff(w http.ResponseWriter, r *http.Request) {
+	s.setStaffActive(w, r, true)
+}
+
+func (s *Server) setStaffActive(w http.ResponseWriter, r *http.Request, active bool) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	managerID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err := s.managerSvc.SetActive(r.Context(), id, managerID, active)
+	if err != nil {
+		staffErrWriter(w, err)
+		return
+	}
+
+	resJson(w, item)
+}
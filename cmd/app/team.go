@@ -0,0 +1,167 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/manucher051299/crud/cmd/app/middleware"
+	"github.com/manucher051299/crud/pkg/managers"
+)
+
+type bossRequest struct {
+	BossID int64 `json:"boss_id"`
+}
+
+type departamentRequest struct {
+	Departament string `json:"departament"`
+}
+
+// scopedManagerID returns ?manager_id when the viewer may see that manager, own id when it's absent.
+// It writes the error response itself and returns false then.
+func (s *Server) scopedManagerID(w http.ResponseWriter, r *http.Request, viewerID int64) (int64, bool) {
+	value := r.URL.Query().Get("manager_id")
+	if value == "" {
+		return viewerID, true
+	}
+
+	managerID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return 0, false
+	}
+
+	visible, err := s.managerSvc.CanSee(r.Context(), viewerID, managerID)
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return 0, false
+	}
+	if !visible {
+		errWriter(w, http.StatusForbidden, err)
+		return 0, false
+	}
+	return managerID, true
+}
+
+// canSeeSale checks that the viewer may see the sale. It writes the error response itself and returns false then.
+func (s *Server) canSeeSale(w http.ResponseWriter, r *http.Request, viewerID int64, saleID int64) bool {
+	visible, err := s.managerSvc.CanSeeSale(r.Context(), viewerID, saleID)
+	if err == managers.ErrNotFound {
+		errWriter(w, http.StatusNotFound, err)
+		return false
+	}
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return false
+	}
+	if !visible {
+		errWriter(w, http.StatusForbidden, err)
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleManagerGetOrgTree(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if id == 0 {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.managerSvc.OrgTree(r.Context())
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resJson(w, items)
+}
+
+func (s *Server) handleManagerSetBoss(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	managerID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	request := &bossRequest{}
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.managerSvc.SetBoss(r.Context(), managerID, request.BossID)
+	if err == managers.ErrNotFound {
+		errWriter(w, http.StatusNotFound, err)
+		return
+	}
+	if err == managers.ErrCycle {
+		errWriter(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resJson(w, map[string]interface{}{"status": "ok"})
+}
+
+func (s *Server) handleManagerSetDepartament(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		errWriter(w, http.StatusForbidden, err)
+		return
+	}
+
+	managerID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	request := &departamentRequest{}
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		errWriter(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.managerSvc.SetDepartament(r.Context(), managerID, request.Departament)
+	if err == managers.ErrNotFound {
+		errWriter(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		errWriter(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resJson(w, map[string]interface{}{"status": "ok"})
+}
@@ -12,7 +12,19 @@ import (
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/manucher051299/crud/cmd/app"
 	"github.com/manucher051299/crud/pkg/customers"
+	"github.com/manucher051299/crud/pkg/giftcards"
+	"github.com/manucher051299/crud/pkg/inventory"
+	"github.com/manucher051299/crud/pkg/loyalty"
+	"github.com/manucher051299/crud/pkg/managers"
+	"github.com/manucher051299/crud/pkg/notifications"
+	"github.com/manucher051299/crud/pkg/orders"
+	"github.com/manucher051299/crud/pkg/payments"
+	"github.com/manucher051299/crud/pkg/payroll"
+	"github.com/manucher051299/crud/pkg/phones"
+	"github.com/manucher051299/crud/pkg/receipts"
 	"github.com/manucher051299/crud/pkg/security"
+	"github.com/manucher051299/crud/pkg/shifts"
+	"github.com/manucher051299/crud/pkg/wishlist"
 	"go.uber.org/dig"
 )
 
@@ -34,10 +46,55 @@ func execute(host string, port string, dsn string) (err error) {
 		app.NewServer,
 		mux.NewRouter, ///mux->"github.com/gorilla/mux"
 		func() (*pgxpool.Pool, error) {
-			ctx, _ := context.WithTimeout(context.Background(), time.Second*5)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
 			return pgxpool.Connect(ctx, dsn)
 		},
 		customers.NewService,
+		func() phones.Config {
+			return phones.Config{DefaultRegion: "TJ"}
+		},
+		func() customers.Sender {
+			return customers.LogSender{}
+		},
+		managers.NewService,
+		payments.NewService,
+		loyalty.NewService,
+		giftcards.NewService,
+		func() loyalty.Config {
+			return loyalty.Config{
+				PointsPerUnit:     1,
+				PointValue:        10,
+				ExcludeDiscounted: true,
+				Expiry:            365 * 24 * time.Hour,
+			}
+		},
+		receipts.NewService,
+		orders.NewService,
+		func() orders.Config {
+			return orders.Config{ReservationTTL: 30 * time.Minute}
+		},
+		func() receipts.Shop {
+			return receipts.Shop{
+				Name:    "CRUD Shop",
+				Address: "Dushanbe",
+				Phone:   "+992000000000",
+			}
+		},
+		wishlist.NewService,
+		notifications.NewOutbox,
+		func() notifications.Notifier {
+			return notifications.Log{}
+		},
+		payroll.NewService,
+		shifts.NewService,
+		inventory.NewService,
+		func() inventory.Config {
+			return inventory.Config{
+				VelocityWindow:   30 * 24 * time.Hour,
+				SnapshotInterval: 24 * time.Hour,
+			}
+		},
 		security.NewService,
 		func(server *app.Server) *http.Server {
 			return &http.Server{
@@ -59,6 +116,13 @@ func execute(host string, port string, dsn string) (err error) {
 	if err != nil {
 		return err
 	}
+	err = container.Invoke(func(inventorySvc *inventory.Service, outbox *notifications.Outbox) {
+		go inventorySvc.Schedule(context.Background())
+		go outbox.Run(context.Background(), 10*time.Second)
+	})
+	if err != nil {
+		return err
+	}
 	return container.Invoke(func(server *http.Server) error {
 		return server.ListenAndServe()
 	})
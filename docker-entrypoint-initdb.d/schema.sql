@@ -4,7 +4,13 @@ create table if not exists customers
     name	text not null,
     phone 	text 	not null unique,
     password text 	not null,
+    email   text not null default '',
     active 	boolean not null default true,
+    sms_opt_in   boolean not null default false,
+    email_opt_in boolean not null default false,
+    deletion_requested timestamp,
+    erased  timestamp,
+    merged_into bigint references customers,
     created timestamp not null default current_timestamp 
 );
 
@@ -20,6 +26,7 @@ create table if not exists managers
     password text ,
     is_admin boolean not null default true,
     active 	boolean not null default true,
+    deactivated timestamp,
     created timestamp not null default current_timestamp 
 );
 
@@ -39,12 +46,41 @@ create table if not exists managers_tokens
     created timestamp not null default current_timestamp
 );
 
+create table if not exists shifts 
+(
+    id          bigserial primary key,
+    manager_id  bigint not null references managers,
+    opening     bigint not null default 0 check(opening >= 0),
+    currency    char(3) not null default 'TJS',
+    counted     bigint check(counted >= 0),
+    note        text not null default '',
+    opened      timestamp not null default current_timestamp,
+    closed      timestamp
+);
+
+create unique index if not exists shifts_open_idx on shifts (manager_id) where closed is null;
+
+create table if not exists categories 
+(
+    id      bigserial primary key,
+    name    text not null unique,
+    tax_rate integer not null default 0 check(tax_rate >= 0 and tax_rate < 10000),
+    created timestamp not null default current_timestamp 
+);
+
 create table if not exists products 
 (
     id      bigserial primary key,
+    sku     text unique,
     name    text not null,
-    price   integer not null check(price >0),
+    price   bigint not null check(price >0),
+    currency char(3) not null default 'TJS',
     qty     integer not null default 0 check(qty >=0),
+    category_id bigint references categories,
+    tax_rate integer check(tax_rate >= 0 and tax_rate < 10000),
+    tax_included boolean not null default true,
+    cost    bigint check(cost >= 0),
+    reorder_point integer check(reorder_point >= 0),
     active 	boolean not null default true,
     created timestamp not null default current_timestamp 
 );
@@ -54,6 +90,12 @@ create table if not exists sales
     id          bigserial primary key,
     manager_id  bigint not null references managers,
     customer_id bigint not null,
+    shift_id    bigint references shifts,
+    currency char(3) not null default 'TJS',
+    tax     bigint not null default 0,
+    total   bigint not null default 0,
+    status  text not null default 'unpaid',
+    paid        timestamp,
     created     timestamp not null default current_timestamp 
 );
 
@@ -62,7 +104,364 @@ create table if not exists sales_positions
     id          bigserial primary key,
     product_id  bigint not null references products,
     sale_id  bigint not null references sales,
-    price integer not null check(price >= 0),
+    price   bigint not null check(price >= 0),
+    currency char(3) not null default 'TJS',
     qty     integer not null default 0 check(qty >=0),
+    discounted boolean not null default false,
+    tax_rate integer not null default 0,
+    tax_included boolean not null default true,
+    tax     bigint not null default 0,
+    total   bigint not null default 0,
+    cost    bigint,
+    created     timestamp not null default current_timestamp 
+);
+
+create table if not exists payments 
+(
+    id          bigserial primary key,
+    sale_id     bigint not null references sales,
+    manager_id  bigint not null references managers,
+    method      text not null,
+    amount      bigint not null check(amount > 0),
+    change      bigint not null default 0 check(change >= 0),
+    currency    char(3) not null default 'TJS',
+    reference   text not null default '',
+    shift_id    bigint references shifts,
+    created     timestamp not null default current_timestamp 
+);
+
+create table if not exists receipts 
+(
+    sale_id     bigint not null unique references sales,
+    number      bigint not null unique,
     created     timestamp not null default current_timestamp 
-);
\ No newline at end of file
+);
+
+create table if not exists carts 
+(
+    customer_id bigint not null references customers,
+    product_id  bigint not null references products,
+    qty         integer not null check(qty > 0),
+    created     timestamp not null default current_timestamp,
+    primary key (customer_id, product_id)
+);
+
+create table if not exists orders 
+(
+    id          bigserial primary key,
+    customer_id bigint not null references customers,
+    manager_id  bigint references managers,
+    sale_id     bigint references sales,
+    status      text not null default 'new',
+    total       bigint not null default 0,
+    currency    char(3) not null default 'TJS',
+    expires     timestamp,
+    created     timestamp not null default current_timestamp,
+    updated     timestamp not null default current_timestamp
+);
+
+create table if not exists orders_items 
+(
+    id          bigserial primary key,
+    order_id    bigint not null references orders,
+    product_id  bigint not null references products,
+    price       bigint not null check(price >= 0),
+    currency    char(3) not null default 'TJS',
+    qty         integer not null check(qty > 0)
+);
+
+create table if not exists loyalty_ledger 
+(
+    id          bigserial primary key,
+    customer_id bigint not null references customers,
+    sale_id     bigint references sales,
+    kind        text not null,
+    points      bigint not null,
+    remaining   bigint not null default 0 check(remaining >= 0),
+    expires     timestamp,
+    created     timestamp not null default current_timestamp
+);
+
+create unique index if not exists loyalty_ledger_earn_idx on loyalty_ledger (sale_id) where kind = 'earn';
+
+create table if not exists gift_cards 
+(
+    id          bigserial primary key,
+    code        text not null unique,
+    initial     bigint not null check(initial > 0),
+    balance     bigint not null check(balance >= 0),
+    currency    char(3) not null default 'TJS',
+    expires     timestamp,
+    active      boolean not null default true,
+    manager_id  bigint references managers,
+    created     timestamp not null default current_timestamp
+);
+
+create table if not exists gift_cards_transactions 
+(
+    id          bigserial primary key,
+    card_id     bigint not null references gift_cards,
+    sale_id     bigint references sales,
+    manager_id  bigint references managers,
+    kind        text not null,
+    amount      bigint not null,
+    created     timestamp not null default current_timestamp
+);
+
+create table if not exists returns 
+(
+    id          bigserial primary key,
+    sale_id     bigint not null references sales,
+    customer_id bigint not null references customers,
+    manager_id  bigint not null references managers,
+    total       bigint not null default 0,
+    currency    char(3) not null default 'TJS',
+    reason      text not null default '',
+    created     timestamp not null default current_timestamp
+);
+
+create index if not exists returns_sale_idx on returns (sale_id);
+
+create table if not exists returns_positions 
+(
+    id          bigserial primary key,
+    return_id   bigint not null references returns,
+    position_id bigint not null references sales_positions,
+    product_id  bigint not null references products,
+    qty         integer not null check(qty > 0),
+    total       bigint not null default 0,
+    created     timestamp not null default current_timestamp
+);
+
+create index if not exists returns_positions_position_idx on returns_positions (position_id);
+create index if not exists returns_positions_return_idx on returns_positions (return_id);
+
+create table if not exists store_credits 
+(
+    id          bigserial primary key,
+    customer_id bigint not null references customers,
+    sale_id     bigint references sales,
+    return_id   bigint references returns,
+    manager_id  bigint references managers,
+    kind        text not null,
+    amount      bigint not null,
+    currency    char(3) not null default 'TJS',
+    reason      text not null default '',
+    created     timestamp not null default current_timestamp
+);
+
+create table if not exists customers_phone_changes 
+(
+    id          bigserial primary key,
+    customer_id bigint not null unique references customers,
+    phone       text not null,
+    code        text not null,
+    attempts    integer not null default 0,
+    expire      timestamp not null,
+    created     timestamp not null default current_timestamp
+);
+
+create table if not exists customers_deletion_requests 
+(
+    id          bigserial primary key,
+    customer_id bigint not null unique references customers,
+    reason      text not null default '',
+    processed   timestamp,
+    created     timestamp not null default current_timestamp
+);
+
+create table if not exists customers_addresses 
+(
+    id          bigserial primary key,
+    customer_id bigint not null references customers,
+    label       text not null default '',
+    line        text not null,
+    city        text not null,
+    lat         double precision check(lat between -90 and 90),
+    lng         double precision check(lng between -180 and 180),
+    is_default  boolean not null default false,
+    created     timestamp not null default current_timestamp
+);
+
+create unique index if not exists customers_addresses_default_idx on customers_addresses (customer_id) where is_default;
+
+create table if not exists wishlists 
+(
+    customer_id bigint not null references customers,
+    product_id  bigint not null references products,
+    created     timestamp not null default current_timestamp,
+    primary key (customer_id, product_id)
+);
+
+create table if not exists stock_subscriptions 
+(
+    id          bigserial primary key,
+    customer_id bigint not null references customers,
+    product_id  bigint not null references products,
+    notified    timestamp,
+    created     timestamp not null default current_timestamp
+);
+
+create unique index if not exists stock_subscriptions_pending_idx on stock_subscriptions (customer_id, product_id) where notified is null;
+
+create table if not exists notifications_outbox 
+(
+    id           bigserial primary key,
+    customer_id  bigint not null references customers,
+    kind         text not null,
+    product_id   bigint references products,
+    text         text not null,
+    attempts     integer not null default 0,
+    error        text not null default '',
+    next_attempt timestamp not null default current_timestamp,
+    sent         timestamp,
+    created      timestamp not null default current_timestamp
+);
+
+create index if not exists notifications_outbox_pending_idx on notifications_outbox (next_attempt) where sent is null;
+
+create table if not exists stock_receipts 
+(
+    id          bigserial primary key,
+    product_id  bigint not null references products,
+    manager_id  bigint references managers,
+    qty         integer not null check(qty > 0),
+    created     timestamp not null default current_timestamp
+);
+
+create table if not exists customers_merges 
+(
+    id           bigserial primary key,
+    survivor_id  bigint not null references customers,
+    duplicate_id bigint not null references customers,
+    manager_id   bigint references managers,
+    snapshot     jsonb not null,
+    moved        jsonb not null,
+    created      timestamp not null default current_timestamp
+);
+
+create table if not exists customers_notes 
+(
+    id          bigserial primary key,
+    customer_id bigint not null references customers,
+    manager_id  bigint not null references managers,
+    text        text not null,
+    created     timestamp not null default current_timestamp
+);
+
+create table if not exists customers_tags 
+(
+    customer_id bigint not null references customers,
+    tag         text not null,
+    created     timestamp not null default current_timestamp,
+    primary key (customer_id, tag)
+);
+
+create index if not exists customers_tags_tag_idx on customers_tags (tag);
+
+create table if not exists customers_segments 
+(
+    id          bigserial primary key,
+    name        text not null,
+    rules       jsonb not null default '{}',
+    manager_id  bigint references managers,
+    created     timestamp not null default current_timestamp
+);
+
+create index if not exists managers_boss_idx on managers (boss_id);
+
+create table if not exists sales_plans 
+(
+    id          bigserial primary key,
+    manager_id  bigint references managers,
+    departament text not null default '',
+    period      text not null,
+    start       date not null,
+    target      bigint not null check(target > 0),
+    currency    char(3) not null default 'TJS',
+    created     timestamp not null default current_timestamp
+);
+
+create unique index if not exists sales_plans_period_idx on sales_plans ((coalesce(manager_id, 0)), departament, period, start);
+
+create table if not exists commission_rules 
+(
+    id          bigserial primary key,
+    kind        text not null check(kind in ('flat', 'tiered', 'category')),
+    manager_id  bigint references managers,
+    category_id bigint references categories,
+    rate        integer not null check(rate >= 0 and rate < 10000),
+    min_percent float8 not null default 0,
+    created     timestamp not null default current_timestamp
+);
+
+create table if not exists payroll_runs 
+(
+    id          bigserial primary key,
+    start       date not null unique,
+    status      text not null default 'draft',
+    created_by  bigint not null references managers,
+    approved_by bigint references managers,
+    approved    timestamp,
+    created     timestamp not null default current_timestamp
+);
+
+create table if not exists payroll_lines 
+(
+    id          bigserial primary key,
+    run_id      bigint not null references payroll_runs,
+    manager_id  bigint not null references managers,
+    name        text not null,
+    salary      bigint not null default 0,
+    sales       bigint not null default 0,
+    returns     bigint not null default 0,
+    percent     float8 not null default 0,
+    commission  bigint not null default 0,
+    total       bigint not null default 0,
+    currency    char(3) not null default 'TJS',
+    details     jsonb not null default '[]',
+    unique (run_id, manager_id)
+);
+
+create index if not exists sales_shift_idx on sales (shift_id);
+create index if not exists payments_shift_idx on payments (shift_id);
+
+create index if not exists sales_created_idx on sales (created);
+
+create table if not exists inventory_snapshots 
+(
+    id            bigserial primary key,
+    currency      char(3) not null,
+    products      integer not null default 0,
+    units         bigint not null default 0,
+    retail_value  bigint not null default 0,
+    cost_value    bigint not null default 0,
+    cost_coverage float8 not null default 0,
+    low_stock     integer not null default 0,
+    taken         timestamp not null default current_timestamp
+);
+
+create index if not exists inventory_snapshots_taken_idx on inventory_snapshots (taken);
+
+create table if not exists inventory_snapshot_items 
+(
+    id            bigserial primary key,
+    product_id    bigint not null references products,
+    currency      char(3) not null,
+    qty           integer not null default 0,
+    reorder_point integer,
+    price         bigint not null default 0,
+    cost          bigint,
+    sold          bigint not null default 0,
+    velocity      float8 not null default 0,
+    days_of_cover float8,
+    low           boolean not null default false,
+    taken         timestamp not null default current_timestamp
+);
+
+create index if not exists inventory_snapshot_items_taken_idx on inventory_snapshot_items (taken);
+create index if not exists inventory_snapshot_items_product_idx on inventory_snapshot_items (product_id, taken);
+
+create index if not exists sales_customer_idx on sales (customer_id);
+create index if not exists customers_created_idx on customers (created);
+create index if not exists sales_paid_idx on sales (paid);
@@ -0,0 +1,24 @@
+-- taxes on products and sales
+create table if not exists categories 
+(
+    id      bigserial primary key,
+    name    text not null unique,
+    tax_rate integer not null default 0 check(tax_rate >= 0 and tax_rate < 10000),
+    created timestamp not null default current_timestamp 
+);
+
+alter table products add column if not exists category_id bigint references categories;
+alter table products add column if not exists tax_rate integer check(tax_rate >= 0 and tax_rate < 10000);
+alter table products add column if not exists tax_included boolean not null default true;
+
+alter table sales add column if not exists tax integer not null default 0;
+alter table sales add column if not exists total integer not null default 0;
+
+alter table sales_positions add column if not exists tax_rate integer not null default 0;
+alter table sales_positions add column if not exists tax_included boolean not null default true;
+alter table sales_positions add column if not exists tax integer not null default 0;
+alter table sales_positions add column if not exists total integer not null default 0;
+
+-- sales made before taxes were tracked are treated as tax free
+update sales_positions set total = price * qty where total = 0;
+update sales s set total = coalesce((select sum(sp.total) from sales_positions sp where sp.sale_id = s.id), 0) where total = 0;
@@ -0,0 +1,12 @@
+-- amounts in minor units as bigint with explicit currency
+alter table products alter column price type bigint;
+alter table products add column if not exists currency char(3) not null default 'TJS';
+
+alter table sales alter column tax type bigint;
+alter table sales alter column total type bigint;
+alter table sales add column if not exists currency char(3) not null default 'TJS';
+
+alter table sales_positions alter column price type bigint;
+alter table sales_positions alter column tax type bigint;
+alter table sales_positions alter column total type bigint;
+alter table sales_positions add column if not exists currency char(3) not null default 'TJS';
@@ -0,0 +1,15 @@
+-- payments against sales
+alter table sales add column if not exists status text not null default 'unpaid';
+
+create table if not exists payments 
+(
+    id          bigserial primary key,
+    sale_id     bigint not null references sales,
+    manager_id  bigint not null references managers,
+    method      text not null,
+    amount      bigint not null check(amount > 0),
+    change      bigint not null default 0 check(change >= 0),
+    currency    char(3) not null default 'TJS',
+    reference   text not null default '',
+    created     timestamp not null default current_timestamp 
+);
@@ -0,0 +1,7 @@
+-- sequential receipt numbers
+create table if not exists receipts 
+(
+    sale_id     bigint not null unique references sales,
+    number      bigint not null unique,
+    created     timestamp not null default current_timestamp 
+);
@@ -0,0 +1,33 @@
+-- customer carts and orders
+create table if not exists carts 
+(
+    customer_id bigint not null references customers,
+    product_id  bigint not null references products,
+    qty         integer not null check(qty > 0),
+    created     timestamp not null default current_timestamp,
+    primary key (customer_id, product_id)
+);
+
+create table if not exists orders 
+(
+    id          bigserial primary key,
+    customer_id bigint not null references customers,
+    manager_id  bigint references managers,
+    sale_id     bigint references sales,
+    status      text not null default 'new',
+    total       bigint not null default 0,
+    currency    char(3) not null default 'TJS',
+    expires     timestamp,
+    created     timestamp not null default current_timestamp,
+    updated     timestamp not null default current_timestamp
+);
+
+create table if not exists orders_items 
+(
+    id          bigserial primary key,
+    order_id    bigint not null references orders,
+    product_id  bigint not null references products,
+    price       bigint not null check(price >= 0),
+    currency    char(3) not null default 'TJS',
+    qty         integer not null check(qty > 0)
+);
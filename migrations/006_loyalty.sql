@@ -0,0 +1,16 @@
+-- loyalty points ledger
+alter table sales_positions add column if not exists discounted boolean not null default false;
+
+create table if not exists loyalty_ledger 
+(
+    id          bigserial primary key,
+    customer_id bigint not null references customers,
+    sale_id     bigint references sales,
+    kind        text not null,
+    points      bigint not null,
+    remaining   bigint not null default 0 check(remaining >= 0),
+    expires     timestamp,
+    created     timestamp not null default current_timestamp
+);
+
+create unique index if not exists loyalty_ledger_earn_idx on loyalty_ledger (sale_id) where kind = 'earn';
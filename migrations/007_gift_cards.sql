@@ -0,0 +1,37 @@
+-- gift cards and store credit
+create table if not exists gift_cards 
+(
+    id          bigserial primary key,
+    code        text not null unique,
+    initial     bigint not null check(initial > 0),
+    balance     bigint not null check(balance >= 0),
+    currency    char(3) not null default 'TJS',
+    expires     timestamp,
+    active      boolean not null default true,
+    manager_id  bigint references managers,
+    created     timestamp not null default current_timestamp
+);
+
+create table if not exists gift_cards_transactions 
+(
+    id          bigserial primary key,
+    card_id     bigint not null references gift_cards,
+    sale_id     bigint references sales,
+    manager_id  bigint references managers,
+    kind        text not null,
+    amount      bigint not null,
+    created     timestamp not null default current_timestamp
+);
+
+create table if not exists store_credits 
+(
+    id          bigserial primary key,
+    customer_id bigint not null references customers,
+    sale_id     bigint references sales,
+    manager_id  bigint references managers,
+    kind        text not null,
+    amount      bigint not null,
+    currency    char(3) not null default 'TJS',
+    reason      text not null default '',
+    created     timestamp not null default current_timestamp
+);
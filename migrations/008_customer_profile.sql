@@ -0,0 +1,22 @@
+-- customer self-service profile
+alter table customers add column if not exists email text not null default '';
+alter table customers add column if not exists deletion_requested timestamp;
+
+create table if not exists customers_phone_changes 
+(
+    id          bigserial primary key,
+    customer_id bigint not null unique references customers,
+    phone       text not null,
+    code        text not null,
+    attempts    integer not null default 0,
+    expire      timestamp not null,
+    created     timestamp not null default current_timestamp
+);
+
+create table if not exists customers_deletion_requests 
+(
+    id          bigserial primary key,
+    customer_id bigint not null unique references customers,
+    reason      text not null default '',
+    created     timestamp not null default current_timestamp
+);
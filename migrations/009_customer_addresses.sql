@@ -0,0 +1,18 @@
+-- customer addresses and contact preferences
+alter table customers add column if not exists sms_opt_in boolean not null default false;
+alter table customers add column if not exists email_opt_in boolean not null default false;
+
+create table if not exists customers_addresses 
+(
+    id          bigserial primary key,
+    customer_id bigint not null references customers,
+    label       text not null default '',
+    line        text not null,
+    city        text not null,
+    lat         double precision check(lat between -90 and 90),
+    lng         double precision check(lng between -180 and 180),
+    is_default  boolean not null default false,
+    created     timestamp not null default current_timestamp
+);
+
+create unique index if not exists customers_addresses_default_idx on customers_addresses (customer_id) where is_default;
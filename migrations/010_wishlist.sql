@@ -0,0 +1,28 @@
+-- wishlist, back-in-stock subscriptions and stock receipts
+create table if not exists wishlists 
+(
+    customer_id bigint not null references customers,
+    product_id  bigint not null references products,
+    created     timestamp not null default current_timestamp,
+    primary key (customer_id, product_id)
+);
+
+create table if not exists stock_subscriptions 
+(
+    id          bigserial primary key,
+    customer_id bigint not null references customers,
+    product_id  bigint not null references products,
+    notified    timestamp,
+    created     timestamp not null default current_timestamp
+);
+
+create unique index if not exists stock_subscriptions_pending_idx on stock_subscriptions (customer_id, product_id) where notified is null;
+
+create table if not exists stock_receipts 
+(
+    id          bigserial primary key,
+    product_id  bigint not null references products,
+    manager_id  bigint references managers,
+    qty         integer not null check(qty > 0),
+    created     timestamp not null default current_timestamp
+);
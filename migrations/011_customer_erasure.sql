@@ -0,0 +1,3 @@
+-- personal data erasure
+alter table customers add column if not exists erased timestamp;
+alter table customers_deletion_requests add column if not exists processed timestamp;
@@ -0,0 +1,13 @@
+-- duplicate customers merge
+alter table customers add column if not exists merged_into bigint references customers;
+
+create table if not exists customers_merges 
+(
+    id           bigserial primary key,
+    survivor_id  bigint not null references customers,
+    duplicate_id bigint not null references customers,
+    manager_id   bigint references managers,
+    snapshot     jsonb not null,
+    moved        jsonb not null,
+    created      timestamp not null default current_timestamp
+);
@@ -0,0 +1,28 @@
+-- customer notes, tags and segments
+create table if not exists customers_notes 
+(
+    id          bigserial primary key,
+    customer_id bigint not null references customers,
+    manager_id  bigint not null references managers,
+    text        text not null,
+    created     timestamp not null default current_timestamp
+);
+
+create table if not exists customers_tags 
+(
+    customer_id bigint not null references customers,
+    tag         text not null,
+    created     timestamp not null default current_timestamp,
+    primary key (customer_id, tag)
+);
+
+create index if not exists customers_tags_tag_idx on customers_tags (tag);
+
+create table if not exists customers_segments 
+(
+    id          bigserial primary key,
+    name        text not null,
+    rules       jsonb not null default '{}',
+    manager_id  bigint references managers,
+    created     timestamp not null default current_timestamp
+);
@@ -0,0 +1,2 @@
+-- manager hierarchy
+create index if not exists managers_boss_idx on managers (boss_id);
@@ -0,0 +1,14 @@
+-- sales plans per period
+create table if not exists sales_plans 
+(
+    id          bigserial primary key,
+    manager_id  bigint references managers,
+    departament text not null default '',
+    period      text not null,
+    start       date not null,
+    target      bigint not null check(target > 0),
+    currency    char(3) not null default 'TJS',
+    created     timestamp not null default current_timestamp
+);
+
+create unique index if not exists sales_plans_period_idx on sales_plans ((coalesce(manager_id, 0)), departament, period, start);
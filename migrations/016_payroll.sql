@@ -0,0 +1,39 @@
+-- commission rules and payroll runs
+create table if not exists commission_rules 
+(
+    id          bigserial primary key,
+    kind        text not null check(kind in ('flat', 'tiered', 'category')),
+    manager_id  bigint references managers,
+    category_id bigint references categories,
+    rate        integer not null check(rate >= 0 and rate < 10000),
+    min_percent float8 not null default 0,
+    created     timestamp not null default current_timestamp
+);
+
+create table if not exists payroll_runs 
+(
+    id          bigserial primary key,
+    start       date not null unique,
+    status      text not null default 'draft',
+    created_by  bigint not null references managers,
+    approved_by bigint references managers,
+    approved    timestamp,
+    created     timestamp not null default current_timestamp
+);
+
+create table if not exists payroll_lines 
+(
+    id          bigserial primary key,
+    run_id      bigint not null references payroll_runs,
+    manager_id  bigint not null references managers,
+    name        text not null,
+    salary      bigint not null default 0,
+    sales       bigint not null default 0,
+    returns     bigint not null default 0,
+    percent     float8 not null default 0,
+    commission  bigint not null default 0,
+    total       bigint not null default 0,
+    currency    char(3) not null default 'TJS',
+    details     jsonb not null default '[]',
+    unique (run_id, manager_id)
+);
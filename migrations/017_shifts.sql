@@ -0,0 +1,20 @@
+-- work shifts with cash drawer sessions
+create table if not exists shifts 
+(
+    id          bigserial primary key,
+    manager_id  bigint not null references managers,
+    opening     bigint not null default 0 check(opening >= 0),
+    currency    char(3) not null default 'TJS',
+    counted     bigint check(counted >= 0),
+    note        text not null default '',
+    opened      timestamp not null default current_timestamp,
+    closed      timestamp
+);
+
+create unique index if not exists shifts_open_idx on shifts (manager_id) where closed is null;
+
+alter table sales add column if not exists shift_id bigint references shifts;
+alter table payments add column if not exists shift_id bigint references shifts;
+
+create index if not exists sales_shift_idx on sales (shift_id);
+create index if not exists payments_shift_idx on payments (shift_id);
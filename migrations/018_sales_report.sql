@@ -0,0 +1,5 @@
+-- product costs for sales margin
+alter table products add column if not exists cost bigint check(cost >= 0);
+alter table sales_positions add column if not exists cost bigint;
+
+create index if not exists sales_created_idx on sales (created);
@@ -0,0 +1,4 @@
+-- product sku for catalog import
+alter table products add column if not exists sku text;
+
+create unique index if not exists products_sku_idx on products (sku);
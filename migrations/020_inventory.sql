@@ -0,0 +1,17 @@
+-- reorder points and stock valuation snapshots
+alter table products add column if not exists reorder_point integer check(reorder_point >= 0);
+
+create table if not exists inventory_snapshots 
+(
+    id            bigserial primary key,
+    currency      char(3) not null,
+    products      integer not null default 0,
+    units         bigint not null default 0,
+    retail_value  bigint not null default 0,
+    cost_value    bigint not null default 0,
+    cost_coverage float8 not null default 0,
+    low_stock     integer not null default 0,
+    taken         timestamp not null default current_timestamp
+);
+
+create index if not exists inventory_snapshots_taken_idx on inventory_snapshots (taken);
@@ -0,0 +1,3 @@
+-- indexes of customer lifetime value and cohorts
+create index if not exists sales_customer_idx on sales (customer_id);
+create index if not exists customers_created_idx on customers (created);
@@ -0,0 +1,16 @@
+-- outbox of notifications, they are sent by a worker after the transaction that caused them
+create table if not exists notifications_outbox 
+(
+    id           bigserial primary key,
+    customer_id  bigint not null references customers,
+    kind         text not null,
+    product_id   bigint references products,
+    text         text not null,
+    attempts     integer not null default 0,
+    error        text not null default '',
+    next_attempt timestamp not null default current_timestamp,
+    sent         timestamp,
+    created      timestamp not null default current_timestamp
+);
+
+create index if not exists notifications_outbox_pending_idx on notifications_outbox (next_attempt) where sent is null;
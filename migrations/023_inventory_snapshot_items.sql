@@ -0,0 +1,19 @@
+-- stock of every product taken with each inventory snapshot, for low stock and days of cover trends
+create table if not exists inventory_snapshot_items 
+(
+    id            bigserial primary key,
+    product_id    bigint not null references products,
+    currency      char(3) not null,
+    qty           integer not null default 0,
+    reorder_point integer,
+    price         bigint not null default 0,
+    cost          bigint,
+    sold          bigint not null default 0,
+    velocity      float8 not null default 0,
+    days_of_cover float8,
+    low           boolean not null default false,
+    taken         timestamp not null default current_timestamp
+);
+
+create index if not exists inventory_snapshot_items_taken_idx on inventory_snapshot_items (taken);
+create index if not exists inventory_snapshot_items_product_idx on inventory_snapshot_items (product_id, taken);
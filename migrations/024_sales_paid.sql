@@ -0,0 +1,7 @@
+-- time the sale became paid, commission is earned in the month of payment
+alter table sales add column if not exists paid timestamp;
+
+update sales s set paid = coalesce((select max(p.created) from payments p where p.sale_id = s.id), s.created)
+where s.status = 'paid' and s.paid is null;
+
+create index if not exists sales_paid_idx on sales (paid);
@@ -0,0 +1,4 @@
+-- time the manager was deactivated, payroll pays salary up to that day
+alter table managers add column if not exists deactivated timestamp;
+
+update managers set deactivated = current_timestamp where not active and deactivated is null;
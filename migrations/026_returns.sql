@@ -0,0 +1,31 @@
+-- returned positions of sales, store credit is issued from a return.
+-- Credit issued before has no return and is not counted as a return by payroll and analytics.
+create table if not exists returns 
+(
+    id          bigserial primary key,
+    sale_id     bigint not null references sales,
+    customer_id bigint not null references customers,
+    manager_id  bigint not null references managers,
+    total       bigint not null default 0,
+    currency    char(3) not null default 'TJS',
+    reason      text not null default '',
+    created     timestamp not null default current_timestamp
+);
+
+create index if not exists returns_sale_idx on returns (sale_id);
+
+create table if not exists returns_positions 
+(
+    id          bigserial primary key,
+    return_id   bigint not null references returns,
+    position_id bigint not null references sales_positions,
+    product_id  bigint not null references products,
+    qty         integer not null check(qty > 0),
+    total       bigint not null default 0,
+    created     timestamp not null default current_timestamp
+);
+
+create index if not exists returns_positions_position_idx on returns_positions (position_id);
+create index if not exists returns_positions_return_idx on returns_positions (return_id);
+
+alter table store_credits add column if not exists return_id bigint references returns;
@@ -0,0 +1,5 @@
+-- receipts are numbered when a sale becomes paid, paid sales not viewed yet get numbers in order of payment
+insert into receipts(sale_id, number, created)
+select s.id, (select coalesce(max(number), 0) from receipts) + row_number() over (order by s.paid, s.id), s.paid
+from sales s
+where s.status = 'paid' and not exists(select 1 from receipts r where r.sale_id = s.id);
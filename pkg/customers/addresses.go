@@ -0,0 +1,223 @@
+package customers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+//ErrInvalidAddress - line or city is empty or coordinates are out of range
+var ErrInvalidAddress = errors.New("invalid address")
+
+//Address - delivery address of the customer
+type Address struct {
+	ID         int64     `json:"id"`
+	CustomerID int64     `json:"customer_id"`
+	Label      string    `json:"label"`
+	Line       string    `json:"line"`
+	City       string    `json:"city"`
+	Lat        *float64  `json:"lat"`
+	Lng        *float64  `json:"lng"`
+	IsDefault  bool      `json:"is_default"`
+	Created    time.Time `json:"created"`
+}
+
+//Preferences - channels the customer agreed to be contacted through
+type Preferences struct {
+	SMS   bool `json:"sms"`
+	Email bool `json:"email"`
+}
+
+//Addresses returns addresses of the customer, default one first
+func (s *Service) Addresses(ctx context.Context, customerID int64) ([]*Address, error) {
+	items := make([]*Address, 0)
+
+	rows, err := s.pool.Query(ctx, `
+	SELECT id, customer_id, label, line, city, lat, lng, is_default, created
+	FROM customers_addresses WHERE customer_id = $1
+	ORDER BY is_default DESC, id
+	`, customerID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Address{}
+		err = rows.Scan(&item.ID, &item.CustomerID, &item.Label, &item.Line, &item.City, &item.Lat, &item.Lng, &item.IsDefault, &item.Created)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+//SaveAddress creates address when ID is 0 and updates it otherwise.
+//The first address of the customer becomes default.
+func (s *Service) SaveAddress(ctx context.Context, customerID int64, address *Address) (*Address, error) {
+	address.Label = strings.TrimSpace(address.Label)
+	address.Line = strings.TrimSpace(address.Line)
+	address.City = strings.TrimSpace(address.City)
+	if address.Line == "" || address.City == "" {
+		return nil, ErrInvalidAddress
+	}
+	if (address.Lat == nil) != (address.Lng == nil) {
+		return nil, ErrInvalidAddress
+	}
+	if address.Lat != nil && (*address.Lat < -90 || *address.Lat > 90 || *address.Lng < -180 || *address.Lng > 180) {
+		return nil, ErrInvalidAddress
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	// serializes default flag changes of one customer
+	_, err = tx.Exec(ctx, `SELECT id FROM customers WHERE id = $1 FOR UPDATE`, customerID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	count := 0
+	err = tx.QueryRow(ctx, `SELECT count(*) FROM customers_addresses WHERE customer_id = $1 AND id <> $2`, customerID, address.ID).Scan(&count)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	if count == 0 {
+		address.IsDefault = true
+	}
+	if address.IsDefault {
+		_, err = tx.Exec(ctx, `UPDATE customers_addresses SET is_default = false WHERE customer_id = $1 AND is_default`, customerID)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+	}
+
+	item := &Address{}
+	if address.ID == 0 {
+		err = tx.QueryRow(ctx, `
+		INSERT INTO customers_addresses(customer_id, label, line, city, lat, lng, is_default)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)
+		RETURNING id, customer_id, label, line, city, lat, lng, is_default, created
+		`, customerID, address.Label, address.Line, address.City, address.Lat, address.Lng, address.IsDefault).
+			Scan(&item.ID, &item.CustomerID, &item.Label, &item.Line, &item.City, &item.Lat, &item.Lng, &item.IsDefault, &item.Created)
+	} else {
+		// the default address can't be unset directly, another one has to be made default
+		err = tx.QueryRow(ctx, `
+		UPDATE customers_addresses SET label = $3, line = $4, city = $5, lat = $6, lng = $7, is_default = is_default OR $8
+		WHERE id = $1 AND customer_id = $2
+		RETURNING id, customer_id, label, line, city, lat, lng, is_default, created
+		`, address.ID, customerID, address.Label, address.Line, address.City, address.Lat, address.Lng, address.IsDefault).
+			Scan(&item.ID, &item.CustomerID, &item.Label, &item.Line, &item.City, &item.Lat, &item.Lng, &item.IsDefault, &item.Created)
+	}
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+//RemoveAddress deletes address, the oldest remaining one becomes default when needed
+func (s *Service) RemoveAddress(ctx context.Context, customerID int64, id int64) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `SELECT id FROM customers WHERE id = $1 FOR UPDATE`, customerID)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	isDefault := false
+	err = tx.QueryRow(ctx, `
+	DELETE FROM customers_addresses WHERE id = $1 AND customer_id = $2 RETURNING is_default
+	`, id, customerID).Scan(&isDefault)
+	if err == pgx.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	if isDefault {
+		_, err = tx.Exec(ctx, `
+		UPDATE customers_addresses SET is_default = true
+		WHERE id = (SELECT min(id) FROM customers_addresses WHERE customer_id = $1)
+		`, customerID)
+		if err != nil {
+			log.Print(err)
+			return ErrInternal
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+//Preferences ...
+func (s *Service) Preferences(ctx context.Context, customerID int64) (*Preferences, error) {
+	item := &Preferences{}
+	err := s.pool.QueryRow(ctx, `
+	SELECT sms_opt_in, email_opt_in FROM customers WHERE id = $1
+	`, customerID).Scan(&item.SMS, &item.Email)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+//SavePreferences ...
+func (s *Service) SavePreferences(ctx context.Context, customerID int64, preferences *Preferences) (*Preferences, error) {
+	item := &Preferences{}
+	err := s.pool.QueryRow(ctx, `
+	UPDATE customers SET sms_opt_in = $2, email_opt_in = $3 WHERE id = $1 RETURNING sms_opt_in, email_opt_in
+	`, customerID, preferences.SMS, preferences.Email).Scan(&item.SMS, &item.Email)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
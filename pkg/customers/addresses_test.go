@@ -0,0 +1,95 @@
+package customers_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/manucher051299/crud/pkg/customers"
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/phones"
+)
+
+func TestDefaultAddress(t *testing.T) {
+	pool := dbtest.Pool(t)
+	ctx := context.Background()
+	svc := customers.NewService(pool, customers.LogSender{}, phones.Config{DefaultRegion: "TJ"})
+	customerID := dbtest.Customer(t, pool)
+
+	save := func(address *customers.Address) *customers.Address {
+		t.Helper()
+		item, err := svc.SaveAddress(ctx, customerID, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return item
+	}
+	// defaultAddress checks that exactly one address is default and that it is listed first
+	defaultAddress := func() int64 {
+		t.Helper()
+		items, err := svc.Addresses(ctx, customerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defaults := 0
+		for _, item := range items {
+			if item.IsDefault {
+				defaults++
+			}
+		}
+		if len(items) == 0 || defaults != 1 || !items[0].IsDefault {
+			t.Fatalf("%d default addresses of %d, want one listed first", defaults, len(items))
+		}
+		return items[0].ID
+	}
+
+	home := save(&customers.Address{Label: "home", Line: "Rudaki 1", City: "Dushanbe"})
+	if !home.IsDefault {
+		t.Errorf("the first address is not default")
+	}
+	work := save(&customers.Address{Label: "work", Line: "Somoni 2", City: "Dushanbe"})
+	if work.IsDefault || defaultAddress() != home.ID {
+		t.Errorf("the second address took the default from the first one")
+	}
+
+	shop := save(&customers.Address{Label: "shop", Line: "Ismoili Somoni 3", City: "Khujand", IsDefault: true})
+	if !shop.IsDefault || defaultAddress() != shop.ID {
+		t.Errorf("the address saved as default is not the only default one")
+	}
+
+	// the default flag moves to another address, it is not simply unset
+	shop.IsDefault = false
+	shop = save(shop)
+	if !shop.IsDefault || defaultAddress() != shop.ID {
+		t.Errorf("the default address lost the flag without another default")
+	}
+	home.IsDefault = true
+	save(home)
+	if defaultAddress() != home.ID {
+		t.Errorf("updated address did not become default")
+	}
+
+	other := dbtest.Customer(t, pool)
+	_, err := svc.SaveAddress(ctx, other, &customers.Address{ID: work.ID, Line: "Somoni 2", City: "Dushanbe", IsDefault: true})
+	if err != customers.ErrNotFound {
+		t.Errorf("address of another customer: err = %v, want %v", err, customers.ErrNotFound)
+	}
+	if defaultAddress() != home.ID {
+		t.Errorf("refused update changed the default address")
+	}
+
+	// the oldest remaining address takes over the default
+	err = svc.RemoveAddress(ctx, customerID, home.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaultAddress() != work.ID {
+		t.Errorf("the oldest remaining address did not become default")
+	}
+	err = svc.RemoveAddress(ctx, customerID, shop.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaultAddress() != work.ID {
+		t.Errorf("removing another address changed the default one")
+	}
+}
@@ -0,0 +1,318 @@
+package customers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//ErrInvalidMerge - customer can't be merged into itself or into a merged customer
+var ErrInvalidMerge = errors.New("invalid merge")
+
+//NameSimilarity - names this similar (0..1) are reported as likely duplicates
+const NameSimilarity = 0.85
+
+//Duplicate - pair of customers that are probably the same person
+type Duplicate struct {
+	First  *Customer `json:"first"`
+	Second *Customer `json:"second"`
+	//Reason - "phone" when numbers are equal after normalization, "name" otherwise
+	Reason string  `json:"reason"`
+	Score  float64 `json:"score"`
+}
+
+//Merge - audit record of one merge
+type Merge struct {
+	ID          int64            `json:"id"`
+	SurvivorID  int64            `json:"survivor_id"`
+	DuplicateID int64            `json:"duplicate_id"`
+	ManagerID   int64            `json:"manager_id"`
+	Moved       map[string]int64 `json:"moved"`
+}
+
+//Duplicates finds likely duplicates among active customers, best matches first
+func (s *Service) Duplicates(ctx context.Context, limit int) ([]*Duplicate, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	items, err := s.AllActive(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make([]string, len(items))
+	for i, item := range items {
+		numbers[i], err = s.phones.Normalize(item.Phone)
+		if err != nil {
+			numbers[i] = ""
+		}
+	}
+
+	duplicates := findDuplicates(items, numbers)
+	if len(duplicates) > limit {
+		duplicates = duplicates[:limit]
+	}
+	return duplicates, nil
+}
+
+//findDuplicates compares customers only with likely candidates instead of every pair:
+//by equal normalized numbers, and by names starting with the same letter and close enough in length.
+//A typo in the first letter of the name is missed, such pairs are still found by phone.
+func findDuplicates(items []*Customer, numbers []string) []*Duplicate {
+	names := make([]string, len(items))
+	lengths := make([]int, len(items))
+	byPhone := make(map[string][]int)
+	byLetter := make(map[rune][]int)
+	for i, item := range items {
+		names[i] = normalizeName(item.Name)
+		lengths[i] = utf8.RuneCountInString(names[i])
+		if numbers[i] != "" {
+			byPhone[numbers[i]] = append(byPhone[numbers[i]], i)
+		}
+		if names[i] != "" {
+			letter, _ := utf8.DecodeRuneInString(names[i])
+			byLetter[letter] = append(byLetter[letter], i)
+		}
+	}
+
+	duplicates := make([]*Duplicate, 0)
+	// indexes in groups are ascending, so a pair is always [smaller, larger]
+	found := make(map[[2]int]bool)
+	for _, group := range byPhone {
+		for a := 0; a < len(group); a++ {
+			for b := a + 1; b < len(group); b++ {
+				found[[2]int{group[a], group[b]}] = true
+				duplicates = append(duplicates, &Duplicate{First: items[group[a]], Second: items[group[b]], Reason: "phone", Score: 1})
+			}
+		}
+	}
+	for _, group := range byLetter {
+		for a := 0; a < len(group); a++ {
+			for b := a + 1; b < len(group); b++ {
+				i, j := group[a], group[b]
+				if found[[2]int{i, j}] || !closeLength(lengths[i], lengths[j]) {
+					continue
+				}
+				score := similarity(names[i], names[j])
+				if score >= NameSimilarity {
+					duplicates = append(duplicates, &Duplicate{First: items[i], Second: items[j], Reason: "name", Score: score})
+				}
+			}
+		}
+	}
+
+	// groups come from maps, ids make the order stable
+	sort.Slice(duplicates, func(i, j int) bool {
+		if duplicates[i].Score != duplicates[j].Score {
+			return duplicates[i].Score > duplicates[j].Score
+		}
+		if duplicates[i].First.ID != duplicates[j].First.ID {
+			return duplicates[i].First.ID < duplicates[j].First.ID
+		}
+		return duplicates[i].Second.ID < duplicates[j].Second.ID
+	})
+	return duplicates
+}
+
+//closeLength - edit distance is at least the difference of lengths, longer differences can't reach NameSimilarity
+func closeLength(a int, b int) bool {
+	longer, diff := a, a-b
+	if b > a {
+		longer, diff = b, b-a
+	}
+	return longer == 0 || 1-float64(diff)/float64(longer) >= NameSimilarity
+}
+
+//Merge moves everything of the duplicate to the survivor and deactivates the duplicate
+func (s *Service) Merge(ctx context.Context, managerID int64, survivorID int64, duplicateID int64) (*Merge, error) {
+	if survivorID == duplicateID {
+		return nil, ErrInvalidMerge
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	// both rows are locked in id order, so concurrent merges can't deadlock
+	rows, err := tx.Query(ctx, `
+	SELECT id, merged_into FROM customers WHERE id IN ($1, $2) ORDER BY id FOR UPDATE
+	`, survivorID, duplicateID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	found := 0
+	for rows.Next() {
+		var id int64
+		var mergedInto *int64
+		err = rows.Scan(&id, &mergedInto)
+		if err != nil {
+			rows.Close()
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		if mergedInto != nil {
+			rows.Close()
+			return nil, ErrInvalidMerge
+		}
+		found++
+	}
+	rows.Close()
+	if found != 2 {
+		return nil, ErrNotFound
+	}
+
+	var snapshot []byte
+	err = tx.QueryRow(ctx, `SELECT to_jsonb(c) - 'password' FROM customers c WHERE id = $1`, duplicateID).Scan(&snapshot)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	item := &Merge{SurvivorID: survivorID, DuplicateID: duplicateID, ManagerID: managerID, Moved: make(map[string]int64)}
+	steps := []struct {
+		name  string
+		query string
+	}{
+		{"sales", `UPDATE sales SET customer_id = $1 WHERE customer_id = $2`},
+		{"orders", `UPDATE orders SET customer_id = $1 WHERE customer_id = $2`},
+		{"tokens", `UPDATE customers_tokens SET customer_id = $1 WHERE customer_id = $2`},
+		{"loyalty", `UPDATE loyalty_ledger SET customer_id = $1 WHERE customer_id = $2`},
+		{"returns", `UPDATE returns SET customer_id = $1 WHERE customer_id = $2`},
+		{"store_credit", `UPDATE store_credits SET customer_id = $1 WHERE customer_id = $2`},
+		{"addresses", `UPDATE customers_addresses SET customer_id = $1, is_default = is_default AND NOT exists(
+			SELECT 1 FROM customers_addresses WHERE customer_id = $1 AND is_default) WHERE customer_id = $2`},
+		{"cart", `INSERT INTO carts(customer_id, product_id, qty, created)
+			SELECT $1, product_id, qty, created FROM carts WHERE customer_id = $2
+			ON CONFLICT (customer_id, product_id) DO UPDATE SET qty = carts.qty + excluded.qty`},
+		{"wishlist", `INSERT INTO wishlists(customer_id, product_id, created)
+			SELECT $1, product_id, created FROM wishlists WHERE customer_id = $2
+			ON CONFLICT (customer_id, product_id) DO NOTHING`},
+		{"notes", `UPDATE customers_notes SET customer_id = $1 WHERE customer_id = $2`},
+		{"tags", `INSERT INTO customers_tags(customer_id, tag, created)
+			SELECT $1, tag, created FROM customers_tags WHERE customer_id = $2
+			ON CONFLICT (customer_id, tag) DO NOTHING`},
+		{"stock_subscriptions", `UPDATE stock_subscriptions ss SET customer_id = $1 WHERE customer_id = $2
+			AND NOT exists(SELECT 1 FROM stock_subscriptions o WHERE o.customer_id = $1 AND o.product_id = ss.product_id
+				AND o.notified IS NULL AND ss.notified IS NULL)`},
+	}
+	for _, step := range steps {
+		tag, err := tx.Exec(ctx, step.query, survivorID, duplicateID)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item.Moved[step.name] = tag.RowsAffected()
+	}
+
+	for _, query := range []string{
+		`DELETE FROM carts WHERE customer_id = $1`,
+		`DELETE FROM wishlists WHERE customer_id = $1`,
+		`DELETE FROM stock_subscriptions WHERE customer_id = $1`,
+		`DELETE FROM customers_tags WHERE customer_id = $1`,
+		`DELETE FROM customers_phone_changes WHERE customer_id = $1`,
+		`DELETE FROM customers_deletion_requests WHERE customer_id = $1`,
+	} {
+		_, err = tx.Exec(ctx, query, duplicateID)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+	}
+
+	// survivor keeps own contacts, empty ones are taken from the duplicate
+	_, err = tx.Exec(ctx, `
+	UPDATE customers s SET email = CASE WHEN s.email = '' THEN d.email ELSE s.email END
+	FROM customers d WHERE s.id = $1 AND d.id = $2
+	`, survivorID, duplicateID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	// phone is freed, the old number stays in the audit snapshot
+	_, err = tx.Exec(ctx, `
+	UPDATE customers SET active = false, phone = 'merged-' || id, merged_into = $1 WHERE id = $2
+	`, survivorID, duplicateID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	moved, err := json.Marshal(item.Moved)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	err = tx.QueryRow(ctx, `
+	INSERT INTO customers_merges(survivor_id, duplicate_id, manager_id, snapshot, moved) VALUES ($1,$2,$3,$4,$5) RETURNING id
+	`, survivorID, duplicateID, managerID, snapshot, moved).Scan(&item.ID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+//normalizeName lowercases name and sorts its words, so "Ali Karimov" equals "karimov  ali"
+func normalizeName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+//similarity - 1 minus edit distance relative to the longer string
+func similarity(a string, b string) float64 {
+	first, second := []rune(a), []rune(b)
+	longer := len(first)
+	if len(second) > longer {
+		longer = len(second)
+	}
+	if longer == 0 {
+		return 1
+	}
+
+	previous := make([]int, len(second)+1)
+	current := make([]int, len(second)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(first); i++ {
+		current[0] = i
+		for j := 1; j <= len(second); j++ {
+			cost := 1
+			if first[i-1] == second[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return 1 - float64(previous[len(second)])/float64(longer)
+}
+
+func minInt(values ...int) int {
+	result := values[0]
+	for _, value := range values[1:] {
+		if value < result {
+			result = value
+		}
+	}
+	return result
+}
@@ -0,0 +1,64 @@
+package customers
+
+import "testing"
+
+func TestFindDuplicates(t *testing.T) {
+	items := []*Customer{
+		{ID: 1, Name: "Ali Karimov"},
+		{ID: 2, Name: "karimov  ali"},
+		{ID: 3, Name: "Ali Karimow"},
+		{ID: 4, Name: "Zarina Rahimova"},
+		{ID: 5, Name: "Someone Else"},
+		{ID: 6, Name: ""},
+	}
+	numbers := []string{"+992900000001", "", "", "+992900000002", "+992900000002", ""}
+
+	duplicates := findDuplicates(items, numbers)
+
+	type pair struct {
+		first, second int64
+		reason        string
+	}
+	// equal names after normalization score as high as equal phones, ties go by ids
+	want := []pair{{1, 2, "name"}, {4, 5, "phone"}, {1, 3, "name"}, {2, 3, "name"}}
+	if len(duplicates) != len(want) {
+		for _, item := range duplicates {
+			t.Logf("%d %d %s %.2f", item.First.ID, item.Second.ID, item.Reason, item.Score)
+		}
+		t.Fatalf("found %d duplicates, want %d", len(duplicates), len(want))
+	}
+	for i, item := range duplicates {
+		got := pair{item.First.ID, item.Second.ID, item.Reason}
+		if got != want[i] {
+			t.Errorf("duplicate %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestFindDuplicatesPhoneAndName(t *testing.T) {
+	items := []*Customer{{ID: 1, Name: "Ali Karimov"}, {ID: 2, Name: "Ali Karimov"}}
+	numbers := []string{"+992900000001", "+992900000001"}
+
+	duplicates := findDuplicates(items, numbers)
+	if len(duplicates) != 1 || duplicates[0].Reason != "phone" {
+		t.Errorf("pair with equal phone and name must be reported once by phone, got %d", len(duplicates))
+	}
+}
+
+func TestCloseLength(t *testing.T) {
+	tests := []struct {
+		a, b int
+		want bool
+	}{
+		{0, 0, true},
+		{20, 20, true},
+		{20, 17, true},
+		{20, 16, false},
+		{3, 20, false},
+	}
+	for _, test := range tests {
+		if got := closeLength(test.a, test.b); got != test.want {
+			t.Errorf("closeLength(%d, %d) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package customers
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//ErrOpenOrders - customer can't be erased while orders are in progress
+var ErrOpenOrders = errors.New("customer has open orders")
+
+//Export - everything stored about the customer, one section per table
+type Export struct {
+	CustomerID int64                      `json:"customer_id"`
+	Generated  time.Time                  `json:"generated"`
+	Sections   map[string]json.RawMessage `json:"sections"`
+}
+
+//exportSections - every query takes customer id and returns a json value.
+//Secrets (password hash, tokens, verification codes) are left out.
+var exportSections = []struct {
+	name  string
+	query string
+}{
+	{"profile", `SELECT to_jsonb(c) - 'password' FROM customers c WHERE id = $1`},
+	{"addresses", `SELECT coalesce(jsonb_agg(to_jsonb(a) ORDER BY a.id), '[]') FROM customers_addresses a WHERE customer_id = $1`},
+	{"sessions", `SELECT coalesce(jsonb_agg(jsonb_build_object('created', t.created, 'expire', t.expire) ORDER BY t.created), '[]')
+		FROM customers_tokens t WHERE customer_id = $1`},
+	{"purchases", `SELECT coalesce(jsonb_agg(to_jsonb(s) || jsonb_build_object('positions',
+		(SELECT coalesce(jsonb_agg(to_jsonb(sp) ORDER BY sp.id), '[]') FROM sales_positions sp WHERE sp.sale_id = s.id)) ORDER BY s.id), '[]')
+		FROM sales s WHERE customer_id = $1`},
+	{"payments", `SELECT coalesce(jsonb_agg(to_jsonb(p) ORDER BY p.id), '[]') FROM payments p JOIN sales s ON s.id = p.sale_id WHERE s.customer_id = $1`},
+	{"cart", `SELECT coalesce(jsonb_agg(to_jsonb(c) ORDER BY c.created), '[]') FROM carts c WHERE customer_id = $1`},
+	{"orders", `SELECT coalesce(jsonb_agg(to_jsonb(o) || jsonb_build_object('items',
+		(SELECT coalesce(jsonb_agg(to_jsonb(oi) ORDER BY oi.id), '[]') FROM orders_items oi WHERE oi.order_id = o.id)) ORDER BY o.id), '[]')
+		FROM orders o WHERE customer_id = $1`},
+	{"returns", `SELECT coalesce(jsonb_agg(to_jsonb(r) || jsonb_build_object('positions',
+		(SELECT coalesce(jsonb_agg(to_jsonb(rp) ORDER BY rp.id), '[]') FROM returns_positions rp WHERE rp.return_id = r.id)) ORDER BY r.id), '[]')
+		FROM returns r WHERE customer_id = $1`},
+	{"loyalty", `SELECT coalesce(jsonb_agg(to_jsonb(l) ORDER BY l.id), '[]') FROM loyalty_ledger l WHERE customer_id = $1`},
+	{"store_credit", `SELECT coalesce(jsonb_agg(to_jsonb(sc) ORDER BY sc.id), '[]') FROM store_credits sc WHERE customer_id = $1`},
+	{"wishlist", `SELECT coalesce(jsonb_agg(to_jsonb(w) ORDER BY w.created), '[]') FROM wishlists w WHERE customer_id = $1`},
+	{"stock_subscriptions", `SELECT coalesce(jsonb_agg(to_jsonb(ss) ORDER BY ss.id), '[]') FROM stock_subscriptions ss WHERE customer_id = $1`},
+	{"notes", `SELECT coalesce(jsonb_agg(jsonb_build_object('text', n.text, 'created', n.created) ORDER BY n.id), '[]')
+		FROM customers_notes n WHERE customer_id = $1`},
+	{"tags", `SELECT coalesce(jsonb_agg(t.tag ORDER BY t.tag), '[]') FROM customers_tags t WHERE customer_id = $1`},
+	{"merges", `SELECT coalesce(jsonb_agg(to_jsonb(m) ORDER BY m.id), '[]') FROM customers_merges m
+		WHERE survivor_id = $1 OR duplicate_id = $1`},
+	{"deletion_requests", `SELECT coalesce(jsonb_agg(to_jsonb(d) ORDER BY d.id), '[]') FROM customers_deletion_requests d WHERE customer_id = $1`},
+}
+
+//Export collects data of the customer in one consistent snapshot
+func (s *Service) Export(ctx context.Context, id int64) (*Export, error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	item := &Export{CustomerID: id, Generated: time.Now(), Sections: make(map[string]json.RawMessage)}
+	for _, section := range exportSections {
+		var data []byte
+		err = tx.QueryRow(ctx, section.query, id).Scan(&data)
+		if err == pgx.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item.Sections[section.name] = data
+	}
+
+	return item, nil
+}
+
+//WriteZip writes export as a zip archive with a json file per section
+func WriteZip(w io.Writer, export *Export) error {
+	archive := zip.NewWriter(w)
+
+	for _, section := range exportSections {
+		file, err := archive.CreateHeader(&zip.FileHeader{Name: section.name + ".json", Method: zip.Deflate, Modified: export.Generated})
+		if err != nil {
+			return err
+		}
+		err = json.NewEncoder(file).Encode(export.Sections[section.name])
+		if err != nil {
+			return err
+		}
+	}
+
+	file, err := archive.CreateHeader(&zip.FileHeader{Name: "README.txt", Method: zip.Deflate, Modified: export.Generated})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(file, "Personal data of customer "+strconv.FormatInt(export.CustomerID, 10)+
+		", generated "+export.Generated.Format(time.RFC3339)+".\n")
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+//Erase anonymizes personal fields of the customer and of customers merged into it, merge snapshots included,
+//and removes data that isn't needed for accounting.
+//Sales, payments, loyalty and store credit records stay linked to the anonymous customer.
+func (s *Service) Erase(ctx context.Context, id int64) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `SELECT id FROM customers WHERE id = $1 FOR UPDATE`, id)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	open := false
+	err = tx.QueryRow(ctx, `
+	SELECT exists(SELECT 1 FROM orders WHERE customer_id = $1 AND status IN ('new', 'confirmed', 'ready'))
+	`, id).Scan(&open)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if open {
+		return ErrOpenOrders
+	}
+
+	// nobody knows this password, so the account can't be used anymore
+	buffer := make([]byte, 32)
+	_, err = rand.Read(buffer)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(buffer)), bcrypt.DefaultCost)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	tag, err := tx.Exec(ctx, `
+	UPDATE customers SET name = 'Deleted customer', phone = 'erased-' || id, email = '', password = $2,
+		active = false, sms_opt_in = false, email_opt_in = false, erased = current_timestamp
+	WHERE id = $1
+	`, id, hash)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	for _, query := range []string{
+		`DELETE FROM customers_tokens WHERE customer_id = $1`,
+		`DELETE FROM customers_addresses WHERE customer_id = $1`,
+		`DELETE FROM customers_phone_changes WHERE customer_id = $1`,
+		`DELETE FROM carts WHERE customer_id = $1`,
+		`DELETE FROM wishlists WHERE customer_id = $1`,
+		`DELETE FROM stock_subscriptions WHERE customer_id = $1`,
+		`DELETE FROM notifications_outbox WHERE customer_id = $1`,
+		`DELETE FROM customers_notes WHERE customer_id = $1`,
+		`DELETE FROM customers_tags WHERE customer_id = $1`,
+		// customers merged into this one are the same person, their rows and merge snapshots are scrubbed too
+		`WITH RECURSIVE merged(id) AS (
+			SELECT id FROM customers WHERE merged_into = $1
+			UNION
+			SELECT c.id FROM customers c JOIN merged m ON c.merged_into = m.id
+		), scrubbed AS (
+			UPDATE customers_merges SET snapshot = jsonb_build_object('id', duplicate_id, 'erased', true)
+			WHERE duplicate_id = $1 OR duplicate_id IN (SELECT id FROM merged)
+		)
+		UPDATE customers SET name = 'Deleted customer', email = '', erased = current_timestamp
+		WHERE id IN (SELECT id FROM merged)`,
+		`UPDATE customers_deletion_requests SET reason = '', processed = coalesce(processed, current_timestamp) WHERE customer_id = $1`,
+	} {
+		_, err = tx.Exec(ctx, query, id)
+		if err != nil {
+			log.Print(err)
+			return ErrInternal
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package customers_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/manucher051299/crud/pkg/customers"
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/phones"
+)
+
+func TestMergeSnapshotsExportedAndErased(t *testing.T) {
+	pool := dbtest.Pool(t)
+	ctx := context.Background()
+	svc := customers.NewService(pool, customers.LogSender{}, phones.Config{DefaultRegion: "TJ"})
+	managerID := dbtest.Manager(t, pool, true)
+	survivorID := dbtest.Customer(t, pool)
+	duplicateID := dbtest.Customer(t, pool)
+
+	phone := ""
+	err := pool.QueryRow(ctx, `
+	update customers set name = 'Secret Name', email = 'secret@example.com' where id = $1 returning phone`, duplicateID).Scan(&phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merge, err := svc.Merge(ctx, managerID, survivorID, duplicateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	export, err := svc.Export(ctx, survivorID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merges := make([]struct {
+		ID       int64           `json:"id"`
+		Snapshot json.RawMessage `json:"snapshot"`
+	}, 0)
+	err = json.Unmarshal(export.Sections["merges"], &merges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merges) != 1 || merges[0].ID != merge.ID || !strings.Contains(string(merges[0].Snapshot), "Secret Name") {
+		t.Fatalf("merges in export = %s, want the merge with the snapshot", export.Sections["merges"])
+	}
+
+	err = svc.Erase(ctx, survivorID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := ""
+	err = pool.QueryRow(ctx, `select snapshot::text from customers_merges where id = $1`, merge.ID).Scan(&snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"Secret Name", "secret@example.com", phone} {
+		if strings.Contains(snapshot, secret) {
+			t.Errorf("snapshot %s still has %q after erase", snapshot, secret)
+		}
+	}
+
+	name, email := "", ""
+	err = pool.QueryRow(ctx, `select name, email from customers where id = $1`, duplicateID).Scan(&name, &email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name == "Secret Name" || email != "" {
+		t.Errorf("merged customer kept name %q and email %q after erase", name, email)
+	}
+}
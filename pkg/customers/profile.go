@@ -0,0 +1,373 @@
+package customers
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	//ErrInvalidProfile - name is empty or email is malformed
+	ErrInvalidProfile = errors.New("invalid profile")
+	//ErrInvalidPhone ...
+	ErrInvalidPhone = errors.New("invalid phone")
+	//ErrWeakPassword ...
+	ErrWeakPassword = errors.New("password is too short")
+	//ErrInvalidCode - verification code doesn't match
+	ErrInvalidCode = errors.New("invalid verification code")
+	//ErrCodeExpired - no pending verification or it has expired
+	ErrCodeExpired = errors.New("verification code expired")
+	//ErrTooManyAttempts ...
+	ErrTooManyAttempts = errors.New("too many attempts")
+)
+
+const (
+	//MinPasswordLength ...
+	MinPasswordLength = 6
+	//CodeTTL - how long a phone verification code is valid
+	CodeTTL = 10 * time.Minute
+	//CodeAttempts - wrong codes allowed before the verification is dropped
+	CodeAttempts = 5
+)
+
+//Sender delivers verification codes to customers
+type Sender interface {
+	SendCode(ctx context.Context, phone string, code string) error
+}
+
+//LogSender writes codes to the log, it is meant for development only
+type LogSender struct{}
+
+//SendCode ...
+func (LogSender) SendCode(ctx context.Context, phone string, code string) error {
+	log.Printf("verification code for %s: %s", phone, code)
+	return nil
+}
+
+//Profile - customer data available to the customer
+type Profile struct {
+	ID                int64      `json:"id"`
+	Name              string     `json:"name"`
+	Phone             string     `json:"phone"`
+	Email             string     `json:"email"`
+	Active            bool       `json:"active"`
+	DeletionRequested *time.Time `json:"deletion_requested"`
+	Created           time.Time  `json:"created"`
+}
+
+//ProfileUpdate - nil fields are left as they are
+type ProfileUpdate struct {
+	Name  *string `json:"name"`
+	Email *string `json:"email"`
+}
+
+//PasswordChange ...
+type PasswordChange struct {
+	Current  string `json:"current"`
+	Password string `json:"password"`
+}
+
+//Profile ...
+func (s *Service) Profile(ctx context.Context, id int64) (*Profile, error) {
+	item := &Profile{}
+	err := s.pool.QueryRow(ctx, `
+	SELECT id, name, phone, email, active, deletion_requested, created FROM customers WHERE id = $1
+	`, id).Scan(&item.ID, &item.Name, &item.Phone, &item.Email, &item.Active, &item.DeletionRequested, &item.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+//UpdateProfile changes name and email, phone is changed through verification
+func (s *Service) UpdateProfile(ctx context.Context, id int64, update *ProfileUpdate) (*Profile, error) {
+	if update.Name != nil {
+		name := strings.TrimSpace(*update.Name)
+		if name == "" {
+			return nil, ErrInvalidProfile
+		}
+		update.Name = &name
+	}
+	if update.Email != nil {
+		email := strings.TrimSpace(*update.Email)
+		if email != "" && (!strings.Contains(email, "@") || strings.ContainsAny(email, " \t")) {
+			return nil, ErrInvalidProfile
+		}
+		update.Email = &email
+	}
+
+	item := &Profile{}
+	err := s.pool.QueryRow(ctx, `
+	UPDATE customers SET name = coalesce($2, name), email = coalesce($3, email)
+	WHERE id = $1
+	RETURNING id, name, phone, email, active, deletion_requested, created
+	`, id, update.Name, update.Email).Scan(&item.ID, &item.Name, &item.Phone, &item.Email, &item.Active, &item.DeletionRequested, &item.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+//RequestPhoneChange sends a code to the new phone, the phone is changed after confirmation
+func (s *Service) RequestPhoneChange(ctx context.Context, id int64, phone string) error {
+	phone, err := s.phones.Normalize(phone)
+	if err != nil {
+		return ErrInvalidPhone
+	}
+
+	used := false
+	err = s.pool.QueryRow(ctx, `SELECT exists(SELECT 1 FROM customers WHERE phone = $1)`, phone).Scan(&used)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if used {
+		return ErrPhoneUsed
+	}
+
+	code, err := verificationCode()
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	// only the latest request can be confirmed
+	_, err = tx.Exec(ctx, `DELETE FROM customers_phone_changes WHERE customer_id = $1`, id)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	_, err = tx.Exec(ctx, `
+	INSERT INTO customers_phone_changes(customer_id, phone, code, expire) VALUES ($1,$2,$3,$4)
+	`, id, phone, hash, time.Now().Add(CodeTTL))
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	err = s.sender.SendCode(ctx, phone, code)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+//ConfirmPhoneChange checks the code and moves the customer to the new phone
+func (s *Service) ConfirmPhoneChange(ctx context.Context, id int64, code string) (*Profile, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	var changeID int64
+	var phone, hash string
+	var attempts int
+	var expire time.Time
+	err = tx.QueryRow(ctx, `
+	SELECT id, phone, code, attempts, expire FROM customers_phone_changes WHERE customer_id = $1 FOR UPDATE
+	`, id).Scan(&changeID, &phone, &hash, &attempts, &expire)
+	if err == pgx.ErrNoRows {
+		return nil, ErrCodeExpired
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	if time.Now().After(expire) {
+		return nil, ErrCodeExpired
+	}
+	if attempts >= CodeAttempts {
+		return nil, ErrTooManyAttempts
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(strings.TrimSpace(code)))
+	if err != nil {
+		_, err = tx.Exec(ctx, `UPDATE customers_phone_changes SET attempts = attempts + 1 WHERE id = $1`, changeID)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		err = tx.Commit(ctx)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		return nil, ErrInvalidCode
+	}
+
+	// the phone could be registered while the code was on its way
+	used := false
+	err = tx.QueryRow(ctx, `SELECT exists(SELECT 1 FROM customers WHERE phone = $1)`, phone).Scan(&used)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	if used {
+		return nil, ErrPhoneUsed
+	}
+
+	item := &Profile{}
+	err = tx.QueryRow(ctx, `
+	UPDATE customers SET phone = $2 WHERE id = $1
+	RETURNING id, name, phone, email, active, deletion_requested, created
+	`, id, phone).Scan(&item.ID, &item.Name, &item.Phone, &item.Email, &item.Active, &item.DeletionRequested, &item.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM customers_phone_changes WHERE id = $1`, changeID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+//ChangePassword sets a new password and revokes all tokens of the customer
+func (s *Service) ChangePassword(ctx context.Context, id int64, change *PasswordChange) error {
+	if len(change.Password) < MinPasswordLength {
+		return ErrWeakPassword
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	var hash string
+	err = tx.QueryRow(ctx, `SELECT password FROM customers WHERE id = $1 FOR UPDATE`, id).Scan(&hash)
+	if err == pgx.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(change.Current))
+	if err != nil {
+		return ErrInvalidPassword
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(change.Password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE customers SET password = $2 WHERE id = $1`, id, hashed)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM customers_tokens WHERE customer_id = $1`, id)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+//RequestDeletion marks the account for deletion, repeated requests keep the first date
+func (s *Service) RequestDeletion(ctx context.Context, id int64, reason string) (*Profile, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	item := &Profile{}
+	err = tx.QueryRow(ctx, `
+	UPDATE customers SET deletion_requested = coalesce(deletion_requested, current_timestamp)
+	WHERE id = $1
+	RETURNING id, name, phone, email, active, deletion_requested, created
+	`, id).Scan(&item.ID, &item.Name, &item.Phone, &item.Email, &item.Active, &item.DeletionRequested, &item.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	_, err = tx.Exec(ctx, `
+	INSERT INTO customers_deletion_requests(customer_id, reason) VALUES ($1,$2)
+	ON CONFLICT (customer_id) DO NOTHING
+	`, id, reason)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+//verificationCode returns random 6 digits
+func verificationCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
@@ -0,0 +1,232 @@
+package customers_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/manucher051299/crud/pkg/customers"
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/phones"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// codeSender keeps the last code sent to every phone
+type codeSender struct {
+	codes map[string]string
+}
+
+func (s *codeSender) SendCode(ctx context.Context, phone string, code string) error {
+	s.codes[phone] = code
+	return nil
+}
+
+// customerPhone returns an unused Tajik number, the service region
+func customerPhone() string {
+	phone := dbtest.Phone()
+	return "+99290" + phone[len(phone)-7:]
+}
+
+func newProfileService(t *testing.T) (*customers.Service, *codeSender, *pgxpool.Pool) {
+	t.Helper()
+	pool := dbtest.Pool(t)
+	sender := &codeSender{codes: make(map[string]string)}
+	return customers.NewService(pool, sender, phones.Config{DefaultRegion: "TJ"}), sender, pool
+}
+
+func register(t *testing.T, svc *customers.Service, phone string, password string) int64 {
+	t.Helper()
+	item, err := svc.Register(context.Background(), &customers.Registration{Name: "profile customer", Phone: phone, Password: password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return item.ID
+}
+
+func TestPhoneChange(t *testing.T) {
+	svc, sender, pool := newProfileService(t)
+	ctx := context.Background()
+	phone := customerPhone()
+	id := register(t, svc, phone, "secret")
+	taken := customerPhone()
+	register(t, svc, taken, "secret")
+
+	err := svc.RequestPhoneChange(ctx, id, "90-00")
+	if err != customers.ErrInvalidPhone {
+		t.Errorf("invalid phone: err = %v, want %v", err, customers.ErrInvalidPhone)
+	}
+	err = svc.RequestPhoneChange(ctx, id, "8"+taken[4:])
+	if err != customers.ErrPhoneUsed {
+		t.Errorf("phone of another customer: err = %v, want %v", err, customers.ErrPhoneUsed)
+	}
+	_, err = svc.ConfirmPhoneChange(ctx, id, "000000")
+	if err != customers.ErrCodeExpired {
+		t.Errorf("nothing requested: err = %v, want %v", err, customers.ErrCodeExpired)
+	}
+
+	next := customerPhone()
+	err = svc.RequestPhoneChange(ctx, id, "8"+next[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := sender.codes[next]
+	if len(code) != 6 {
+		t.Fatalf("code sent to the new phone = %q, want 6 digits", code)
+	}
+	// only a hash of the code is kept
+	hash := ""
+	err = pool.QueryRow(ctx, `select code from customers_phone_changes where customer_id = $1`, id).Scan(&hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash == code || bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) != nil {
+		t.Errorf("stored code = %q, want bcrypt hash of the code", hash)
+	}
+
+	_, err = svc.ConfirmPhoneChange(ctx, id, wrongCode(code))
+	if err != customers.ErrInvalidCode {
+		t.Errorf("wrong code: err = %v, want %v", err, customers.ErrInvalidCode)
+	}
+	profile, err := svc.ConfirmPhoneChange(ctx, id, " "+code+" ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Phone != next {
+		t.Errorf("phone = %s, want %s", profile.Phone, next)
+	}
+	_, err = svc.ConfirmPhoneChange(ctx, id, code)
+	if err != customers.ErrCodeExpired {
+		t.Errorf("code used twice: err = %v, want %v", err, customers.ErrCodeExpired)
+	}
+}
+
+func TestPhoneChangeAttempts(t *testing.T) {
+	svc, sender, _ := newProfileService(t)
+	ctx := context.Background()
+	phone := customerPhone()
+	id := register(t, svc, phone, "secret")
+
+	next := customerPhone()
+	err := svc.RequestPhoneChange(ctx, id, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := sender.codes[next]
+
+	for i := 0; i < customers.CodeAttempts; i++ {
+		_, err = svc.ConfirmPhoneChange(ctx, id, wrongCode(code))
+		if err != customers.ErrInvalidCode {
+			t.Fatalf("attempt %d: err = %v, want %v", i+1, err, customers.ErrInvalidCode)
+		}
+	}
+	_, err = svc.ConfirmPhoneChange(ctx, id, code)
+	if err != customers.ErrTooManyAttempts {
+		t.Errorf("right code after %d wrong ones: err = %v, want %v", customers.CodeAttempts, err, customers.ErrTooManyAttempts)
+	}
+	profile, err := svc.Profile(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Phone != phone {
+		t.Errorf("phone = %s, want %s kept", profile.Phone, phone)
+	}
+
+	// a new request starts over
+	err = svc.RequestPhoneChange(ctx, id, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err = svc.ConfirmPhoneChange(ctx, id, sender.codes[next])
+	if err != nil || profile.Phone != next {
+		t.Errorf("after a new request: profile = %+v, err = %v, want phone %s", profile, err, next)
+	}
+}
+
+func TestPhoneChangeExpiry(t *testing.T) {
+	svc, sender, pool := newProfileService(t)
+	ctx := context.Background()
+	id := register(t, svc, customerPhone(), "secret")
+
+	next := customerPhone()
+	err := svc.RequestPhoneChange(ctx, id, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a day covers any difference between the database and local time zones
+	_, err = pool.Exec(ctx, `update customers_phone_changes set expire = expire - interval '1 day' where customer_id = $1`, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = svc.ConfirmPhoneChange(ctx, id, sender.codes[next])
+	if err != customers.ErrCodeExpired {
+		t.Errorf("expired code: err = %v, want %v", err, customers.ErrCodeExpired)
+	}
+
+	// the phone is registered by someone else while the code is on its way
+	err = svc.RequestPhoneChange(ctx, id, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	register(t, svc, next, "secret")
+	_, err = svc.ConfirmPhoneChange(ctx, id, sender.codes[next])
+	if err != customers.ErrPhoneUsed {
+		t.Errorf("phone registered meanwhile: err = %v, want %v", err, customers.ErrPhoneUsed)
+	}
+}
+
+func TestChangePasswordRevokesTokens(t *testing.T) {
+	svc, _, _ := newProfileService(t)
+	ctx := context.Background()
+	phone := customerPhone()
+	id := register(t, svc, phone, "secret")
+
+	tokens := make([]string, 0)
+	for i := 0; i < 2; i++ {
+		token, err := svc.Token(ctx, phone, "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	err := svc.ChangePassword(ctx, id, &customers.PasswordChange{Current: "secret", Password: "short"})
+	if err != customers.ErrWeakPassword {
+		t.Errorf("short password: err = %v, want %v", err, customers.ErrWeakPassword)
+	}
+	err = svc.ChangePassword(ctx, id, &customers.PasswordChange{Current: "wrong", Password: "changed"})
+	if err != customers.ErrInvalidPassword {
+		t.Errorf("wrong current password: err = %v, want %v", err, customers.ErrInvalidPassword)
+	}
+	if got, _ := svc.IDByToken(ctx, tokens[0]); got != id {
+		t.Errorf("token revoked by a refused change")
+	}
+
+	err = svc.ChangePassword(ctx, id, &customers.PasswordChange{Current: "secret", Password: "changed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens {
+		if got, _ := svc.IDByToken(ctx, token); got != 0 {
+			t.Errorf("IDByToken after password change = %d, want 0", got)
+		}
+	}
+	_, err = svc.Token(ctx, phone, "secret")
+	if err != customers.ErrInvalidPassword {
+		t.Errorf("old password: err = %v, want %v", err, customers.ErrInvalidPassword)
+	}
+	token, err := svc.Token(ctx, phone, "changed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := svc.IDByToken(ctx, token); got != id {
+		t.Errorf("IDByToken with the new password = %d, want %d", got, id)
+	}
+}
+
+// wrongCode returns a code that differs from code
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "000001"
+	}
+	return "000000"
+}
@@ -0,0 +1,177 @@
+package customers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/manucher051299/crud/pkg/customers"
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/phones"
+)
+
+type purchasesFixture struct {
+	svc        *customers.Service
+	customerID int64
+	// sales of the customer from the oldest to the newest
+	sales []int64
+	other int64
+}
+
+func newPurchasesFixture(t *testing.T) *purchasesFixture {
+	pool := dbtest.Pool(t)
+	managerID := dbtest.Manager(t, pool, false)
+	customerID := dbtest.Customer(t, pool)
+	otherID := dbtest.Customer(t, pool)
+	tea := dbtest.Product(t, pool, "tea", 1000, 10)
+	cup := dbtest.Product(t, pool, "cup", 2500, 10)
+
+	day := func(month time.Month, day int) time.Time {
+		return time.Date(2021, month, day, 12, 0, 0, 0, time.UTC)
+	}
+	fixture := &purchasesFixture{
+		svc:        customers.NewService(pool, customers.LogSender{}, phones.Config{DefaultRegion: "TJ"}),
+		customerID: customerID,
+	}
+	fixture.sales = []int64{
+		dbtest.Sale(t, pool, managerID, customerID, "paid", day(time.January, 10),
+			dbtest.Position{ProductID: tea, Price: 1000, Qty: 2}, dbtest.Position{ProductID: cup, Price: 2500, Qty: 1}),
+		dbtest.Sale(t, pool, managerID, customerID, "paid", day(time.February, 10),
+			dbtest.Position{ProductID: cup, Price: 2500, Qty: 2}),
+		dbtest.Sale(t, pool, managerID, customerID, "unpaid", day(time.March, 10),
+			dbtest.Position{ProductID: tea, Price: 1000, Qty: 1}),
+	}
+	fixture.other = dbtest.Sale(t, pool, managerID, otherID, "paid", day(time.February, 11),
+		dbtest.Position{ProductID: tea, Price: 1000, Qty: 5})
+	return fixture
+}
+
+func ids(items []*customers.Purchase) []int64 {
+	result := make([]int64, 0, len(items))
+	for _, item := range items {
+		result = append(result, item.ID)
+	}
+	return result
+}
+
+func equal(a []int64, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPurchasesGroupedBySale(t *testing.T) {
+	fixture := newPurchasesFixture(t)
+
+	items, err := fixture.svc.Purchases(context.Background(), fixture.customerID, customers.PurchasesFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int64{fixture.sales[2], fixture.sales[1], fixture.sales[0]}
+	if !equal(ids(items), want) {
+		t.Fatalf("purchases = %v, want %v newest first without sales of other customers", ids(items), want)
+	}
+
+	first := items[2]
+	if len(first.Positions) != 2 {
+		t.Fatalf("first sale has %d positions, want 2", len(first.Positions))
+	}
+	if first.Positions[0].Name != "tea" || first.Positions[0].Qty != 2 || first.Positions[0].Total.Amount != 2000 {
+		t.Errorf("position = %+v, want 2 tea for 2000", first.Positions[0])
+	}
+	if first.Positions[1].Name != "cup" || first.Positions[1].Total.Amount != 2500 {
+		t.Errorf("position = %+v, want cup for 2500", first.Positions[1])
+	}
+	if first.Total.Amount != 4500 || first.Total.Currency == "" {
+		t.Errorf("total = %v, want 4500 with currency", first.Total)
+	}
+	if len(items[0].Positions) != 1 || len(items[1].Positions) != 1 {
+		t.Errorf("positions of other sales must not be mixed in")
+	}
+}
+
+func TestPurchasesDateFilter(t *testing.T) {
+	fixture := newPurchasesFixture(t)
+
+	tests := []struct {
+		name   string
+		filter customers.PurchasesFilter
+		want   []int64
+	}{
+		{"from", customers.PurchasesFilter{From: time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)},
+			[]int64{fixture.sales[2], fixture.sales[1]}},
+		{"to is exclusive", customers.PurchasesFilter{To: time.Date(2021, 2, 10, 12, 0, 0, 0, time.UTC)},
+			[]int64{fixture.sales[0]}},
+		{"period", customers.PurchasesFilter{From: time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC),
+			To: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)}, []int64{fixture.sales[1]}},
+		{"empty", customers.PurchasesFilter{From: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}, []int64{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			items, err := fixture.svc.Purchases(context.Background(), fixture.customerID, test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equal(ids(items), test.want) {
+				t.Errorf("purchases = %v, want %v", ids(items), test.want)
+			}
+		})
+	}
+}
+
+func TestPurchasesPagination(t *testing.T) {
+	fixture := newPurchasesFixture(t)
+	ctx := context.Background()
+
+	page, err := fixture.svc.Purchases(ctx, fixture.customerID, customers.PurchasesFilter{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(ids(page), []int64{fixture.sales[2], fixture.sales[1]}) {
+		t.Errorf("first page = %v", ids(page))
+	}
+
+	page, err = fixture.svc.Purchases(ctx, fixture.customerID, customers.PurchasesFilter{Limit: 2, Offset: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(ids(page), []int64{fixture.sales[0]}) {
+		t.Errorf("second page = %v", ids(page))
+	}
+	if len(page) == 1 && len(page[0].Positions) != 2 {
+		t.Errorf("positions of a sale on the second page = %d, want 2", len(page[0].Positions))
+	}
+
+	page, err = fixture.svc.Purchases(ctx, fixture.customerID, customers.PurchasesFilter{Limit: 2, Offset: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 0 {
+		t.Errorf("page after the end = %v, want empty", ids(page))
+	}
+}
+
+func TestPurchaseDetail(t *testing.T) {
+	fixture := newPurchasesFixture(t)
+	ctx := context.Background()
+
+	item, err := fixture.svc.Purchase(ctx, fixture.customerID, fixture.sales[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.ID != fixture.sales[0] || item.Status != "paid" || item.Total.Amount != 4500 || len(item.Positions) != 2 {
+		t.Errorf("purchase = %+v", item)
+	}
+
+	_, err = fixture.svc.Purchase(ctx, fixture.customerID, fixture.other)
+	if err != customers.ErrNotFound {
+		t.Errorf("sale of another customer: err = %v, want %v", err, customers.ErrNotFound)
+	}
+}
@@ -11,6 +11,8 @@ import (
 
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/manucher051299/crud/pkg/money"
+	"github.com/manucher051299/crud/pkg/phones"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -24,11 +26,13 @@ var ErrTokenNotFound = errors.New("token not found")
 var ErrTokenExpired = errors.New("token expired")
 
 type Service struct {
-	pool *pgxpool.Pool
+	pool   *pgxpool.Pool
+	sender Sender
+	phones phones.Config
 }
 
-func NewService(pool *pgxpool.Pool) *Service {
-	return &Service{pool: pool}
+func NewService(pool *pgxpool.Pool, sender Sender, phones phones.Config) *Service {
+	return &Service{pool: pool, sender: sender, phones: phones}
 }
 
 type Customer struct {
@@ -50,6 +54,11 @@ func (s *Service) Register(ctx context.Context, registration *Registration) (*Cu
 	var err error
 	item := &Customer{}
 
+	phone, err := s.phones.Normalize(registration.Phone)
+	if err != nil {
+		return nil, ErrInvalidPhone
+	}
+
 	hash, err := bcrypt.GenerateFromPassword([]byte(registration.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, ErrInternal
@@ -60,7 +69,7 @@ func (s *Service) Register(ctx context.Context, registration *Registration) (*Cu
 	INSERT INTO customers (name, phone, password)
 	VALUES ($1,$2,$3)
 	ON CONFLICT (phone) DO NOTHING RETURNING id,name,phone, active, created
-	`, registration.Name, registration.Phone, hash).Scan(
+	`, registration.Name, phone, hash).Scan(
 		&item.ID, &item.Name, &item.Phone, &item.Active, &item.Created,
 	)
 	if err == pgx.ErrNoRows {
@@ -82,26 +91,46 @@ type Token struct {
 	Token string `json:"token"`
 }
 
-type Sales struct {
-	ID      int64     `json:"id"`
-	Name    string    `json:"name"`
-	Price   int       `json:"price"`
-	Qty     int       `json:"qty"`
-	Created time.Time `json:"created"`
+//Purchase - sale made to the customer
+type Purchase struct {
+	ID        int64               `json:"id"`
+	Total     money.Money         `json:"total"`
+	Tax       money.Money         `json:"tax"`
+	Status    string              `json:"status"`
+	Created   time.Time           `json:"created"`
+	Positions []*PurchasePosition `json:"positions"`
+}
+
+//PurchasePosition ...
+type PurchasePosition struct {
+	ID        int64       `json:"id"`
+	ProductID int64       `json:"product_id"`
+	Name      string      `json:"name"`
+	Price     money.Money `json:"price"`
+	Qty       int         `json:"qty"`
+	Total     money.Money `json:"total"`
+}
+
+//PurchasesFilter - zero From/To are not applied, To is exclusive
+type PurchasesFilter struct {
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
 }
 
 type Product struct {
-	ID    int64  `json:"id"`
-	Name  string `json:"name"`
-	Price int    `json:"price"`
-	Qty   int    `json:"qty"`
+	ID    int64       `json:"id"`
+	Name  string      `json:"name"`
+	Price money.Money `json:"price"`
+	Qty   int         `json:"qty"`
 }
 
 func (s *Service) Products(ctx context.Context) ([]*Product, error) {
 	items := make([]*Product, 0)
 
 	rows, err := s.pool.Query(ctx, `
-	SELECT id,name, price,qty FROM products WHERE active ORDER BY id LIMIT 500
+	SELECT id,name, price,currency,qty FROM products WHERE active ORDER BY id LIMIT 500
 	`)
 	if errors.Is(err, pgx.ErrNoRows) {
 		return items, nil
@@ -113,7 +142,7 @@ func (s *Service) Products(ctx context.Context) ([]*Product, error) {
 
 	for rows.Next() {
 		item := &Product{}
-		err = rows.Scan(&item.ID, &item.Name, &item.Price, &item.Qty)
+		err = rows.Scan(&item.ID, &item.Name, &item.Price.Amount, &item.Price.Currency, &item.Qty)
 		if err != nil {
 			log.Print(err)
 			return nil, err
@@ -144,35 +173,140 @@ func (s *Service) IDByToken(ctx context.Context, token string) (int64, error) {
 	return id, nil
 }
 
-func (s *Service) Purchases(ctx context.Context, id int64) ([]*Sales, error) {
-	sales := make([]*Sales, 0)
+//Purchases - purchase history of the customer, newest first
+func (s *Service) Purchases(ctx context.Context, id int64, filter PurchasesFilter) ([]*Purchase, error) {
+	purchases := make([]*Purchase, 0)
+
+	if filter.Limit <= 0 || filter.Limit > 100 {
+		filter.Limit = 20
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+	var from, to *time.Time
+	if !filter.From.IsZero() {
+		from = &filter.From
+	}
+	if !filter.To.IsZero() {
+		to = &filter.To
+	}
 
 	rows, err := s.pool.Query(ctx, `
-	SELECT sp.id, sp.name, sp.price,sp.qty,sp.created 
-	FROM sale_positions sp 
-	JOIN sales s on s.id = sp.sale_id
-	WHERE s.customer_id = $1;
-	`, id)
+	SELECT id, total, tax, currency, status, created
+	FROM sales
+	WHERE customer_id = $1
+		AND ($2::timestamp IS NULL OR created >= $2)
+		AND ($3::timestamp IS NULL OR created < $3)
+	ORDER BY created DESC, id DESC
+	LIMIT $4 OFFSET $5
+	`, id, from, to, filter.Limit, filter.Offset)
 	if err != nil {
+		log.Print(err)
 		return nil, ErrInternal
 	}
 	defer rows.Close()
 
+	ids := make([]int64, 0)
+	byID := make(map[int64]*Purchase)
 	for rows.Next() {
-		sale := &Sales{}
-		err = rows.Scan(&sale.ID, &sale.Name, &sale.Price, &sale.Qty, &sale.Created)
+		purchase := &Purchase{Positions: make([]*PurchasePosition, 0)}
+		err = rows.Scan(&purchase.ID, &purchase.Total.Amount, &purchase.Tax.Amount, &purchase.Total.Currency, &purchase.Status, &purchase.Created)
 		if err != nil {
 			log.Print(err)
-			return nil, err
+			return nil, ErrInternal
 		}
-		sales = append(sales, sale)
+		purchase.Tax.Currency = purchase.Total.Currency
+		purchases = append(purchases, purchase)
+		ids = append(ids, purchase.ID)
+		byID[purchase.ID] = purchase
 	}
 	err = rows.Err()
 	if err != nil {
 		log.Print(err)
+		return nil, ErrInternal
+	}
+	if len(ids) == 0 {
+		return purchases, nil
+	}
+
+	positions, err := s.purchasePositions(ctx, ids)
+	if err != nil {
 		return nil, err
 	}
-	return sales, nil
+	for _, position := range positions {
+		purchase := byID[position.saleID]
+		purchase.Positions = append(purchase.Positions, &position.PurchasePosition)
+	}
+
+	return purchases, nil
+}
+
+//Purchase - one sale of the customer with its positions
+func (s *Service) Purchase(ctx context.Context, id int64, saleID int64) (*Purchase, error) {
+	purchase := &Purchase{Positions: make([]*PurchasePosition, 0)}
+
+	err := s.pool.QueryRow(ctx, `
+	SELECT id, total, tax, currency, status, created FROM sales WHERE id = $1 AND customer_id = $2
+	`, saleID, id).Scan(&purchase.ID, &purchase.Total.Amount, &purchase.Tax.Amount, &purchase.Total.Currency, &purchase.Status, &purchase.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	purchase.Tax.Currency = purchase.Total.Currency
+
+	positions, err := s.purchasePositions(ctx, []int64{saleID})
+	if err != nil {
+		return nil, err
+	}
+	for _, position := range positions {
+		purchase.Positions = append(purchase.Positions, &position.PurchasePosition)
+	}
+
+	return purchase, nil
+}
+
+type salePosition struct {
+	PurchasePosition
+	saleID int64
+}
+
+func (s *Service) purchasePositions(ctx context.Context, saleIDs []int64) ([]*salePosition, error) {
+	positions := make([]*salePosition, 0)
+
+	rows, err := s.pool.Query(ctx, `
+	SELECT sp.sale_id, sp.id, sp.product_id, p.name, sp.price, sp.currency, sp.qty, sp.total
+	FROM sales_positions sp
+	JOIN products p ON p.id = sp.product_id
+	WHERE sp.sale_id = ANY($1)
+	ORDER BY sp.sale_id, sp.id
+	`, saleIDs)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		position := &salePosition{}
+		err = rows.Scan(&position.saleID, &position.ID, &position.ProductID, &position.Name,
+			&position.Price.Amount, &position.Price.Currency, &position.Qty, &position.Total.Amount)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		position.Total.Currency = position.Price.Currency
+		positions = append(positions, position)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	return positions, nil
 }
 
 // method for generating a token
@@ -180,6 +314,11 @@ func (s *Service) Token(ctx context.Context, phone string, password string) (tok
 	var hash string
 	var id int64
 
+	phone, err = s.phones.Normalize(phone)
+	if err != nil {
+		return "", ErrNoSuchUser
+	}
+
 	err = s.pool.QueryRow(ctx, `SELECT id,password FROM customers WHERE phone =$1`, phone).Scan(&id, &hash)
 
 	if err == pgx.ErrNoRows {
@@ -290,6 +429,12 @@ func (s *Service) Save(ctx context.Context, customer *Customer) (*Customer, erro
 		Phone: customer.Phone,
 	}
 
+	phone, err := s.phones.Normalize(item.Phone)
+	if err != nil {
+		return nil, ErrInvalidPhone
+	}
+	item.Phone = phone
+
 	if item.ID == 0 {
 		err := s.pool.QueryRow(ctx, `
 	INSERT INTO customers(name,phone) VALUES ($1,$2) ON CONFLICT(phone) DO UPDATE SET name=excluded.name RETURNING id,name,phone,active,created;
@@ -301,9 +446,12 @@ func (s *Service) Save(ctx context.Context, customer *Customer) (*Customer, erro
 		return item, nil
 	}
 
-	err := s.pool.QueryRow(ctx, `
-UPDATE customers SET name=$1 phone=$2 RETURNING id,name,phone,active,created;
-`, item.Name, item.Phone).Scan(&item.ID, &item.Name, &item.Phone, &item.Active, &item.Created)
+	err = s.pool.QueryRow(ctx, `
+UPDATE customers SET name=$1, phone=$2 WHERE id=$3 RETURNING id,name,phone,active,created;
+`, item.Name, item.Phone, item.ID).Scan(&item.ID, &item.Name, &item.Phone, &item.Active, &item.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		log.Print(err)
 		return nil, ErrInternal
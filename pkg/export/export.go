@@ -0,0 +1,293 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+var (
+	//ErrUnknownFormat ...
+	ErrUnknownFormat = errors.New("unknown format")
+	//ErrUnsupportedValue - row has a value of a type Writer doesn't know, it would be written as a blank cell
+	ErrUnsupportedValue = errors.New("unsupported value")
+)
+
+//export formats
+const (
+	CSV  = "csv"
+	XLSX = "xlsx"
+)
+
+//content types of the formats
+const (
+	CSVContentType  = "text/csv"
+	XLSXContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+//TimeLayout - layout of dates and times in both formats
+const TimeLayout = "2006-01-02 15:04:05"
+
+//Options - number formatting of csv files, xlsx keeps numbers as numbers and ignores them
+type Options struct {
+	//Decimal - decimal separator, "." or ","
+	Decimal string
+	//Delimiter - field separator, ";" is used by spreadsheets of locales with decimal comma
+	Delimiter rune
+}
+
+//DefaultOptions ...
+var DefaultOptions = Options{Decimal: ".", Delimiter: ','}
+
+//Writer writes rows one by one, nothing but the current row is kept in memory.
+//Supported values are strings, integers, floats, bools, money.Money, time.Time, pointers to them and nil,
+//Write returns ErrUnsupportedValue for others.
+type Writer interface {
+	Write(row ...interface{}) error
+	//Close completes the file, it must be called after the last row
+	Close() error
+}
+
+//NewWriter creates writer of the format and writes the header row
+func NewWriter(w io.Writer, format string, columns []string, options Options) (Writer, error) {
+	var writer Writer
+	switch format {
+	case CSV:
+		writer = newCSVWriter(w, options)
+	case XLSX:
+		xw, err := newXLSXWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		writer = xw
+	default:
+		return nil, ErrUnknownFormat
+	}
+
+	header := make([]interface{}, len(columns))
+	for i, column := range columns {
+		header[i] = column
+	}
+	err := writer.Write(header...)
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+//ContentType ...
+func ContentType(format string) string {
+	if format == XLSX {
+		return XLSXContentType
+	}
+	return CSVContentType + "; charset=utf-8"
+}
+
+type csvWriter struct {
+	writer  *csv.Writer
+	options Options
+	record  []string
+}
+
+func newCSVWriter(w io.Writer, options Options) *csvWriter {
+	writer := csv.NewWriter(w)
+	if options.Delimiter != 0 {
+		writer.Comma = options.Delimiter
+	}
+	if options.Decimal == "" {
+		options.Decimal = DefaultOptions.Decimal
+	}
+	return &csvWriter{writer: writer, options: options}
+}
+
+func (c *csvWriter) Write(row ...interface{}) error {
+	c.record = c.record[:0]
+	for _, value := range row {
+		text, numeric, err := format(value)
+		if err != nil {
+			return err
+		}
+		if numeric && c.options.Decimal != "." {
+			text = strings.Replace(text, ".", c.options.Decimal, 1)
+		}
+		if !numeric && formula(text) {
+			// spreadsheets would run it
+			text = "'" + text
+		}
+		c.record = append(c.record, text)
+	}
+	return c.writer.Write(c.record)
+}
+
+//formula reports whether spreadsheet would treat text as a formula, phones like +992... are fine
+func formula(text string) bool {
+	if text == "" || !strings.ContainsAny(text[:1], "=+-@") {
+		return false
+	}
+	return text[0] == '=' || text[0] == '@' || strings.Trim(text[1:], "0123456789 ") != ""
+}
+
+func (c *csvWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+//xlsxWriter writes a workbook with one sheet, the sheet is streamed into the zip entry
+type xlsxWriter struct {
+	archive *zip.Writer
+	sheet   *bufio.Writer
+}
+
+var xlsxParts = []struct {
+	name    string
+	content string
+}{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+func newXLSXWriter(w io.Writer) (*xlsxWriter, error) {
+	archive := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		file, err := archive.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.WriteString(file, part.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// sheet goes last, so its entry stays open while rows are written
+	file, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	sheet := bufio.NewWriter(file)
+	_, err = sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	if err != nil {
+		return nil, err
+	}
+	return &xlsxWriter{archive: archive, sheet: sheet}, nil
+}
+
+func (x *xlsxWriter) Write(row ...interface{}) error {
+	_, err := x.sheet.WriteString("<row>")
+	if err != nil {
+		return err
+	}
+	for _, value := range row {
+		text, numeric, err := format(value)
+		if err != nil {
+			return err
+		}
+		switch {
+		case text == "":
+			_, err = x.sheet.WriteString("<c/>")
+		case numeric:
+			_, err = x.sheet.WriteString("<c><v>" + text + "</v></c>")
+		default:
+			_, err = x.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			if err == nil {
+				err = xml.EscapeText(x.sheet, []byte(text))
+			}
+			if err == nil {
+				_, err = x.sheet.WriteString("</t></is></c>")
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = x.sheet.WriteString("</row>")
+	return err
+}
+
+func (x *xlsxWriter) Close() error {
+	_, err := x.sheet.WriteString("</sheetData></worksheet>")
+	if err != nil {
+		return err
+	}
+	err = x.sheet.Flush()
+	if err != nil {
+		return err
+	}
+	return x.archive.Close()
+}
+
+//format returns text of the value and whether it is a number, numbers always use "." as decimal separator
+func format(value interface{}) (string, bool, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", false, nil
+	case string:
+		return v, false, nil
+	case int:
+		return strconv.Itoa(v), true, nil
+	case int64:
+		return strconv.FormatInt(v, 10), true, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true, nil
+	case bool:
+		return strconv.FormatBool(v), false, nil
+	case money.Money:
+		return v.Decimal(), true, nil
+	case *money.Money:
+		if v == nil {
+			return "", false, nil
+		}
+		return v.Decimal(), true, nil
+	case time.Time:
+		if v.IsZero() {
+			return "", false, nil
+		}
+		return v.Format(TimeLayout), false, nil
+	case *time.Time:
+		if v == nil {
+			return "", false, nil
+		}
+		return format(*v)
+	case *int64:
+		if v == nil {
+			return "", false, nil
+		}
+		return format(*v)
+	case *int:
+		if v == nil {
+			return "", false, nil
+		}
+		return format(*v)
+	case *float64:
+		if v == nil {
+			return "", false, nil
+		}
+		return format(*v)
+	}
+	return "", false, ErrUnsupportedValue
+}
@@ -0,0 +1,149 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+func TestCSVValues(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writer, err := NewWriter(buffer, CSV, []string{"a", "b", "c", "d", "e", "f"}, Options{Decimal: ",", Delimiter: ';'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	qty := 3
+	var missing *money.Money
+	err = writer.Write("=1+1", money.New(1050, money.DefaultCurrency), &qty, missing, 1.5,
+		time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "a;b;c;d;e;f\n'=1+1;10,50;3;;1,5;2021-01-02 03:04:05\n"
+	if buffer.String() != want {
+		t.Errorf("csv = %q, want %q", buffer.String(), want)
+	}
+}
+
+//xlsxCell - cell of sheet1.xml, numbers are in V, inline strings in Is
+type xlsxCell struct {
+	Type string `xml:"t,attr"`
+	V    string `xml:"v"`
+	Is   string `xml:"is>t"`
+}
+
+func TestXLSXValues(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writer, err := NewWriter(buffer, XLSX, []string{"name", "price", "qty", "missing", "rate", "created"}, Options{Decimal: ",", Delimiter: ';'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	qty := 3
+	var missing *money.Money
+	err = writer.Write("Tea <green> & \"mint\"", money.New(1050, money.DefaultCurrency), &qty, missing, 1.5,
+		time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.Write("=1+1", nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]*zip.File)
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels"} {
+		if files[name] == nil {
+			t.Errorf("no %s in the workbook", name)
+		}
+	}
+	if files["xl/worksheets/sheet1.xml"] == nil {
+		t.Fatal("no sheet1.xml in the workbook")
+	}
+	reader, err := files["xl/worksheets/sheet1.xml"].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sheet := struct {
+		Rows []struct {
+			Cells []xlsxCell `xml:"c"`
+		} `xml:"sheetData>row"`
+	}{}
+	err = xml.Unmarshal(data, &sheet)
+	if err != nil {
+		t.Fatalf("sheet1.xml is not valid xml: %v\n%s", err, data)
+	}
+
+	// numbers use "." whatever the csv options are, text is escaped and formulas stay text
+	text := func(value string) xlsxCell { return xlsxCell{Type: "inlineStr", Is: value} }
+	number := func(value string) xlsxCell { return xlsxCell{V: value} }
+	want := [][]xlsxCell{
+		{text("name"), text("price"), text("qty"), text("missing"), text("rate"), text("created")},
+		{text("Tea <green> & \"mint\""), number("10.50"), number("3"), {}, number("1.5"), text("2021-01-02 03:04:05")},
+		{text("=1+1"), {}, {}, {}, {}, {}},
+	}
+	if len(sheet.Rows) != len(want) {
+		t.Fatalf("%d rows, want %d:\n%s", len(sheet.Rows), len(want), data)
+	}
+	for i, row := range want {
+		if len(sheet.Rows[i].Cells) != len(row) {
+			t.Errorf("row %d has %d cells, want %d", i, len(sheet.Rows[i].Cells), len(row))
+			continue
+		}
+		for j, cell := range row {
+			if sheet.Rows[i].Cells[j] != cell {
+				t.Errorf("cell %d of row %d = %+v, want %+v", j, i, sheet.Rows[i].Cells[j], cell)
+			}
+		}
+	}
+	if bytes.Contains(data, []byte("<green>")) {
+		t.Errorf("text is not escaped:\n%s", data)
+	}
+}
+
+func TestUnsupportedValue(t *testing.T) {
+	for _, format := range []string{CSV, XLSX} {
+		writer, err := NewWriter(&bytes.Buffer{}, format, []string{"a"}, DefaultOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, value := range []interface{}{int32(1), []string{"a"}, struct{}{}} {
+			err = writer.Write(value)
+			if err != ErrUnsupportedValue {
+				t.Errorf("%s: Write(%T) err = %v, want %v", format, value, err, ErrUnsupportedValue)
+			}
+		}
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := NewWriter(&bytes.Buffer{}, "pdf", []string{"a"}, DefaultOptions)
+	if err != ErrUnknownFormat {
+		t.Errorf("err = %v, want %v", err, ErrUnknownFormat)
+	}
+}
@@ -0,0 +1,396 @@
+package giftcards
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+var (
+	//ErrNotFound ...
+	ErrNotFound = errors.New("item not found")
+	//ErrInternal ...
+	ErrInternal = errors.New("internal error")
+	//ErrInvalidAmount ...
+	ErrInvalidAmount = errors.New("invalid amount")
+	//ErrCodeUsed ...
+	ErrCodeUsed = errors.New("gift card code already used")
+	//ErrCardUnavailable - card is blocked or expired
+	ErrCardUnavailable = errors.New("gift card unavailable")
+	//ErrInsufficientBalance ...
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	//ErrNoCustomer - store credit can't be used in sales without a customer
+	ErrNoCustomer = errors.New("sale has no customer")
+	//ErrCreditExceedsSale - credit for a return can't exceed what was paid for the sale
+	ErrCreditExceedsSale = errors.New("credit exceeds amount paid for the sale")
+)
+
+//transaction kinds
+const (
+	Issue  = "issue"
+	Redeem = "redeem"
+)
+
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+type Service struct {
+	db *pgxpool.Pool
+}
+
+func NewService(db *pgxpool.Pool) *Service {
+	return &Service{db: db}
+}
+
+//GiftCard ...
+type GiftCard struct {
+	ID        int64       `json:"id"`
+	Code      string      `json:"code"`
+	Initial   money.Money `json:"initial"`
+	Balance   money.Money `json:"balance"`
+	Expires   *time.Time  `json:"expires"`
+	Active    bool        `json:"active"`
+	ManagerID int64       `json:"manager_id"`
+	Created   time.Time   `json:"created"`
+}
+
+//StoreCredit - one record of customer's store credit, balance is the sum of amounts.
+//Credit is issued for a return, ReturnID is 0 for redemptions.
+type StoreCredit struct {
+	ID         int64       `json:"id"`
+	CustomerID int64       `json:"customer_id"`
+	SaleID     int64       `json:"sale_id"`
+	ReturnID   int64       `json:"return_id"`
+	ManagerID  int64       `json:"manager_id"`
+	Kind       string      `json:"kind"`
+	Amount     money.Money `json:"amount"`
+	Reason     string      `json:"reason"`
+	Created    time.Time   `json:"created"`
+}
+
+//Liability - money owed to holders of gift cards and store credit in one currency
+type Liability struct {
+	Currency      string      `json:"currency"`
+	GiftCards     money.Money `json:"gift_cards"`
+	GiftCardCount int         `json:"gift_card_count"`
+	Expired       money.Money `json:"expired"`
+	StoreCredit   money.Money `json:"store_credit"`
+	Customers     int         `json:"customers"`
+}
+
+//GenerateCode returns code like "ABCD-EFGH-JKLM-NPQR"
+func GenerateCode() (string, error) {
+	buffer := make([]byte, 16)
+	n, err := rand.Read(buffer)
+	if n != len(buffer) || err != nil {
+		return "", ErrInternal
+	}
+
+	code := &strings.Builder{}
+	for i, b := range buffer {
+		if i > 0 && i%4 == 0 {
+			code.WriteByte('-')
+		}
+		code.WriteByte(codeAlphabet[int(b)%len(codeAlphabet)])
+	}
+	return code.String(), nil
+}
+
+//NormalizeCode makes codes typed by people comparable
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+//Issue creates gift card with the initial balance, code is generated when empty
+func (s *Service) Issue(ctx context.Context, card *GiftCard) (*GiftCard, error) {
+	if card.Initial.Amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
+	if card.Initial.Currency == "" {
+		card.Initial.Currency = money.DefaultCurrency
+	}
+
+	var err error
+	card.Code = NormalizeCode(card.Code)
+	if card.Code == "" {
+		card.Code, err = GenerateCode()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+	insert into gift_cards(code, initial, balance, currency, expires, manager_id)
+	values ($1,$2,$2,$3,$4,$5)
+	on conflict (code) do nothing
+	returning id, active, created`, card.Code, card.Initial.Amount, card.Initial.Currency, card.Expires, card.ManagerID).
+		Scan(&card.ID, &card.Active, &card.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrCodeUsed
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	_, err = tx.Exec(ctx, `
+	insert into gift_cards_transactions(card_id, manager_id, kind, amount) values ($1,$2,$3,$4)`,
+		card.ID, card.ManagerID, Issue, card.Initial.Amount)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	card.Balance = card.Initial
+	return card, nil
+}
+
+//ByCode - balance check
+func (s *Service) ByCode(ctx context.Context, code string) (*GiftCard, error) {
+	card := &GiftCard{}
+	err := s.db.QueryRow(ctx, `
+	select id, code, initial, balance, currency, expires, active, coalesce(manager_id,0), created
+	from gift_cards where code = $1`, NormalizeCode(code)).
+		Scan(&card.ID, &card.Code, &card.Initial.Amount, &card.Balance.Amount, &card.Initial.Currency,
+			&card.Expires, &card.Active, &card.ManagerID, &card.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	card.Balance.Currency = card.Initial.Currency
+	return card, nil
+}
+
+//RedeemGiftCard takes amount from the card balance as a payment for the sale, partial use is allowed
+func (s *Service) RedeemGiftCard(ctx context.Context, tx pgx.Tx, saleID int64, managerID int64, code string, amount money.Money) error {
+	cardID, balance := int64(0), int64(0)
+	currency := ""
+	active := false
+	var expires *time.Time
+	err := tx.QueryRow(ctx, `
+	select id, balance, currency, active, expires from gift_cards where code = $1 for update`, NormalizeCode(code)).
+		Scan(&cardID, &balance, &currency, &active, &expires)
+	if err == pgx.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if !active || (expires != nil && expires.Before(time.Now())) {
+		return ErrCardUnavailable
+	}
+	if currency != amount.Currency {
+		return money.ErrCurrencyMismatch
+	}
+	if amount.Amount <= 0 {
+		return ErrInvalidAmount
+	}
+	if balance < amount.Amount {
+		return ErrInsufficientBalance
+	}
+
+	_, err = tx.Exec(ctx, `update gift_cards set balance = balance - $1 where id = $2`, amount.Amount, cardID)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	_, err = tx.Exec(ctx, `
+	insert into gift_cards_transactions(card_id, sale_id, manager_id, kind, amount) values ($1,$2,$3,$4,$5)`,
+		cardID, saleID, managerID, Redeem, -amount.Amount)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+//IssueStoreCredit credits the customer inside tx for a return of the sale.
+//All credits of a sale together can't exceed what was paid for it, change given back doesn't count.
+func (s *Service) IssueStoreCredit(ctx context.Context, tx pgx.Tx, credit *StoreCredit) (*StoreCredit, error) {
+	if credit.Amount.Amount <= 0 || credit.ReturnID == 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	paid, credited := int64(0), int64(0)
+	currency := ""
+	err := tx.QueryRow(ctx, `
+	select s.currency,
+		coalesce((select sum(amount - change) from payments where sale_id = s.id),0)::bigint,
+		coalesce((select sum(amount) from store_credits where sale_id = s.id and kind = $3),0)::bigint
+	from sales s where s.id = $1 and s.customer_id = $2
+	for update of s`, credit.SaleID, credit.CustomerID, Issue).Scan(&currency, &paid, &credited)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	if credit.Amount.Currency == "" {
+		credit.Amount.Currency = currency
+	}
+	if credit.Amount.Currency != currency {
+		return nil, money.ErrCurrencyMismatch
+	}
+	if credited+credit.Amount.Amount > paid {
+		return nil, ErrCreditExceedsSale
+	}
+
+	credit.Kind = Issue
+	err = tx.QueryRow(ctx, `
+	insert into store_credits(customer_id, sale_id, return_id, manager_id, kind, amount, currency, reason)
+	values ($1,$2,$3,$4,$5,$6,$7,$8) returning id, created`,
+		credit.CustomerID, credit.SaleID, credit.ReturnID, credit.ManagerID, credit.Kind, credit.Amount.Amount, credit.Amount.Currency,
+		credit.Reason).Scan(&credit.ID, &credit.Created)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return credit, nil
+}
+
+//RedeemStoreCredit takes amount from store credit of the sale's customer
+func (s *Service) RedeemStoreCredit(ctx context.Context, tx pgx.Tx, saleID int64, managerID int64, amount money.Money) error {
+	customerID := int64(0)
+	err := tx.QueryRow(ctx, `select customer_id from sales where id = $1`, saleID).Scan(&customerID)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if customerID == 0 {
+		return ErrNoCustomer
+	}
+
+	// serializes redemptions of one customer
+	_, err = tx.Exec(ctx, `select id from customers where id = $1 for update`, customerID)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	balance := int64(0)
+	err = tx.QueryRow(ctx, `
+	select coalesce(sum(amount),0)::bigint from store_credits where customer_id = $1 and currency = $2`,
+		customerID, amount.Currency).Scan(&balance)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if balance < amount.Amount {
+		return ErrInsufficientBalance
+	}
+
+	_, err = tx.Exec(ctx, `
+	insert into store_credits(customer_id, sale_id, manager_id, kind, amount, currency) values ($1,$2,$3,$4,$5,$6)`,
+		customerID, saleID, managerID, Redeem, -amount.Amount, amount.Currency)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+//StoreCredits - store credit history of the customer, newest first
+func (s *Service) StoreCredits(ctx context.Context, customerID int64) ([]*StoreCredit, error) {
+	items := make([]*StoreCredit, 0)
+
+	rows, err := s.db.Query(ctx, `
+	select id, customer_id, coalesce(sale_id,0), coalesce(return_id,0), coalesce(manager_id,0), kind, amount, currency, reason, created
+	from store_credits where customer_id = $1 order by id desc limit 500`, customerID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &StoreCredit{}
+		err = rows.Scan(&item.ID, &item.CustomerID, &item.SaleID, &item.ReturnID, &item.ManagerID, &item.Kind,
+			&item.Amount.Amount, &item.Amount.Currency, &item.Reason, &item.Created)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
+
+//Liabilities - outstanding balances of gift cards and store credit by currency
+func (s *Service) Liabilities(ctx context.Context) ([]*Liability, error) {
+	items := make([]*Liability, 0)
+
+	rows, err := s.db.Query(ctx, `
+	with cards as (
+		select currency,
+			coalesce(sum(balance) filter (where active and (expires is null or expires >= current_timestamp)),0)::bigint outstanding,
+			count(*) filter (where active and balance > 0 and (expires is null or expires >= current_timestamp)) cards,
+			coalesce(sum(balance) filter (where not active or expires < current_timestamp),0)::bigint expired
+		from gift_cards
+		group by currency
+	), credits as (
+		select currency, coalesce(sum(balance),0)::bigint outstanding, count(*) filter (where balance > 0) customers
+		from (select customer_id, currency, sum(amount) balance from store_credits group by customer_id, currency) b
+		group by currency
+	)
+	select coalesce(c.currency, sc.currency), coalesce(c.outstanding,0), coalesce(c.cards,0), coalesce(c.expired,0),
+		coalesce(sc.outstanding,0), coalesce(sc.customers,0)
+	from cards c
+	full join credits sc on sc.currency = c.currency
+	order by 1`)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Liability{}
+		err = rows.Scan(&item.Currency, &item.GiftCards.Amount, &item.GiftCardCount, &item.Expired.Amount,
+			&item.StoreCredit.Amount, &item.Customers)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item.GiftCards.Currency = item.Currency
+		item.Expired.Currency = item.Currency
+		item.StoreCredit.Currency = item.Currency
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
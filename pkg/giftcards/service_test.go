@@ -0,0 +1,72 @@
+package giftcards_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/giftcards"
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+func TestIssueStoreCreditCappedByPaid(t *testing.T) {
+	pool := dbtest.Pool(t)
+	ctx := context.Background()
+	svc := giftcards.NewService(pool)
+
+	managerID := dbtest.Manager(t, pool, false)
+	customerID := dbtest.Customer(t, pool)
+	productID := dbtest.Product(t, pool, "tea", 1000, 10)
+	saleID := dbtest.Sale(t, pool, managerID, customerID, "unpaid", time.Now(),
+		dbtest.Position{ProductID: productID, Price: 1000, Qty: 1})
+	credit := func(amount int64) error {
+		tx, err := pool.Begin(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback(ctx)
+		returnID := int64(0)
+		err = tx.QueryRow(ctx, `
+		insert into returns(sale_id, customer_id, manager_id, total) values ($1, $2, $3, $4) returning id`,
+			saleID, customerID, managerID, amount).Scan(&returnID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = svc.IssueStoreCredit(ctx, tx, &giftcards.StoreCredit{CustomerID: customerID, SaleID: saleID, ReturnID: returnID,
+			ManagerID: managerID, Amount: money.New(amount, money.DefaultCurrency)})
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	}
+
+	err := credit(1)
+	if err != giftcards.ErrCreditExceedsSale {
+		t.Errorf("unpaid sale: err = %v, want %v", err, giftcards.ErrCreditExceedsSale)
+	}
+
+	// 500 handed over in cash with 100 change is 400 paid
+	_, err = pool.Exec(ctx, `
+	insert into payments(sale_id, manager_id, method, amount, change) values ($1, $2, 'cash', 500, 100)`, saleID, managerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = credit(401)
+	if err != giftcards.ErrCreditExceedsSale {
+		t.Errorf("credit over paid: err = %v, want %v", err, giftcards.ErrCreditExceedsSale)
+	}
+	err = credit(300)
+	if err != nil {
+		t.Fatalf("credit within paid: %v", err)
+	}
+	err = credit(101)
+	if err != giftcards.ErrCreditExceedsSale {
+		t.Errorf("credits together over paid: err = %v, want %v", err, giftcards.ErrCreditExceedsSale)
+	}
+	err = credit(100)
+	if err != nil {
+		t.Errorf("the rest of paid: %v", err)
+	}
+}
@@ -0,0 +1,487 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+//ErrInternal ...
+var ErrInternal = errors.New("internal error")
+
+//Config ...
+type Config struct {
+	//VelocityWindow - period of recent sales the daily sales velocity is computed from
+	VelocityWindow time.Duration
+	//SnapshotInterval - how often Schedule writes valuation snapshots, 0 turns it off
+	SnapshotInterval time.Duration
+}
+
+//Item - stock of one active product.
+//Velocity is units sold a day within the velocity window, DaysOfCover is how long the stock lasts at that pace,
+//it is nil when nothing was sold. Low is set when qty fell to the reorder point.
+type Item struct {
+	ProductID    int64        `json:"product_id"`
+	SKU          string       `json:"sku"`
+	Name         string       `json:"name"`
+	Qty          int          `json:"qty"`
+	ReorderPoint *int         `json:"reorder_point"`
+	Price        money.Money  `json:"price"`
+	Cost         *money.Money `json:"cost,omitempty"`
+	RetailValue  money.Money  `json:"retail_value"`
+	CostValue    *money.Money `json:"cost_value,omitempty"`
+	Sold         int64        `json:"sold"`
+	Velocity     float64      `json:"velocity"`
+	DaysOfCover  *float64     `json:"days_of_cover"`
+	Low          bool         `json:"low"`
+	//Taken - time of the snapshot, it is nil for the current stock
+	Taken *time.Time `json:"taken,omitempty"`
+}
+
+//Valuation - value of the stock in one currency, at prices and at costs.
+//CostValue counts only products with known cost, CostCoverage is the share of units it covers.
+type Valuation struct {
+	Currency     string       `json:"currency"`
+	Products     int          `json:"products"`
+	Units        int64        `json:"units"`
+	RetailValue  money.Money  `json:"retail_value"`
+	CostValue    *money.Money `json:"cost_value,omitempty"`
+	CostCoverage *float64     `json:"cost_coverage,omitempty"`
+	LowStock     int          `json:"low_stock"`
+	//Taken - time of the snapshot, it is nil for the current valuation
+	Taken *time.Time `json:"taken,omitempty"`
+}
+
+//Filter - Low leaves only products at or below their reorder point, the most short ones first
+type Filter struct {
+	Low bool
+}
+
+//SnapshotFilter - products stock taken within [From, To), ProductID 0 means all products.
+//Low leaves products that were at or below their reorder point.
+type SnapshotFilter struct {
+	From      time.Time
+	To        time.Time
+	ProductID int64
+	Low       bool
+}
+
+//snapshotLock - key of the advisory lock, snapshots of several instances of the app go one after another
+const snapshotLock = 20210301
+
+type Service struct {
+	db     *pgxpool.Pool
+	config Config
+}
+
+func NewService(db *pgxpool.Pool, config Config) *Service {
+	return &Service{db: db, config: config}
+}
+
+//Items ...
+func (s *Service) Items(ctx context.Context, filter Filter) ([]*Item, error) {
+	items := make([]*Item, 0)
+
+	err := s.EachItem(ctx, filter, func(item *Item) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+//EachItem passes stock items to fn one by one, error of fn stops it and is returned as it is
+func (s *Service) EachItem(ctx context.Context, filter Filter, fn func(*Item) error) error {
+	window := s.config.VelocityWindow
+	if window < 24*time.Hour {
+		window = 24 * time.Hour
+	}
+
+	rows, err := s.db.Query(ctx, `
+	select p.id, coalesce(p.sku, ''), p.name, p.qty, p.reorder_point, p.price, p.currency, p.cost, coalesce(v.units, 0)::bigint
+	from products p
+	left join (
+		select sp.product_id, sum(sp.qty) units
+		from sales_positions sp
+		join sales s on s.id = sp.sale_id
+		where s.created >= current_timestamp - $1::bigint * interval '1 second'
+		group by sp.product_id
+	) v on v.product_id = p.id
+	where p.active and (not $2 or p.qty <= p.reorder_point)
+	order by case when $2 then p.qty - p.reorder_point end, p.id`, int64(window/time.Second), filter.Low)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	defer rows.Close()
+
+	days := window.Hours() / 24
+	for rows.Next() {
+		item := &Item{}
+		var cost *int64
+		err = rows.Scan(&item.ProductID, &item.SKU, &item.Name, &item.Qty, &item.ReorderPoint, &item.Price.Amount, &item.Price.Currency,
+			&cost, &item.Sold)
+		if err != nil {
+			log.Print(err)
+			return ErrInternal
+		}
+
+		setValues(item, cost)
+		item.Velocity = round(float64(item.Sold) / days)
+		if item.Sold > 0 {
+			cover := round(float64(item.Qty) * days / float64(item.Sold))
+			item.DaysOfCover = &cover
+		}
+		item.Low = item.ReorderPoint != nil && item.Qty <= *item.ReorderPoint
+
+		err = fn(item)
+		if err != nil {
+			return err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+//setValues fills cost and value of the stock at prices and at costs
+func setValues(item *Item, cost *int64) {
+	currency := item.Price.Currency
+	item.RetailValue = money.New(item.Price.Amount*int64(item.Qty), currency)
+	if cost != nil {
+		value := money.New(*cost, currency)
+		item.Cost = &value
+		total := money.New(*cost*int64(item.Qty), currency)
+		item.CostValue = &total
+	}
+}
+
+//HideCosts leaves out cost of the product, only admins see costs
+func (item *Item) HideCosts() {
+	item.Cost = nil
+	item.CostValue = nil
+}
+
+//HideCosts leaves out value at costs, only admins see costs
+func (item *Valuation) HideCosts() {
+	item.CostValue = nil
+	item.CostCoverage = nil
+}
+
+//round keeps two decimals
+func round(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+//Valuation sums stock of active products by currency
+func (s *Service) Valuation(ctx context.Context) ([]*Valuation, error) {
+	totals := newValuations()
+	err := s.EachItem(ctx, Filter{}, func(item *Item) error {
+		totals.add(item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return totals.result(), nil
+}
+
+//valuations sums items by currency
+type valuations struct {
+	totals    map[string]*Valuation
+	costUnits map[string]int64
+}
+
+func newValuations() *valuations {
+	return &valuations{totals: make(map[string]*Valuation), costUnits: make(map[string]int64)}
+}
+
+func (v *valuations) add(item *Item) {
+	currency := item.Price.Currency
+	total, ok := v.totals[currency]
+	if !ok {
+		costValue := money.New(0, currency)
+		total = &Valuation{Currency: currency, RetailValue: money.New(0, currency), CostValue: &costValue}
+		v.totals[currency] = total
+	}
+	total.Products++
+	total.Units += int64(item.Qty)
+	total.RetailValue.Amount += item.RetailValue.Amount
+	if item.CostValue != nil {
+		total.CostValue.Amount += item.CostValue.Amount
+		v.costUnits[currency] += int64(item.Qty)
+	}
+	if item.Low {
+		total.LowStock++
+	}
+}
+
+//result returns totals sorted by currency
+func (v *valuations) result() []*Valuation {
+	items := make([]*Valuation, 0, len(v.totals))
+	for currency, total := range v.totals {
+		coverage := float64(0)
+		if total.Units > 0 {
+			coverage = float64(v.costUnits[currency]*10000/total.Units) / 100
+		}
+		total.CostCoverage = &coverage
+		items = append(items, total)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Currency < items[j].Currency
+	})
+	return items
+}
+
+//Snapshot writes the current valuation and stock of every product for dashboards, all rows get the same time
+func (s *Service) Snapshot(ctx context.Context) ([]*Valuation, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `select pg_advisory_xact_lock($1)`, snapshotLock)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	items, err := s.snapshot(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+//snapshot writes rows of the snapshot in tx, current_timestamp of tx is their time
+func (s *Service) snapshot(ctx context.Context, tx pgx.Tx) ([]*Valuation, error) {
+	totals := newValuations()
+	err := s.EachItem(ctx, Filter{}, func(item *Item) error {
+		totals.add(item)
+
+		var cost *int64
+		if item.Cost != nil {
+			cost = &item.Cost.Amount
+		}
+		_, err := tx.Exec(ctx, `
+		insert into inventory_snapshot_items(product_id, currency, qty, reorder_point, price, cost, sold, velocity, days_of_cover, low)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`, item.ProductID, item.Price.Currency, item.Qty, item.ReorderPoint,
+			item.Price.Amount, cost, item.Sold, item.Velocity, item.DaysOfCover, item.Low)
+		if err != nil {
+			log.Print(err)
+			return ErrInternal
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items := totals.result()
+	for _, item := range items {
+		item.Taken = new(time.Time)
+		err = tx.QueryRow(ctx, `
+		insert into inventory_snapshots(currency, products, units, retail_value, cost_value, cost_coverage, low_stock)
+		values ($1, $2, $3, $4, $5, $6, $7)
+		returning taken`, item.Currency, item.Products, item.Units, item.RetailValue.Amount, item.CostValue.Amount,
+			item.CostCoverage, item.LowStock).Scan(item.Taken)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+	}
+	return items, nil
+}
+
+//scheduledSnapshot takes a snapshot unless another instance of the app is taking it
+//or took it less than half an interval ago
+func (s *Service) scheduledSnapshot(ctx context.Context, interval time.Duration) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	locked := false
+	err = tx.QueryRow(ctx, `select pg_try_advisory_xact_lock($1)`, snapshotLock).Scan(&locked)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if !locked {
+		return nil
+	}
+
+	due := false
+	err = tx.QueryRow(ctx, `
+	select coalesce(max(taken) <= current_timestamp - $1::bigint * interval '1 second', true) from inventory_snapshots`,
+		int64(interval/2/time.Second)).Scan(&due)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if !due {
+		return nil
+	}
+
+	_, err = s.snapshot(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+//Snapshots returns snapshots taken within [from, to), oldest first
+func (s *Service) Snapshots(ctx context.Context, from time.Time, to time.Time) ([]*Valuation, error) {
+	items := make([]*Valuation, 0)
+
+	rows, err := s.db.Query(ctx, `
+	select currency, products, units, retail_value, cost_value, cost_coverage, low_stock, taken
+	from inventory_snapshots
+	where taken >= $1 and taken < $2
+	order by taken, currency`, from, to)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Valuation{Taken: new(time.Time), CostValue: &money.Money{}, CostCoverage: new(float64)}
+		err = rows.Scan(&item.Currency, &item.Products, &item.Units, &item.RetailValue.Amount, &item.CostValue.Amount,
+			&item.CostCoverage, &item.LowStock, item.Taken)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item.RetailValue.Currency = item.Currency
+		item.CostValue.Currency = item.Currency
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+//SnapshotItems ...
+func (s *Service) SnapshotItems(ctx context.Context, filter SnapshotFilter) ([]*Item, error) {
+	items := make([]*Item, 0)
+
+	err := s.EachSnapshotItem(ctx, filter, func(item *Item) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+//EachSnapshotItem passes stock of products taken by snapshots to fn, oldest first.
+//Sku and name are the current ones. Error of fn stops it and is returned as it is.
+func (s *Service) EachSnapshotItem(ctx context.Context, filter SnapshotFilter, fn func(*Item) error) error {
+	rows, err := s.db.Query(ctx, `
+	select si.product_id, coalesce(p.sku, ''), p.name, si.qty, si.reorder_point, si.price, si.currency, si.cost, si.sold,
+		si.velocity, si.days_of_cover, si.low, si.taken
+	from inventory_snapshot_items si
+	join products p on p.id = si.product_id
+	where si.taken >= $1 and si.taken < $2 and ($3::bigint = 0 or si.product_id = $3) and (not $4 or si.low)
+	order by si.taken, si.product_id`, filter.From, filter.To, filter.ProductID, filter.Low)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Item{Taken: new(time.Time)}
+		var cost *int64
+		err = rows.Scan(&item.ProductID, &item.SKU, &item.Name, &item.Qty, &item.ReorderPoint, &item.Price.Amount, &item.Price.Currency,
+			&cost, &item.Sold, &item.Velocity, &item.DaysOfCover, &item.Low, item.Taken)
+		if err != nil {
+			log.Print(err)
+			return ErrInternal
+		}
+		setValues(item, cost)
+
+		err = fn(item)
+		if err != nil {
+			return err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+//Schedule takes a snapshot every SnapshotInterval until ctx is done.
+//After a restart the first one waits for the rest of the interval since the last snapshot.
+//Every instance of the app runs it, the one that comes first takes the snapshot and others skip it.
+func (s *Service) Schedule(ctx context.Context) {
+	interval := s.config.SnapshotInterval
+	if interval <= 0 {
+		return
+	}
+
+	var wait *int64
+	err := s.db.QueryRow(ctx, `
+	select extract(epoch from max(taken) + $1::bigint * interval '1 second' - current_timestamp)::bigint
+	from inventory_snapshots`, int64(interval/time.Second)).Scan(&wait)
+	if err != nil {
+		log.Print(err)
+	}
+
+	delay := time.Duration(0)
+	if wait != nil && *wait > 0 {
+		delay = time.Duration(*wait) * time.Second
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		err = s.scheduledSnapshot(ctx, interval)
+		if err != nil {
+			log.Print(err)
+		}
+		timer.Reset(interval)
+	}
+}
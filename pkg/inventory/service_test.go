@@ -0,0 +1,55 @@
+package inventory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/inventory"
+)
+
+func TestSnapshotKeepsStockOfProducts(t *testing.T) {
+	pool := dbtest.Pool(t)
+	ctx := context.Background()
+	svc := inventory.NewService(pool, inventory.Config{VelocityWindow: 30 * 24 * time.Hour})
+
+	low := dbtest.Product(t, pool, "Snapshot low", 1000, 2)
+	enough := dbtest.Product(t, pool, "Snapshot enough", 1000, 20)
+	_, err := pool.Exec(ctx, `update products set reorder_point = 5 where id = any($1)`, []int64{low, enough})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := time.Now().Add(-time.Minute)
+	_, err = svc.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// stock changes after the snapshot don't change it
+	_, err = pool.Exec(ctx, `update products set qty = 0 where id = $1`, enough)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := time.Now().Add(time.Minute)
+
+	items, err := svc.SnapshotItems(ctx, inventory.SnapshotFilter{From: from, To: to, ProductID: enough})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Qty != 20 || items[0].Low || items[0].Taken == nil {
+		t.Fatalf("snapshot items of the product = %+v, want one with qty 20 taken before the change", items)
+	}
+
+	items, err = svc.SnapshotItems(ctx, inventory.SnapshotFilter{From: from, To: to, Low: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[int64]bool{}
+	for _, item := range items {
+		found[item.ProductID] = true
+	}
+	if !found[low] || found[enough] {
+		t.Errorf("low stock in the snapshot = %v, want product %d and not %d", found, low, enough)
+	}
+}
@@ -0,0 +1,334 @@
+package loyalty
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+var (
+	//ErrInternal ...
+	ErrInternal = errors.New("internal error")
+	//ErrNoCustomer - points can't be used in sales without a customer
+	ErrNoCustomer = errors.New("sale has no customer")
+	//ErrInsufficientPoints ...
+	ErrInsufficientPoints = errors.New("insufficient points")
+	//ErrInvalidAmount - amount is not a whole number of points
+	ErrInvalidAmount = errors.New("invalid points amount")
+)
+
+//ledger entry kinds
+const (
+	Earn   = "earn"
+	Spend  = "spend"
+	Expire = "expire"
+	//Reversal - points of returned goods taken back
+	Reversal = "reversal"
+)
+
+//Config ...
+type Config struct {
+	//PointsPerUnit - points earned for every 100 minor units (1.00) of eligible spend
+	PointsPerUnit int64
+	//PointValue - minor units one point is worth when spent
+	PointValue int64
+	//ExcludeDiscounted - positions sold below list price don't earn points
+	ExcludeDiscounted bool
+	//Expiry - earned points are lost after this period, zero means never
+	Expiry time.Duration
+}
+
+type Service struct {
+	db     *pgxpool.Pool
+	config Config
+}
+
+func NewService(db *pgxpool.Pool, config Config) *Service {
+	return &Service{db: db, config: config}
+}
+
+//Entry - one ledger record, balance is the sum of all entries
+type Entry struct {
+	ID      int64      `json:"id"`
+	SaleID  int64      `json:"sale_id"`
+	Kind    string     `json:"kind"`
+	Points  int64      `json:"points"`
+	Expires *time.Time `json:"expires"`
+	Created time.Time  `json:"created"`
+}
+
+//Account ...
+type Account struct {
+	CustomerID int64    `json:"customer_id"`
+	Balance    int64    `json:"balance"`
+	PointValue int64    `json:"point_value"`
+	History    []*Entry `json:"history"`
+}
+
+//Account returns balance and recent history of the customer
+func (s *Service) Account(ctx context.Context, customerID int64, limit int, offset int) (*Account, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	err = s.expire(ctx, tx, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	item := &Account{CustomerID: customerID, PointValue: s.config.PointValue, History: make([]*Entry, 0)}
+	err = tx.QueryRow(ctx, `select coalesce(sum(points),0)::bigint from loyalty_ledger where customer_id = $1`, customerID).
+		Scan(&item.Balance)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	rows, err := tx.Query(ctx, `
+	select id, coalesce(sale_id,0), kind, points, expires, created
+	from loyalty_ledger
+	where customer_id = $1
+	order by id desc
+	limit $2 offset $3`, customerID, limit, offset)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry := &Entry{}
+		err = rows.Scan(&entry.ID, &entry.SaleID, &entry.Kind, &entry.Points, &entry.Expires, &entry.Created)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item.History = append(item.History, entry)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	rows.Close()
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+//Earn books points for a paid sale, it does nothing when the sale already earned them
+func (s *Service) Earn(ctx context.Context, tx pgx.Tx, saleID int64) error {
+	customerID := int64(0)
+	err := tx.QueryRow(ctx, `select customer_id from sales where id = $1`, saleID).Scan(&customerID)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if customerID == 0 || s.config.PointsPerUnit <= 0 {
+		return nil
+	}
+
+	// amount paid with points doesn't earn new points
+	eligible := int64(0)
+	err = tx.QueryRow(ctx, `
+	select greatest(
+		coalesce((select sum(total) from sales_positions where sale_id = $1 and (not $2 or not discounted)),0)
+		- coalesce((select sum(amount) from payments where sale_id = $1 and method = 'points'),0),
+	0)::bigint`, saleID, s.config.ExcludeDiscounted).Scan(&eligible)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	points := eligible * s.config.PointsPerUnit / 100
+	if points <= 0 {
+		return nil
+	}
+
+	var expires *time.Time
+	if s.config.Expiry > 0 {
+		value := time.Now().Add(s.config.Expiry)
+		expires = &value
+	}
+
+	_, err = tx.Exec(ctx, `
+	insert into loyalty_ledger(customer_id, sale_id, kind, points, remaining, expires)
+	values ($1,$2,$3,$4,$4,$5)
+	on conflict (sale_id) where kind = 'earn' do nothing`, customerID, saleID, Earn, points, expires)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+//Reverse takes back points the sale earned for goods returned from it.
+//Points are taken in proportion of returned eligible spend, only from what is left of the sale's points:
+//points already spent or expired stay as they are.
+func (s *Service) Reverse(ctx context.Context, tx pgx.Tx, saleID int64) error {
+	id, customerID, points, remaining := int64(0), int64(0), int64(0), int64(0)
+	err := tx.QueryRow(ctx, `
+	select id, customer_id, points, remaining from loyalty_ledger where sale_id = $1 and kind = $2 for update`, saleID, Earn).
+		Scan(&id, &customerID, &points, &remaining)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	eligible, returned, reversed := int64(0), int64(0), int64(0)
+	err = tx.QueryRow(ctx, `
+	select
+		coalesce((select sum(total) from sales_positions where sale_id = $1 and (not $2 or not discounted)),0)::bigint,
+		coalesce((select sum(rp.total) from returns_positions rp join sales_positions sp on sp.id = rp.position_id
+			where sp.sale_id = $1 and (not $2 or not sp.discounted)),0)::bigint,
+		coalesce((select -sum(points) from loyalty_ledger where sale_id = $1 and kind = $3),0)::bigint`,
+		saleID, s.config.ExcludeDiscounted, Reversal).Scan(&eligible, &returned, &reversed)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if eligible <= 0 {
+		return nil
+	}
+
+	taken := points*returned/eligible - reversed
+	if taken > remaining {
+		taken = remaining
+	}
+	if taken <= 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(ctx, `update loyalty_ledger set remaining = remaining - $1 where id = $2`, taken, id)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	_, err = tx.Exec(ctx, `
+	insert into loyalty_ledger(customer_id, sale_id, kind, points) values ($1,$2,$3,$4)`, customerID, saleID, Reversal, -taken)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+//Spend takes points worth amount from the customer of the sale, oldest points go first
+func (s *Service) Spend(ctx context.Context, tx pgx.Tx, saleID int64, amount money.Money) (int64, error) {
+	if s.config.PointValue <= 0 || amount.Amount <= 0 || amount.Amount%s.config.PointValue != 0 {
+		return 0, ErrInvalidAmount
+	}
+	points := amount.Amount / s.config.PointValue
+
+	customerID := int64(0)
+	err := tx.QueryRow(ctx, `select customer_id from sales where id = $1`, saleID).Scan(&customerID)
+	if err != nil {
+		log.Print(err)
+		return 0, ErrInternal
+	}
+	if customerID == 0 {
+		return 0, ErrNoCustomer
+	}
+
+	err = s.expire(ctx, tx, customerID)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.Query(ctx, `
+	select id, remaining from loyalty_ledger
+	where customer_id = $1 and kind = $2 and remaining > 0
+	order by coalesce(expires, 'infinity'), id
+	for update`, customerID, Earn)
+	if err != nil {
+		log.Print(err)
+		return 0, ErrInternal
+	}
+	type lot struct {
+		id        int64
+		remaining int64
+	}
+	lots := make([]lot, 0)
+	available := int64(0)
+	for rows.Next() {
+		item := lot{}
+		err = rows.Scan(&item.id, &item.remaining)
+		if err != nil {
+			rows.Close()
+			log.Print(err)
+			return 0, ErrInternal
+		}
+		lots = append(lots, item)
+		available += item.remaining
+	}
+	rows.Close()
+	if available < points {
+		return 0, ErrInsufficientPoints
+	}
+
+	left := points
+	for _, item := range lots {
+		if left == 0 {
+			break
+		}
+		used := item.remaining
+		if used > left {
+			used = left
+		}
+		_, err = tx.Exec(ctx, `update loyalty_ledger set remaining = remaining - $1 where id = $2`, used, item.id)
+		if err != nil {
+			log.Print(err)
+			return 0, ErrInternal
+		}
+		left -= used
+	}
+
+	_, err = tx.Exec(ctx, `
+	insert into loyalty_ledger(customer_id, sale_id, kind, points) values ($1,$2,$3,$4)`, customerID, saleID, Spend, -points)
+	if err != nil {
+		log.Print(err)
+		return 0, ErrInternal
+	}
+	return points, nil
+}
+
+//expire writes off unused points whose period has ended
+func (s *Service) expire(ctx context.Context, tx pgx.Tx, customerID int64) error {
+	_, err := tx.Exec(ctx, `
+	with expired as (
+		select id, remaining from loyalty_ledger
+		where customer_id = $1 and kind = $2 and remaining > 0 and expires < current_timestamp
+		for update
+	), cleared as (
+		update loyalty_ledger l set remaining = 0 from expired e where l.id = e.id
+	)
+	insert into loyalty_ledger(customer_id, kind, points)
+	select $1, $3, -sum(remaining) from expired having sum(remaining) > 0`, customerID, Earn, Expire)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package loyalty_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/loyalty"
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+// inTx runs fn in a transaction and commits it when fn succeeds
+func inTx(t *testing.T, pool *pgxpool.Pool, fn func(tx pgx.Tx) error) error {
+	t.Helper()
+	ctx := context.Background()
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nil
+}
+
+// paidSale makes a paid sale of the customer with one position of the amount and earns points for it
+func paidSale(t *testing.T, pool *pgxpool.Pool, svc *loyalty.Service, managerID int64, customerID int64, amount int64) int64 {
+	t.Helper()
+	productID := dbtest.Product(t, pool, "loyalty tea", amount, 10)
+	saleID := dbtest.Sale(t, pool, managerID, customerID, "paid", time.Now(), dbtest.Position{ProductID: productID, Price: amount, Qty: 1})
+	err := inTx(t, pool, func(tx pgx.Tx) error {
+		return svc.Earn(context.Background(), tx, saleID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return saleID
+}
+
+func balance(t *testing.T, svc *loyalty.Service, customerID int64) int64 {
+	t.Helper()
+	account, err := svc.Account(context.Background(), customerID, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return account.Balance
+}
+
+func TestEarn(t *testing.T) {
+	pool := dbtest.Pool(t)
+	ctx := context.Background()
+	svc := loyalty.NewService(pool, loyalty.Config{PointsPerUnit: 2, PointValue: 10, ExcludeDiscounted: true})
+	managerID := dbtest.Manager(t, pool, false)
+	customerID := dbtest.Customer(t, pool)
+
+	productID := dbtest.Product(t, pool, "loyalty cup", 1000, 10)
+	saleID := dbtest.Sale(t, pool, managerID, customerID, "paid", time.Now(),
+		dbtest.Position{ProductID: productID, Price: 1000, Qty: 3}, dbtest.Position{ProductID: productID, Price: 500, Qty: 2})
+	// the second position is sold below list price, 200 of the rest is paid with points
+	_, err := pool.Exec(ctx, `update sales_positions set discounted = true where sale_id = $1 and price = 500`, saleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pool.Exec(ctx, `
+	insert into payments(sale_id, manager_id, method, amount) values ($1, $2, 'points', 200)`, saleID, managerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = inTx(t, pool, func(tx pgx.Tx) error {
+			return svc.Earn(ctx, tx, saleID)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// (3000 - 200) * 2 / 100, earned once
+	if got := balance(t, svc, customerID); got != 56 {
+		t.Errorf("balance = %d, want 56", got)
+	}
+
+	included := loyalty.NewService(pool, loyalty.Config{PointsPerUnit: 2, PointValue: 10})
+	other := dbtest.Customer(t, pool)
+	otherSale := dbtest.Sale(t, pool, managerID, other, "paid", time.Now(), dbtest.Position{ProductID: productID, Price: 500, Qty: 2})
+	_, err = pool.Exec(ctx, `update sales_positions set discounted = true where sale_id = $1`, otherSale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = inTx(t, pool, func(tx pgx.Tx) error {
+		return included.Earn(ctx, tx, otherSale)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := balance(t, included, other); got != 20 {
+		t.Errorf("discounted positions without ExcludeDiscounted: balance = %d, want 20", got)
+	}
+}
+
+func TestSpendOldestFirst(t *testing.T) {
+	pool := dbtest.Pool(t)
+	ctx := context.Background()
+	svc := loyalty.NewService(pool, loyalty.Config{PointsPerUnit: 1, PointValue: 10, Expiry: 30 * 24 * time.Hour})
+	managerID := dbtest.Manager(t, pool, false)
+	customerID := dbtest.Customer(t, pool)
+
+	late := paidSale(t, pool, svc, managerID, customerID, 5000)
+	early := paidSale(t, pool, svc, managerID, customerID, 3000)
+	// points of the second sale expire first, so they go first
+	_, err := pool.Exec(ctx, `update loyalty_ledger set expires = current_timestamp + interval '1 day' where sale_id = $1`, early)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spending := dbtest.Sale(t, pool, managerID, customerID, "unpaid", time.Now())
+	spend := func(amount int64) (int64, error) {
+		points := int64(0)
+		err := inTx(t, pool, func(tx pgx.Tx) error {
+			var err error
+			points, err = svc.Spend(ctx, tx, spending, money.New(amount, money.DefaultCurrency))
+			return err
+		})
+		return points, err
+	}
+
+	_, err = spend(15)
+	if err != loyalty.ErrInvalidAmount {
+		t.Errorf("part of a point: err = %v, want %v", err, loyalty.ErrInvalidAmount)
+	}
+	_, err = spend(810)
+	if err != loyalty.ErrInsufficientPoints {
+		t.Errorf("more than balance: err = %v, want %v", err, loyalty.ErrInsufficientPoints)
+	}
+
+	points, err := spend(400)
+	if err != nil || points != 40 {
+		t.Fatalf("spend 400 = %d points, %v, want 40", points, err)
+	}
+	remaining := map[int64]int64{}
+	rows, err := pool.Query(ctx, `select sale_id, remaining from loyalty_ledger where sale_id = any($1) and kind = 'earn'`,
+		[]int64{late, early})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		saleID, value := int64(0), int64(0)
+		err = rows.Scan(&saleID, &value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		remaining[saleID] = value
+	}
+	rows.Close()
+	if remaining[early] != 0 || remaining[late] != 40 {
+		t.Errorf("remaining points = %v, want the earliest expiring lot used up first", remaining)
+	}
+	if got := balance(t, svc, customerID); got != 40 {
+		t.Errorf("balance = %d, want 40", got)
+	}
+
+	noCustomer := dbtest.Sale(t, pool, managerID, 0, "unpaid", time.Now())
+	err = inTx(t, pool, func(tx pgx.Tx) error {
+		_, err := svc.Spend(ctx, tx, noCustomer, money.New(10, money.DefaultCurrency))
+		return err
+	})
+	if err != loyalty.ErrNoCustomer {
+		t.Errorf("sale without customer: err = %v, want %v", err, loyalty.ErrNoCustomer)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	pool := dbtest.Pool(t)
+	ctx := context.Background()
+	svc := loyalty.NewService(pool, loyalty.Config{PointsPerUnit: 1, PointValue: 10, Expiry: 30 * 24 * time.Hour})
+	managerID := dbtest.Manager(t, pool, false)
+	customerID := dbtest.Customer(t, pool)
+
+	expired := paidSale(t, pool, svc, managerID, customerID, 2000)
+	paidSale(t, pool, svc, managerID, customerID, 1000)
+	_, err := pool.Exec(ctx, `update loyalty_ledger set expires = current_timestamp - interval '1 day' where sale_id = $1`, expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// expiry is booked once, however often the account is read
+	for i := 0; i < 2; i++ {
+		if got := balance(t, svc, customerID); got != 10 {
+			t.Errorf("balance = %d, want 10 left after 20 points expired", got)
+		}
+	}
+	entries := 0
+	err = pool.QueryRow(ctx, `select count(*) from loyalty_ledger where customer_id = $1 and kind = 'expire' and points = -20`,
+		customerID).Scan(&entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries != 1 {
+		t.Errorf("expire entries = %d, want 1", entries)
+	}
+}
@@ -0,0 +1,349 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+//ErrInvalidRFMSegment ...
+var ErrInvalidRFMSegment = errors.New("invalid rfm segment")
+
+//RFM segments. Recency and monetary scores are 1 to 5 within customers buying in the same currency,
+//frequency score comes from the number of purchases, see frequencyScore
+const (
+	//Champions - bought recently and often
+	Champions = "champions"
+	//Loyal - buy regularly
+	Loyal = "loyal"
+	//Newcomers - recent first purchase
+	Newcomers = "new"
+	//Promising - recent buyers with few purchases
+	Promising = "promising"
+	//AtRisk - used to buy often, but not lately
+	AtRisk = "at_risk"
+	//Hibernating - few purchases long ago
+	Hibernating = "hibernating"
+	//Lost - the longest without purchases
+	Lost = "lost"
+)
+
+//Segments - all segments from the best to the worst
+var Segments = []string{Champions, Loyal, Newcomers, Promising, AtRisk, Hibernating, Lost}
+
+//frequencyScore - fixed thresholds of purchases, most customers buy once or twice,
+//so quantiles of purchases are mostly ties and can't tell one purchase from a few
+func frequencyScore(purchases int64) int {
+	switch {
+	case purchases >= 10:
+		return 5
+	case purchases >= 5:
+		return 4
+	case purchases >= 3:
+		return 3
+	case purchases == 2:
+		return 2
+	}
+	return 1
+}
+
+//segment maps recency and frequency scores to a segment, monetary score only ranks customers inside it
+func segment(recency int, frequency int) string {
+	switch {
+	case recency >= 4 && frequency >= 4:
+		return Champions
+	case recency >= 3 && frequency >= 3:
+		return Loyal
+	case recency >= 4 && frequency <= 1:
+		return Newcomers
+	case recency >= 3:
+		return Promising
+	case frequency >= 3:
+		return AtRisk
+	case recency == 2:
+		return Hibernating
+	}
+	return Lost
+}
+
+//CustomerAnalyticsQuery - ManagerIDs limits purchases to sales of these managers, nil means all sales.
+//Segment leaves customers of one segment.
+type CustomerAnalyticsQuery struct {
+	ManagerIDs []int64
+	Segment    string
+}
+
+//CustomerStats - lifetime purchases of a customer in one currency.
+//Spend is net of returns, Frequency is purchases a month since the first one.
+type CustomerStats struct {
+	CustomerID   int64       `json:"customer_id"`
+	Name         string      `json:"name"`
+	Phone        string      `json:"phone"`
+	Created      time.Time   `json:"created"`
+	Spend        money.Money `json:"spend"`
+	Returns      money.Money `json:"returns"`
+	Purchases    int64       `json:"purchases"`
+	AverageOrder money.Money `json:"average_order"`
+	Frequency    float64     `json:"frequency"`
+	First        time.Time   `json:"first_purchase"`
+	Last         time.Time   `json:"last_purchase"`
+	RecencyDays  int         `json:"recency_days"`
+	R            int         `json:"r"`
+	F            int         `json:"f"`
+	M            int         `json:"m"`
+	Segment      string      `json:"segment"`
+}
+
+//CustomerAnalytics ...
+func (s *Service) CustomerAnalytics(ctx context.Context, query CustomerAnalyticsQuery) ([]*CustomerStats, error) {
+	items := make([]*CustomerStats, 0)
+
+	err := s.EachCustomerStats(ctx, query, func(item *CustomerStats) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+//EachCustomerStats passes customers with purchases to fn one by one, the biggest spenders first.
+//Error of fn stops it and is returned as it is.
+func (s *Service) EachCustomerStats(ctx context.Context, query CustomerAnalyticsQuery, fn func(*CustomerStats) error) error {
+	if query.Segment != "" && !validSegment(query.Segment) {
+		return ErrInvalidRFMSegment
+	}
+
+	// cume_dist gives equal scores to equal values, frequency score is set below
+	rows, err := s.db.Query(ctx, `
+	with purchases as (
+		select s.customer_id, s.currency, count(*) purchases, sum(s.total) total, min(s.created) first, max(s.created) last
+		from sales s
+		where $1::bigint[] is null or s.manager_id = any($1)
+		group by s.customer_id, s.currency
+	), returned as (
+		select s.customer_id, r.currency, sum(r.total) amount
+		from returns r join sales s on s.id = r.sale_id
+		where $1::bigint[] is null or s.manager_id = any($1)
+		group by s.customer_id, r.currency
+	), stats as (
+		select p.customer_id, p.currency, p.purchases, p.total - coalesce(r.amount, 0) spend, coalesce(r.amount, 0) returned,
+			p.first, p.last
+		from purchases p
+		left join returned r on r.customer_id = p.customer_id and r.currency = p.currency
+	)
+	select c.id, c.name, c.phone, c.created, st.currency, st.spend::bigint, st.returned::bigint, st.purchases, st.first, st.last,
+		extract(day from current_timestamp - st.last)::int,
+		ceil(5 * cume_dist() over (partition by st.currency order by st.last))::int,
+		ceil(5 * cume_dist() over (partition by st.currency order by st.spend))::int
+	from stats st
+	join customers c on c.id = st.customer_id
+	order by st.currency, st.spend desc, c.id`, query.ManagerIDs)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &CustomerStats{}
+		currency := ""
+		err = rows.Scan(&item.CustomerID, &item.Name, &item.Phone, &item.Created, &currency, &item.Spend.Amount, &item.Returns.Amount,
+			&item.Purchases, &item.First, &item.Last, &item.RecencyDays, &item.R, &item.M)
+		if err != nil {
+			log.Print(err)
+			return ErrInternal
+		}
+		item.F = frequencyScore(item.Purchases)
+		item.Segment = segment(item.R, item.F)
+		if query.Segment != "" && item.Segment != query.Segment {
+			continue
+		}
+
+		item.Spend.Currency = currency
+		item.Returns.Currency = currency
+		item.AverageOrder = money.New(0, currency)
+		if item.Purchases > 0 {
+			item.AverageOrder.Amount = (item.Spend.Amount + item.Purchases/2) / item.Purchases
+		}
+		// purchases within the first month count as one month of buying
+		months := item.Last.Sub(item.First).Hours() / 24 / 30
+		if months < 1 {
+			months = 1
+		}
+		item.Frequency = float64(int64(float64(item.Purchases)/months*100+0.5)) / 100
+
+		err = fn(item)
+		if err != nil {
+			return err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+func validSegment(value string) bool {
+	for _, item := range Segments {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+//SegmentSummary - customers of a segment buying in one currency
+type SegmentSummary struct {
+	Segment      string      `json:"segment"`
+	Customers    int64       `json:"customers"`
+	Spend        money.Money `json:"spend"`
+	AverageSpend money.Money `json:"average_spend"`
+}
+
+//CustomerSegments sums customer analytics by segment, segments go from the best to the worst
+func (s *Service) CustomerSegments(ctx context.Context, query CustomerAnalyticsQuery) ([]*SegmentSummary, error) {
+	totals := make(map[string]*SegmentSummary)
+	order := make(map[string]int)
+	for i, item := range Segments {
+		order[item] = i
+	}
+
+	err := s.EachCustomerStats(ctx, query, func(item *CustomerStats) error {
+		key := item.Spend.Currency + item.Segment
+		total, ok := totals[key]
+		if !ok {
+			total = &SegmentSummary{Segment: item.Segment, Spend: money.New(0, item.Spend.Currency)}
+			totals[key] = total
+		}
+		total.Customers++
+		total.Spend.Amount += item.Spend.Amount
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*SegmentSummary, 0, len(totals))
+	for _, total := range totals {
+		total.AverageSpend = money.New((total.Spend.Amount+total.Customers/2)/total.Customers, total.Spend.Currency)
+		items = append(items, total)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Spend.Currency != items[j].Spend.Currency {
+			return items[i].Spend.Currency < items[j].Spend.Currency
+		}
+		return order[items[i].Segment] < order[items[j].Segment]
+	})
+	return items, nil
+}
+
+//CohortQuery - cohorts of customers registered within [From, To), both are starts of months in UTC
+type CohortQuery struct {
+	From       time.Time
+	To         time.Time
+	ManagerIDs []int64
+}
+
+//Cohort - customers registered in one month.
+//Active[i] is how many of them bought something in the i-th month after it, Retention[i] is their share in percent.
+type Cohort struct {
+	Month     time.Time `json:"month"`
+	Customers int64     `json:"customers"`
+	Active    []int64   `json:"active"`
+	Retention []float64 `json:"retention"`
+}
+
+//Cohorts groups customers by the month of registration and follows their purchases up to the current month
+func (s *Service) Cohorts(ctx context.Context, query CohortQuery) ([]*Cohort, error) {
+	items := make([]*Cohort, 0)
+	now := time.Now().UTC()
+	current := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	rows, err := s.db.Query(ctx, `
+	select date_trunc('month', created), count(*)
+	from customers
+	where created >= $1 and created < $2 and merged_into is null
+	group by 1
+	order by 1`, query.From, query.To)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	cohorts := make(map[time.Time]*Cohort)
+	for rows.Next() {
+		item := &Cohort{}
+		err = rows.Scan(&item.Month, &item.Customers)
+		if err != nil {
+			rows.Close()
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		months := monthsBetween(item.Month, current) + 1
+		if months < 1 {
+			months = 1
+		}
+		item.Active = make([]int64, months)
+		item.Retention = make([]float64, months)
+		cohorts[item.Month] = item
+		items = append(items, item)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	rows, err = s.db.Query(ctx, `
+	select date_trunc('month', c.created), date_trunc('month', s.created), count(distinct c.id)
+	from customers c
+	join sales s on s.customer_id = c.id
+	where c.created >= $1 and c.created < $2 and c.merged_into is null and s.created >= date_trunc('month', c.created)
+		and ($3::bigint[] is null or s.manager_id = any($3))
+	group by 1, 2`, query.From, query.To, query.ManagerIDs)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var month, active time.Time
+		count := int64(0)
+		err = rows.Scan(&month, &active, &count)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item, ok := cohorts[month]
+		offset := monthsBetween(month, active)
+		if !ok || offset >= len(item.Active) {
+			continue
+		}
+		item.Active[offset] = count
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	for _, item := range items {
+		for i, count := range item.Active {
+			item.Retention[i] = float64(count*10000/item.Customers) / 100
+		}
+	}
+	return items, nil
+}
+
+func monthsBetween(from time.Time, to time.Time) int {
+	return (to.Year()-from.Year())*12 + int(to.Month()) - int(from.Month())
+}
@@ -0,0 +1,66 @@
+package managers
+
+import "testing"
+
+func TestFrequencyScore(t *testing.T) {
+	tests := []struct {
+		purchases int64
+		want      int
+	}{
+		{1, 1},
+		{2, 2},
+		{3, 3},
+		{4, 3},
+		{5, 4},
+		{9, 4},
+		{10, 5},
+		{150, 5},
+	}
+	for _, test := range tests {
+		if got := frequencyScore(test.purchases); got != test.want {
+			t.Errorf("frequencyScore(%d) = %d, want %d", test.purchases, got, test.want)
+		}
+	}
+}
+
+func TestSegment(t *testing.T) {
+	tests := []struct {
+		recency   int
+		frequency int
+		want      string
+	}{
+		{5, 5, Champions},
+		{4, 4, Champions},
+		{3, 5, Loyal},
+		{4, 3, Loyal},
+		{5, 1, Newcomers},
+		{4, 1, Newcomers},
+		{5, 2, Promising},
+		{3, 1, Promising},
+		{2, 5, AtRisk},
+		{1, 3, AtRisk},
+		{2, 2, Hibernating},
+		{2, 1, Hibernating},
+		{1, 2, Lost},
+		{1, 1, Lost},
+	}
+	for _, test := range tests {
+		if got := segment(test.recency, test.frequency); got != test.want {
+			t.Errorf("segment(%d, %d) = %q, want %q", test.recency, test.frequency, got, test.want)
+		}
+	}
+}
+
+func TestSegmentsAreReachable(t *testing.T) {
+	reached := make(map[string]bool)
+	for recency := 1; recency <= 5; recency++ {
+		for _, purchases := range []int64{1, 2, 3, 5, 10} {
+			reached[segment(recency, frequencyScore(purchases))] = true
+		}
+	}
+	for _, item := range Segments {
+		if !reached[item] {
+			t.Errorf("segment %q is never reached", item)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package managers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+var (
+	//ErrInvalidNote ...
+	ErrInvalidNote = errors.New("invalid note")
+	//ErrInvalidTag - tags are short words of letters, digits, "-" and "_"
+	ErrInvalidTag = errors.New("invalid tag")
+	//ErrInvalidSegment ...
+	ErrInvalidSegment = errors.New("invalid segment")
+)
+
+//MaxTagLength ...
+const MaxTagLength = 32
+
+//Note - free-form remark about a customer
+type Note struct {
+	ID         int64     `json:"id"`
+	CustomerID int64     `json:"customer_id"`
+	ManagerID  int64     `json:"manager_id"`
+	Author     string    `json:"author"`
+	Text       string    `json:"text"`
+	Created    time.Time `json:"created"`
+}
+
+//SegmentRules - nil and empty rules are not applied
+type SegmentRules struct {
+	//Currency of spend limits, default currency when empty
+	Currency string `json:"currency"`
+	MinSpend *int64 `json:"min_spend"`
+	MaxSpend *int64 `json:"max_spend"`
+	//LastPurchaseFrom, LastPurchaseTo - bounds of the latest purchase, To is exclusive
+	LastPurchaseFrom *time.Time `json:"last_purchase_from"`
+	LastPurchaseTo   *time.Time `json:"last_purchase_to"`
+	//Tags - customer must have all of them
+	Tags []string `json:"tags"`
+}
+
+//Segment - saved set of rules
+type Segment struct {
+	ID        int64        `json:"id"`
+	Name      string       `json:"name"`
+	Rules     SegmentRules `json:"rules"`
+	ManagerID int64        `json:"manager_id"`
+	Created   time.Time    `json:"created"`
+}
+
+//CustomersFilter - Tag and SegmentID are optional, segment rules go first.
+//Limit 0 means all customers for EachCustomer and a page of 100 for Customers.
+type CustomersFilter struct {
+	Tag       string
+	SegmentID int64
+	Limit     int
+	Offset    int
+}
+
+//CustomersPage - Total counts all customers matching the filter, not only the page
+type CustomersPage struct {
+	Items  []*Customer `json:"items"`
+	Total  int64       `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+}
+
+//CustomerNotes ...
+func (s *Service) CustomerNotes(ctx context.Context, customerID int64) ([]*Note, error) {
+	items := make([]*Note, 0)
+
+	rows, err := s.db.Query(ctx, `
+	select n.id, n.customer_id, n.manager_id, m.name, n.text, n.created
+	from customers_notes n
+	join managers m on m.id = n.manager_id
+	where n.customer_id = $1
+	order by n.id desc`, customerID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Note{}
+		err = rows.Scan(&item.ID, &item.CustomerID, &item.ManagerID, &item.Author, &item.Text, &item.Created)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+//AddCustomerNote ...
+func (s *Service) AddCustomerNote(ctx context.Context, managerID int64, customerID int64, text string) (*Note, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, ErrInvalidNote
+	}
+
+	item := &Note{}
+	err := s.db.QueryRow(ctx, `
+	with note as (
+		insert into customers_notes(customer_id, manager_id, text)
+		select id, $2, $3 from customers where id = $1
+		returning id, customer_id, manager_id, text, created
+	)
+	select n.id, n.customer_id, n.manager_id, m.name, n.text, n.created from note n join managers m on m.id = n.manager_id`,
+		customerID, managerID, text).Scan(&item.ID, &item.CustomerID, &item.ManagerID, &item.Author, &item.Text, &item.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+//RemoveCustomerNote deletes note, only its author or an admin can do it
+func (s *Service) RemoveCustomerNote(ctx context.Context, managerID int64, customerID int64, noteID int64) error {
+	tag, err := s.db.Exec(ctx, `
+	delete from customers_notes
+	where id = $1 and customer_id = $2 and (manager_id = $3 or (select is_admin from managers where id = $3))`,
+		noteID, customerID, managerID)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+//CustomerTags ...
+func (s *Service) CustomerTags(ctx context.Context, customerID int64) ([]string, error) {
+	items := make([]string, 0)
+	err := s.db.QueryRow(ctx, `
+	select coalesce(array_agg(tag order by tag), '{}') from customers_tags where customer_id = $1`, customerID).Scan(&items)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+//SetCustomerTags replaces tags of the customer
+func (s *Service) SetCustomerTags(ctx context.Context, customerID int64, tags []string) ([]string, error) {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	exists := false
+	err = tx.QueryRow(ctx, `select exists(select 1 from customers where id = $1)`, customerID).Scan(&exists)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	_, err = tx.Exec(ctx, `delete from customers_tags where customer_id = $1 and not tag = any($2)`, customerID, tags)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	_, err = tx.Exec(ctx, `
+	insert into customers_tags(customer_id, tag) select $1, unnest($2::text[])
+	on conflict do nothing`, customerID, tags)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return tags, nil
+}
+
+//Segments ...
+func (s *Service) Segments(ctx context.Context) ([]*Segment, error) {
+	items := make([]*Segment, 0)
+
+	rows, err := s.db.Query(ctx, `select id, name, rules, manager_id, created from customers_segments order by id`)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Segment{}
+		var rules []byte
+		err = rows.Scan(&item.ID, &item.Name, &rules, &item.ManagerID, &item.Created)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		err = json.Unmarshal(rules, &item.Rules)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+//SaveSegment creates segment when ID is 0 and updates it otherwise
+func (s *Service) SaveSegment(ctx context.Context, segment *Segment) (*Segment, error) {
+	var err error
+
+	segment.Name = strings.TrimSpace(segment.Name)
+	if segment.Name == "" {
+		return nil, ErrInvalidSegment
+	}
+	if segment.Rules.Currency == "" {
+		segment.Rules.Currency = money.DefaultCurrency
+	}
+	if !money.ValidCurrency(segment.Rules.Currency) {
+		return nil, money.ErrInvalidCurrency
+	}
+	segment.Rules.Tags, err = normalizeTags(segment.Rules.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := json.Marshal(segment.Rules)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	if segment.ID == 0 {
+		err = s.db.QueryRow(ctx, `
+		insert into customers_segments(name, rules, manager_id) values ($1,$2,$3) returning id, created`,
+			segment.Name, rules, segment.ManagerID).Scan(&segment.ID, &segment.Created)
+	} else {
+		err = s.db.QueryRow(ctx, `
+		update customers_segments set name = $2, rules = $3 where id = $1 returning manager_id, created`,
+			segment.ID, segment.Name, rules).Scan(&segment.ManagerID, &segment.Created)
+	}
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return segment, nil
+}
+
+//RemoveSegment ...
+func (s *Service) RemoveSegment(ctx context.Context, id int64) error {
+	tag, err := s.db.Exec(ctx, `delete from customers_segments where id = $1`, id)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+//segmentRules ...
+func (s *Service) segmentRules(ctx context.Context, id int64) (*SegmentRules, error) {
+	rules := &SegmentRules{}
+	var data []byte
+	err := s.db.QueryRow(ctx, `select rules from customers_segments where id = $1`, id).Scan(&data)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	err = json.Unmarshal(data, rules)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return rules, nil
+}
+
+//normalizeTags lowercases, checks and deduplicates tags
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	items := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || len(tag) > MaxTagLength {
+			return nil, ErrInvalidTag
+		}
+		for _, r := range tag {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r > 127) {
+				return nil, ErrInvalidTag
+			}
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			items = append(items, tag)
+		}
+	}
+	sort.Strings(items)
+	return items, nil
+}
@@ -0,0 +1,100 @@
+package managers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/managers"
+)
+
+func TestSegmentRules(t *testing.T) {
+	svc, pool := newService(t)
+	ctx := context.Background()
+	managerID := dbtest.Manager(t, pool, false)
+	productID := dbtest.Product(t, pool, "Segment tea", 100, 1000)
+
+	// a tag of its own leaves only customers of this test
+	tag := "segment" + dbtest.Phone()[1:]
+	customer := func(tags ...string) int64 {
+		id := dbtest.Customer(t, pool)
+		for _, value := range append(tags, tag) {
+			_, err := pool.Exec(ctx, `insert into customers_tags(customer_id, tag) values ($1, $2)`, id, value)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return id
+	}
+	sale := func(customerID int64, status string, amount int64, created time.Time) {
+		dbtest.Sale(t, pool, managerID, customerID, status, created, dbtest.Position{ProductID: productID, Price: amount, Qty: 1})
+	}
+	day := func(month time.Month, day int) time.Time {
+		return time.Date(2021, month, day, 12, 0, 0, 0, time.UTC)
+	}
+	midnight := func(month time.Month, day int) *time.Time {
+		value := time.Date(2021, month, day, 0, 0, 0, 0, time.UTC)
+		return &value
+	}
+	amount := func(value int64) *int64 { return &value }
+
+	big := customer("vip", "tea")
+	sale(big, "paid", 5000, day(time.March, 10))
+	small := customer("vip")
+	sale(small, "paid", 1000, day(time.January, 10))
+	// unpaid sales are not spend and not purchases
+	unpaid := customer("tea")
+	sale(unpaid, "paid", 500, day(time.February, 1))
+	sale(unpaid, "unpaid", 9000, day(time.March, 12))
+	idle := customer()
+
+	tests := []struct {
+		name  string
+		rules managers.SegmentRules
+		want  []int64
+	}{
+		{"min spend", managers.SegmentRules{MinSpend: amount(2000)}, []int64{big}},
+		{"max spend", managers.SegmentRules{MaxSpend: amount(1000)}, []int64{small, unpaid, idle}},
+		{"spend bounds are inclusive", managers.SegmentRules{MinSpend: amount(500), MaxSpend: amount(1000)}, []int64{small, unpaid}},
+		{"last purchase from", managers.SegmentRules{LastPurchaseFrom: midnight(time.March, 1)}, []int64{big}},
+		{"last purchase to is exclusive", managers.SegmentRules{LastPurchaseTo: midnight(time.February, 1)}, []int64{small}},
+		{"last purchase window", managers.SegmentRules{LastPurchaseFrom: midnight(time.February, 1), LastPurchaseTo: midnight(time.March, 10)},
+			[]int64{unpaid}},
+		{"all tags", managers.SegmentRules{Tags: []string{"vip", "tea"}}, []int64{big}},
+		{"one tag", managers.SegmentRules{Tags: []string{"VIP"}}, []int64{big, small}},
+		{"no rules", managers.SegmentRules{}, []int64{big, small, unpaid, idle}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			segment, err := svc.SaveSegment(ctx, &managers.Segment{Name: test.name, Rules: test.rules, ManagerID: managerID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, err := svc.Customers(ctx, managers.CustomersFilter{Tag: tag, SegmentID: segment.ID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.Total != int64(len(test.want)) || len(page.Items) != len(test.want) {
+				t.Fatalf("%d customers of %d total, want %d", len(page.Items), page.Total, len(test.want))
+			}
+			for i, item := range page.Items {
+				if item.ID != test.want[i] {
+					t.Errorf("customer %d = %d, want %d", i, item.ID, test.want[i])
+				}
+			}
+		})
+	}
+
+	page, err := svc.Customers(ctx, managers.CustomersFilter{Tag: tag})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spent := make(map[int64]int64)
+	for _, item := range page.Items {
+		spent[item.ID] = item.Spent.Amount
+	}
+	if spent[big] != 5000 || spent[unpaid] != 500 || spent[idle] != 0 {
+		t.Errorf("spent = %v, want 5000, 500 and 0 paid", spent)
+	}
+}
@@ -0,0 +1,388 @@
+package managers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/manucher051299/crud/pkg/money"
+	"github.com/manucher051299/crud/pkg/taxes"
+)
+
+var (
+	//ErrInvalidImport - file can't be read at all, errors of single rows go to the report
+	ErrInvalidImport = errors.New("invalid import file")
+	//ErrInvalidProduct ...
+	ErrInvalidProduct = errors.New("invalid product")
+	//ErrDuplicateSKU - sku repeats in the file
+	ErrDuplicateSKU = errors.New("duplicate sku")
+	//ErrUnknownCategory ...
+	ErrUnknownCategory = errors.New("unknown category")
+)
+
+//MaxSKULength ...
+const MaxSKULength = 64
+
+//ImportRow - product parsed from one row of the file, Line counts from 1 with the header.
+//Columns lists optional fields the row sets, others keep their values when the product is updated.
+type ImportRow struct {
+	Line    int
+	Product *Product
+	Columns []string
+	Err     error
+}
+
+//optionalColumns - product fields besides sku, name and price that a row may leave out
+var optionalColumns = []string{"currency", "qty", "category_id", "tax_rate", "tax_included", "cost", "reorder_point"}
+
+//ImportOptions - BatchSize 0 applies all valid rows in one transaction, otherwise every batch is committed separately
+type ImportOptions struct {
+	DryRun    bool
+	BatchSize int
+}
+
+//ImportError ...
+type ImportError struct {
+	Line  int    `json:"line"`
+	SKU   string `json:"sku"`
+	Error string `json:"error"`
+}
+
+//ImportReport - what was (or would be on dry run) done with the rows
+type ImportReport struct {
+	DryRun  bool           `json:"dry_run"`
+	Rows    int            `json:"rows"`
+	Created int            `json:"created"`
+	Updated int            `json:"updated"`
+	Failed  int            `json:"failed"`
+	Errors  []*ImportError `json:"errors"`
+}
+
+//ParseProductsCSV reads rows of a csv file with a header, a broken row is reported and the rest is read on.
+//Columns are sku, name, price, currency, qty, category_id, tax_rate, tax_included, cost and reorder_point in any order,
+//the first three are required, unknown ones are skipped, so products export can be loaded back.
+//Missing columns and empty cells leave fields of existing products as they are.
+//Prices and costs are in major units, tax_rate is in basis points.
+func ParseProductsCSV(r io.Reader, delimiter rune) ([]*ImportRow, error) {
+	reader := csv.NewReader(r)
+	if delimiter != 0 {
+		reader.Comma = delimiter
+	}
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, ErrInvalidImport
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, name := range []string{"sku", "name", "price"} {
+		if _, ok := columns[name]; !ok {
+			return nil, ErrInvalidImport
+		}
+	}
+
+	items := make([]*ImportRow, 0)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		item := &ImportRow{Line: line}
+		items = append(items, item)
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); !ok {
+				return nil, ErrInvalidImport
+			}
+			item.Err = ErrInvalidImport
+			continue
+		}
+
+		value := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		item.Product, item.Err = productFromRecord(value)
+		item.Columns = make([]string, 0)
+		for _, name := range optionalColumns {
+			if value(name) != "" {
+				item.Columns = append(item.Columns, name)
+			}
+		}
+	}
+	return items, nil
+}
+
+func productFromRecord(value func(name string) string) (*Product, error) {
+	var err error
+	product := &Product{SKU: value("sku"), Name: value("name"), TaxIncluded: true}
+
+	currency := value("currency")
+	if currency == "" {
+		currency = money.DefaultCurrency
+	}
+	product.Price, err = money.Parse(value("price"), strings.ToUpper(currency))
+	if err != nil {
+		return product, err
+	}
+	if text := value("qty"); text != "" {
+		product.Qty, err = strconv.Atoi(text)
+		if err != nil {
+			return product, ErrInvalidQty
+		}
+	}
+	if text := value("category_id"); text != "" {
+		product.CategoryID, err = strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return product, ErrUnknownCategory
+		}
+	}
+	if text := value("tax_rate"); text != "" {
+		rate, err := strconv.Atoi(text)
+		if err != nil {
+			return product, taxes.ErrInvalidRate
+		}
+		product.TaxRate = &rate
+	}
+	if text := value("tax_included"); text != "" {
+		product.TaxIncluded, err = strconv.ParseBool(text)
+		if err != nil {
+			return product, ErrInvalidProduct
+		}
+	}
+	if text := value("cost"); text != "" {
+		cost, err := money.Parse(text, product.Price.Currency)
+		if err != nil {
+			return product, ErrInvalidCost
+		}
+		product.Cost = &cost.Amount
+	}
+	if text := value("reorder_point"); text != "" {
+		point, err := strconv.Atoi(text)
+		if err != nil {
+			return product, ErrInvalidReorderPoint
+		}
+		product.ReorderPoint = &point
+	}
+	return product, nil
+}
+
+//ParseProductsJSONLines reads one product json per line, amounts are in minor units like in the api.
+//Fields left out of the json keep their values, null clears cost, tax_rate and reorder_point.
+func ParseProductsJSONLines(r io.Reader) ([]*ImportRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	items := make([]*ImportRow, 0)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		item := &ImportRow{Line: line, Product: &Product{TaxIncluded: true}, Columns: make([]string, 0)}
+		fields := make(map[string]json.RawMessage)
+		err := json.Unmarshal(data, &fields)
+		if err == nil {
+			err = json.Unmarshal(data, item.Product)
+		}
+		if err != nil {
+			item.Err = ErrInvalidImport
+		}
+		for _, name := range optionalColumns {
+			_, ok := fields[name]
+			// currency comes with the price
+			if name == "currency" {
+				_, ok = fields["price"]
+			}
+			if ok {
+				item.Columns = append(item.Columns, name)
+			}
+		}
+		items = append(items, item)
+	}
+	if scanner.Err() != nil {
+		return nil, ErrInvalidImport
+	}
+	return items, nil
+}
+
+//validateImport checks fields of every row and repeated skus, failed rows keep their error
+func (s *Service) validateImport(ctx context.Context, rows []*ImportRow) error {
+	categories := make(map[int64]bool)
+	items, err := s.Categories(ctx)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		categories[item.ID] = true
+	}
+
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		if row.Err != nil {
+			continue
+		}
+		product := row.Product
+		product.SKU = strings.TrimSpace(product.SKU)
+		product.Name = strings.TrimSpace(product.Name)
+		if product.Price.Currency == "" {
+			product.Price.Currency = money.DefaultCurrency
+		}
+
+		switch {
+		case product.SKU == "" || len(product.SKU) > MaxSKULength:
+			row.Err = ErrInvalidSKU
+		case seen[product.SKU]:
+			row.Err = ErrDuplicateSKU
+		case product.Name == "" || product.Price.Amount <= 0:
+			row.Err = ErrInvalidProduct
+		case !money.ValidCurrency(product.Price.Currency):
+			row.Err = money.ErrInvalidCurrency
+		case product.Qty < 0:
+			row.Err = ErrInvalidQty
+		case product.TaxRate != nil && !taxes.ValidRate(*product.TaxRate):
+			row.Err = taxes.ErrInvalidRate
+		case product.Cost != nil && *product.Cost < 0:
+			row.Err = ErrInvalidCost
+		case product.ReorderPoint != nil && *product.ReorderPoint < 0:
+			row.Err = ErrInvalidReorderPoint
+		case product.CategoryID != 0 && !categories[product.CategoryID]:
+			row.Err = ErrUnknownCategory
+		}
+		seen[product.SKU] = true
+	}
+	return nil
+}
+
+//ImportProducts validates rows and upserts valid ones by sku.
+//Dry run does everything in a transaction that is rolled back, so the report shows what would happen.
+func (s *Service) ImportProducts(ctx context.Context, rows []*ImportRow, options ImportOptions) (*ImportReport, error) {
+	err := s.validateImport(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{DryRun: options.DryRun, Rows: len(rows), Errors: make([]*ImportError, 0)}
+	valid := make([]*ImportRow, 0, len(rows))
+	for _, row := range rows {
+		if row.Err == nil {
+			valid = append(valid, row)
+		}
+	}
+
+	size := options.BatchSize
+	if size <= 0 || options.DryRun {
+		size = len(valid)
+	}
+	for start := 0; start < len(valid); start += size {
+		end := start + size
+		if end > len(valid) {
+			end = len(valid)
+		}
+		created, updated, err := s.importBatch(ctx, valid[start:end], options.DryRun)
+		if err != nil {
+			// batch is rolled back as a whole, its rows are reported failed
+			for _, row := range valid[start:end] {
+				row.Err = err
+			}
+			continue
+		}
+		report.Created += created
+		report.Updated += updated
+	}
+
+	for _, row := range rows {
+		if row.Err == nil {
+			continue
+		}
+		item := &ImportError{Line: row.Line, Error: row.Err.Error()}
+		if row.Product != nil {
+			item.SKU = row.Product.SKU
+		}
+		report.Errors = append(report.Errors, item)
+	}
+	report.Failed = len(report.Errors)
+	return report, nil
+}
+
+//importBatch upserts products in one transaction and notifies subscribers of products back in stock
+func (s *Service) importBatch(ctx context.Context, rows []*ImportRow, dryRun bool) (int, int, error) {
+	created, updated := 0, 0
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return 0, 0, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	for _, row := range rows {
+		product := row.Product
+
+		previous := 0
+		err = tx.QueryRow(ctx, `select qty from products where sku = $1 for update`, product.SKU).Scan(&previous)
+		switch err {
+		case nil:
+			updated++
+		case pgx.ErrNoRows:
+			created++
+		default:
+			log.Print(err)
+			return 0, 0, ErrInternal
+		}
+
+		// fields the row doesn't set keep their values, archived product is listed again
+		err = tx.QueryRow(ctx, `
+		insert into products(sku,name,qty,price,currency,category_id,tax_rate,tax_included,cost,reorder_point)
+		values ($1,$2,$3,$4,$5,nullif($6,0),$7,$8,$9,$10)
+		on conflict (sku) do update set name = excluded.name, price = excluded.price, active = true,
+			currency = case when 'currency' = any($11) then excluded.currency else products.currency end,
+			qty = case when 'qty' = any($11) then excluded.qty else products.qty end,
+			category_id = case when 'category_id' = any($11) then excluded.category_id else products.category_id end,
+			tax_rate = case when 'tax_rate' = any($11) then excluded.tax_rate else products.tax_rate end,
+			tax_included = case when 'tax_included' = any($11) then excluded.tax_included else products.tax_included end,
+			cost = case when 'cost' = any($11) then excluded.cost else products.cost end,
+			reorder_point = case when 'reorder_point' = any($11) then excluded.reorder_point else products.reorder_point end
+		returning id, qty, active, created`, product.SKU, product.Name, product.Qty, product.Price.Amount, product.Price.Currency,
+			product.CategoryID, product.TaxRate, product.TaxIncluded, product.Cost, product.ReorderPoint, row.Columns).
+			Scan(&product.ID, &product.Qty, &product.Active, &product.Created)
+		if err != nil {
+			log.Print(err)
+			return 0, 0, ErrInternal
+		}
+
+		if previous <= 0 && product.Qty > 0 && !dryRun {
+			err = s.wishlistSvc.Restocked(ctx, tx, product.ID)
+			if err != nil {
+				return 0, 0, ErrInternal
+			}
+		}
+	}
+
+	if dryRun {
+		return created, updated, nil
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return 0, 0, ErrInternal
+	}
+
+	s.wishlistSvc.Notify()
+	return created, updated, nil
+}
@@ -0,0 +1,108 @@
+package managers_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/managers"
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+func TestParseProductsCSVColumns(t *testing.T) {
+	file := "SKU;Name;Price;Qty;Cost\nA-1;Tea;12.5;3;\nA-2;Cup;7;;4\n"
+	rows, err := managers.ParseProductsCSV(strings.NewReader(file), ';')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("%d rows, want 2", len(rows))
+	}
+
+	first, second := rows[0], rows[1]
+	if first.Err != nil || first.Product.Price.Amount != 1250 || first.Product.Qty != 3 || first.Product.Cost != nil {
+		t.Errorf("first row = %+v, %v", first.Product, first.Err)
+	}
+	if strings.Join(first.Columns, ",") != "qty" {
+		t.Errorf("first row sets %v, want only qty", first.Columns)
+	}
+	if second.Err != nil || second.Product.Cost == nil || *second.Product.Cost != 400 {
+		t.Errorf("second row = %+v, %v", second.Product, second.Err)
+	}
+	if strings.Join(second.Columns, ",") != "cost" {
+		t.Errorf("second row sets %v, want only cost", second.Columns)
+	}
+}
+
+func TestParseProductsJSONLinesColumns(t *testing.T) {
+	file := `{"sku": "A-1", "name": "Tea", "price": 1250, "cost": null}
+{"sku": "A-2", "name": "Cup", "qty": 5, "tax_included": false}
+not json`
+	rows, err := managers.ParseProductsJSONLines(strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("%d rows, want 3", len(rows))
+	}
+	if strings.Join(rows[0].Columns, ",") != "currency,cost" {
+		t.Errorf("first row sets %v, want currency with the price and cost", rows[0].Columns)
+	}
+	if strings.Join(rows[1].Columns, ",") != "qty,tax_included" || rows[1].Product.TaxIncluded {
+		t.Errorf("second row sets %v, want qty and tax_included", rows[1].Columns)
+	}
+	if rows[2].Err != managers.ErrInvalidImport {
+		t.Errorf("broken line err = %v, want %v", rows[2].Err, managers.ErrInvalidImport)
+	}
+}
+
+func TestImportKeepsMissingColumns(t *testing.T) {
+	svc, pool := newService(t)
+	ctx := context.Background()
+
+	rate, cost, point := 1500, int64(300), 2
+	product, err := svc.SaveProduct(ctx, dbtest.Manager(t, pool, true), &managers.Product{SKU: "keep" + dbtest.Phone()[1:], Name: "Tea", Qty: 5,
+		Price: money.New(1000, money.DefaultCurrency), TaxRate: &rate, TaxIncluded: false, Cost: &cost, ReorderPoint: &point})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// archived products are listed again by import
+	_, err = pool.Exec(ctx, `update products set active = false where id = $1`, product.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := managers.ParseProductsCSV(strings.NewReader("sku,name,price\n"+product.SKU+",Green tea,12.50\n"), ',')
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := svc.ImportProducts(ctx, rows, managers.ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Updated != 1 || report.Failed != 0 {
+		t.Fatalf("report = %+v, want one product updated", report)
+	}
+
+	var taxRate, reorderPoint *int
+	var unitCost *int64
+	name, price, qty, taxIncluded, active := "", int64(0), 0, true, false
+	err = pool.QueryRow(ctx, `
+	select name, price, qty, tax_rate, tax_included, cost, reorder_point, active from products where id = $1`, product.ID).
+		Scan(&name, &price, &qty, &taxRate, &taxIncluded, &unitCost, &reorderPoint, &active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "Green tea" || price != 1250 {
+		t.Errorf("name and price = %q %d, want them updated", name, price)
+	}
+	if qty != 5 || taxIncluded || taxRate == nil || *taxRate != 1500 || unitCost == nil || *unitCost != 300 ||
+		reorderPoint == nil || *reorderPoint != 2 {
+		t.Errorf("fields missing from the file changed: qty %d, tax included %v, tax rate %v, cost %v, reorder point %v",
+			qty, taxIncluded, taxRate, unitCost, reorderPoint)
+	}
+	if !active {
+		t.Errorf("imported product must be active")
+	}
+}
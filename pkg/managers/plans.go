@@ -0,0 +1,321 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+//plan periods
+const (
+	Month   = "month"
+	Quarter = "quarter"
+)
+
+var (
+	//ErrInvalidPeriod ...
+	ErrInvalidPeriod = errors.New("invalid period")
+	//ErrInvalidPlan - plan needs either manager or departament and a positive target
+	ErrInvalidPlan = errors.New("invalid plan")
+)
+
+//Plan - sales target of a manager or a departament for one period
+type Plan struct {
+	ID          int64       `json:"id"`
+	ManagerID   int64       `json:"manager_id"`
+	Departament string      `json:"departament"`
+	Period      string      `json:"period"`
+	Start       time.Time   `json:"start"`
+	Target      money.Money `json:"target"`
+	Created     time.Time   `json:"created"`
+}
+
+//Progress - how far sales are from the target
+type Progress struct {
+	ManagerID   int64       `json:"manager_id,omitempty"`
+	Name        string      `json:"name,omitempty"`
+	Departament string      `json:"departament,omitempty"`
+	Period      string      `json:"period"`
+	Start       time.Time   `json:"start"`
+	End         time.Time   `json:"end"`
+	Target      money.Money `json:"target"`
+	Achieved    money.Money `json:"achieved"`
+	Percent     float64     `json:"percent"`
+	//Projection - expected result at the period end if sales go on at the same pace
+	Projection money.Money `json:"projection"`
+	Rank       int         `json:"rank,omitempty"`
+}
+
+//PeriodBounds returns [start, end) of the month or quarter containing day
+func PeriodBounds(period string, day time.Time) (time.Time, time.Time, error) {
+	switch period {
+	case Month:
+		start := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, day.Location())
+		return start, start.AddDate(0, 1, 0), nil
+	case Quarter:
+		month := (day.Month()-1)/3*3 + 1
+		start := time.Date(day.Year(), month, 1, 0, 0, 0, 0, day.Location())
+		return start, start.AddDate(0, 3, 0), nil
+	}
+	return time.Time{}, time.Time{}, ErrInvalidPeriod
+}
+
+//SavePlan creates or replaces plan for the period containing plan.Start
+func (s *Service) SavePlan(ctx context.Context, plan *Plan) (*Plan, error) {
+	var err error
+
+	plan.Departament = strings.TrimSpace(plan.Departament)
+	if (plan.ManagerID == 0) == (plan.Departament == "") || plan.Target.Amount <= 0 {
+		return nil, ErrInvalidPlan
+	}
+	if plan.Target.Currency == "" {
+		plan.Target.Currency = money.DefaultCurrency
+	}
+	if !money.ValidCurrency(plan.Target.Currency) {
+		return nil, money.ErrInvalidCurrency
+	}
+	plan.Start, _, err = PeriodBounds(plan.Period, plan.Start)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.QueryRow(ctx, `
+	insert into sales_plans(manager_id, departament, period, start, target, currency)
+	values (nullif($1::bigint, 0), $2, $3, $4, $5, $6)
+	on conflict ((coalesce(manager_id, 0)), departament, period, start)
+	do update set target = excluded.target, currency = excluded.currency
+	returning id, created`, plan.ManagerID, plan.Departament, plan.Period, plan.Start, plan.Target.Amount, plan.Target.Currency).
+		Scan(&plan.ID, &plan.Created)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return plan, nil
+}
+
+//Plans lists plans of the period containing day the viewer can see:
+//admins see every plan, others only plans of their team and no departament plans
+func (s *Service) Plans(ctx context.Context, viewerID int64, period string, day time.Time) ([]*Plan, error) {
+	start, _, err := PeriodBounds(period, day)
+	if err != nil {
+		return nil, err
+	}
+
+	var team []int64
+	if !s.IsAdmin(ctx, viewerID) {
+		team, err = s.Team(ctx, viewerID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	items := make([]*Plan, 0)
+	rows, err := s.db.Query(ctx, `
+	select id, coalesce(manager_id, 0), departament, period, start, target, currency, created
+	from sales_plans where period = $1 and start = $2 and ($3::bigint[] is null or manager_id = any($3))
+	order by departament, manager_id`, period, start, team)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Plan{}
+		err = rows.Scan(&item.ID, &item.ManagerID, &item.Departament, &item.Period, &item.Start, &item.Target.Amount, &item.Target.Currency, &item.Created)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+//Progress of the manager, without explicit plan managers.plan is used as monthly target
+func (s *Service) Progress(ctx context.Context, managerID int64, period string, day time.Time) (*Progress, error) {
+	start, end, err := PeriodBounds(period, day)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &Progress{ManagerID: managerID, Period: period, Start: start, End: end}
+	months := int64(1)
+	if period == Quarter {
+		months = 3
+	}
+	err = s.db.QueryRow(ctx, `
+	select m.name, coalesce(m.departament, ''),
+		coalesce(p.target, m.plan::bigint * $4), coalesce(p.currency, $5)
+	from managers m
+	left join sales_plans p on p.manager_id = m.id and p.period = $2 and p.start = $3
+	where m.id = $1`, managerID, period, start, months, money.DefaultCurrency).
+		Scan(&item.Name, &item.Departament, &item.Target.Amount, &item.Target.Currency)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	err = s.achieved(ctx, item, []int64{managerID})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+//DepartamentProgress - progress of all managers of the departament against its plan
+func (s *Service) DepartamentProgress(ctx context.Context, departament string, period string, day time.Time) (*Progress, error) {
+	start, end, err := PeriodBounds(period, day)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &Progress{Departament: departament, Period: period, Start: start, End: end, Target: money.New(0, money.DefaultCurrency)}
+	err = s.db.QueryRow(ctx, `
+	select target, currency from sales_plans where manager_id is null and departament = $1 and period = $2 and start = $3`,
+		departament, period, start).Scan(&item.Target.Amount, &item.Target.Currency)
+	if err != nil && err != pgx.ErrNoRows {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	ids := make([]int64, 0)
+	rows, err := s.db.Query(ctx, `select id from managers where departament = $1`, departament)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	err = s.achieved(ctx, item, ids)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+//Leaderboard ranks the boss and subordinates by plan completion, admins get everyone
+func (s *Service) Leaderboard(ctx context.Context, bossID int64, period string, day time.Time) ([]*Progress, error) {
+	var ids []int64
+	var err error
+	if s.IsAdmin(ctx, bossID) {
+		ids, err = s.allManagerIDs(ctx)
+	} else {
+		ids, err = s.Team(ctx, bossID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*Progress, 0, len(ids))
+	for _, id := range ids {
+		item, err := s.Progress(ctx, id, period, day)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Percent != items[j].Percent {
+			return items[i].Percent > items[j].Percent
+		}
+		return items[i].Achieved.Amount > items[j].Achieved.Amount
+	})
+	for i, item := range items {
+		item.Rank = i + 1
+	}
+	return items, nil
+}
+
+//achieved fills achieved amount, percent and projection of the progress.
+//Like commission in payroll it counts sales paid within the period less returns made within it.
+func (s *Service) achieved(ctx context.Context, item *Progress, managerIDs []int64) error {
+	item.Achieved = money.New(0, item.Target.Currency)
+	err := s.db.QueryRow(ctx, `
+	select coalesce((select sum(total) from sales
+		where manager_id = any($1) and status = 'paid' and currency = $2 and paid >= $3 and paid < $4), 0)::bigint -
+		coalesce((select sum(r.total) from returns r join sales s on s.id = r.sale_id
+		where s.manager_id = any($1) and r.currency = $2 and r.created >= $3 and r.created < $4), 0)::bigint`,
+		managerIDs, item.Target.Currency, item.Start, item.End).Scan(&item.Achieved.Amount)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if item.Achieved.Amount < 0 {
+		item.Achieved.Amount = 0
+	}
+
+	if item.Target.Amount > 0 {
+		item.Percent = float64(item.Achieved.Amount*10000/item.Target.Amount) / 100
+	}
+
+	item.Projection = money.New(projection(item.Achieved.Amount, item.Start, item.End, time.Now()), item.Achieved.Currency)
+	return nil
+}
+
+//projection extends amount achieved by now to the whole [start, end) period, past periods are what they are
+func projection(achieved int64, start time.Time, end time.Time, now time.Time) int64 {
+	if !now.After(start) || !now.Before(end) {
+		return achieved
+	}
+	return int64(float64(achieved) * float64(end.Sub(start)) / float64(now.Sub(start)))
+}
+
+func (s *Service) allManagerIDs(ctx context.Context) ([]int64, error) {
+	items := make([]int64, 0)
+	rows, err := s.db.Query(ctx, `select id from managers where active order by id`)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		items = append(items, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
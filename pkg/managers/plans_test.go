@@ -0,0 +1,229 @@
+package managers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/managers"
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+func TestPlansScopedToTeam(t *testing.T) {
+	svc, pool := newService(t)
+	ctx := context.Background()
+
+	adminID := dbtest.Manager(t, pool, true)
+	bossID := dbtest.Manager(t, pool, false)
+	subordinateID := dbtest.Manager(t, pool, false)
+	outsiderID := dbtest.Manager(t, pool, false)
+	_, err := pool.Exec(ctx, `update managers set boss_id = $1 where id = $2`, bossID, subordinateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2021, time.May, 10, 0, 0, 0, 0, time.UTC)
+	target := money.New(100000, money.DefaultCurrency)
+	plans := map[string]*managers.Plan{
+		"boss":        {ManagerID: bossID, Period: managers.Month, Start: day, Target: target},
+		"subordinate": {ManagerID: subordinateID, Period: managers.Month, Start: day, Target: target},
+		"outsider":    {ManagerID: outsiderID, Period: managers.Month, Start: day, Target: target},
+		"departament": {Departament: "plans" + dbtest.Phone()[1:], Period: managers.Month, Start: day, Target: target},
+	}
+	for name, plan := range plans {
+		_, err = svc.SavePlan(ctx, plan)
+		if err != nil {
+			t.Fatalf("%s plan: %v", name, err)
+		}
+	}
+
+	visible := func(viewerID int64) map[int64]bool {
+		items, err := svc.Plans(ctx, viewerID, managers.Month, day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := make(map[int64]bool)
+		for _, item := range items {
+			result[item.ID] = true
+		}
+		return result
+	}
+
+	tests := []struct {
+		name   string
+		viewer int64
+		want   map[string]bool
+	}{
+		{"admin", adminID, map[string]bool{"boss": true, "subordinate": true, "outsider": true, "departament": true}},
+		{"boss", bossID, map[string]bool{"boss": true, "subordinate": true}},
+		{"subordinate", subordinateID, map[string]bool{"subordinate": true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			seen := visible(test.viewer)
+			for name, plan := range plans {
+				if seen[plan.ID] != test.want[name] {
+					t.Errorf("%s plan visible = %v, want %v", name, seen[plan.ID], test.want[name])
+				}
+			}
+		})
+	}
+}
+
+func march(day int) time.Time {
+	return time.Date(2021, time.March, day, 12, 0, 0, 0, time.UTC)
+}
+
+// paidSale makes a sale of the manager for the amount paid at the time
+func paidSale(t *testing.T, pool *pgxpool.Pool, managerID int64, amount int64, paid time.Time) int64 {
+	t.Helper()
+	productID := dbtest.Product(t, pool, "Plan tea", amount, 10)
+	return dbtest.Sale(t, pool, managerID, dbtest.Customer(t, pool), "paid", paid, dbtest.Position{ProductID: productID, Price: amount, Qty: 1})
+}
+
+func savePlan(t *testing.T, svc *managers.Service, plan *managers.Plan) {
+	t.Helper()
+	_, err := svc.SavePlan(context.Background(), plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProgressCountsPaidSalesLessReturns(t *testing.T) {
+	svc, pool := newService(t)
+	ctx := context.Background()
+	managerID := dbtest.Manager(t, pool, false)
+	savePlan(t, svc, &managers.Plan{ManagerID: managerID, Period: managers.Month, Start: march(10),
+		Target: money.New(10000, money.DefaultCurrency)})
+
+	paidSale(t, pool, managerID, 3000, march(5))
+	// made in February, paid in March
+	late := paidSale(t, pool, managerID, 2000, time.Date(2021, time.February, 27, 12, 0, 0, 0, time.UTC))
+	_, err := pool.Exec(ctx, `update sales set paid = $2 where id = $1`, late, march(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	returned := paidSale(t, pool, managerID, 1000, march(6))
+	_, err = pool.Exec(ctx, `
+	insert into returns(sale_id, customer_id, manager_id, total, created)
+	select id, customer_id, manager_id, 500, $2 from sales where id = $1`, returned, march(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	productID := dbtest.Product(t, pool, "Plan cup", 5000, 10)
+	dbtest.Sale(t, pool, managerID, 0, "unpaid", march(7), dbtest.Position{ProductID: productID, Price: 5000, Qty: 1})
+	paidSale(t, pool, managerID, 4000, time.Date(2021, time.April, 1, 12, 0, 0, 0, time.UTC))
+
+	item, err := svc.Progress(ctx, managerID, managers.Month, march(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 3000 + 2000 + 1000 paid in March, 500 of them returned
+	if item.Target.Amount != 10000 || item.Achieved.Amount != 5500 || item.Percent != 55 {
+		t.Errorf("progress = target %d, achieved %d, %.2f%%, want 10000, 5500, 55%%", item.Target.Amount, item.Achieved.Amount, item.Percent)
+	}
+	// the period is over, so nothing is projected
+	if item.Projection.Amount != item.Achieved.Amount {
+		t.Errorf("projection of a past period = %d, want %d", item.Projection.Amount, item.Achieved.Amount)
+	}
+	if !item.Start.Equal(time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC)) || !item.End.Equal(time.Date(2021, time.April, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("period = [%v, %v), want March", item.Start, item.End)
+	}
+
+	// without a plan for the period the monthly plan of the manager counts for every month
+	_, err = pool.Exec(ctx, `update managers set plan = 4000 where id = $1`, managerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err = svc.Progress(ctx, managerID, managers.Quarter, march(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Target.Amount != 12000 || item.Achieved.Amount != 5500 {
+		t.Errorf("quarter progress = target %d, achieved %d, want 12000, 5500", item.Target.Amount, item.Achieved.Amount)
+	}
+
+	_, err = svc.Progress(ctx, managerID, "week", march(20))
+	if err != managers.ErrInvalidPeriod {
+		t.Errorf("unknown period: err = %v, want %v", err, managers.ErrInvalidPeriod)
+	}
+	_, err = svc.Progress(ctx, -1, managers.Month, march(20))
+	if err != managers.ErrNotFound {
+		t.Errorf("unknown manager: err = %v, want %v", err, managers.ErrNotFound)
+	}
+}
+
+func TestDepartamentProgress(t *testing.T) {
+	svc, pool := newService(t)
+	ctx := context.Background()
+	departament := "Plans " + dbtest.Phone()
+	first := dbtest.Manager(t, pool, false)
+	second := dbtest.Manager(t, pool, false)
+	outside := dbtest.Manager(t, pool, false)
+	for _, id := range []int64{first, second} {
+		err := svc.SetDepartament(ctx, id, departament)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	savePlan(t, svc, &managers.Plan{Departament: departament, Period: managers.Month, Start: march(1),
+		Target: money.New(8000, money.DefaultCurrency)})
+
+	paidSale(t, pool, first, 2000, march(3))
+	paidSale(t, pool, second, 1000, march(4))
+	paidSale(t, pool, outside, 5000, march(4))
+
+	item, err := svc.DepartamentProgress(ctx, departament, managers.Month, march(15))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Departament != departament || item.Target.Amount != 8000 || item.Achieved.Amount != 3000 || item.Percent != 37.5 {
+		t.Errorf("progress = %s target %d, achieved %d, %.2f%%, want target 8000, achieved 3000, 37.5%%",
+			item.Departament, item.Target.Amount, item.Achieved.Amount, item.Percent)
+	}
+}
+
+func TestLeaderboard(t *testing.T) {
+	svc, pool := newService(t)
+	ctx := context.Background()
+	boss := dbtest.Manager(t, pool, false)
+	steady := dbtest.Manager(t, pool, false)
+	strong := dbtest.Manager(t, pool, false)
+	outside := dbtest.Manager(t, pool, false)
+	for _, id := range []int64{steady, strong} {
+		err := svc.SetBoss(ctx, id, boss)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the boss reaches half of the plan, both subordinates 80%, the one who sold more goes first
+	for id, amounts := range map[int64][2]int64{boss: {1000, 500}, steady: {1000, 800}, strong: {2000, 1600}, outside: {1000, 1000}} {
+		savePlan(t, svc, &managers.Plan{ManagerID: id, Period: managers.Month, Start: march(1), Target: money.New(amounts[0], money.DefaultCurrency)})
+		paidSale(t, pool, id, amounts[1], march(10))
+	}
+
+	items, err := svc.Leaderboard(ctx, boss, managers.Month, march(15))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{strong, steady, boss}
+	if len(items) != len(want) {
+		t.Fatalf("%d managers on the leaderboard, want %d", len(items), len(want))
+	}
+	for i, id := range want {
+		if items[i].ManagerID != id || items[i].Rank != i+1 {
+			t.Errorf("place %d = manager %d with rank %d, want manager %d", i+1, items[i].ManagerID, items[i].Rank, id)
+		}
+	}
+
+	items, err = svc.Leaderboard(ctx, steady, managers.Month, march(15))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].ManagerID != steady || items[0].Percent != 80 {
+		t.Errorf("leaderboard of a manager without team = %+v, want only the manager at 80%%", items)
+	}
+}
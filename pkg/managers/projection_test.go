@@ -0,0 +1,30 @@
+package managers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProjection(t *testing.T) {
+	start := time.Date(2021, time.April, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	tests := []struct {
+		name string
+		now  time.Time
+		want int64
+	}{
+		{"a third of the month", start.AddDate(0, 0, 10), 3000},
+		{"half of the month", start.AddDate(0, 0, 15), 2000},
+		{"period start", start, 1000},
+		{"before the period", start.AddDate(0, 0, -1), 1000},
+		{"period end", end, 1000},
+		{"after the period", end.AddDate(0, 0, 1), 1000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := projection(1000, start, end, test.now); got != test.want {
+				t.Errorf("projection = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+//ErrInvalidGrouping ...
+var ErrInvalidGrouping = errors.New("invalid grouping")
+
+//sales report groupings
+const (
+	ByDay      = "day"
+	ByWeek     = "week"
+	ByMonth    = "month"
+	ByProduct  = "product"
+	ByCategory = "category"
+	ByManager  = "manager"
+	ByCustomer = "customer"
+)
+
+//salesGroupings - bucket, id and name columns of every grouping, $4 is the time zone of buckets
+var salesGroupings = map[string]string{
+	ByDay:      `date_trunc('day', (s.created at time zone 'UTC') at time zone $4), 0::bigint, ''`,
+	ByWeek:     `date_trunc('week', (s.created at time zone 'UTC') at time zone $4), 0::bigint, ''`,
+	ByMonth:    `date_trunc('month', (s.created at time zone 'UTC') at time zone $4), 0::bigint, ''`,
+	ByProduct:  `null::timestamp, p.id, p.name`,
+	ByCategory: `null::timestamp, coalesce(c.id, 0), coalesce(c.name, '')`,
+	ByManager:  `null::timestamp, m.id, m.name`,
+	ByCustomer: `null::timestamp, coalesce(cu.id, 0), coalesce(cu.name, '')`,
+}
+
+//SalesReportQuery - period is [From, To), time buckets are days, weeks or months of Location.
+//ManagerIDs limits the report to sales of these managers, nil means all sales.
+//Costs adds margin and cost coverage, only admins may see them.
+type SalesReportQuery struct {
+	From       time.Time
+	To         time.Time
+	GroupBy    string
+	Location   *time.Location
+	ManagerIDs []int64
+	Costs      bool
+}
+
+//SalesReportRow - metrics of paid sales of one group in one currency, like commission in payroll.
+//Margin is revenue without taxes minus costs, it is nil when costs of sold products aren't known.
+//CostCoverage is the share of units with known cost the margin is computed from.
+//Both are left out unless the query asks for costs.
+type SalesReportRow struct {
+	Bucket        *time.Time   `json:"bucket,omitempty"`
+	ID            int64        `json:"id,omitempty"`
+	Name          string       `json:"name,omitempty"`
+	Revenue       money.Money  `json:"revenue"`
+	Units         int64        `json:"units"`
+	Sales         int64        `json:"sales"`
+	AverageTicket money.Money  `json:"average_ticket"`
+	Margin        *money.Money `json:"margin,omitempty"`
+	CostCoverage  *float64     `json:"cost_coverage,omitempty"`
+}
+
+//SalesReport ...
+func (s *Service) SalesReport(ctx context.Context, query SalesReportQuery) ([]*SalesReportRow, error) {
+	items := make([]*SalesReportRow, 0)
+
+	err := s.EachSalesReportRow(ctx, query, func(item *SalesReportRow) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+//EachSalesReportRow passes rows of the report to fn one by one, error of fn stops it and is returned as it is
+func (s *Service) EachSalesReportRow(ctx context.Context, query SalesReportQuery, fn func(*SalesReportRow) error) error {
+	columns, ok := salesGroupings[query.GroupBy]
+	if !ok {
+		return ErrInvalidGrouping
+	}
+	location := query.Location
+	if location == nil {
+		location = time.UTC
+	}
+
+	args := []interface{}{query.From.UTC(), query.To.UTC(), query.ManagerIDs}
+	if strings.Contains(columns, "$4") {
+		args = append(args, location.String())
+	}
+
+	// sales.created is kept in UTC, so the period is passed in UTC too
+	rows, err := s.db.Query(ctx, `
+	select `+columns+`, sp.currency,
+		coalesce(sum(sp.total), 0)::bigint, coalesce(sum(sp.qty), 0)::bigint, count(distinct s.id),
+		(sum(sp.total - sp.tax - sp.cost * sp.qty) filter (where sp.cost is not null))::bigint,
+		coalesce(sum(sp.qty) filter (where sp.cost is not null), 0)::bigint
+	from sales s
+	join sales_positions sp on sp.sale_id = s.id
+	join products p on p.id = sp.product_id
+	left join categories c on c.id = p.category_id
+	join managers m on m.id = s.manager_id
+	left join customers cu on cu.id = s.customer_id
+	where s.status = 'paid' and s.created >= $1 and s.created < $2 and ($3::bigint[] is null or s.manager_id = any($3))
+	group by 1, 2, 3, 4
+	order by 1, 2, 4`, args...)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &SalesReportRow{}
+		var bucket *time.Time
+		var margin *int64
+		costUnits := int64(0)
+		currency := ""
+		err = rows.Scan(&bucket, &item.ID, &item.Name, &currency, &item.Revenue.Amount, &item.Units, &item.Sales, &margin, &costUnits)
+		if err != nil {
+			log.Print(err)
+			return ErrInternal
+		}
+		if bucket != nil {
+			// bucket is a wall clock time of the location
+			value := time.Date(bucket.Year(), bucket.Month(), bucket.Day(), 0, 0, 0, 0, location)
+			item.Bucket = &value
+		}
+		item.Revenue.Currency = currency
+		item.AverageTicket = money.New(0, currency)
+		if item.Sales > 0 {
+			item.AverageTicket.Amount = (item.Revenue.Amount + item.Sales/2) / item.Sales
+		}
+		if query.Costs {
+			if margin != nil {
+				value := money.New(*margin, currency)
+				item.Margin = &value
+			}
+			coverage := float64(0)
+			if item.Units > 0 {
+				coverage = float64(costUnits*10000/item.Units) / 100
+			}
+			item.CostCoverage = &coverage
+		}
+		err = fn(item)
+		if err != nil {
+			return err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package managers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/managers"
+)
+
+func TestSalesReportBucketsInTimeZone(t *testing.T) {
+	svc, pool := newService(t)
+	ctx := context.Background()
+	// UTC+5 all year round, evening sales in UTC are sales of the next day there
+	location, err := time.LoadLocation("Asia/Dushanbe")
+	if err != nil {
+		t.Skip(err)
+	}
+	managerID := dbtest.Manager(t, pool, false)
+	customerID := dbtest.Customer(t, pool)
+	productID := dbtest.Product(t, pool, "Report tea", 1000, 100)
+
+	utc := func(month time.Month, day int, hour int) time.Time {
+		return time.Date(2021, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	dbtest.Sale(t, pool, managerID, customerID, "paid", utc(time.February, 28, 10), dbtest.Position{ProductID: productID, Price: 1000, Qty: 1})
+	dbtest.Sale(t, pool, managerID, customerID, "paid", utc(time.February, 28, 20), dbtest.Position{ProductID: productID, Price: 1000, Qty: 2})
+	dbtest.Sale(t, pool, managerID, customerID, "paid", utc(time.March, 1, 10), dbtest.Position{ProductID: productID, Price: 1000, Qty: 3})
+	// unpaid sales are not revenue
+	dbtest.Sale(t, pool, managerID, customerID, "unpaid", utc(time.March, 1, 11), dbtest.Position{ProductID: productID, Price: 1000, Qty: 4})
+
+	local := func(month time.Month, day int) time.Time {
+		return time.Date(2021, month, day, 0, 0, 0, 0, location)
+	}
+	type bucket struct {
+		day     time.Time
+		revenue int64
+		sales   int64
+	}
+	tests := []struct {
+		groupBy string
+		want    []bucket
+	}{
+		{managers.ByDay, []bucket{{local(time.February, 28), 1000, 1}, {local(time.March, 1), 5000, 2}}},
+		// March 1, 2021 is Monday
+		{managers.ByWeek, []bucket{{local(time.February, 22), 1000, 1}, {local(time.March, 1), 5000, 2}}},
+		{managers.ByMonth, []bucket{{local(time.February, 1), 1000, 1}, {local(time.March, 1), 5000, 2}}},
+	}
+	for _, test := range tests {
+		t.Run(test.groupBy, func(t *testing.T) {
+			items, err := svc.SalesReport(ctx, managers.SalesReportQuery{From: local(time.February, 1), To: local(time.April, 1),
+				GroupBy: test.groupBy, Location: location, ManagerIDs: []int64{managerID}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(items) != len(test.want) {
+				t.Fatalf("got %d buckets, want %d", len(items), len(test.want))
+			}
+			for i, want := range test.want {
+				item := items[i]
+				if item.Bucket == nil || !item.Bucket.Equal(want.day) || item.Revenue.Amount != want.revenue || item.Sales != want.sales {
+					t.Errorf("bucket %d = %v: %d in %d sales, want %v: %d in %d sales", i, item.Bucket, item.Revenue.Amount,
+						item.Sales, want.day, want.revenue, want.sales)
+				}
+			}
+		})
+	}
+}
+
+func TestSalesReportCostsForAdmins(t *testing.T) {
+	svc, pool := newService(t)
+	ctx := context.Background()
+	managerID := dbtest.Manager(t, pool, false)
+	customerID := dbtest.Customer(t, pool)
+	productID := dbtest.Product(t, pool, "Report cup", 1000, 100)
+	day := time.Date(2021, time.May, 10, 12, 0, 0, 0, time.UTC)
+
+	saleID := dbtest.Sale(t, pool, managerID, customerID, "paid", day, dbtest.Position{ProductID: productID, Price: 1000, Qty: 2})
+	_, err := pool.Exec(ctx, `update sales_positions set cost = 600 where sale_id = $1`, saleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := managers.SalesReportQuery{From: day.AddDate(0, 0, -1), To: day.AddDate(0, 0, 1), GroupBy: managers.ByProduct,
+		ManagerIDs: []int64{managerID}}
+	items, err := svc.SalesReport(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].ID != productID || items[0].Units != 2 || items[0].Revenue.Amount != 2000 {
+		t.Fatalf("report by product = %+v, want 2 units of product %d for 2000", items, productID)
+	}
+	if items[0].Margin != nil || items[0].CostCoverage != nil {
+		t.Errorf("report without costs has margin %v and coverage %v", items[0].Margin, items[0].CostCoverage)
+	}
+
+	query.Costs = true
+	items, err = svc.SalesReport(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items[0].Margin == nil || items[0].Margin.Amount != 800 || items[0].CostCoverage == nil || *items[0].CostCoverage != 100 {
+		t.Errorf("report with costs has margin %v and coverage %v, want 800 and 100", items[0].Margin, items[0].CostCoverage)
+	}
+}
@@ -0,0 +1,216 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/manucher051299/crud/pkg/giftcards"
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+//ErrInvalidReturn - returned positions must belong to the sale and can't exceed what is left of them
+var ErrInvalidReturn = errors.New("invalid return")
+
+//Return - goods brought back from a sale, the customer gets their value as store credit
+type Return struct {
+	ID         int64                  `json:"id"`
+	SaleID     int64                  `json:"sale_id"`
+	CustomerID int64                  `json:"customer_id"`
+	ManagerID  int64                  `json:"manager_id"`
+	Total      money.Money            `json:"total"`
+	Reason     string                 `json:"reason"`
+	Created    time.Time              `json:"created"`
+	Positions  []*ReturnPosition      `json:"positions"`
+	Credit     *giftcards.StoreCredit `json:"credit,omitempty"`
+}
+
+//ReturnPosition - returned qty of a sale position, Total is its share of the position total
+type ReturnPosition struct {
+	ID         int64       `json:"id"`
+	PositionID int64       `json:"position_id"`
+	ProductID  int64       `json:"product_id"`
+	Qty        int         `json:"qty"`
+	Total      money.Money `json:"total"`
+}
+
+//MakeReturn puts returned goods back in stock, takes back loyalty points earned for them
+//and credits the customer of the sale with what they cost
+func (s *Service) MakeReturn(ctx context.Context, item *Return) (*Return, error) {
+	if len(item.Positions) == 0 {
+		return nil, ErrInvalidReturn
+	}
+	item.Reason = strings.TrimSpace(item.Reason)
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	// returns of one sale go one by one, so returned qty can't exceed sold qty
+	currency := ""
+	err = tx.QueryRow(ctx, `select customer_id, currency from sales where id = $1 for update`, item.SaleID).
+		Scan(&item.CustomerID, &currency)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	if item.CustomerID == 0 {
+		return nil, giftcards.ErrNoCustomer
+	}
+
+	err = tx.QueryRow(ctx, `
+	insert into returns(sale_id, customer_id, manager_id, currency, reason) values ($1, $2, $3, $4, $5) returning id, created`,
+		item.SaleID, item.CustomerID, item.ManagerID, currency, item.Reason).Scan(&item.ID, &item.Created)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	item.Total = money.New(0, currency)
+	restocked := make([]int64, 0)
+	for _, position := range item.Positions {
+		if position.Qty <= 0 {
+			return nil, ErrInvalidReturn
+		}
+
+		sold, returned, total := 0, 0, int64(0)
+		err = tx.QueryRow(ctx, `
+		select sp.product_id, sp.qty, sp.total, coalesce((select sum(qty) from returns_positions where position_id = sp.id), 0)
+		from sales_positions sp where sp.id = $1 and sp.sale_id = $2`, position.PositionID, item.SaleID).
+			Scan(&position.ProductID, &sold, &total, &returned)
+		if err == pgx.ErrNoRows {
+			return nil, ErrInvalidReturn
+		}
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		if returned+position.Qty > sold {
+			return nil, ErrInvalidReturn
+		}
+
+		// shares of all returns of a position add up to its total exactly
+		position.Total = money.New(total*int64(returned+position.Qty)/int64(sold)-total*int64(returned)/int64(sold), currency)
+		err = tx.QueryRow(ctx, `
+		insert into returns_positions(return_id, position_id, product_id, qty, total) values ($1, $2, $3, $4, $5) returning id`,
+			item.ID, position.PositionID, position.ProductID, position.Qty, position.Total.Amount).Scan(&position.ID)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item.Total.Amount += position.Total.Amount
+
+		qty := 0
+		err = tx.QueryRow(ctx, `update products set qty = qty + $2 where id = $1 returning qty`, position.ProductID, position.Qty).Scan(&qty)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		if qty-position.Qty <= 0 && qty > 0 {
+			restocked = append(restocked, position.ProductID)
+		}
+	}
+
+	_, err = tx.Exec(ctx, `update returns set total = $2 where id = $1`, item.ID, item.Total.Amount)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	credit := &giftcards.StoreCredit{CustomerID: item.CustomerID, SaleID: item.SaleID, ReturnID: item.ID, ManagerID: item.ManagerID,
+		Amount: item.Total, Reason: item.Reason}
+	err = s.paymentsSvc.Refund(ctx, tx, credit)
+	if err != nil {
+		return nil, err
+	}
+	if credit.ID != 0 {
+		item.Credit = credit
+	}
+
+	for _, productID := range restocked {
+		err = s.wishlistSvc.Restocked(ctx, tx, productID)
+		if err != nil {
+			return nil, ErrInternal
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	s.wishlistSvc.Notify()
+	return item, nil
+}
+
+//Returns lists returns of the sale with their positions, oldest first
+func (s *Service) Returns(ctx context.Context, saleID int64) ([]*Return, error) {
+	items := make([]*Return, 0)
+	byID := make(map[int64]*Return)
+
+	rows, err := s.db.Query(ctx, `
+	select id, sale_id, customer_id, manager_id, total, currency, reason, created
+	from returns where sale_id = $1 order by id`, saleID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Return{Positions: make([]*ReturnPosition, 0)}
+		err = rows.Scan(&item.ID, &item.SaleID, &item.CustomerID, &item.ManagerID, &item.Total.Amount, &item.Total.Currency,
+			&item.Reason, &item.Created)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+		byID[item.ID] = item
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	rows.Close()
+
+	rows, err = s.db.Query(ctx, `
+	select rp.id, rp.return_id, rp.position_id, rp.product_id, rp.qty, rp.total
+	from returns_positions rp join returns r on r.id = rp.return_id
+	where r.sale_id = $1 order by rp.id`, saleID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		position := &ReturnPosition{}
+		returnID := int64(0)
+		err = rows.Scan(&position.ID, &returnID, &position.PositionID, &position.ProductID, &position.Qty, &position.Total.Amount)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item := byID[returnID]
+		position.Total.Currency = item.Total.Currency
+		item.Positions = append(item.Positions, position)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
@@ -0,0 +1,98 @@
+package managers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/manucher051299/crud/pkg/customers"
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/giftcards"
+	"github.com/manucher051299/crud/pkg/loyalty"
+	"github.com/manucher051299/crud/pkg/managers"
+	"github.com/manucher051299/crud/pkg/money"
+	"github.com/manucher051299/crud/pkg/notifications"
+	"github.com/manucher051299/crud/pkg/payments"
+	"github.com/manucher051299/crud/pkg/phones"
+	"github.com/manucher051299/crud/pkg/wishlist"
+)
+
+func TestMakeReturn(t *testing.T) {
+	pool := dbtest.Pool(t)
+	ctx := context.Background()
+	config := phones.Config{DefaultRegion: "TJ"}
+	paymentsSvc := payments.NewService(pool, loyalty.NewService(pool, loyalty.Config{PointsPerUnit: 1, PointValue: 10}),
+		giftcards.NewService(pool))
+	wishlistSvc := wishlist.NewService(pool, notifications.NewOutbox(pool, notifications.NewMemory()))
+	svc := managers.NewService(pool, paymentsSvc, customers.NewService(pool, customers.LogSender{}, config), wishlistSvc, config)
+
+	managerID := dbtest.Manager(t, pool, false)
+	customerID := dbtest.Customer(t, pool)
+	productID := dbtest.Product(t, pool, "Returned mug", 1000, 5)
+	saleID := dbtest.Sale(t, pool, managerID, customerID, payments.Unpaid, time.Now(),
+		dbtest.Position{ProductID: productID, Price: 1000, Qty: 3})
+	// 3000 paid earn 30 points
+	_, err := paymentsSvc.Pay(ctx, saleID, managerID, []*payments.Payment{{Method: payments.Card, Amount: money.New(3000, money.DefaultCurrency)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	positionID := int64(0)
+	err = pool.QueryRow(ctx, `select id from sales_positions where sale_id = $1`, saleID).Scan(&positionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := func() (qty int, credit int64, points int64) {
+		err := pool.QueryRow(ctx, `
+		select (select qty from products where id = $1),
+			(select coalesce(sum(amount), 0)::bigint from store_credits where customer_id = $2),
+			(select coalesce(sum(points), 0)::bigint from loyalty_ledger where customer_id = $2)`, productID, customerID).
+			Scan(&qty, &credit, &points)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	giveBack := func(qty int) (*managers.Return, error) {
+		return svc.MakeReturn(ctx, &managers.Return{SaleID: saleID, ManagerID: managerID, Reason: "broken",
+			Positions: []*managers.ReturnPosition{{PositionID: positionID, Qty: qty}}})
+	}
+
+	item, err := giveBack(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Total.Amount != 1000 || item.Credit == nil || item.Credit.Amount.Amount != 1000 || item.Credit.ReturnID != item.ID {
+		t.Errorf("return of one unit = %+v, credit %+v, want 1000 credited for the return", item, item.Credit)
+	}
+	qty, credit, points := state()
+	if qty != 6 || credit != 1000 || points != 20 {
+		t.Errorf("after one unit: stock %d, credit %d, points %d, want 6, 1000 and 20", qty, credit, points)
+	}
+
+	_, err = giveBack(3)
+	if err != managers.ErrInvalidReturn {
+		t.Errorf("more than left: err = %v, want %v", err, managers.ErrInvalidReturn)
+	}
+	_, err = svc.MakeReturn(ctx, &managers.Return{SaleID: saleID, ManagerID: managerID,
+		Positions: []*managers.ReturnPosition{{PositionID: positionID + 1000000, Qty: 1}}})
+	if err != managers.ErrInvalidReturn {
+		t.Errorf("position of another sale: err = %v, want %v", err, managers.ErrInvalidReturn)
+	}
+
+	_, err = giveBack(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qty, credit, points = state()
+	if qty != 8 || credit != 3000 || points != 0 {
+		t.Errorf("after all units: stock %d, credit %d, points %d, want 8, 3000 and 0", qty, credit, points)
+	}
+
+	items, err := svc.Returns(ctx, saleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || len(items[0].Positions) != 1 || items[1].Positions[0].Qty != 2 {
+		t.Errorf("returns of the sale = %+v, want two returns with their positions", items)
+	}
+}
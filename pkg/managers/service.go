@@ -6,13 +6,20 @@ import (
 	"encoding/hex"
 	"errors"
 	"log"
-	"strconv"
+	"strings"
 	"time"
 
 	//
 
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/manucher051299/crud/pkg/customers"
+	"github.com/manucher051299/crud/pkg/money"
+	"github.com/manucher051299/crud/pkg/payments"
+	"github.com/manucher051299/crud/pkg/phones"
+	"github.com/manucher051299/crud/pkg/shifts"
+	"github.com/manucher051299/crud/pkg/taxes"
+	"github.com/manucher051299/crud/pkg/wishlist"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -31,14 +38,37 @@ var (
 	ErrPhoneUsed = errors.New("phone already registered")
 	//ErrTokenExpired ...
 	ErrTokenExpired = errors.New("token expired")
+	//ErrInvalidPosition ...
+	ErrInvalidPosition = errors.New("invalid sale position")
+	//ErrMixedCurrency ...
+	ErrMixedCurrency = errors.New("sale positions in different currencies")
+	//ErrInvalidPhone ...
+	ErrInvalidPhone = errors.New("invalid phone")
+	//ErrInvalidQty ...
+	ErrInvalidQty = errors.New("invalid qty")
+	//ErrInvalidCost ...
+	ErrInvalidCost = errors.New("invalid cost")
+	//ErrInvalidSKU ...
+	ErrInvalidSKU = errors.New("invalid sku")
+	//ErrInvalidReorderPoint ...
+	ErrInvalidReorderPoint = errors.New("invalid reorder point")
+	//ErrSKUUsed ...
+	ErrSKUUsed = errors.New("sku already used")
+	//ErrCostForbidden - only admins see and set cost of products
+	ErrCostForbidden = errors.New("cost is available to admins only")
 )
 
 type Service struct {
-	db *pgxpool.Pool
+	db           *pgxpool.Pool
+	paymentsSvc  *payments.Service
+	customersSvc *customers.Service
+	wishlistSvc  *wishlist.Service
+	phones       phones.Config
 }
 
-func NewService(db *pgxpool.Pool) *Service {
-	return &Service{db: db}
+func NewService(db *pgxpool.Pool, paymentsSvc *payments.Service, customersSvc *customers.Service, wishlistSvc *wishlist.Service,
+	phones phones.Config) *Service {
+	return &Service{db: db, paymentsSvc: paymentsSvc, customersSvc: customersSvc, wishlistSvc: wishlistSvc, phones: phones}
 }
 
 type Manager struct {
@@ -49,43 +79,94 @@ type Manager struct {
 	BossID      int64     `json:"boss_id"`
 	Departament string    `json:"departament"`
 	Phone       string    `json:"phone"`
-	Password    string    `json:"password"`
+	Password    string    `json:"password,omitempty"`
 	IsAdmin     bool      `json:"is_admin"`
+	Active      bool      `json:"active"`
 	Created     time.Time `json:"created"`
 }
 
 type Product struct {
+	ID          int64       `json:"id"`
+	SKU         string      `json:"sku"`
+	Name        string      `json:"name"`
+	Price       money.Money `json:"price"`
+	Qty         int         `json:"qty"`
+	CategoryID  int64       `json:"category_id"`
+	TaxRate     *int        `json:"tax_rate"`
+	TaxIncluded bool        `json:"tax_included"`
+	//Cost - unit purchase cost in the price currency, nil when unknown or hidden from the viewer
+	Cost *int64 `json:"cost,omitempty"`
+	//ReorderPoint - product is low on stock when qty falls to it, nil when not tracked
+	ReorderPoint *int      `json:"reorder_point"`
+	Active       bool      `json:"active"`
+	Created      time.Time `json:"created"`
+}
+
+//Category - group of products sharing a tax rate
+type Category struct {
 	ID      int64     `json:"id"`
 	Name    string    `json:"name"`
-	Price   int       `json:"price"`
-	Qty     int       `json:"qty"`
-	Active  bool      `json:"active"`
+	TaxRate int       `json:"tax_rate"`
 	Created time.Time `json:"created"`
 }
 
 type Sale struct {
-	ID         int64           `json:"id"`
-	ManagerID  int64           `json:"manager_id"`
-	CustomerID int64           `json:"customer_id"`
-	Created    time.Time       `json:"created"`
-	Positions  []*SalePosition `json:"positions"`
+	ID         int64               `json:"id"`
+	ManagerID  int64               `json:"manager_id"`
+	CustomerID int64               `json:"customer_id"`
+	ShiftID    int64               `json:"shift_id"`
+	Tax        money.Money         `json:"tax"`
+	Total      money.Money         `json:"total"`
+	Status     string              `json:"status"`
+	Change     money.Money         `json:"change"`
+	Created    time.Time           `json:"created"`
+	Positions  []*SalePosition     `json:"positions"`
+	Payments   []*payments.Payment `json:"payments"`
 }
 
 type SalePosition struct {
-	ID        int64     `json:"id"`
-	ProductID int64     `json:"product_id"`
-	SaleID    int64     `json:"sale_id"`
-	Price     int       `json:"price"`
-	Qty       int       `json:"qty"`
-	Created   time.Time `json:"created"`
+	ID          int64       `json:"id"`
+	ProductID   int64       `json:"product_id"`
+	SaleID      int64       `json:"sale_id"`
+	Price       money.Money `json:"price"`
+	Qty         int         `json:"qty"`
+	Discounted  bool        `json:"discounted"`
+	TaxRate     int         `json:"tax_rate"`
+	TaxIncluded bool        `json:"tax_included"`
+	Tax         money.Money `json:"tax"`
+	Total       money.Money `json:"total"`
+	//Cost - unit cost of the product at the moment of sale, it is not shown to customers
+	Cost    *int64    `json:"-"`
+	Created time.Time `json:"created"`
+}
+
+//SalesTotal ...
+type SalesTotal struct {
+	Total money.Money `json:"total"`
+	Tax   money.Money `json:"tax"`
+}
+
+//TaxReportRow - taxes collected with one rate
+type TaxReportRow struct {
+	TaxRate int         `json:"tax_rate"`
+	Net     money.Money `json:"net"`
+	Tax     money.Money `json:"tax"`
+	Total   money.Money `json:"total"`
 }
 
 type Customer struct {
-	ID      int64     `json:"id"`
-	Name    string    `json:"name"`
-	Phone   string    `json:"phone"`
-	Active  bool      `json:"active"`
-	Created time.Time `json:"created"`
+	ID           int64                  `json:"id"`
+	Name         string                 `json:"name"`
+	Phone        string                 `json:"phone"`
+	Email        string                 `json:"email,omitempty"`
+	Active       bool                   `json:"active"`
+	Created      time.Time              `json:"created"`
+	Spent        *money.Money           `json:"spent,omitempty"`
+	LastPurchase *time.Time             `json:"last_purchase,omitempty"`
+	Tags         []string               `json:"tags"`
+	Addresses    []*customers.Address   `json:"addresses,omitempty"`
+	Preferences  *customers.Preferences `json:"preferences,omitempty"`
+	Notes        []*Note                `json:"notes,omitempty"`
 }
 
 func GenerateTokenStr() (string, error) {
@@ -101,7 +182,7 @@ func GenerateTokenStr() (string, error) {
 
 func (s *Service) IDByToken(ctx context.Context, token string) (int64, error) {
 	var id int64
-	sqlStatement := `select manager_id from managers_tokens where token = $1`
+	sqlStatement := `select t.manager_id from managers_tokens t join managers m on m.id = t.manager_id where t.token = $1 and m.active`
 
 	err := s.db.QueryRow(ctx, sqlStatement, token).Scan(&id)
 
@@ -131,8 +212,14 @@ func (s *Service) Create(ctx context.Context, item *Manager) (string, error) {
 	var token string
 	var id int64
 
+	phone, err := s.phones.Normalize(item.Phone)
+	if err != nil {
+		return "", ErrInvalidPhone
+	}
+	item.Phone = phone
+
 	sqlStmt := `insert into managers(name,phone,is_admin) values ($1,$2,$3) on conflict (phone) do nothing returning id;`
-	err := s.db.QueryRow(ctx, sqlStmt, item.Name, item.Phone, item.IsAdmin).Scan(&id)
+	err = s.db.QueryRow(ctx, sqlStmt, item.Name, item.Phone, item.IsAdmin).Scan(&id)
 	if err != nil {
 		log.Print(err)
 		return "", ErrInternal
@@ -155,7 +242,12 @@ func (s *Service) Create(ctx context.Context, item *Manager) (string, error) {
 func (s *Service) Token(ctx context.Context, phone, password string) (token string, err error) {
 	var hash string
 	var id int64
-	err = s.db.QueryRow(ctx, `select id,password from managers where phone = $1`, phone).Scan(&id, &hash)
+	active := false
+	phone, err = s.phones.Normalize(phone)
+	if err != nil {
+		return "", ErrInvalidPassword
+	}
+	err = s.db.QueryRow(ctx, `select id,password,active from managers where phone = $1`, phone).Scan(&id, &hash, &active)
 
 	if err == pgx.ErrNoRows {
 		return "", ErrInvalidPassword
@@ -168,6 +260,9 @@ func (s *Service) Token(ctx context.Context, phone, password string) (token stri
 	if err != nil {
 		return "", ErrInvalidPassword
 	}
+	if !active {
+		return "", ErrInactive
+	}
 
 	token, err = GenerateTokenStr()
 	if err != nil {
@@ -182,95 +277,427 @@ func (s *Service) Token(ctx context.Context, phone, password string) (token stri
 	return token, nil
 }
 
-//SaveProduct
-func (s *Service) SaveProduct(ctx context.Context, product *Product) (*Product, error) {
+//SaveProduct creates or updates the product on behalf of the manager,
+//cost can be set only by admins and is kept as it is when others update the product
+func (s *Service) SaveProduct(ctx context.Context, managerID int64, product *Product) (*Product, error) {
 
 	var err error
 
+	if product.TaxRate != nil && !taxes.ValidRate(*product.TaxRate) {
+		return nil, taxes.ErrInvalidRate
+	}
+	if product.Price.Currency == "" {
+		product.Price.Currency = money.DefaultCurrency
+	}
+	if !money.ValidCurrency(product.Price.Currency) {
+		return nil, money.ErrInvalidCurrency
+	}
+	isAdmin := s.IsAdmin(ctx, managerID)
+	if product.Cost != nil && !isAdmin {
+		return nil, ErrCostForbidden
+	}
+	if product.Cost != nil && *product.Cost < 0 {
+		return nil, ErrInvalidCost
+	}
+	if product.ReorderPoint != nil && *product.ReorderPoint < 0 {
+		return nil, ErrInvalidReorderPoint
+	}
+	product.SKU = strings.TrimSpace(product.SKU)
+	if len(product.SKU) > MaxSKULength {
+		return nil, ErrInvalidSKU
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	if product.SKU != "" {
+		used := false
+		err = tx.QueryRow(ctx, `select exists(select 1 from products where sku = $1 and id <> $2)`, product.SKU, product.ID).Scan(&used)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		if used {
+			return nil, ErrSKUUsed
+		}
+	}
+
+	previous := 0
 	if product.ID == 0 {
-		sqlstmt := `insert into products(name,qty,price) values ($1,$2,$3) returning id,name,qty,price,active,created;`
-		err = s.db.QueryRow(ctx, sqlstmt, product.Name, product.Qty, product.Price).
-			Scan(&product.ID, &product.Name, &product.Qty, &product.Price, &product.Active, &product.Created)
+		sqlstmt := `insert into products(name,qty,price,currency,category_id,tax_rate,tax_included,cost,sku,reorder_point) values ($1,$2,$3,$4,nullif($5,0),$6,$7,$8,nullif($9,''),$10)
+		returning id,coalesce(sku,''),name,qty,price,currency,coalesce(category_id,0),tax_rate,tax_included,cost,reorder_point,active,created;`
+		err = tx.QueryRow(ctx, sqlstmt, product.Name, product.Qty, product.Price.Amount, product.Price.Currency, product.CategoryID, product.TaxRate, product.TaxIncluded, product.Cost, product.SKU, product.ReorderPoint).
+			Scan(&product.ID, &product.SKU, &product.Name, &product.Qty, &product.Price.Amount, &product.Price.Currency, &product.CategoryID, &product.TaxRate, &product.TaxIncluded, &product.Cost, &product.ReorderPoint, &product.Active, &product.Created)
 	} else {
-		sqlstmt := `update  products set  name=$1, qty=$2,price=$3  where id = $4 returning id,name,qty,price,active,created;`
-		err = s.db.QueryRow(ctx, sqlstmt, product.Name, product.Qty, product.Price, product.ID).
-			Scan(&product.ID, &product.Name, &product.Qty, &product.Price, &product.Active, &product.Created)
+		err = tx.QueryRow(ctx, `select qty from products where id = $1 for update`, product.ID).Scan(&previous)
+		if err == pgx.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+
+		sqlstmt := `update  products set  name=$1, qty=$2,price=$3,currency=$4,category_id=nullif($5,0),tax_rate=$6,tax_included=$7,cost=case when $12 then $9 else cost end,sku=nullif($10,''),reorder_point=$11  where id = $8
+		returning id,coalesce(sku,''),name,qty,price,currency,coalesce(category_id,0),tax_rate,tax_included,cost,reorder_point,active,created;`
+		err = tx.QueryRow(ctx, sqlstmt, product.Name, product.Qty, product.Price.Amount, product.Price.Currency, product.CategoryID, product.TaxRate, product.TaxIncluded, product.ID, product.Cost, product.SKU, product.ReorderPoint, isAdmin).
+			Scan(&product.ID, &product.SKU, &product.Name, &product.Qty, &product.Price.Amount, &product.Price.Currency, &product.CategoryID, &product.TaxRate, &product.TaxIncluded, &product.Cost, &product.ReorderPoint, &product.Active, &product.Created)
+	}
+
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	err = s.commitStock(ctx, tx, product.ID, previous, product.Qty)
+	if err != nil {
+		return nil, err
+	}
+	return product, nil
+}
+
+//ReceiveStock adds delivered qty to the product
+func (s *Service) ReceiveStock(ctx context.Context, managerID int64, productID int64, qty int) (*Product, error) {
+	if qty <= 0 {
+		return nil, ErrInvalidQty
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
 	}
+	defer tx.Rollback(ctx)
 
+	product := &Product{}
+	err = tx.QueryRow(ctx, `update products set qty = qty + $2 where id = $1
+	returning id,coalesce(sku,''),name,qty,price,currency,coalesce(category_id,0),tax_rate,tax_included,cost,reorder_point,active,created`, productID, qty).
+		Scan(&product.ID, &product.SKU, &product.Name, &product.Qty, &product.Price.Amount, &product.Price.Currency, &product.CategoryID, &product.TaxRate, &product.TaxIncluded, &product.Cost, &product.ReorderPoint, &product.Active, &product.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		log.Print(err)
 		return nil, ErrInternal
 	}
+
+	_, err = tx.Exec(ctx, `insert into stock_receipts(product_id, manager_id, qty) values ($1,$2,$3)`, productID, managerID, qty)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	err = s.commitStock(ctx, tx, product.ID, product.Qty-qty, product.Qty)
+	if err != nil {
+		return nil, err
+	}
 	return product, nil
 }
 
-//MakeSalePosition
-func (s *Service) MakeSalePosition(ctx context.Context, position *SalePosition) bool {
+//commitStock commits tx and notifies subscribers when the product went back in stock
+func (s *Service) commitStock(ctx context.Context, tx pgx.Tx, productID int64, previous int, qty int) error {
+	if previous <= 0 && qty > 0 {
+		err := s.wishlistSvc.Restocked(ctx, tx, productID)
+		if err != nil {
+			return ErrInternal
+		}
+	}
+
+	err := tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	s.wishlistSvc.Notify()
+	return nil
+}
+
+//Categories ...
+func (s *Service) Categories(ctx context.Context) ([]*Category, error) {
+
+	items := make([]*Category, 0)
+
+	rows, err := s.db.Query(ctx, `select id, name, tax_rate, created from categories order by id`)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Category{}
+		err = rows.Scan(&item.ID, &item.Name, &item.TaxRate, &item.Created)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
+
+//SaveCategory ...
+func (s *Service) SaveCategory(ctx context.Context, category *Category) (*Category, error) {
+
+	var err error
+
+	if !taxes.ValidRate(category.TaxRate) {
+		return nil, taxes.ErrInvalidRate
+	}
+
+	if category.ID == 0 {
+		sqlstmt := `insert into categories(name,tax_rate) values ($1,$2) returning id,name,tax_rate,created;`
+		err = s.db.QueryRow(ctx, sqlstmt, category.Name, category.TaxRate).
+			Scan(&category.ID, &category.Name, &category.TaxRate, &category.Created)
+	} else {
+		sqlstmt := `update categories set name=$1, tax_rate=$2 where id = $3 returning id,name,tax_rate,created;`
+		err = s.db.QueryRow(ctx, sqlstmt, category.Name, category.TaxRate, category.ID).
+			Scan(&category.ID, &category.Name, &category.TaxRate, &category.Created)
+	}
+
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return category, nil
+}
+
+//MakeSalePosition checks stock, writes it off and computes taxes of the position
+func (s *Service) MakeSalePosition(ctx context.Context, tx pgx.Tx, position *SalePosition) error {
 	active := false
 	qty := 0
-	if err := s.db.QueryRow(ctx, `select qty,active from products where id = $1`, position.ProductID).
-		Scan(&qty, &active); err != nil {
-		return false
+	price := int64(0)
+	currency := ""
+	err := tx.QueryRow(ctx, `
+	select p.qty, p.active, p.price, p.currency, coalesce(p.tax_rate, c.tax_rate, 0), p.tax_included, p.cost
+	from products p
+	left join categories c on c.id = p.category_id
+	where p.id = $1
+	for update of p`, position.ProductID).
+		Scan(&qty, &active, &price, &currency, &position.TaxRate, &position.TaxIncluded, &position.Cost)
+	if err == pgx.ErrNoRows {
+		return ErrInvalidPosition
 	}
-	if qty < position.Qty || !active {
-		return false
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if position.Qty <= 0 || qty < position.Qty || !active {
+		return ErrInvalidPosition
+	}
+
+	if position.Price.Currency == "" {
+		position.Price.Currency = currency
+	}
+	if position.Price.Currency != currency {
+		return ErrMixedCurrency
+	}
+	position.Discounted = position.Price.Amount < price
+
+	line, err := taxes.Compute(position.Price.Amount, position.Qty, position.TaxRate, position.TaxIncluded)
+	if err != nil {
+		log.Print(err)
+		return ErrInvalidPosition
 	}
-	if _, err := s.db.Exec(ctx, `update products set qty = $1 where id = $2`, qty-position.Qty, position.ProductID); err != nil {
+	position.Tax = money.New(line.Tax, currency)
+	position.Total = money.New(line.Gross, currency)
+
+	if _, err := tx.Exec(ctx, `update products set qty = $1 where id = $2`, qty-position.Qty, position.ProductID); err != nil {
 		log.Print(err)
-		return false
+		return ErrInternal
 	}
-	return true
+	return nil
 }
 
 //MakeSale
 func (s *Service) MakeSale(ctx context.Context, sale *Sale) (*Sale, error) {
 
-	positionsSQLstmt := "insert into sales_positions (sale_id,product_id,qty,price) values "
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	sale, err = s.MakeSaleTx(ctx, tx, sale)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	return sale, nil
+}
+
+//MakeSaleTx makes sale inside tx, so other operations (orders, returns) can be done atomically with it
+func (s *Service) MakeSaleTx(ctx context.Context, tx pgx.Tx, sale *Sale) (*Sale, error) {
+
+	if len(sale.Positions) == 0 {
+		return nil, ErrInvalidPosition
+	}
+
+	// sale belongs to the open shift of the manager, if there is one
+	shiftID, err := shifts.Lock(ctx, tx, sale.ManagerID)
+	if err != nil {
+		return nil, ErrInternal
+	}
 
-	sqlstmt := `insert into sales(manager_id,customer_id) values ($1,$2) returning id, created;`
+	sqlstmt := `insert into sales(manager_id,customer_id,shift_id)
+	values ($1,$2,nullif($3::bigint, 0))
+	returning id, coalesce(shift_id,0), created;`
 
-	err := s.db.QueryRow(ctx, sqlstmt, sale.ManagerID, sale.CustomerID).Scan(&sale.ID, &sale.Created)
+	err = tx.QueryRow(ctx, sqlstmt, sale.ManagerID, sale.CustomerID, shiftID).Scan(&sale.ID, &sale.ShiftID, &sale.Created)
 	if err != nil {
 		log.Print(err)
 		return nil, ErrInternal
 	}
+
+	sale.Tax = money.Money{}
+	sale.Total = money.Money{}
 	for _, position := range sale.Positions {
-		if !s.MakeSalePosition(ctx, position) {
-			log.Print("Invalid position")
+		err = s.MakeSalePosition(ctx, tx, position)
+		if err != nil {
+			return nil, err
+		}
+
+		sale.Tax, err = sale.Tax.Add(position.Tax)
+		if err != nil {
+			return nil, ErrMixedCurrency
+		}
+		sale.Total, err = sale.Total.Add(position.Total)
+		if err != nil {
+			return nil, ErrMixedCurrency
+		}
+
+		position.SaleID = sale.ID
+		err = tx.QueryRow(ctx, `
+		insert into sales_positions (sale_id,product_id,qty,price,currency,discounted,tax_rate,tax_included,tax,total,cost)
+		values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) returning id, created`,
+			sale.ID, position.ProductID, position.Qty, position.Price.Amount, position.Price.Currency, position.Discounted,
+			position.TaxRate, position.TaxIncluded, position.Tax.Amount, position.Total.Amount, position.Cost).Scan(&position.ID, &position.Created)
+		if err != nil {
+			log.Print(err)
 			return nil, ErrInternal
 		}
-		positionsSQLstmt += "(" + strconv.FormatInt(sale.ID, 10) + "," + strconv.FormatInt(position.ProductID, 10) + "," + strconv.Itoa(position.Price) + "," + strconv.Itoa(position.Qty) + "),"
 	}
 
-	positionsSQLstmt = positionsSQLstmt[0 : len(positionsSQLstmt)-1]
-
-	log.Print(positionsSQLstmt)
-	_, err = s.db.Exec(ctx, positionsSQLstmt)
+	_, err = tx.Exec(ctx, `update sales set currency = $1, tax = $2, total = $3 where id = $4`,
+		sale.Total.Currency, sale.Tax.Amount, sale.Total.Amount, sale.ID)
 	if err != nil {
 		log.Print(err)
 		return nil, ErrInternal
 	}
 
+	sale.Status = payments.Unpaid
+	sale.Change = money.New(0, sale.Total.Currency)
+	if len(sale.Payments) > 0 {
+		summary, err := s.paymentsSvc.Record(ctx, tx, sale.ID, sale.ManagerID, sale.Payments)
+		if err != nil {
+			return nil, err
+		}
+		sale.Status = summary.Status
+		sale.Change = summary.Change
+		sale.Payments = summary.Payments
+	}
+
 	return sale, nil
 }
 
-//GetSales
-func (s *Service) GetSales(ctx context.Context, id int64) (sum int, err error) {
+//GetSales - lifetime totals of the manager, one row per currency
+func (s *Service) GetSales(ctx context.Context, id int64) ([]*SalesTotal, error) {
+
+	items := make([]*SalesTotal, 0)
 
 	sqlstmt := `
-	select coalesce(sum(sp.qty * sp.price),0) total
-	from managers m
-	left join sales s on s.manager_id= $1
-	left join sales_positions sp on sp.sale_id = s.id
-	group by m.id
-	limit 1`
+	select sp.currency, coalesce(sum(sp.total),0)::bigint total, coalesce(sum(sp.tax),0)::bigint tax
+	from sales s
+	join sales_positions sp on sp.sale_id = s.id
+	where s.manager_id = $1
+	group by sp.currency
+	order by sp.currency`
+
+	rows, err := s.db.Query(ctx, sqlstmt, id)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
 
-	err = s.db.QueryRow(ctx, sqlstmt, id).Scan(&sum)
+	for rows.Next() {
+		item := &SalesTotal{}
+		err = rows.Scan(&item.Total.Currency, &item.Total.Amount, &item.Tax.Amount)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item.Tax.Currency = item.Total.Currency
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
+
+//TaxReport - taxes collected by rate for the period [from, to)
+func (s *Service) TaxReport(ctx context.Context, from, to time.Time) ([]*TaxReportRow, error) {
+
+	items := make([]*TaxReportRow, 0)
+
+	sqlstmt := `
+	select sp.currency, sp.tax_rate, coalesce(sum(sp.total - sp.tax),0)::bigint, coalesce(sum(sp.tax),0)::bigint, coalesce(sum(sp.total),0)::bigint
+	from sales_positions sp
+	join sales s on s.id = sp.sale_id
+	where s.created >= $1 and s.created < $2
+	group by sp.currency, sp.tax_rate
+	order by sp.currency, sp.tax_rate`
+
+	rows, err := s.db.Query(ctx, sqlstmt, from, to)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &TaxReportRow{}
+		err = rows.Scan(&item.Net.Currency, &item.TaxRate, &item.Net.Amount, &item.Tax.Amount, &item.Total.Amount)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item.Tax.Currency = item.Net.Currency
+		item.Total.Currency = item.Net.Currency
+		items = append(items, item)
+	}
+	err = rows.Err()
 	if err != nil {
 		log.Print(err)
-		return 0, ErrInternal
+		return nil, ErrInternal
 	}
-	return sum, nil
+
+	return items, nil
 }
 
 //Products ...
@@ -278,28 +705,48 @@ func (s *Service) Products(ctx context.Context) ([]*Product, error) {
 
 	items := make([]*Product, 0)
 
-	sqlstmt := `select id, name, price, qty from products where active = true order by id limit 500`
-	rows, err := s.db.Query(ctx, sqlstmt)
+	err := s.EachProduct(ctx, 500, func(item *Product) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+//EachProduct passes active products to fn one by one, limit 0 means all of them
+func (s *Service) EachProduct(ctx context.Context, limit int, fn func(*Product) error) error {
+
+	sqlstmt := `select id, coalesce(sku,''), name, price, currency, qty, coalesce(category_id,0), tax_rate, tax_included, cost, reorder_point from products where active = true order by id limit nullif($1::bigint, 0)`
+	rows, err := s.db.Query(ctx, sqlstmt, limit)
 
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return items, nil
-		}
-		return nil, ErrInternal
+		log.Print(err)
+		return ErrInternal
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		item := &Product{}
-		err = rows.Scan(&item.ID, &item.Name, &item.Price, &item.Qty)
+		item := &Product{Active: true}
+		err = rows.Scan(&item.ID, &item.SKU, &item.Name, &item.Price.Amount, &item.Price.Currency, &item.Qty, &item.CategoryID, &item.TaxRate, &item.TaxIncluded, &item.Cost, &item.ReorderPoint)
 		if err != nil {
 			log.Print(err)
-			return nil, err
+			return ErrInternal
+		}
+		err = fn(item)
+		if err != nil {
+			return err
 		}
-		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
 	}
 
-	return items, nil
+	return nil
 }
 
 //RemoveProductByID ...
@@ -324,36 +771,162 @@ func (s *Service) RemoveCustomerByID(ctx context.Context, id int64) (err error)
 	return nil
 }
 
-//Customers ...
-func (s *Service) Customers(ctx context.Context) ([]*Customer, error) {
+//Customers lists a page of active customers matching the filter with their spend and tags
+func (s *Service) Customers(ctx context.Context, filter CustomersFilter) (*CustomersPage, error) {
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 100
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
 
-	items := make([]*Customer, 0)
-	sqlstmt := `select id, name, phone, active, created from customers where active = true order by id limit 500`
-	rows, err := s.db.Query(ctx, sqlstmt)
+	page := &CustomersPage{Items: make([]*Customer, 0), Limit: filter.Limit, Offset: filter.Offset}
+	err := s.EachCustomer(ctx, filter, func(item *Customer) error {
+		page.Items = append(page.Items, item)
+		return nil
+	})
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return items, nil
-		}
+		return nil, err
+	}
+
+	rules, err := s.customersRules(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.QueryRow(ctx, customersMatched+` select count(*) from matched`, rules.args(filter)...).Scan(&page.Total)
+	if err != nil {
+		log.Print(err)
 		return nil, ErrInternal
 	}
+
+	return page, nil
+}
+
+//customersMatched selects customers matching the rules, parameters are made by SegmentRules.args.
+//Spend and last purchase count paid sales only.
+const customersMatched = `with matched as (
+	select c.id, c.name, c.phone, c.active, c.created, coalesce(st.spent,0)::bigint spent, st.last,
+		coalesce((select array_agg(t.tag order by t.tag) from customers_tags t where t.customer_id = c.id), '{}') tags
+	from customers c
+	left join (
+		select customer_id, sum(total) filter (where currency = $1) spent, max(created) last
+		from sales where status = 'paid' group by customer_id
+	) st on st.customer_id = c.id
+	where c.active = true
+		and ($2::bigint is null or coalesce(st.spent,0) >= $2)
+		and ($3::bigint is null or coalesce(st.spent,0) <= $3)
+		and ($4::timestamp is null or st.last >= $4)
+		and ($5::timestamp is null or st.last < $5)
+		and (select count(*) from customers_tags t where t.customer_id = c.id and t.tag = any($6)) = cardinality($6::text[])
+		and ($7 = '' or exists(select 1 from customers_tags t where t.customer_id = c.id and t.tag = $7))
+)`
+
+//args - parameters of customersMatched
+func (rules *SegmentRules) args(filter CustomersFilter) []interface{} {
+	return []interface{}{rules.Currency, rules.MinSpend, rules.MaxSpend, rules.LastPurchaseFrom, rules.LastPurchaseTo,
+		rules.Tags, strings.ToLower(strings.TrimSpace(filter.Tag))}
+}
+
+//customersRules returns rules of the filter segment with defaults filled in
+func (s *Service) customersRules(ctx context.Context, filter CustomersFilter) (*SegmentRules, error) {
+	rules := &SegmentRules{}
+	if filter.SegmentID != 0 {
+		var err error
+		rules, err = s.segmentRules(ctx, filter.SegmentID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rules.Currency == "" {
+		rules.Currency = money.DefaultCurrency
+	}
+	if rules.Tags == nil {
+		rules.Tags = make([]string, 0)
+	}
+	return rules, nil
+}
+
+//EachCustomer passes customers matching the filter to fn one by one in id order.
+//Error of fn stops the iteration and is returned as it is.
+func (s *Service) EachCustomer(ctx context.Context, filter CustomersFilter, fn func(*Customer) error) error {
+	rules, err := s.customersRules(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	args := append(rules.args(filter), filter.Limit, filter.Offset)
+	rows, err := s.db.Query(ctx, customersMatched+`
+	select id, name, phone, active, created, spent, last, tags
+	from matched
+	order by id limit nullif($8::bigint, 0) offset $9`, args...)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
 	defer rows.Close()
 
 	for rows.Next() {
-		item := &Customer{}
-		err = rows.Scan(&item.ID, &item.Name, &item.Phone, &item.Active, &item.Created)
+		item := &Customer{Spent: &money.Money{Currency: rules.Currency}}
+		err = rows.Scan(&item.ID, &item.Name, &item.Phone, &item.Active, &item.Created, &item.Spent.Amount, &item.LastPurchase, &item.Tags)
 		if err != nil {
 			log.Print(err)
-			return nil, err
+			return ErrInternal
+		}
+		err = fn(item)
+		if err != nil {
+			return err
 		}
-		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
 	}
 
-	return items, nil
+	return nil
+}
+
+//CustomerByID returns customer with addresses, contact preferences, tags and notes
+func (s *Service) CustomerByID(ctx context.Context, id int64) (*Customer, error) {
+	item := &Customer{}
+	err := s.db.QueryRow(ctx, `select id, name, phone, email, active, created from customers where id = $1`, id).
+		Scan(&item.ID, &item.Name, &item.Phone, &item.Email, &item.Active, &item.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	item.Addresses, err = s.customersSvc.Addresses(ctx, id)
+	if err != nil {
+		return nil, ErrInternal
+	}
+	item.Preferences, err = s.customersSvc.Preferences(ctx, id)
+	if err != nil {
+		return nil, ErrInternal
+	}
+	item.Tags, err = s.CustomerTags(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	item.Notes, err = s.CustomerNotes(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
 }
 
 //ChangeCustomer ...
 func (s *Service) ChangeCustomer(ctx context.Context, customer *Customer) (*Customer, error) {
 
+	phone, err := s.phones.Normalize(customer.Phone)
+	if err != nil {
+		return nil, ErrInvalidPhone
+	}
+	customer.Phone = phone
+
 	sqlstmt := `update customers set name = $2, phone = $3, active = $4  where id = $1 returning name,phone,active`
 
 	if err := s.db.QueryRow(ctx, sqlstmt, customer.ID, customer.Name, customer.Phone, customer.Active).
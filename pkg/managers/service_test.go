@@ -0,0 +1,119 @@
+package managers_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/manucher051299/crud/pkg/customers"
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/giftcards"
+	"github.com/manucher051299/crud/pkg/loyalty"
+	"github.com/manucher051299/crud/pkg/managers"
+	"github.com/manucher051299/crud/pkg/money"
+	"github.com/manucher051299/crud/pkg/notifications"
+	"github.com/manucher051299/crud/pkg/payments"
+	"github.com/manucher051299/crud/pkg/phones"
+	"github.com/manucher051299/crud/pkg/wishlist"
+)
+
+func newService(t *testing.T) (*managers.Service, *pgxpool.Pool) {
+	t.Helper()
+	pool := dbtest.Pool(t)
+	config := phones.Config{DefaultRegion: "TJ"}
+	paymentsSvc := payments.NewService(pool, loyalty.NewService(pool, loyalty.Config{}), giftcards.NewService(pool))
+	wishlistSvc := wishlist.NewService(pool, notifications.NewOutbox(pool, notifications.NewMemory()))
+	return managers.NewService(pool, paymentsSvc, customers.NewService(pool, customers.LogSender{}, config), wishlistSvc, config), pool
+}
+
+func TestCustomersPages(t *testing.T) {
+	svc, pool := newService(t)
+	ctx := context.Background()
+
+	// a tag of its own leaves only customers of this test
+	tag := "page" + dbtest.Phone()[1:]
+	ids := make([]int64, 0)
+	for i := 0; i < 3; i++ {
+		id := dbtest.Customer(t, pool)
+		_, err := pool.Exec(ctx, `insert into customers_tags(customer_id, tag) values ($1, $2)`, id, tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	tests := []struct {
+		name   string
+		filter managers.CustomersFilter
+		want   []int64
+	}{
+		{"first page", managers.CustomersFilter{Tag: tag, Limit: 2}, ids[:2]},
+		{"second page", managers.CustomersFilter{Tag: tag, Limit: 2, Offset: 2}, ids[2:]},
+		{"after the end", managers.CustomersFilter{Tag: tag, Limit: 2, Offset: 5}, []int64{}},
+		{"default limit", managers.CustomersFilter{Tag: tag}, ids},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := svc.Customers(ctx, test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.Total != 3 {
+				t.Errorf("total = %d, want 3", page.Total)
+			}
+			if len(page.Items) != len(test.want) {
+				t.Fatalf("%d customers on the page, want %d", len(page.Items), len(test.want))
+			}
+			for i, item := range page.Items {
+				if item.ID != test.want[i] {
+					t.Errorf("customer %d = %d, want %d", i, item.ID, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSaveProductKeepsCostForNonAdmins(t *testing.T) {
+	svc, pool := newService(t)
+	ctx := context.Background()
+	admin := dbtest.Manager(t, pool, true)
+	manager := dbtest.Manager(t, pool, false)
+
+	cost := int64(400)
+	product, err := svc.SaveProduct(ctx, admin, &managers.Product{Name: "Cup", Qty: 3, Price: money.New(1000, money.DefaultCurrency),
+		TaxIncluded: true, Cost: &cost})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a manager gets the product without cost and saves it back with a new price
+	product.Cost = nil
+	product.Price.Amount = 1200
+	saved, err := svc.SaveProduct(ctx, manager, product)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Price.Amount != 1200 || saved.Cost == nil || *saved.Cost != cost {
+		t.Errorf("saved by manager: price %d, cost %v, want price 1200 and cost %d kept", saved.Price.Amount, saved.Cost, cost)
+	}
+
+	other := int64(1)
+	product.Cost = &other
+	_, err = svc.SaveProduct(ctx, manager, product)
+	if err != managers.ErrCostForbidden {
+		t.Errorf("cost set by manager err = %v, want %v", err, managers.ErrCostForbidden)
+	}
+	_, err = svc.SaveProduct(ctx, manager, &managers.Product{Name: "Plate", Price: money.New(500, money.DefaultCurrency), Cost: &other})
+	if err != managers.ErrCostForbidden {
+		t.Errorf("new product with cost by manager err = %v, want %v", err, managers.ErrCostForbidden)
+	}
+
+	stored := int64(0)
+	err = pool.QueryRow(ctx, `select cost from products where id = $1`, product.ID).Scan(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != cost {
+		t.Errorf("stored cost = %d, want %d", stored, cost)
+	}
+}
@@ -0,0 +1,201 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+var (
+	//ErrInactive - deactivated manager can't log in
+	ErrInactive = errors.New("manager is deactivated")
+	//ErrInvalidManager ...
+	ErrInvalidManager = errors.New("invalid manager")
+	//ErrOwnAccount - admin can't deactivate or demote itself
+	ErrOwnAccount = errors.New("can't change own account")
+)
+
+//StaffFilter - Query matches name or phone, empty fields are not applied
+type StaffFilter struct {
+	Query       string
+	Departament string
+	Active      *bool
+}
+
+//StaffUpdate - nil fields are left as they are
+type StaffUpdate struct {
+	Name        *string `json:"name"`
+	Phone       *string `json:"phone"`
+	Departament *string `json:"departament"`
+	Salary      *int64  `json:"salary"`
+	Plan        *int64  `json:"plan"`
+	IsAdmin     *bool   `json:"is_admin"`
+}
+
+const staffColumns = `id, name, salary, plan, coalesce(boss_id, 0), coalesce(departament, ''), phone, is_admin, active, created`
+
+func scanManager(row pgx.Row, item *Manager) error {
+	return row.Scan(&item.ID, &item.Name, &item.Salary, &item.Plan, &item.BossID, &item.Departament, &item.Phone,
+		&item.IsAdmin, &item.Active, &item.Created)
+}
+
+//Staff lists managers matching the filter
+func (s *Service) Staff(ctx context.Context, filter StaffFilter) ([]*Manager, error) {
+	items := make([]*Manager, 0)
+
+	query := strings.TrimSpace(filter.Query)
+	rows, err := s.db.Query(ctx, `
+	select `+staffColumns+` from managers
+	where ($1 = '' or name ilike '%' || $1 || '%' or phone like '%' || $1 || '%')
+		and ($2 = '' or departament = $2)
+		and ($3::boolean is null or active = $3)
+	order by id limit 500`, query, strings.TrimSpace(filter.Departament), filter.Active)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Manager{}
+		err = scanManager(rows, item)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+//ManagerByID ...
+func (s *Service) ManagerByID(ctx context.Context, id int64) (*Manager, error) {
+	item := &Manager{}
+	err := scanManager(s.db.QueryRow(ctx, `select `+staffColumns+` from managers where id = $1`, id), item)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+//UpdateManager changes profile, pay and role of the manager, admin can't take own admin role
+func (s *Service) UpdateManager(ctx context.Context, adminID int64, id int64, update *StaffUpdate) (*Manager, error) {
+	if update.Name != nil {
+		name := strings.TrimSpace(*update.Name)
+		if name == "" {
+			return nil, ErrInvalidManager
+		}
+		update.Name = &name
+	}
+	if update.Phone != nil {
+		phone, err := s.phones.Normalize(*update.Phone)
+		if err != nil {
+			return nil, ErrInvalidPhone
+		}
+		update.Phone = &phone
+	}
+	if update.Departament != nil {
+		departament := strings.TrimSpace(*update.Departament)
+		update.Departament = &departament
+	}
+	if update.Salary != nil && *update.Salary < 0 || update.Plan != nil && *update.Plan < 0 {
+		return nil, ErrInvalidManager
+	}
+	if update.IsAdmin != nil && !*update.IsAdmin && adminID == id {
+		return nil, ErrOwnAccount
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	if update.Phone != nil {
+		used := false
+		err = tx.QueryRow(ctx, `select exists(select 1 from managers where phone = $1 and id <> $2)`, *update.Phone, id).Scan(&used)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		if used {
+			return nil, ErrPhoneUsed
+		}
+	}
+
+	item := &Manager{}
+	err = scanManager(tx.QueryRow(ctx, `
+	update managers set name = coalesce($2, name), phone = coalesce($3, phone),
+		departament = case when $4::text is null then departament else nullif($4, '') end,
+		salary = coalesce($5, salary), plan = coalesce($6, plan), is_admin = coalesce($7, is_admin)
+	where id = $1
+	returning `+staffColumns, id, update.Name, update.Phone, update.Departament, update.Salary, update.Plan, update.IsAdmin), item)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+//SetActive deactivates or reactivates the manager, deactivation revokes all tokens
+func (s *Service) SetActive(ctx context.Context, adminID int64, id int64, active bool) (*Manager, error) {
+	if !active && adminID == id {
+		return nil, ErrOwnAccount
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	item := &Manager{}
+	err = scanManager(tx.QueryRow(ctx, `
+	update managers set active = $2, deactivated = case when $2 then null else coalesce(deactivated, current_timestamp) end
+	where id = $1 returning `+staffColumns, id, active), item)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	if !active {
+		_, err = tx.Exec(ctx, `delete from managers_tokens where manager_id = $1`, id)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
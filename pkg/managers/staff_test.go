@@ -0,0 +1,180 @@
+package managers_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/managers"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// staffPhone returns an unused Tajik number, the service region
+func staffPhone() string {
+	phone := dbtest.Phone()
+	return "+99290" + phone[len(phone)-7:]
+}
+
+// staffManager inserts a manager who logs in with the phone and password
+func staffManager(t *testing.T, pool *pgxpool.Pool, phone string, password string) int64 {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := int64(0)
+	err = pool.QueryRow(context.Background(), `
+	insert into managers(name, phone, password, is_admin) values ('staff manager', $1, $2, false) returning id`,
+		phone, string(hash)).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestUpdateManager(t *testing.T) {
+	svc, pool := newService(t)
+	ctx := context.Background()
+	admin := dbtest.Manager(t, pool, true)
+	phone := staffPhone()
+	id := staffManager(t, pool, phone, "secret")
+	taken := staffPhone()
+	staffManager(t, pool, taken, "secret")
+
+	text := func(value string) *string { return &value }
+	number := func(value int64) *int64 { return &value }
+	flag := func(value bool) *bool { return &value }
+
+	item, err := svc.UpdateManager(ctx, admin, id, &managers.StaffUpdate{Name: text("  Rustam  "), Departament: text(" Sales "),
+		Salary: number(3000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Name != "Rustam" || item.Departament != "Sales" || item.Salary != 3000 || item.Phone != phone || item.IsAdmin {
+		t.Errorf("updated manager = %+v, want trimmed name and departament, new salary, the rest as it was", item)
+	}
+
+	// own number in another format is not a conflict
+	newPhone := staffPhone()
+	item, err = svc.UpdateManager(ctx, admin, id, &managers.StaffUpdate{Phone: text("8" + newPhone[4:]), Departament: text("")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Phone != newPhone || item.Departament != "" || item.Name != "Rustam" {
+		t.Errorf("updated manager = %+v, want phone %s and no departament", item, newPhone)
+	}
+	_, err = svc.UpdateManager(ctx, admin, id, &managers.StaffUpdate{Phone: text(newPhone)})
+	if err != nil {
+		t.Errorf("keeping own phone: err = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		adminID int64
+		id      int64
+		update  *managers.StaffUpdate
+		err     error
+	}{
+		{"phone of another manager", admin, id, &managers.StaffUpdate{Phone: text("8" + taken[4:])}, managers.ErrPhoneUsed},
+		{"invalid phone", admin, id, &managers.StaffUpdate{Phone: text("90-00")}, managers.ErrInvalidPhone},
+		{"blank name", admin, id, &managers.StaffUpdate{Name: text("  ")}, managers.ErrInvalidManager},
+		{"negative salary", admin, id, &managers.StaffUpdate{Salary: number(-1)}, managers.ErrInvalidManager},
+		{"negative plan", admin, id, &managers.StaffUpdate{Plan: number(-1)}, managers.ErrInvalidManager},
+		{"own admin role", admin, admin, &managers.StaffUpdate{IsAdmin: flag(false)}, managers.ErrOwnAccount},
+		{"unknown manager", admin, -1, &managers.StaffUpdate{Plan: number(1)}, managers.ErrNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := svc.UpdateManager(ctx, test.adminID, test.id, test.update)
+			if err != test.err {
+				t.Errorf("err = %v, want %v", err, test.err)
+			}
+		})
+	}
+
+	item, err = svc.ManagerByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Phone != newPhone || item.Name != "Rustam" || item.Salary != 3000 || item.Plan != 0 {
+		t.Errorf("manager after refused updates = %+v, want it unchanged", item)
+	}
+
+	item, err = svc.UpdateManager(ctx, admin, id, &managers.StaffUpdate{IsAdmin: flag(true)})
+	if err != nil || !item.IsAdmin {
+		t.Errorf("promote: manager = %+v, err = %v, want an admin", item, err)
+	}
+}
+
+func TestSetActiveRevokesTokens(t *testing.T) {
+	svc, pool := newService(t)
+	ctx := context.Background()
+	admin := dbtest.Manager(t, pool, true)
+	phone := staffPhone()
+	id := staffManager(t, pool, phone, "secret")
+
+	token, err := svc.Token(ctx, phone, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := svc.IDByToken(ctx, token); got != id {
+		t.Fatalf("IDByToken = %d, want %d", got, id)
+	}
+
+	_, err = svc.SetActive(ctx, admin, admin, false)
+	if err != managers.ErrOwnAccount {
+		t.Errorf("deactivate own account: err = %v, want %v", err, managers.ErrOwnAccount)
+	}
+	_, err = svc.SetActive(ctx, admin, -1, false)
+	if err != managers.ErrNotFound {
+		t.Errorf("unknown manager: err = %v, want %v", err, managers.ErrNotFound)
+	}
+
+	item, err := svc.SetActive(ctx, admin, id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Active {
+		t.Errorf("manager is active after deactivation")
+	}
+	if got, _ := svc.IDByToken(ctx, token); got != 0 {
+		t.Errorf("IDByToken of a deactivated manager = %d, want 0", got)
+	}
+	tokens := 0
+	err = pool.QueryRow(ctx, `select count(*) from managers_tokens where manager_id = $1`, id).Scan(&tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != 0 {
+		t.Errorf("%d tokens left after deactivation, want 0", tokens)
+	}
+
+	_, err = svc.Token(ctx, phone, "secret")
+	if err != managers.ErrInactive {
+		t.Errorf("login of a deactivated manager: err = %v, want %v", err, managers.ErrInactive)
+	}
+	// wrong password doesn't tell that the account exists
+	_, err = svc.Token(ctx, phone, "wrong")
+	if err != managers.ErrInvalidPassword {
+		t.Errorf("wrong password: err = %v, want %v", err, managers.ErrInvalidPassword)
+	}
+
+	item, err = svc.SetActive(ctx, admin, id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !item.Active {
+		t.Errorf("manager is inactive after reactivation")
+	}
+	if got, _ := svc.IDByToken(ctx, token); got != 0 {
+		t.Errorf("revoked token works again after reactivation")
+	}
+	token, err = svc.Token(ctx, phone, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := svc.IDByToken(ctx, token); got != id {
+		t.Errorf("IDByToken after reactivation = %d, want %d", got, id)
+	}
+}
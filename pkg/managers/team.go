@@ -0,0 +1,233 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+//ErrCycle - manager can't report to itself or to own subordinate
+var ErrCycle = errors.New("hierarchy cycle")
+
+//OrgNode - manager with direct subordinates
+type OrgNode struct {
+	ID           int64      `json:"id"`
+	Name         string     `json:"name"`
+	Departament  string     `json:"departament"`
+	IsAdmin      bool       `json:"is_admin"`
+	Subordinates []*OrgNode `json:"subordinates"`
+}
+
+//ManagerSales - sales totals of one team member
+type ManagerSales struct {
+	ManagerID int64         `json:"manager_id"`
+	Name      string        `json:"name"`
+	Totals    []*SalesTotal `json:"totals"`
+}
+
+//Team returns id of the boss and ids of all direct and indirect subordinates
+func (s *Service) Team(ctx context.Context, bossID int64) ([]int64, error) {
+	items := make([]int64, 0)
+
+	rows, err := s.db.Query(ctx, `
+	with recursive team as (
+		select id from managers where id = $1
+		union
+		select m.id from managers m join team t on m.boss_id = t.id
+	)
+	select id from team order by id`, bossID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		items = append(items, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+//CanSee reports whether viewer may see data of the manager: own data, data of subordinates, or anything for admins
+func (s *Service) CanSee(ctx context.Context, viewerID int64, managerID int64) (bool, error) {
+	if viewerID == managerID || s.IsAdmin(ctx, viewerID) {
+		return true, nil
+	}
+
+	visible := false
+	err := s.db.QueryRow(ctx, `
+	with recursive chain as (
+		select boss_id from managers where id = $2
+		union
+		select m.boss_id from managers m join chain c on m.id = c.boss_id
+	)
+	select exists(select 1 from chain where boss_id = $1)`, viewerID, managerID).Scan(&visible)
+	if err != nil {
+		log.Print(err)
+		return false, ErrInternal
+	}
+	return visible, nil
+}
+
+//CanSeeSale reports whether viewer may see the sale, that is data of the manager who made it
+func (s *Service) CanSeeSale(ctx context.Context, viewerID int64, saleID int64) (bool, error) {
+	managerID := int64(0)
+	err := s.db.QueryRow(ctx, `select manager_id from sales where id = $1`, saleID).Scan(&managerID)
+	if err == pgx.ErrNoRows {
+		return false, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return false, ErrInternal
+	}
+	return s.CanSee(ctx, viewerID, managerID)
+}
+
+//SetBoss assigns boss of the manager, 0 makes the manager a root of the tree
+func (s *Service) SetBoss(ctx context.Context, managerID int64, bossID int64) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	// concurrent changes could build a cycle together, so they go one by one
+	_, err = tx.Exec(ctx, `select pg_advisory_xact_lock(hashtext('managers.boss_id'))`)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	if bossID != 0 {
+		cycle := false
+		err = tx.QueryRow(ctx, `
+		with recursive team as (
+			select id from managers where id = $1
+			union
+			select m.id from managers m join team t on m.boss_id = t.id
+		)
+		select exists(select 1 from team where id = $2)`, managerID, bossID).Scan(&cycle)
+		if err != nil {
+			log.Print(err)
+			return ErrInternal
+		}
+		if cycle {
+			return ErrCycle
+		}
+	}
+
+	tag, err := tx.Exec(ctx, `
+	update managers set boss_id = nullif($2::bigint, 0)
+	where id = $1 and ($2::bigint = 0 or exists(select 1 from managers where id = $2))`, managerID, bossID)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+//SetDepartament ...
+func (s *Service) SetDepartament(ctx context.Context, managerID int64, departament string) error {
+	tag, err := s.db.Exec(ctx, `update managers set departament = nullif($2, '') where id = $1`, managerID, strings.TrimSpace(departament))
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+//OrgTree returns managers without boss with their subordinates
+func (s *Service) OrgTree(ctx context.Context) ([]*OrgNode, error) {
+	rows, err := s.db.Query(ctx, `select id, name, coalesce(departament, ''), is_admin, coalesce(boss_id, 0) from managers order by id`)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	nodes := make([]*OrgNode, 0)
+	bosses := make(map[int64]int64)
+	byID := make(map[int64]*OrgNode)
+	for rows.Next() {
+		node := &OrgNode{Subordinates: make([]*OrgNode, 0)}
+		var bossID int64
+		err = rows.Scan(&node.ID, &node.Name, &node.Departament, &node.IsAdmin, &bossID)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		nodes = append(nodes, node)
+		bosses[node.ID] = bossID
+		byID[node.ID] = node
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	roots := make([]*OrgNode, 0)
+	for _, node := range nodes {
+		boss, ok := byID[bosses[node.ID]]
+		if !ok {
+			roots = append(roots, node)
+			continue
+		}
+		boss.Subordinates = append(boss.Subordinates, node)
+	}
+	return roots, nil
+}
+
+//TeamSales returns sales totals of the boss and every subordinate
+func (s *Service) TeamSales(ctx context.Context, bossID int64) ([]*ManagerSales, error) {
+	team, err := s.Team(ctx, bossID)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*ManagerSales, 0, len(team))
+	for _, id := range team {
+		item := &ManagerSales{ManagerID: id}
+		err = s.db.QueryRow(ctx, `select name from managers where id = $1`, id).Scan(&item.Name)
+		if err == pgx.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item.Totals, err = s.GetSales(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
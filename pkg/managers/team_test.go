@@ -0,0 +1,115 @@
+package managers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/managers"
+)
+
+func TestSetBossPreventsCycles(t *testing.T) {
+	svc, pool := newService(t)
+	ctx := context.Background()
+	top := dbtest.Manager(t, pool, false)
+	middle := dbtest.Manager(t, pool, false)
+	bottom := dbtest.Manager(t, pool, false)
+
+	for _, link := range [][2]int64{{middle, top}, {bottom, middle}} {
+		err := svc.SetBoss(ctx, link[0], link[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		managerID int64
+		bossID    int64
+		err       error
+	}{
+		{"own boss", top, top, managers.ErrCycle},
+		{"direct subordinate", middle, bottom, managers.ErrCycle},
+		{"indirect subordinate", top, bottom, managers.ErrCycle},
+		{"unknown boss", bottom, -1, managers.ErrNotFound},
+		{"unknown manager", -1, top, managers.ErrNotFound},
+		{"boss of the boss", bottom, top, nil},
+		{"no boss", top, 0, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := svc.SetBoss(ctx, test.managerID, test.bossID)
+			if err != test.err {
+				t.Errorf("SetBoss(%d, %d) = %v, want %v", test.managerID, test.bossID, err, test.err)
+			}
+		})
+	}
+
+	team, err := svc.Team(ctx, top)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bottom moved to top, refused changes left the tree as it was
+	if len(team) != 3 {
+		t.Errorf("team of the top manager = %v, want the top, middle and bottom managers", team)
+	}
+}
+
+func TestCanSee(t *testing.T) {
+	svc, pool := newService(t)
+	ctx := context.Background()
+	admin := dbtest.Manager(t, pool, true)
+	boss := dbtest.Manager(t, pool, false)
+	lead := dbtest.Manager(t, pool, false)
+	seller := dbtest.Manager(t, pool, false)
+	other := dbtest.Manager(t, pool, false)
+
+	for _, link := range [][2]int64{{lead, boss}, {seller, lead}, {other, boss}} {
+		err := svc.SetBoss(ctx, link[0], link[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		viewerID  int64
+		managerID int64
+		want      bool
+	}{
+		{"own data", seller, seller, true},
+		{"direct subordinate", lead, seller, true},
+		{"indirect subordinate", boss, seller, true},
+		{"admin", admin, seller, true},
+		{"boss", seller, lead, false},
+		{"colleague", other, seller, false},
+		{"colleague of the boss", other, lead, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			visible, err := svc.CanSee(ctx, test.viewerID, test.managerID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if visible != test.want {
+				t.Errorf("CanSee(%d, %d) = %v, want %v", test.viewerID, test.managerID, visible, test.want)
+			}
+		})
+	}
+
+	saleID := dbtest.Sale(t, pool, seller, 0, "paid", time.Now())
+	for viewerID, want := range map[int64]bool{seller: true, boss: true, admin: true, other: false} {
+		visible, err := svc.CanSeeSale(ctx, viewerID, saleID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if visible != want {
+			t.Errorf("CanSeeSale(%d) = %v, want %v", viewerID, visible, want)
+		}
+	}
+	_, err := svc.CanSeeSale(ctx, seller, -1)
+	if err != managers.ErrNotFound {
+		t.Errorf("unknown sale: err = %v, want %v", err, managers.ErrNotFound)
+	}
+}
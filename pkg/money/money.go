@@ -0,0 +1,180 @@
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+//ErrCurrencyMismatch ...
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+//ErrInvalidCurrency ...
+var ErrInvalidCurrency = errors.New("invalid currency")
+
+//ErrInvalidAmount ...
+var ErrInvalidAmount = errors.New("invalid amount")
+
+//DefaultCurrency - currency of amounts that come without one
+var DefaultCurrency = "TJS"
+
+//exponents - number of minor units digits, currencies not listed here use 2
+var exponents = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"BHD": 3,
+}
+
+//Money - amount in minor units (dirams, cents) of an ISO 4217 currency
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+//New ...
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+//ValidCurrency checks that code looks like an ISO 4217 code
+func ValidCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+//Exponent returns number of minor unit digits of the currency
+func Exponent(currency string) int {
+	if exp, ok := exponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+//IsZero ...
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+//Add sums two amounts of the same currency, zero value adopts the other currency
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency == "" {
+		m.Currency = other.Currency
+	}
+	if other.Currency != "" && other.Currency != m.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+//Sub ...
+func (m Money) Sub(other Money) (Money, error) {
+	return m.Add(Money{Amount: -other.Amount, Currency: other.Currency})
+}
+
+//Mul ...
+func (m Money) Mul(qty int) Money {
+	return Money{Amount: m.Amount * int64(qty), Currency: m.Currency}
+}
+
+//String formats amount in major units, e.g. "12.50 TJS"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+//Decimal formats amount in major units without currency, e.g. "12.50"
+func (m Money) Decimal() string {
+	exp := Exponent(m.Currency)
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	if exp == 0 {
+		return sign + strconv.FormatInt(amount, 10)
+	}
+
+	base := int64(1)
+	for i := 0; i < exp; i++ {
+		base *= 10
+	}
+	minor := strconv.FormatInt(amount%base, 10)
+	minor = strings.Repeat("0", exp-len(minor)) + minor
+
+	return sign + strconv.FormatInt(amount/base, 10) + "." + minor
+}
+
+//Parse reads amount in major units, e.g. "12.50" or "12,5", into minor units of the currency
+func Parse(text string, currency string) (Money, error) {
+	text = strings.TrimSpace(text)
+	sign := int64(1)
+	if strings.HasPrefix(text, "-") {
+		sign = -1
+		text = text[1:]
+	}
+
+	major, minor := text, ""
+	if i := strings.IndexAny(text, ".,"); i >= 0 {
+		major, minor = text[:i], text[i+1:]
+	}
+	exp := Exponent(currency)
+	if major == "" || len(minor) > exp || strings.Trim(major+minor, "0123456789") != "" {
+		return Money{}, ErrInvalidAmount
+	}
+	minor += strings.Repeat("0", exp-len(minor))
+
+	amount, err := strconv.ParseInt(major+minor, 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidAmount
+	}
+	return Money{Amount: sign * amount, Currency: currency}, nil
+}
+
+//UnmarshalJSON accepts {"amount":1250,"currency":"TJS"} as well as
+//a bare number of minor units in DefaultCurrency
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	if len(data) > 0 && data[0] != '{' {
+		amount, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return err
+		}
+		m.Amount = amount
+		m.Currency = DefaultCurrency
+		return nil
+	}
+
+	var value struct {
+		Amount   int64  `json:"amount"`
+		Currency string `json:"currency"`
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if value.Currency == "" {
+		value.Currency = DefaultCurrency
+	}
+	value.Currency = strings.ToUpper(value.Currency)
+	if !ValidCurrency(value.Currency) {
+		return ErrInvalidCurrency
+	}
+
+	m.Amount = value.Amount
+	m.Currency = value.Currency
+	return nil
+}
@@ -0,0 +1,123 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAdd(t *testing.T) {
+	sum, err := New(150, "TJS").Add(New(250, "TJS"))
+	if err != nil || sum != New(400, "TJS") {
+		t.Errorf("Add = %v, %v, want 400 TJS", sum, err)
+	}
+
+	sum, err = Money{}.Add(New(250, "USD"))
+	if err != nil || sum != New(250, "USD") {
+		t.Errorf("zero value must adopt currency: %v, %v", sum, err)
+	}
+
+	_, err = New(150, "TJS").Add(New(250, "USD"))
+	if err != ErrCurrencyMismatch {
+		t.Errorf("err = %v, want %v", err, ErrCurrencyMismatch)
+	}
+
+	diff, err := New(150, "TJS").Sub(New(250, "TJS"))
+	if err != nil || diff != New(-100, "TJS") {
+		t.Errorf("Sub = %v, %v, want -100 TJS", diff, err)
+	}
+
+	if product := New(1250, "TJS").Mul(3); product != New(3750, "TJS") {
+		t.Errorf("Mul = %v, want 3750 TJS", product)
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		value Money
+		want  string
+	}{
+		{New(1250, "TJS"), "12.50 TJS"},
+		{New(5, "TJS"), "0.05 TJS"},
+		{New(-1250, "TJS"), "-12.50 TJS"},
+		{New(-5, "TJS"), "-0.05 TJS"},
+		{New(0, "TJS"), "0.00 TJS"},
+		{New(1250, "JPY"), "1250 JPY"},
+		{New(1250, "KWD"), "1.250 KWD"},
+	}
+	for _, test := range tests {
+		if got := test.value.String(); got != test.want {
+			t.Errorf("%#v.String() = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text     string
+		currency string
+		want     int64
+	}{
+		{"12.50", "TJS", 1250},
+		{"12,5", "TJS", 1250},
+		{"12", "TJS", 1200},
+		{"12.", "TJS", 1200},
+		{" 0.05 ", "TJS", 5},
+		{"-3.10", "TJS", -310},
+		{"1250", "JPY", 1250},
+		{"1.25", "KWD", 1250},
+	}
+	for _, test := range tests {
+		got, err := Parse(test.text, test.currency)
+		if err != nil || got != New(test.want, test.currency) {
+			t.Errorf("Parse(%q, %q) = %v, %v, want %d", test.text, test.currency, got, err, test.want)
+		}
+	}
+
+	for _, text := range []string{"", "-", ".5", "12.505", "1.2.3", "12a", "1 000", "1e3", "1.5"} {
+		currency := "TJS"
+		if text == "1.5" {
+			currency = "JPY"
+		}
+		_, err := Parse(text, currency)
+		if err != ErrInvalidAmount {
+			t.Errorf("Parse(%q, %q): err = %v, want %v", text, currency, err, ErrInvalidAmount)
+		}
+	}
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	for _, value := range []Money{New(1250, "TJS"), New(-7, "TJS"), New(99, "JPY"), New(1001, "KWD")} {
+		got, err := Parse(value.Decimal(), value.Currency)
+		if err != nil || got != value {
+			t.Errorf("Parse(%q) = %v, %v, want %v", value.Decimal(), got, err, value)
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		data string
+		want Money
+	}{
+		{`{"amount":1250,"currency":"usd"}`, New(1250, "USD")},
+		{`{"amount":1250}`, New(1250, DefaultCurrency)},
+		{`1250`, New(1250, DefaultCurrency)},
+	}
+	for _, test := range tests {
+		var got Money
+		err := json.Unmarshal([]byte(test.data), &got)
+		if err != nil || got != test.want {
+			t.Errorf("Unmarshal(%s) = %v, %v, want %v", test.data, got, err, test.want)
+		}
+	}
+
+	var got Money
+	err := json.Unmarshal([]byte(`{"amount":1,"currency":"DOLLAR"}`), &got)
+	if err != ErrInvalidCurrency {
+		t.Errorf("err = %v, want %v", err, ErrInvalidCurrency)
+	}
+	err = json.Unmarshal([]byte(`12.5`), &got)
+	if err == nil {
+		t.Errorf("fractional minor units must be rejected")
+	}
+}
@@ -0,0 +1,73 @@
+package notifications
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+//notification kinds
+const (
+	BackInStock = "back_in_stock"
+)
+
+//Notification - message for one customer
+type Notification struct {
+	//ID - id in the outbox, the same notification keeps it when delivered again
+	ID         int64     `json:"id"`
+	CustomerID int64     `json:"customer_id"`
+	Kind       string    `json:"kind"`
+	ProductID  int64     `json:"product_id"`
+	Text       string    `json:"text"`
+	Created    time.Time `json:"created"`
+}
+
+//Notifier delivers notifications, implementations decide the channel
+type Notifier interface {
+	Notify(ctx context.Context, notification *Notification) error
+}
+
+//Log writes notifications to the log
+type Log struct{}
+
+//Notify ...
+func (Log) Notify(ctx context.Context, notification *Notification) error {
+	log.Printf("notification for customer %d: %s", notification.CustomerID, notification.Text)
+	return nil
+}
+
+//Memory keeps notifications in process, it is meant for tests
+type Memory struct {
+	mu    sync.Mutex
+	items []*Notification
+}
+
+//NewMemory ...
+func NewMemory() *Memory {
+	return &Memory{items: make([]*Notification, 0)}
+}
+
+//Notify ...
+func (m *Memory) Notify(ctx context.Context, notification *Notification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items = append(m.items, notification)
+	return nil
+}
+
+//Sent returns copy of notifications received so far
+func (m *Memory) Sent() []*Notification {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	items := make([]*Notification, len(m.items))
+	copy(items, m.items)
+	return items
+}
+
+//Reset forgets received notifications
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items = m.items[:0]
+}
@@ -0,0 +1,32 @@
+package notifications
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemory(t *testing.T) {
+	memory := NewMemory()
+	ctx := context.Background()
+
+	for _, text := range []string{"tea is back in stock", "cup is back in stock"} {
+		err := memory.Notify(ctx, &Notification{CustomerID: 1, Kind: BackInStock, Text: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sent := memory.Sent()
+	if len(sent) != 2 || sent[0].Text != "tea is back in stock" || sent[1].Text != "cup is back in stock" {
+		t.Fatalf("Sent() = %v, want both notifications in order", sent)
+	}
+
+	// Sent returns a copy, it doesn't change with later notifications
+	memory.Reset()
+	if len(memory.Sent()) != 0 {
+		t.Errorf("Sent() after Reset = %v, want empty", memory.Sent())
+	}
+	if len(sent) != 2 {
+		t.Errorf("copy returned before Reset changed: %v", sent)
+	}
+}
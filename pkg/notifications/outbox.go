@@ -0,0 +1,148 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+//ErrInternal ...
+var ErrInternal = errors.New("internal error")
+
+//MaxAttempts - notification is given up after that many failed deliveries, it stays in the outbox with the last error
+const MaxAttempts = 10
+
+//outboxBatch - notifications locked and sent in one transaction
+const outboxBatch = 100
+
+//Outbox keeps notifications in the database until the notifier accepts them.
+//They are enqueued in the transaction that caused them, so they are neither lost on failed sends
+//nor sent for changes that were rolled back. Delivery is at least once, notifiers may drop repeated IDs.
+type Outbox struct {
+	db       *pgxpool.Pool
+	notifier Notifier
+	wake     chan struct{}
+}
+
+func NewOutbox(db *pgxpool.Pool, notifier Notifier) *Outbox {
+	return &Outbox{db: db, notifier: notifier, wake: make(chan struct{}, 1)}
+}
+
+//Enqueue stores notifications in tx, they are delivered after it is committed
+func (o *Outbox) Enqueue(ctx context.Context, tx pgx.Tx, items []*Notification) error {
+	for _, item := range items {
+		err := tx.QueryRow(ctx, `
+		insert into notifications_outbox(customer_id, kind, product_id, text) values ($1, $2, nullif($3, 0), $4)
+		returning id, created`, item.CustomerID, item.Kind, item.ProductID, item.Text).Scan(&item.ID, &item.Created)
+		if err != nil {
+			log.Print(err)
+			return ErrInternal
+		}
+	}
+	return nil
+}
+
+//Wake makes Run deliver without waiting for the interval, it is called after enqueuing transaction is committed
+func (o *Outbox) Wake() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+//Deliver sends notifications that are due and returns how many were sent.
+//Failed ones are retried later, each next attempt waits a minute longer.
+//Rows are locked while sent, so several instances of the app don't send the same notification.
+func (o *Outbox) Deliver(ctx context.Context) (int, error) {
+	tx, err := o.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return 0, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+	select id, customer_id, kind, coalesce(product_id, 0), text, created
+	from notifications_outbox
+	where sent is null and attempts < $1 and next_attempt <= current_timestamp
+	order by id
+	limit $2
+	for update skip locked`, MaxAttempts, outboxBatch)
+	if err != nil {
+		log.Print(err)
+		return 0, ErrInternal
+	}
+	items := make([]*Notification, 0)
+	for rows.Next() {
+		item := &Notification{}
+		err = rows.Scan(&item.ID, &item.CustomerID, &item.Kind, &item.ProductID, &item.Text, &item.Created)
+		if err != nil {
+			rows.Close()
+			log.Print(err)
+			return 0, ErrInternal
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return 0, ErrInternal
+	}
+
+	sent := 0
+	for _, item := range items {
+		sendErr := o.notifier.Notify(ctx, item)
+		if sendErr != nil {
+			log.Print(sendErr)
+			_, err = tx.Exec(ctx, `
+			update notifications_outbox
+			set attempts = attempts + 1, error = $2, next_attempt = current_timestamp + (attempts + 1) * interval '1 minute'
+			where id = $1`, item.ID, sendErr.Error())
+		} else {
+			sent++
+			_, err = tx.Exec(ctx, `
+			update notifications_outbox set attempts = attempts + 1, error = '', sent = current_timestamp where id = $1`, item.ID)
+		}
+		if err != nil {
+			log.Print(err)
+			return 0, ErrInternal
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return 0, ErrInternal
+	}
+	return sent, nil
+}
+
+//Run delivers notifications until ctx is done, it checks the outbox every interval and on Wake
+func (o *Outbox) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// a full batch means there may be more due
+		sent := outboxBatch
+		for sent == outboxBatch {
+			var err error
+			sent, err = o.Deliver(ctx)
+			if err != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
@@ -0,0 +1,525 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/manucher051299/crud/pkg/managers"
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+var (
+	//ErrNotFound ...
+	ErrNotFound = errors.New("item not found")
+	//ErrInternal ...
+	ErrInternal = errors.New("internal error")
+	//ErrInvalidQty ...
+	ErrInvalidQty = errors.New("invalid quantity")
+	//ErrProductUnavailable - product is inactive or there is not enough stock
+	ErrProductUnavailable = errors.New("product unavailable")
+	//ErrEmptyCart ...
+	ErrEmptyCart = errors.New("cart is empty")
+	//ErrInvalidTransition ...
+	ErrInvalidTransition = errors.New("invalid order status transition")
+	//ErrMixedCurrency ...
+	ErrMixedCurrency = errors.New("cart items in different currencies")
+)
+
+//order statuses
+const (
+	New       = "new"
+	Confirmed = "confirmed"
+	Ready     = "ready"
+	Completed = "completed"
+	Cancelled = "cancelled"
+)
+
+//transitions - allowed status changes, confirming an order turns it into a sale
+var transitions = map[string][]string{
+	New:       {Confirmed, Cancelled},
+	Confirmed: {Ready},
+	Ready:     {Completed},
+}
+
+//Config ...
+type Config struct {
+	//ReservationTTL - how long stock of a new order is held before it is cancelled
+	ReservationTTL time.Duration
+}
+
+type Service struct {
+	db          *pgxpool.Pool
+	managersSvc *managers.Service
+	config      Config
+}
+
+func NewService(db *pgxpool.Pool, managersSvc *managers.Service, config Config) *Service {
+	return &Service{db: db, managersSvc: managersSvc, config: config}
+}
+
+//CartItem ...
+type CartItem struct {
+	ProductID int64       `json:"product_id"`
+	Name      string      `json:"name"`
+	Price     money.Money `json:"price"`
+	Qty       int         `json:"qty"`
+	Total     money.Money `json:"total"`
+	Available int         `json:"available"`
+}
+
+//Cart ...
+type Cart struct {
+	CustomerID int64       `json:"customer_id"`
+	Items      []*CartItem `json:"items"`
+}
+
+//Order ...
+type Order struct {
+	ID         int64        `json:"id"`
+	CustomerID int64        `json:"customer_id"`
+	ManagerID  int64        `json:"manager_id"`
+	SaleID     int64        `json:"sale_id"`
+	Status     string       `json:"status"`
+	Total      money.Money  `json:"total"`
+	Expires    *time.Time   `json:"expires"`
+	Created    time.Time    `json:"created"`
+	Updated    time.Time    `json:"updated"`
+	Items      []*OrderItem `json:"items"`
+}
+
+//OrderItem ...
+type OrderItem struct {
+	ID        int64       `json:"id"`
+	ProductID int64       `json:"product_id"`
+	Name      string      `json:"name"`
+	Price     money.Money `json:"price"`
+	Qty       int         `json:"qty"`
+}
+
+//Cart returns cart of the customer with current prices
+func (s *Service) Cart(ctx context.Context, customerID int64) (*Cart, error) {
+	cart := &Cart{CustomerID: customerID, Items: make([]*CartItem, 0)}
+
+	rows, err := s.db.Query(ctx, `
+	select c.product_id, p.name, p.price, p.currency, c.qty, case when p.active then p.qty else 0 end
+	from carts c
+	join products p on p.id = c.product_id
+	where c.customer_id = $1
+	order by c.created`, customerID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &CartItem{}
+		err = rows.Scan(&item.ProductID, &item.Name, &item.Price.Amount, &item.Price.Currency, &item.Qty, &item.Available)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item.Total = item.Price.Mul(item.Qty)
+		cart.Items = append(cart.Items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	return cart, nil
+}
+
+//AddToCart increases qty of the product in the cart
+func (s *Service) AddToCart(ctx context.Context, customerID int64, productID int64, qty int) (*Cart, error) {
+	if qty <= 0 {
+		return nil, ErrInvalidQty
+	}
+
+	active := false
+	err := s.db.QueryRow(ctx, `select active from products where id = $1`, productID).Scan(&active)
+	if err == pgx.ErrNoRows || (err == nil && !active) {
+		return nil, ErrProductUnavailable
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	_, err = s.db.Exec(ctx, `
+	insert into carts(customer_id, product_id, qty) values ($1,$2,$3)
+	on conflict (customer_id, product_id) do update set qty = carts.qty + excluded.qty`, customerID, productID, qty)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	return s.Cart(ctx, customerID)
+}
+
+//UpdateCart sets qty of the product in the cart, zero removes it
+func (s *Service) UpdateCart(ctx context.Context, customerID int64, productID int64, qty int) (*Cart, error) {
+	if qty < 0 {
+		return nil, ErrInvalidQty
+	}
+	if qty == 0 {
+		return s.RemoveFromCart(ctx, customerID, productID)
+	}
+
+	tag, err := s.db.Exec(ctx, `update carts set qty = $3 where customer_id = $1 and product_id = $2`, customerID, productID, qty)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrNotFound
+	}
+
+	return s.Cart(ctx, customerID)
+}
+
+//RemoveFromCart ...
+func (s *Service) RemoveFromCart(ctx context.Context, customerID int64, productID int64) (*Cart, error) {
+	_, err := s.db.Exec(ctx, `delete from carts where customer_id = $1 and product_id = $2`, customerID, productID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	return s.Cart(ctx, customerID)
+}
+
+//Checkout creates order from the cart and reserves stock for ReservationTTL
+func (s *Service) Checkout(ctx context.Context, customerID int64) (*Order, error) {
+	err := s.ReleaseExpired(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+	select c.product_id, p.name, p.price, p.currency, c.qty, p.qty, p.active
+	from carts c
+	join products p on p.id = c.product_id
+	where c.customer_id = $1
+	order by c.product_id
+	for update of p`, customerID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	items := make([]*OrderItem, 0)
+	for rows.Next() {
+		item := &OrderItem{}
+		stock := 0
+		active := false
+		err = rows.Scan(&item.ProductID, &item.Name, &item.Price.Amount, &item.Price.Currency, &item.Qty, &stock, &active)
+		if err != nil {
+			rows.Close()
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		if !active || stock < item.Qty {
+			rows.Close()
+			return nil, ErrProductUnavailable
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		log.Print(rows.Err())
+		return nil, ErrInternal
+	}
+	if len(items) == 0 {
+		return nil, ErrEmptyCart
+	}
+
+	order := &Order{CustomerID: customerID, Status: New, Items: items}
+	for _, item := range items {
+		order.Total, err = order.Total.Add(item.Price.Mul(item.Qty))
+		if err != nil {
+			return nil, ErrMixedCurrency
+		}
+	}
+
+	err = tx.QueryRow(ctx, `
+	insert into orders(customer_id, status, total, currency, expires)
+	values ($1, $2, $3, $4, current_timestamp + $5::bigint * interval '1 second')
+	returning id, expires, created, updated`,
+		customerID, New, order.Total.Amount, order.Total.Currency, int64(s.config.ReservationTTL/time.Second)).
+		Scan(&order.ID, &order.Expires, &order.Created, &order.Updated)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	for _, item := range items {
+		err = tx.QueryRow(ctx, `
+		insert into orders_items(order_id, product_id, price, currency, qty) values ($1,$2,$3,$4,$5) returning id`,
+			order.ID, item.ProductID, item.Price.Amount, item.Price.Currency, item.Qty).Scan(&item.ID)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		_, err = tx.Exec(ctx, `update products set qty = qty - $1 where id = $2`, item.Qty, item.ProductID)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+	}
+
+	_, err = tx.Exec(ctx, `delete from carts where customer_id = $1`, customerID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return order, nil
+}
+
+//ReleaseExpired cancels new orders whose reservation has expired and returns their stock
+func (s *Service) ReleaseExpired(ctx context.Context) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+	with expired as (
+		update orders set status = $1, expires = null, updated = current_timestamp
+		where status = $2 and expires < current_timestamp
+		returning id
+	), released as (
+		select oi.product_id, sum(oi.qty) qty
+		from orders_items oi
+		join expired e on e.id = oi.order_id
+		group by oi.product_id
+	)
+	update products p set qty = p.qty + r.qty from released r where p.id = r.product_id`, Cancelled, New)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+//Orders lists orders, customerID 0 means all customers, empty status means any
+func (s *Service) Orders(ctx context.Context, customerID int64, status string) ([]*Order, error) {
+	err := s.ReleaseExpired(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*Order, 0)
+	rows, err := s.db.Query(ctx, `
+	select id, customer_id, coalesce(manager_id,0), coalesce(sale_id,0), status, total, currency, expires, created, updated
+	from orders
+	where ($1::bigint = 0 or customer_id = $1) and ($2 = '' or status = $2)
+	order by id desc
+	limit 500`, customerID, status)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Order{}
+		err = rows.Scan(&item.ID, &item.CustomerID, &item.ManagerID, &item.SaleID, &item.Status,
+			&item.Total.Amount, &item.Total.Currency, &item.Expires, &item.Created, &item.Updated)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
+
+//ByID returns order with items, customerID 0 means any customer
+func (s *Service) ByID(ctx context.Context, id int64, customerID int64) (*Order, error) {
+	err := s.ReleaseExpired(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &Order{}
+	err = s.db.QueryRow(ctx, `
+	select id, customer_id, coalesce(manager_id,0), coalesce(sale_id,0), status, total, currency, expires, created, updated
+	from orders
+	where id = $1 and ($2::bigint = 0 or customer_id = $2)`, id, customerID).
+		Scan(&item.ID, &item.CustomerID, &item.ManagerID, &item.SaleID, &item.Status,
+			&item.Total.Amount, &item.Total.Currency, &item.Expires, &item.Created, &item.Updated)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	item.Items, err = orderItems(ctx, s.db, id)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+func orderItems(ctx context.Context, db querier, orderID int64) ([]*OrderItem, error) {
+	items := make([]*OrderItem, 0)
+
+	rows, err := db.Query(ctx, `
+	select oi.id, oi.product_id, p.name, oi.price, oi.currency, oi.qty
+	from orders_items oi
+	join products p on p.id = oi.product_id
+	where oi.order_id = $1
+	order by oi.id`, orderID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &OrderItem{}
+		err = rows.Scan(&item.ID, &item.ProductID, &item.Name, &item.Price.Amount, &item.Price.Currency, &item.Qty)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
+
+//ChangeStatus moves order along the workflow. Confirmed orders become sales of the manager,
+//cancelled new orders return reserved stock. customerID limits access to own orders, 0 means any.
+func (s *Service) ChangeStatus(ctx context.Context, id int64, customerID int64, managerID int64, status string) (*Order, error) {
+	err := s.ReleaseExpired(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	current := ""
+	orderCustomerID := int64(0)
+	err = tx.QueryRow(ctx, `
+	select status, customer_id from orders where id = $1 and ($2::bigint = 0 or customer_id = $2) for update`, id, customerID).
+		Scan(&current, &orderCustomerID)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	allowed := false
+	for _, next := range transitions[current] {
+		if next == status {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return nil, ErrInvalidTransition
+	}
+
+	items, err := orderItems(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case Cancelled:
+		for _, item := range items {
+			_, err = tx.Exec(ctx, `update products set qty = qty + $1 where id = $2`, item.Qty, item.ProductID)
+			if err != nil {
+				log.Print(err)
+				return nil, ErrInternal
+			}
+		}
+	case Confirmed:
+		// stock is returned from the reservation and written off again by the sale
+		sale := &managers.Sale{ManagerID: managerID, CustomerID: orderCustomerID}
+		for _, item := range items {
+			_, err = tx.Exec(ctx, `update products set qty = qty + $1 where id = $2`, item.Qty, item.ProductID)
+			if err != nil {
+				log.Print(err)
+				return nil, ErrInternal
+			}
+			sale.Positions = append(sale.Positions, &managers.SalePosition{ProductID: item.ProductID, Price: item.Price, Qty: item.Qty})
+		}
+		sale, err = s.managersSvc.MakeSaleTx(ctx, tx, sale)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(ctx, `update orders set sale_id = $1, total = $2 where id = $3`, sale.ID, sale.Total.Amount, id)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+	update orders set status = $1, manager_id = coalesce(nullif($2::bigint,0), manager_id), expires = null, updated = current_timestamp
+	where id = $3`, status, managerID, id)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	return s.ByID(ctx, id, customerID)
+}
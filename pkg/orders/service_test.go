@@ -0,0 +1,214 @@
+package orders_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/manucher051299/crud/pkg/customers"
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/giftcards"
+	"github.com/manucher051299/crud/pkg/loyalty"
+	"github.com/manucher051299/crud/pkg/managers"
+	"github.com/manucher051299/crud/pkg/notifications"
+	"github.com/manucher051299/crud/pkg/orders"
+	"github.com/manucher051299/crud/pkg/payments"
+	"github.com/manucher051299/crud/pkg/phones"
+	"github.com/manucher051299/crud/pkg/wishlist"
+)
+
+func newService(t *testing.T) (*orders.Service, *pgxpool.Pool) {
+	t.Helper()
+	pool := dbtest.Pool(t)
+	config := phones.Config{DefaultRegion: "TJ"}
+	paymentsSvc := payments.NewService(pool, loyalty.NewService(pool, loyalty.Config{}), giftcards.NewService(pool))
+	wishlistSvc := wishlist.NewService(pool, notifications.NewOutbox(pool, notifications.NewMemory()))
+	managersSvc := managers.NewService(pool, paymentsSvc, customers.NewService(pool, customers.LogSender{}, config), wishlistSvc, config)
+	return orders.NewService(pool, managersSvc, orders.Config{ReservationTTL: time.Hour}), pool
+}
+
+func stock(t *testing.T, pool *pgxpool.Pool, productID int64) int {
+	t.Helper()
+	qty := 0
+	err := pool.QueryRow(context.Background(), `select qty from products where id = $1`, productID).Scan(&qty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return qty
+}
+
+// checkout puts qty of the product in the cart of the customer and checks it out
+func checkout(t *testing.T, svc *orders.Service, customerID int64, productID int64, qty int) *orders.Order {
+	t.Helper()
+	ctx := context.Background()
+	_, err := svc.AddToCart(ctx, customerID, productID, qty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, err := svc.Checkout(ctx, customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+func TestCheckoutReservesStock(t *testing.T) {
+	svc, pool := newService(t)
+	ctx := context.Background()
+	productID := dbtest.Product(t, pool, "Reserved kettle", 1000, 5)
+	customerID := dbtest.Customer(t, pool)
+
+	order := checkout(t, svc, customerID, productID, 3)
+	if order.Status != orders.New || order.Expires == nil || order.Total.Amount != 3000 {
+		t.Errorf("order = %+v, want a new order of 3000 with reservation", order)
+	}
+	if got := stock(t, pool, productID); got != 2 {
+		t.Errorf("stock = %d, want 2 left after reserving 3", got)
+	}
+	cart, err := svc.Cart(ctx, customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cart.Items) != 0 {
+		t.Errorf("cart has %d items after checkout, want none", len(cart.Items))
+	}
+
+	_, err = svc.Checkout(ctx, customerID)
+	if err != orders.ErrEmptyCart {
+		t.Errorf("empty cart: err = %v, want %v", err, orders.ErrEmptyCart)
+	}
+
+	// reserved stock is not available to others
+	other := dbtest.Customer(t, pool)
+	_, err = svc.AddToCart(ctx, other, productID, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = svc.Checkout(ctx, other)
+	if err != orders.ErrProductUnavailable {
+		t.Errorf("more than left in stock: err = %v, want %v", err, orders.ErrProductUnavailable)
+	}
+	if got := stock(t, pool, productID); got != 2 {
+		t.Errorf("stock = %d after a failed checkout, want 2", got)
+	}
+}
+
+func TestReleaseExpired(t *testing.T) {
+	svc, pool := newService(t)
+	ctx := context.Background()
+	productID := dbtest.Product(t, pool, "Expiring kettle", 1000, 5)
+	customerID := dbtest.Customer(t, pool)
+
+	order := checkout(t, svc, customerID, productID, 3)
+	_, err := pool.Exec(ctx, `update orders set expires = current_timestamp - interval '1 minute' where id = $1`, order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// released once, however often it runs
+	for i := 0; i < 2; i++ {
+		err = svc.ReleaseExpired(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := stock(t, pool, productID); got != 5 {
+		t.Errorf("stock = %d, want 5 after the reservation expired", got)
+	}
+	order, err = svc.ByID(ctx, order.ID, customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != orders.Cancelled || order.Expires != nil {
+		t.Errorf("expired order status = %s, expires = %v, want cancelled without reservation", order.Status, order.Expires)
+	}
+}
+
+func TestCancelReturnsStock(t *testing.T) {
+	svc, pool := newService(t)
+	ctx := context.Background()
+	productID := dbtest.Product(t, pool, "Cancelled kettle", 1000, 5)
+	customerID := dbtest.Customer(t, pool)
+
+	order := checkout(t, svc, customerID, productID, 2)
+
+	_, err := svc.ChangeStatus(ctx, order.ID, dbtest.Customer(t, pool), 0, orders.Cancelled)
+	if err != orders.ErrNotFound {
+		t.Errorf("order of another customer: err = %v, want %v", err, orders.ErrNotFound)
+	}
+
+	order, err = svc.ChangeStatus(ctx, order.ID, customerID, 0, orders.Cancelled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != orders.Cancelled {
+		t.Errorf("status = %s, want %s", order.Status, orders.Cancelled)
+	}
+	if got := stock(t, pool, productID); got != 5 {
+		t.Errorf("stock = %d, want 5 after cancelling", got)
+	}
+
+	_, err = svc.ChangeStatus(ctx, order.ID, 0, dbtest.Manager(t, pool, false), orders.Confirmed)
+	if err != orders.ErrInvalidTransition {
+		t.Errorf("confirm cancelled order: err = %v, want %v", err, orders.ErrInvalidTransition)
+	}
+	if got := stock(t, pool, productID); got != 5 {
+		t.Errorf("stock = %d after a refused transition, want 5", got)
+	}
+}
+
+func TestConfirmMakesSale(t *testing.T) {
+	svc, pool := newService(t)
+	ctx := context.Background()
+	productID := dbtest.Product(t, pool, "Confirmed kettle", 1000, 5)
+	customerID := dbtest.Customer(t, pool)
+	managerID := dbtest.Manager(t, pool, false)
+
+	order := checkout(t, svc, customerID, productID, 3)
+
+	_, err := svc.ChangeStatus(ctx, order.ID, 0, managerID, orders.Ready)
+	if err != orders.ErrInvalidTransition {
+		t.Errorf("new to ready: err = %v, want %v", err, orders.ErrInvalidTransition)
+	}
+
+	order, err = svc.ChangeStatus(ctx, order.ID, 0, managerID, orders.Confirmed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != orders.Confirmed || order.SaleID == 0 || order.ManagerID != managerID || order.Expires != nil {
+		t.Errorf("confirmed order = %+v, want a sale of manager %d", order, managerID)
+	}
+	// the reservation turns into the sale, stock is written off once
+	if got := stock(t, pool, productID); got != 2 {
+		t.Errorf("stock = %d, want 2 after the sale", got)
+	}
+
+	saleManagerID, saleCustomerID, qty := int64(0), int64(0), 0
+	err = pool.QueryRow(ctx, `
+	select s.manager_id, s.customer_id, sp.qty from sales s join sales_positions sp on sp.sale_id = s.id
+	where s.id = $1 and sp.product_id = $2`, order.SaleID, productID).Scan(&saleManagerID, &saleCustomerID, &qty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saleManagerID != managerID || saleCustomerID != customerID || qty != 3 {
+		t.Errorf("sale of manager %d, customer %d, qty %d, want %d, %d, 3", saleManagerID, saleCustomerID, qty, managerID, customerID)
+	}
+
+	for _, status := range []string{orders.Ready, orders.Completed} {
+		order, err = svc.ChangeStatus(ctx, order.ID, 0, managerID, status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.Status != status {
+			t.Errorf("status = %s, want %s", order.Status, status)
+		}
+	}
+	_, err = svc.ChangeStatus(ctx, order.ID, 0, managerID, orders.Cancelled)
+	if err != orders.ErrInvalidTransition {
+		t.Errorf("cancel completed order: err = %v, want %v", err, orders.ErrInvalidTransition)
+	}
+	if got := stock(t, pool, productID); got != 2 {
+		t.Errorf("stock = %d, want 2 after completing", got)
+	}
+}
@@ -0,0 +1,387 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/manucher051299/crud/pkg/giftcards"
+	"github.com/manucher051299/crud/pkg/loyalty"
+	"github.com/manucher051299/crud/pkg/money"
+	"github.com/manucher051299/crud/pkg/receipts"
+	"github.com/manucher051299/crud/pkg/shifts"
+)
+
+var (
+	//ErrNotFound ...
+	ErrNotFound = errors.New("item not found")
+	//ErrInternal ...
+	ErrInternal = errors.New("internal error")
+	//ErrInvalidMethod ...
+	ErrInvalidMethod = errors.New("invalid payment method")
+	//ErrInvalidAmount ...
+	ErrInvalidAmount = errors.New("invalid payment amount")
+	//ErrOverpayment - only cash can be handed over more than due
+	ErrOverpayment = errors.New("payment exceeds amount due")
+	//ErrAlreadyPaid ...
+	ErrAlreadyPaid = errors.New("sale already paid")
+)
+
+//payment methods
+const (
+	Cash        = "cash"
+	Card        = "card"
+	Transfer    = "transfer"
+	StoreCredit = "store_credit"
+	Points      = "points"
+	GiftCard    = "gift_card"
+)
+
+//sale statuses
+const (
+	Unpaid        = "unpaid"
+	PartiallyPaid = "partially_paid"
+	Paid          = "paid"
+)
+
+var methods = map[string]bool{
+	Cash:        true,
+	Card:        true,
+	Transfer:    true,
+	StoreCredit: true,
+	Points:      true,
+	GiftCard:    true,
+}
+
+type Service struct {
+	db           *pgxpool.Pool
+	loyaltySvc   *loyalty.Service
+	giftCardsSvc *giftcards.Service
+}
+
+func NewService(db *pgxpool.Pool, loyaltySvc *loyalty.Service, giftCardsSvc *giftcards.Service) *Service {
+	return &Service{db: db, loyaltySvc: loyaltySvc, giftCardsSvc: giftCardsSvc}
+}
+
+//Payment - one tender of a sale. Amount is what was handed over,
+//for cash it may exceed the amount due, the difference is returned as Change.
+//Reference holds gift card code for gift card payments.
+type Payment struct {
+	ID        int64       `json:"id"`
+	SaleID    int64       `json:"sale_id"`
+	ManagerID int64       `json:"manager_id"`
+	Method    string      `json:"method"`
+	Amount    money.Money `json:"amount"`
+	Change    money.Money `json:"change"`
+	Reference string      `json:"reference"`
+	Created   time.Time   `json:"created"`
+}
+
+//Summary - payment state of a sale
+type Summary struct {
+	SaleID   int64       `json:"sale_id"`
+	Status   string      `json:"status"`
+	Total    money.Money `json:"total"`
+	Paid     money.Money `json:"paid"`
+	Due      money.Money `json:"due"`
+	Change   money.Money `json:"change"`
+	Payments []*Payment  `json:"payments"`
+}
+
+//CashReportRow - drawer reconciliation of one manager for a day
+type CashReportRow struct {
+	ManagerID   int64       `json:"manager_id"`
+	Currency    string      `json:"currency"`
+	Sales       int         `json:"sales"`
+	CashIn      money.Money `json:"cash_in"`
+	ChangeOut   money.Money `json:"change_out"`
+	Cash        money.Money `json:"cash"`
+	Card        money.Money `json:"card"`
+	Transfer    money.Money `json:"transfer"`
+	StoreCredit money.Money `json:"store_credit"`
+	Points      money.Money `json:"points"`
+	GiftCard    money.Money `json:"gift_card"`
+}
+
+//ValidMethod ...
+func ValidMethod(method string) bool {
+	return methods[method]
+}
+
+//Pay records payments of a sale
+func (s *Service) Pay(ctx context.Context, saleID int64, managerID int64, items []*Payment) (*Summary, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	summary, err := s.Record(ctx, tx, saleID, managerID, items)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return summary, nil
+}
+
+//Record stores payments inside tx and updates status of the sale.
+//It is used by MakeSale to take payments together with the sale.
+//Sale that becomes paid gets its receipt number and earns loyalty points for its customer.
+func (s *Service) Record(ctx context.Context, tx pgx.Tx, saleID int64, managerID int64, items []*Payment) (*Summary, error) {
+	summary := &Summary{SaleID: saleID}
+
+	previous := ""
+	err := tx.QueryRow(ctx, `select total, currency, status from sales where id = $1 for update`, saleID).
+		Scan(&summary.Total.Amount, &summary.Total.Currency, &previous)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	paid := int64(0)
+	err = tx.QueryRow(ctx, `select coalesce(sum(amount - change),0)::bigint from payments where sale_id = $1`, saleID).Scan(&paid)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	due := summary.Total.Amount - paid
+	if due <= 0 && len(items) > 0 {
+		return nil, ErrAlreadyPaid
+	}
+
+	// payments belong to the open shift of the manager taking them, if there is one
+	shiftID, err := shifts.Lock(ctx, tx, managerID)
+	if err != nil {
+		return nil, ErrInternal
+	}
+
+	for _, item := range items {
+		if !ValidMethod(item.Method) {
+			return nil, ErrInvalidMethod
+		}
+		if item.Amount.Currency == "" {
+			item.Amount.Currency = summary.Total.Currency
+		}
+		if item.Amount.Currency != summary.Total.Currency {
+			return nil, money.ErrCurrencyMismatch
+		}
+		if item.Amount.Amount <= 0 {
+			return nil, ErrInvalidAmount
+		}
+
+		item.Change = money.New(0, summary.Total.Currency)
+		if item.Amount.Amount > due {
+			if item.Method != Cash {
+				return nil, ErrOverpayment
+			}
+			item.Change.Amount = item.Amount.Amount - due
+		}
+		due -= item.Amount.Amount - item.Change.Amount
+
+		switch item.Method {
+		case Points:
+			_, err = s.loyaltySvc.Spend(ctx, tx, saleID, item.Amount)
+		case GiftCard:
+			err = s.giftCardsSvc.RedeemGiftCard(ctx, tx, saleID, managerID, item.Reference, item.Amount)
+		case StoreCredit:
+			err = s.giftCardsSvc.RedeemStoreCredit(ctx, tx, saleID, managerID, item.Amount)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		item.SaleID = saleID
+		item.ManagerID = managerID
+		err = tx.QueryRow(ctx, `
+		insert into payments(sale_id, manager_id, method, amount, change, currency, reference, shift_id)
+		values ($1,$2,$3,$4,$5,$6,$7,nullif($8::bigint, 0)) returning id, created`,
+			item.SaleID, item.ManagerID, item.Method, item.Amount.Amount, item.Change.Amount, item.Amount.Currency, item.Reference, shiftID).
+			Scan(&item.ID, &item.Created)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		summary.Change.Amount += item.Change.Amount
+	}
+
+	status := Paid
+	if due > 0 {
+		status = PartiallyPaid
+		if due == summary.Total.Amount {
+			status = Unpaid
+		}
+	}
+	// the first payment in full fixes the month commission of the sale is earned in
+	_, err = tx.Exec(ctx, `update sales set status = $1, paid = case when $1 = 'paid' then coalesce(paid, current_timestamp) else paid end
+	where id = $2`, status, saleID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	if status == Paid && previous != Paid {
+		err = receipts.Issue(ctx, tx, saleID)
+		if err != nil {
+			return nil, ErrInternal
+		}
+		err = s.loyaltySvc.Earn(ctx, tx, saleID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	payments, err := paymentsOf(ctx, tx, saleID)
+	if err != nil {
+		return nil, err
+	}
+
+	summary.Status = status
+	summary.Paid = money.New(summary.Total.Amount-due, summary.Total.Currency)
+	summary.Due = money.New(due, summary.Total.Currency)
+	summary.Change.Currency = summary.Total.Currency
+	summary.Payments = payments
+	return summary, nil
+}
+
+//Refund pays for a return inside tx with store credit and takes back loyalty points earned for the returned goods
+func (s *Service) Refund(ctx context.Context, tx pgx.Tx, credit *giftcards.StoreCredit) error {
+	if credit.Amount.Amount > 0 {
+		_, err := s.giftCardsSvc.IssueStoreCredit(ctx, tx, credit)
+		if err != nil {
+			return err
+		}
+	}
+	return s.loyaltySvc.Reverse(ctx, tx, credit.SaleID)
+}
+
+//BySale returns payment state of a sale
+func (s *Service) BySale(ctx context.Context, saleID int64) (*Summary, error) {
+	summary := &Summary{SaleID: saleID}
+
+	err := s.db.QueryRow(ctx, `
+	select s.status, s.total, s.currency, coalesce(sum(p.amount - p.change),0)::bigint
+	from sales s
+	left join payments p on p.sale_id = s.id
+	where s.id = $1
+	group by s.id`, saleID).Scan(&summary.Status, &summary.Total.Amount, &summary.Total.Currency, &summary.Paid.Amount)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	currency := summary.Total.Currency
+	summary.Paid.Currency = currency
+	summary.Due = money.New(summary.Total.Amount-summary.Paid.Amount, currency)
+	summary.Change = money.New(0, currency)
+
+	summary.Payments, err = paymentsOf(ctx, s.db, saleID)
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+func paymentsOf(ctx context.Context, db querier, saleID int64) ([]*Payment, error) {
+	items := make([]*Payment, 0)
+
+	rows, err := db.Query(ctx, `
+	select id, sale_id, manager_id, method, amount, change, currency, reference, created
+	from payments where sale_id = $1 order by id`, saleID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Payment{}
+		err = rows.Scan(&item.ID, &item.SaleID, &item.ManagerID, &item.Method,
+			&item.Amount.Amount, &item.Change.Amount, &item.Amount.Currency, &item.Reference, &item.Created)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item.Change.Currency = item.Amount.Currency
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
+
+//CashReport - payments taken by managers on the day, managerID 0 means all managers
+func (s *Service) CashReport(ctx context.Context, day time.Time, managerID int64) ([]*CashReportRow, error) {
+	items := make([]*CashReportRow, 0)
+
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 1)
+
+	rows, err := s.db.Query(ctx, `
+	select manager_id, currency, count(distinct sale_id),
+		coalesce(sum(amount) filter (where method = 'cash'),0)::bigint,
+		coalesce(sum(change) filter (where method = 'cash'),0)::bigint,
+		coalesce(sum(amount) filter (where method = 'card'),0)::bigint,
+		coalesce(sum(amount) filter (where method = 'transfer'),0)::bigint,
+		coalesce(sum(amount) filter (where method = 'store_credit'),0)::bigint,
+		coalesce(sum(amount) filter (where method = 'points'),0)::bigint,
+		coalesce(sum(amount) filter (where method = 'gift_card'),0)::bigint
+	from payments
+	where created >= $1 and created < $2 and ($3::bigint = 0 or manager_id = $3)
+	group by manager_id, currency
+	order by manager_id, currency`, from, to, managerID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &CashReportRow{}
+		err = rows.Scan(&item.ManagerID, &item.Currency, &item.Sales, &item.CashIn.Amount, &item.ChangeOut.Amount,
+			&item.Card.Amount, &item.Transfer.Amount, &item.StoreCredit.Amount, &item.Points.Amount, &item.GiftCard.Amount)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item.Cash = money.New(item.CashIn.Amount-item.ChangeOut.Amount, item.Currency)
+		item.CashIn.Currency = item.Currency
+		item.ChangeOut.Currency = item.Currency
+		item.Card.Currency = item.Currency
+		item.Transfer.Currency = item.Currency
+		item.StoreCredit.Currency = item.Currency
+		item.Points.Currency = item.Currency
+		item.GiftCard.Currency = item.Currency
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
@@ -0,0 +1,144 @@
+package payments_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/giftcards"
+	"github.com/manucher051299/crud/pkg/loyalty"
+	"github.com/manucher051299/crud/pkg/money"
+	"github.com/manucher051299/crud/pkg/payments"
+)
+
+func TestPayStatusTransitions(t *testing.T) {
+	pool := dbtest.Pool(t)
+	ctx := context.Background()
+	svc := payments.NewService(pool, loyalty.NewService(pool, loyalty.Config{PointsPerUnit: 1, PointValue: 10}),
+		giftcards.NewService(pool))
+
+	managerID := dbtest.Manager(t, pool, false)
+	customerID := dbtest.Customer(t, pool)
+	productID := dbtest.Product(t, pool, "tea", 1000, 10)
+	saleID := dbtest.Sale(t, pool, managerID, customerID, payments.Unpaid, time.Now(),
+		dbtest.Position{ProductID: productID, Price: 1000, Qty: 1})
+	tender := func(method string, amount int64) []*payments.Payment {
+		return []*payments.Payment{{Method: method, Amount: money.New(amount, money.DefaultCurrency)}}
+	}
+
+	summary, err := svc.Pay(ctx, saleID, managerID, nil)
+	if err != nil || summary.Status != payments.Unpaid || summary.Due.Amount != 1000 {
+		t.Fatalf("no payments: %+v, %v, want unpaid with 1000 due", summary, err)
+	}
+
+	summary, err = svc.Pay(ctx, saleID, managerID, tender(payments.Card, 400))
+	if err != nil || summary.Status != payments.PartiallyPaid || summary.Due.Amount != 600 || summary.Paid.Amount != 400 {
+		t.Fatalf("card 400: %+v, %v, want partially paid with 600 due", summary, err)
+	}
+
+	_, err = svc.Pay(ctx, saleID, managerID, tender(payments.Card, 700))
+	if err != payments.ErrOverpayment {
+		t.Errorf("card over due: err = %v, want %v", err, payments.ErrOverpayment)
+	}
+	_, err = svc.Pay(ctx, saleID, managerID, tender("cheque", 100))
+	if err != payments.ErrInvalidMethod {
+		t.Errorf("unknown method: err = %v, want %v", err, payments.ErrInvalidMethod)
+	}
+	_, err = svc.Pay(ctx, saleID, managerID, tender(payments.Card, 0))
+	if err != payments.ErrInvalidAmount {
+		t.Errorf("zero amount: err = %v, want %v", err, payments.ErrInvalidAmount)
+	}
+	_, err = svc.Pay(ctx, saleID, managerID, []*payments.Payment{{Method: payments.Card, Amount: money.New(100, "USD")}})
+	if err != money.ErrCurrencyMismatch {
+		t.Errorf("other currency: err = %v, want %v", err, money.ErrCurrencyMismatch)
+	}
+
+	// rejected payments must not change anything
+	summary, err = svc.BySale(ctx, saleID)
+	if err != nil || summary.Status != payments.PartiallyPaid || summary.Due.Amount != 600 || len(summary.Payments) != 1 {
+		t.Fatalf("after rejected payments: %+v, %v", summary, err)
+	}
+
+	summary, err = svc.Pay(ctx, saleID, managerID, tender(payments.Cash, 1000))
+	if err != nil || summary.Status != payments.Paid || summary.Due.Amount != 0 || summary.Change.Amount != 400 {
+		t.Fatalf("cash 1000: %+v, %v, want paid with 400 change", summary, err)
+	}
+
+	_, err = svc.Pay(ctx, saleID, managerID, tender(payments.Cash, 100))
+	if err != payments.ErrAlreadyPaid {
+		t.Errorf("paid sale: err = %v, want %v", err, payments.ErrAlreadyPaid)
+	}
+
+	// the sale became paid once, so points are earned once
+	points := int64(0)
+	err = pool.QueryRow(ctx, `select count(*) from loyalty_ledger where sale_id = $1 and kind = 'earn'`, saleID).Scan(&points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if points != 1 {
+		t.Errorf("loyalty entries of the sale = %d, want 1", points)
+	}
+}
+
+func TestPaySplitTender(t *testing.T) {
+	pool := dbtest.Pool(t)
+	ctx := context.Background()
+	svc := payments.NewService(pool, loyalty.NewService(pool, loyalty.Config{}), giftcards.NewService(pool))
+
+	managerID := dbtest.Manager(t, pool, false)
+	productID := dbtest.Product(t, pool, "cup", 2500, 10)
+	saleID := dbtest.Sale(t, pool, managerID, dbtest.Customer(t, pool), payments.Unpaid, time.Now(),
+		dbtest.Position{ProductID: productID, Price: 2500, Qty: 1})
+
+	summary, err := svc.Pay(ctx, saleID, managerID, []*payments.Payment{
+		{Method: payments.Card, Amount: money.New(1000, money.DefaultCurrency)},
+		{Method: payments.Cash, Amount: money.New(2000, money.DefaultCurrency)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != payments.Paid || summary.Change.Amount != 500 || len(summary.Payments) != 2 {
+		t.Errorf("split tender: %+v, want paid with 500 change", summary)
+	}
+	if summary.Payments[0].Change.Amount != 0 || summary.Payments[1].Change.Amount != 500 {
+		t.Errorf("change must be given from cash only: %+v %+v", summary.Payments[0], summary.Payments[1])
+	}
+}
+
+func TestPaySetsPaidTime(t *testing.T) {
+	pool := dbtest.Pool(t)
+	ctx := context.Background()
+	svc := payments.NewService(pool, loyalty.NewService(pool, loyalty.Config{}), giftcards.NewService(pool))
+
+	managerID := dbtest.Manager(t, pool, false)
+	productID := dbtest.Product(t, pool, "kettle", 3000, 10)
+	// sold in the previous month and paid in this one
+	created := time.Now().AddDate(0, -1, 0)
+	saleID := dbtest.Sale(t, pool, managerID, dbtest.Customer(t, pool), payments.Unpaid, created,
+		dbtest.Position{ProductID: productID, Price: 3000, Qty: 1})
+	paidAt := func() *time.Time {
+		var value *time.Time
+		err := pool.QueryRow(ctx, `select paid from sales where id = $1`, saleID).Scan(&value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+
+	_, err := svc.Pay(ctx, saleID, managerID, []*payments.Payment{{Method: payments.Card, Amount: money.New(1000, money.DefaultCurrency)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := paidAt(); value != nil {
+		t.Errorf("partially paid sale has paid time %v", value)
+	}
+
+	_, err = svc.Pay(ctx, saleID, managerID, []*payments.Payment{{Method: payments.Cash, Amount: money.New(2000, money.DefaultCurrency)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := paidAt(); value == nil || value.Sub(created) < 24*time.Hour {
+		t.Errorf("paid time = %v, want the time of the last payment, not of the sale %v", value, created)
+	}
+}
@@ -0,0 +1,554 @@
+package payroll
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/manucher051299/crud/pkg/managers"
+	"github.com/manucher051299/crud/pkg/money"
+	"github.com/manucher051299/crud/pkg/taxes"
+)
+
+var (
+	//ErrNotFound ...
+	ErrNotFound = errors.New("item not found")
+	//ErrInternal ...
+	ErrInternal = errors.New("internal error")
+	//ErrInvalidRule ...
+	ErrInvalidRule = errors.New("invalid commission rule")
+	//ErrApproved - approved payroll can't be changed
+	ErrApproved = errors.New("payroll already approved")
+)
+
+//commission rule kinds
+const (
+	//Flat - rate applied to all sales
+	Flat = "flat"
+	//Tiered - rate applied to all sales when plan completion reaches MinPercent, the highest reached tier wins
+	Tiered = "tiered"
+	//Category - rate for sales of one category, it overrides flat and tiered rates
+	Category = "category"
+)
+
+//payroll statuses
+const (
+	Draft    = "draft"
+	Approved = "approved"
+)
+
+//Rule - commission rule, rules with manager apply to that manager and override common ones
+type Rule struct {
+	ID         int64     `json:"id"`
+	Kind       string    `json:"kind"`
+	ManagerID  int64     `json:"manager_id"`
+	CategoryID int64     `json:"category_id"`
+	Rate       int       `json:"rate"`
+	MinPercent float64   `json:"min_percent"`
+	Created    time.Time `json:"created"`
+}
+
+//Run - payroll of one month
+type Run struct {
+	ID         int64       `json:"id"`
+	Start      time.Time   `json:"start"`
+	End        time.Time   `json:"end"`
+	Status     string      `json:"status"`
+	CreatedBy  int64       `json:"created_by"`
+	ApprovedBy int64       `json:"approved_by"`
+	Approved   *time.Time  `json:"approved"`
+	Created    time.Time   `json:"created"`
+	Total      money.Money `json:"total"`
+	Lines      []*Line     `json:"lines,omitempty"`
+}
+
+//Line - pay of one manager, commission is earned on sales paid within the month net of returns made within it
+type Line struct {
+	ManagerID  int64       `json:"manager_id"`
+	Name       string      `json:"name"`
+	Salary     money.Money `json:"salary"`
+	Sales      money.Money `json:"sales"`
+	Returns    money.Money `json:"returns"`
+	Percent    float64     `json:"percent"`
+	Commission money.Money `json:"commission"`
+	Total      money.Money `json:"total"`
+	Details    []*Detail   `json:"details"`
+}
+
+//Detail - commission of one category
+type Detail struct {
+	CategoryID int64 `json:"category_id"`
+	Sales      int64 `json:"sales"`
+	Returns    int64 `json:"returns"`
+	Rate       int   `json:"rate"`
+	Commission int64 `json:"commission"`
+}
+
+type Service struct {
+	db          *pgxpool.Pool
+	managersSvc *managers.Service
+}
+
+func NewService(db *pgxpool.Pool, managersSvc *managers.Service) *Service {
+	return &Service{db: db, managersSvc: managersSvc}
+}
+
+//Rules ...
+func (s *Service) Rules(ctx context.Context) ([]*Rule, error) {
+	items := make([]*Rule, 0)
+
+	rows, err := s.db.Query(ctx, `
+	select id, kind, coalesce(manager_id, 0), coalesce(category_id, 0), rate, min_percent, created
+	from commission_rules order by kind, manager_id nulls first, min_percent, id`)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Rule{}
+		err = rows.Scan(&item.ID, &item.Kind, &item.ManagerID, &item.CategoryID, &item.Rate, &item.MinPercent, &item.Created)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+//SaveRule ...
+func (s *Service) SaveRule(ctx context.Context, rule *Rule) (*Rule, error) {
+	if !taxes.ValidRate(rule.Rate) || rule.MinPercent < 0 {
+		return nil, ErrInvalidRule
+	}
+	switch rule.Kind {
+	case Flat:
+		rule.CategoryID, rule.MinPercent = 0, 0
+	case Tiered:
+		rule.CategoryID = 0
+	case Category:
+		if rule.CategoryID == 0 {
+			return nil, ErrInvalidRule
+		}
+		rule.MinPercent = 0
+	default:
+		return nil, ErrInvalidRule
+	}
+
+	err := s.db.QueryRow(ctx, `
+	insert into commission_rules(kind, manager_id, category_id, rate, min_percent)
+	values ($1, nullif($2::bigint, 0), nullif($3::bigint, 0), $4, $5)
+	returning id, created`, rule.Kind, rule.ManagerID, rule.CategoryID, rule.Rate, rule.MinPercent).Scan(&rule.ID, &rule.Created)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return rule, nil
+}
+
+//RemoveRule ...
+func (s *Service) RemoveRule(ctx context.Context, id int64) error {
+	tag, err := s.db.Exec(ctx, `delete from commission_rules where id = $1`, id)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+//Calculate makes payroll draft of the month containing day, existing draft is recalculated.
+//Amounts are in the default currency, sales in other currencies don't earn commission.
+func (s *Service) Calculate(ctx context.Context, managerID int64, day time.Time) (*Run, error) {
+	start, end, err := managers.PeriodBounds(managers.Month, day)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := s.Rules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	run := &Run{Start: start, End: end, CreatedBy: managerID}
+	err = tx.QueryRow(ctx, `
+	insert into payroll_runs(start, created_by) values ($1, $2)
+	on conflict (start) do update set created_by = excluded.created_by, created = current_timestamp
+	where payroll_runs.status = $3
+	returning id, status, created`, start, managerID, Draft).Scan(&run.ID, &run.Status, &run.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrApproved
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	_, err = tx.Exec(ctx, `delete from payroll_lines where run_id = $1`, run.ID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	// managers deactivated within the month are paid for the days they worked,
+	// those deactivated earlier still get commission on their sales paid or returned in the month
+	staff := make([]*Line, 0)
+	rows, err := tx.Query(ctx, `
+	select m.id, m.name, m.salary, m.deactivated from managers m
+	where m.created < $2 and (m.active or m.deactivated >= $1)
+		or exists(select 1 from sales s where s.manager_id = m.id and s.paid >= $1 and s.paid < $2)
+		or exists(select 1 from returns r join sales s on s.id = r.sale_id
+			where s.manager_id = m.id and r.created >= $1 and r.created < $2)
+	order by m.id`, start, end)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	for rows.Next() {
+		line := &Line{Salary: money.New(0, money.DefaultCurrency)}
+		var deactivated *time.Time
+		err = rows.Scan(&line.ManagerID, &line.Name, &line.Salary.Amount, &deactivated)
+		if err != nil {
+			rows.Close()
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		line.Salary.Amount = salary(line.Salary.Amount, start, end, deactivated)
+		staff = append(staff, line)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	run.Total = money.New(0, money.DefaultCurrency)
+	run.Lines = make([]*Line, 0, len(staff))
+	for _, line := range staff {
+		err = s.line(ctx, tx, line, rules, start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		details, err := json.Marshal(line.Details)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		_, err = tx.Exec(ctx, `
+		insert into payroll_lines(run_id, manager_id, name, salary, sales, returns, percent, commission, total, currency, details)
+		values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)`, run.ID, line.ManagerID, line.Name, line.Salary.Amount, line.Sales.Amount,
+			line.Returns.Amount, line.Percent, line.Commission.Amount, line.Total.Amount, money.DefaultCurrency, details)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		run.Total.Amount += line.Total.Amount
+		run.Lines = append(run.Lines, line)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return run, nil
+}
+
+//line computes commission of one manager from paid sales
+func (s *Service) line(ctx context.Context, tx pgx.Tx, line *Line, rules []*Rule, start time.Time, end time.Time) error {
+	currency := money.DefaultCurrency
+	line.Sales = money.New(0, currency)
+	line.Returns = money.New(0, currency)
+	line.Commission = money.New(0, currency)
+	line.Details = make([]*Detail, 0)
+
+	// unpaid and partially paid sales earn nothing until they are paid, then they count in the month of payment
+	rows, err := tx.Query(ctx, `
+	select coalesce(p.category_id, 0), coalesce(sum(sp.total), 0)::bigint
+	from sales s
+	join sales_positions sp on sp.sale_id = s.id
+	join products p on p.id = sp.product_id
+	where s.manager_id = $1 and s.status = 'paid' and sp.currency = $2 and s.paid >= $3 and s.paid < $4
+	group by 1 order by 1`, line.ManagerID, currency, start, end)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	for rows.Next() {
+		detail := &Detail{}
+		err = rows.Scan(&detail.CategoryID, &detail.Sales)
+		if err != nil {
+			rows.Close()
+			log.Print(err)
+			return ErrInternal
+		}
+		line.Sales.Amount += detail.Sales
+		line.Details = append(line.Details, detail)
+	}
+	rows.Close()
+
+	// returns made within the month reverse commission of the seller at the rate of the returned goods
+	rows, err = tx.Query(ctx, `
+	select coalesce(p.category_id, 0), coalesce(sum(rp.total), 0)::bigint
+	from returns_positions rp
+	join returns r on r.id = rp.return_id
+	join sales s on s.id = r.sale_id
+	join products p on p.id = rp.product_id
+	where s.manager_id = $1 and r.currency = $2 and r.created >= $3 and r.created < $4
+	group by 1 order by 1`, line.ManagerID, currency, start, end)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	for rows.Next() {
+		categoryID, amount := int64(0), int64(0)
+		err = rows.Scan(&categoryID, &amount)
+		if err != nil {
+			rows.Close()
+			log.Print(err)
+			return ErrInternal
+		}
+		line.Returns.Amount += amount
+		line.Details = addReturns(line.Details, categoryID, amount)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	progress, err := s.managersSvc.Progress(ctx, line.ManagerID, managers.Month, start)
+	if err != nil {
+		return err
+	}
+	line.Percent = progress.Percent
+
+	applyRates(line, rules)
+	line.Total = money.New(line.Salary.Amount+line.Commission.Amount, currency)
+	return nil
+}
+
+//salary - monthly salary for the days of [start, end) the manager worked, the day of deactivation is worked
+func salary(amount int64, start time.Time, end time.Time, deactivated *time.Time) int64 {
+	if deactivated == nil {
+		return amount
+	}
+	// deactivated is a wall clock time like start and end
+	last := time.Date(deactivated.Year(), deactivated.Month(), deactivated.Day(), 0, 0, 0, 0, start.Location())
+	total := days(start, end)
+	worked := days(start, last) + 1
+	if worked >= total {
+		return amount
+	}
+	if worked <= 0 {
+		return 0
+	}
+	return (amount*int64(worked) + int64(total)/2) / int64(total)
+}
+
+//days between midnights, daylight saving changes make some days shorter or longer than 24 hours
+func days(from time.Time, to time.Time) int {
+	return int(math.Floor(to.Sub(from).Hours()/24 + 0.5))
+}
+
+//addReturns adds returns to the detail of the category, returned goods may have been sold in an earlier month
+func addReturns(details []*Detail, categoryID int64, amount int64) []*Detail {
+	for _, detail := range details {
+		if detail.CategoryID == categoryID {
+			detail.Returns += amount
+			return details
+		}
+	}
+	return append(details, &Detail{CategoryID: categoryID, Returns: amount})
+}
+
+//applyRates computes commission of every category, returns are reversed at the rate of their category
+func applyRates(line *Line, rules []*Rule) {
+	base := baseRate(rules, line.ManagerID, line.Percent)
+	line.Commission.Amount = 0
+	for _, detail := range line.Details {
+		detail.Rate = base
+		if rate, ok := categoryRate(rules, line.ManagerID, detail.CategoryID); ok {
+			detail.Rate = rate
+		}
+		detail.Commission = commission(detail.Sales, detail.Rate) - commission(detail.Returns, detail.Rate)
+		line.Commission.Amount += detail.Commission
+	}
+	if line.Commission.Amount < 0 {
+		line.Commission.Amount = 0
+	}
+}
+
+//baseRate - best reached tier, otherwise flat rate; rules of the manager override common ones
+func baseRate(rules []*Rule, managerID int64, percent float64) int {
+	for _, own := range []bool{true, false} {
+		tier, found := -1.0, false
+		rate := 0
+		for _, rule := range rules {
+			if rule.Kind != Tiered || (rule.ManagerID == managerID) != own || (rule.ManagerID != 0 && rule.ManagerID != managerID) {
+				continue
+			}
+			if percent >= rule.MinPercent && rule.MinPercent > tier {
+				tier, rate, found = rule.MinPercent, rule.Rate, true
+			}
+		}
+		if found {
+			return rate
+		}
+		for _, rule := range rules {
+			if rule.Kind == Flat && (rule.ManagerID == managerID) == own && (rule.ManagerID == 0 || rule.ManagerID == managerID) {
+				return rule.Rate
+			}
+		}
+	}
+	return 0
+}
+
+func categoryRate(rules []*Rule, managerID int64, categoryID int64) (int, bool) {
+	if categoryID == 0 {
+		return 0, false
+	}
+	for _, own := range []bool{true, false} {
+		for _, rule := range rules {
+			if rule.Kind == Category && rule.CategoryID == categoryID && (rule.ManagerID == managerID) == own &&
+				(rule.ManagerID == 0 || rule.ManagerID == managerID) {
+				return rule.Rate, true
+			}
+		}
+	}
+	return 0, false
+}
+
+//commission - rate in basis points of the amount rounded half up
+func commission(amount int64, rate int) int64 {
+	return (amount*int64(rate) + taxes.RateBase/2) / taxes.RateBase
+}
+
+//Runs lists payrolls without lines, newest first
+func (s *Service) Runs(ctx context.Context) ([]*Run, error) {
+	items := make([]*Run, 0)
+
+	rows, err := s.db.Query(ctx, `
+	select r.id, r.start, r.status, r.created_by, coalesce(r.approved_by, 0), r.approved, r.created,
+		coalesce((select sum(total) from payroll_lines where run_id = r.id), 0)::bigint
+	from payroll_runs r order by r.start desc limit 100`)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Run{Total: money.New(0, money.DefaultCurrency)}
+		err = rows.Scan(&item.ID, &item.Start, &item.Status, &item.CreatedBy, &item.ApprovedBy, &item.Approved, &item.Created, &item.Total.Amount)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item.End = item.Start.AddDate(0, 1, 0)
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+//Run returns payroll with lines as they were saved
+func (s *Service) Run(ctx context.Context, id int64) (*Run, error) {
+	item := &Run{Total: money.New(0, money.DefaultCurrency), Lines: make([]*Line, 0)}
+	err := s.db.QueryRow(ctx, `
+	select id, start, status, created_by, coalesce(approved_by, 0), approved, created from payroll_runs where id = $1`, id).
+		Scan(&item.ID, &item.Start, &item.Status, &item.CreatedBy, &item.ApprovedBy, &item.Approved, &item.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	item.End = item.Start.AddDate(0, 1, 0)
+
+	rows, err := s.db.Query(ctx, `
+	select manager_id, name, salary, sales, returns, percent, commission, total, currency, details
+	from payroll_lines where run_id = $1 order by manager_id`, id)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		line := &Line{}
+		currency := ""
+		var details []byte
+		err = rows.Scan(&line.ManagerID, &line.Name, &line.Salary.Amount, &line.Sales.Amount, &line.Returns.Amount, &line.Percent,
+			&line.Commission.Amount, &line.Total.Amount, &currency, &details)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		line.Salary.Currency, line.Sales.Currency, line.Returns.Currency = currency, currency, currency
+		line.Commission.Currency, line.Total.Currency = currency, currency
+		err = json.Unmarshal(details, &line.Details)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item.Total.Amount += line.Total.Amount
+		item.Lines = append(item.Lines, line)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+//Approve freezes the payroll, it can't be recalculated afterwards
+func (s *Service) Approve(ctx context.Context, managerID int64, id int64) (*Run, error) {
+	tag, err := s.db.Exec(ctx, `
+	update payroll_runs set status = $3, approved_by = $2, approved = current_timestamp where id = $1 and status = $4`,
+		id, managerID, Approved, Draft)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		item, err := s.Run(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if item.Status == Approved {
+			return nil, ErrApproved
+		}
+	}
+	return s.Run(ctx, id)
+}
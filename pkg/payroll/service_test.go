@@ -0,0 +1,89 @@
+package payroll
+
+import (
+	"testing"
+	"time"
+
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+func TestApplyRates(t *testing.T) {
+	rules := []*Rule{
+		{Kind: Flat, Rate: 200},
+		{Kind: Tiered, Rate: 500, MinPercent: 100},
+		{Kind: Category, CategoryID: 7, Rate: 1000},
+	}
+
+	tests := []struct {
+		name    string
+		percent float64
+		details []*Detail
+		want    []int64
+		total   int64
+	}{
+		{"flat", 50, []*Detail{{CategoryID: 1, Sales: 10000}}, []int64{200}, 200},
+		{"tier reached", 120, []*Detail{{CategoryID: 1, Sales: 10000}}, []int64{500}, 500},
+		{"category rate", 50, []*Detail{{CategoryID: 1, Sales: 10000}, {CategoryID: 7, Sales: 10000}}, []int64{200, 1000}, 1200},
+		{"returns at category rate", 50,
+			[]*Detail{{CategoryID: 1, Sales: 10000}, {CategoryID: 7, Sales: 10000, Returns: 4000}}, []int64{200, 600}, 800},
+		{"returns of an earlier month", 50,
+			[]*Detail{{CategoryID: 1, Sales: 10000}, {CategoryID: 7, Returns: 1000}}, []int64{200, -100}, 100},
+		{"never negative", 50, []*Detail{{CategoryID: 7, Returns: 5000}}, []int64{-500}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			line := &Line{ManagerID: 1, Percent: test.percent, Details: test.details,
+				Commission: money.New(0, money.DefaultCurrency)}
+			applyRates(line, rules)
+			for i, detail := range line.Details {
+				if detail.Commission != test.want[i] {
+					t.Errorf("category %d commission = %d, want %d", detail.CategoryID, detail.Commission, test.want[i])
+				}
+			}
+			if line.Commission.Amount != test.total {
+				t.Errorf("commission = %d, want %d", line.Commission.Amount, test.total)
+			}
+		})
+	}
+}
+
+func TestAddReturns(t *testing.T) {
+	details := []*Detail{{CategoryID: 1, Sales: 1000}}
+
+	details = addReturns(details, 1, 300)
+	details = addReturns(details, 2, 100)
+	details = addReturns(details, 1, 200)
+
+	if len(details) != 2 || details[0].Returns != 500 || details[1].CategoryID != 2 || details[1].Returns != 100 {
+		t.Errorf("details = %+v %+v, want returns added to their categories", details[0], details[len(details)-1])
+	}
+}
+
+func TestSalary(t *testing.T) {
+	start := time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	day := func(day int, hour int) *time.Time {
+		value := time.Date(2021, time.June, day, hour, 0, 0, 0, time.UTC)
+		return &value
+	}
+
+	tests := []struct {
+		name        string
+		deactivated *time.Time
+		want        int64
+	}{
+		{"active", nil, 3000},
+		{"deactivated on the 10th", day(10, 15), 1000},
+		{"deactivated on the first day", day(1, 9), 100},
+		{"deactivated on the last day", day(30, 18), 3000},
+		{"deactivated before the month", day(-3, 12), 0},
+		{"deactivated after the month", day(35, 12), 3000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := salary(3000, start, end, test.deactivated); got != test.want {
+				t.Errorf("salary = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
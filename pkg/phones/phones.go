@@ -0,0 +1,133 @@
+package phones
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	//ErrInvalid - number can't be parsed or has wrong length for its country
+	ErrInvalid = errors.New("invalid phone number")
+	//ErrUnknownRegion ...
+	ErrUnknownRegion = errors.New("unknown region")
+)
+
+//DefaultRegion is used when config has no region
+const DefaultRegion = "TJ"
+
+//Country - numbering plan of one region
+type Country struct {
+	Code string
+	//Trunk - prefix dialed before national numbers inside the country
+	Trunk string
+	//Min, Max - length of the national significant number
+	Min int
+	Max int
+}
+
+//Countries - known regions, numbers of other countries are only checked for E.164 length
+var Countries = map[string]Country{
+	"TJ": {Code: "992", Trunk: "8", Min: 9, Max: 9},
+	"UZ": {Code: "998", Trunk: "8", Min: 9, Max: 9},
+	"KG": {Code: "996", Trunk: "0", Min: 9, Max: 9},
+	"KZ": {Code: "7", Trunk: "8", Min: 10, Max: 10},
+	"RU": {Code: "7", Trunk: "8", Min: 10, Max: 10},
+	"AF": {Code: "93", Trunk: "0", Min: 9, Max: 9},
+	"TR": {Code: "90", Trunk: "0", Min: 10, Max: 10},
+	"AE": {Code: "971", Trunk: "0", Min: 8, Max: 9},
+	"CN": {Code: "86", Trunk: "0", Min: 10, Max: 11},
+	"DE": {Code: "49", Trunk: "0", Min: 6, Max: 13},
+	"GB": {Code: "44", Trunk: "0", Min: 9, Max: 10},
+	"US": {Code: "1", Trunk: "1", Min: 10, Max: 10},
+}
+
+//Config ...
+type Config struct {
+	//DefaultRegion - region of numbers written without country code
+	DefaultRegion string
+}
+
+//Normalize parses number written in any common way into E.164, e.g. "+992000000001"
+func (c Config) Normalize(raw string) (string, error) {
+	region := c.DefaultRegion
+	if region == "" {
+		region = DefaultRegion
+	}
+	return Normalize(raw, region)
+}
+
+//Normalize parses number into E.164, numbers without "+" or "00" are treated as national numbers of region
+func Normalize(raw string, region string) (string, error) {
+	digits := strings.Builder{}
+	international := false
+	for i, r := range strings.TrimSpace(raw) {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+			international = true
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			return "", ErrInvalid
+		}
+	}
+	number := digits.String()
+	if !international && strings.HasPrefix(number, "00") {
+		international = true
+		number = number[2:]
+	}
+	if number == "" {
+		return "", ErrInvalid
+	}
+
+	if international {
+		return validate(number)
+	}
+
+	country, ok := Countries[strings.ToUpper(region)]
+	if !ok {
+		return "", ErrUnknownRegion
+	}
+	// "8 900 00 0001" in Tajikistan and "900 00 0001" are the same number,
+	// so is "992 900 00 0001" typed without "+"
+	switch {
+	case len(number) >= country.Min && len(number) <= country.Max:
+	case strings.HasPrefix(number, country.Code) && fits(country, len(number)-len(country.Code)):
+		number = number[len(country.Code):]
+	case country.Trunk != "" && strings.HasPrefix(number, country.Trunk) && fits(country, len(number)-len(country.Trunk)):
+		number = number[len(country.Trunk):]
+	default:
+		return "", ErrInvalid
+	}
+	return "+" + country.Code + number, nil
+}
+
+//validate checks full international number against the plan of its country
+func validate(number string) (string, error) {
+	if len(number) < 8 || len(number) > 15 || number[0] == '0' {
+		return "", ErrInvalid
+	}
+	for _, country := range Countries {
+		if strings.HasPrefix(number, country.Code) && !fits(country, len(number)-len(country.Code)) {
+			// codes like "7" and "79" overlap only for unknown countries, longer match wins
+			if !longerMatch(number, country.Code) {
+				return "", ErrInvalid
+			}
+		}
+	}
+	return "+" + number, nil
+}
+
+func fits(country Country, length int) bool {
+	return length >= country.Min && length <= country.Max
+}
+
+//longerMatch reports whether another known code is a longer prefix of number
+func longerMatch(number string, code string) bool {
+	for _, country := range Countries {
+		if len(country.Code) > len(code) && strings.HasPrefix(number, country.Code) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package phones_test
+
+import (
+	"testing"
+
+	"github.com/manucher051299/crud/pkg/phones"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		region string
+		want   string
+		err    error
+	}{
+		{"spaced international", "+992 00 000 0001", "TJ", "+992000000001", nil},
+		{"compact international", "+992000000001", "TJ", "+992000000001", nil},
+		{"international in another region", "+992 00 000 0001", "RU", "+992000000001", nil},
+		{"national", "900-00-0001", "TJ", "+992900000001", nil},
+		{"trunk prefix", "8 900 00 0001", "TJ", "+992900000001", nil},
+		{"zero trunk prefix", "0 555 123 456", "KG", "+996555123456", nil},
+		{"trunk prefix with brackets", "8 (912) 345-67-89", "RU", "+79123456789", nil},
+		{"country code without plus", "992 900 00 0001", "TJ", "+992900000001", nil},
+		{"00 prefix", "00992 900 00 0001", "TJ", "+992900000001", nil},
+		{"00 prefix in another region", "00 7 912 345 67 89", "TJ", "+79123456789", nil},
+		{"region in lower case", "900000001", "tj", "+992900000001", nil},
+		{"unknown country code", "+353 85 123 4567", "TJ", "+353851234567", nil},
+		{"short for its country", "+992 90 000 001", "TJ", "", phones.ErrInvalid},
+		{"long for its country", "+992 900 00 00015", "TJ", "", phones.ErrInvalid},
+		{"short national", "90 000 001", "TJ", "", phones.ErrInvalid},
+		{"long national", "8 900 00 00015", "TJ", "", phones.ErrInvalid},
+		{"short with 00 prefix", "00 7 912 345 67", "TJ", "", phones.ErrInvalid},
+		{"short for E.164", "+3531234", "TJ", "", phones.ErrInvalid},
+		{"long for E.164", "+3531234567890123", "TJ", "", phones.ErrInvalid},
+		{"country code starting with zero", "+0992900000001", "TJ", "", phones.ErrInvalid},
+		{"plus in the middle", "992+900000001", "TJ", "", phones.ErrInvalid},
+		{"letters", "+992 90O 00 0001", "TJ", "", phones.ErrInvalid},
+		{"only 00", "00", "TJ", "", phones.ErrInvalid},
+		{"empty", " ", "TJ", "", phones.ErrInvalid},
+		{"unknown region", "900000001", "XX", "", phones.ErrUnknownRegion},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := phones.Normalize(test.raw, test.region)
+			if err != test.err || got != test.want {
+				t.Errorf("Normalize(%q, %q) = %q, %v, want %q, %v", test.raw, test.region, got, err, test.want, test.err)
+			}
+		})
+	}
+}
+
+func TestConfigNormalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		config phones.Config
+		want   string
+	}{
+		{"default region", phones.Config{}, "+992900000001"},
+		{"configured region", phones.Config{DefaultRegion: "UZ"}, "+998900000001"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.config.Normalize("8 900 00 0001")
+			if err != nil || got != test.want {
+				t.Errorf("Normalize = %q, %v, want %q", got, err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package receipts
+
+import (
+	"bytes"
+	"compress/zlib"
+	_ "embed"
+	"encoding/binary"
+	"errors"
+)
+
+//goMono - Go Mono font covering Latin, Cyrillic and Greek, see fonts/README for its license
+//
+//go:embed fonts/Go-Mono.ttf
+var goMono []byte
+
+//font - TrueType font embedded into PDF receipts, sizes are in 1/1000 of the font size
+type font struct {
+	Name    string
+	Data    []byte
+	Glyphs  map[rune]uint16
+	Advance int
+	Ascent  int
+	Descent int
+	BBox    [4]int
+}
+
+var errInvalidFont = errors.New("invalid font")
+
+//substitutes - Tajik letters missing from the font are printed as the closest Russian ones
+var substitutes = map[rune]rune{
+	'Ғ': 'Г', 'ғ': 'г', 'Ӣ': 'И', 'ӣ': 'и', 'Қ': 'К', 'қ': 'к',
+	'Ӯ': 'У', 'ӯ': 'у', 'Ҳ': 'Х', 'ҳ': 'х', 'Ҷ': 'Ч', 'ҷ': 'ч',
+}
+
+var receiptFont = mustFont("GoMono", goMono)
+
+func mustFont(name string, data []byte) *font {
+	item, err := parseFont(name, data)
+	if err != nil {
+		panic(err)
+	}
+	return item
+}
+
+//parseFont reads what PDF needs from TrueType tables: unicode cmap, metrics and bounding box
+func parseFont(name string, data []byte) (*font, error) {
+	tables := make(map[string][]byte)
+	if len(data) < 12 {
+		return nil, errInvalidFont
+	}
+	count := int(binary.BigEndian.Uint16(data[4:]))
+	for i := 0; i < count; i++ {
+		record := 12 + i*16
+		if record+16 > len(data) {
+			return nil, errInvalidFont
+		}
+		offset := int(binary.BigEndian.Uint32(data[record+8:]))
+		length := int(binary.BigEndian.Uint32(data[record+12:]))
+		if offset+length > len(data) {
+			return nil, errInvalidFont
+		}
+		tables[string(data[record:record+4])] = data[offset : offset+length]
+	}
+
+	head, hhea, hmtx := tables["head"], tables["hhea"], tables["hmtx"]
+	if len(head) < 54 || len(hhea) < 36 || len(hmtx) < 4 {
+		return nil, errInvalidFont
+	}
+	unitsPerEm := int(binary.BigEndian.Uint16(head[18:]))
+	if unitsPerEm == 0 {
+		return nil, errInvalidFont
+	}
+	scale := func(value int16) int {
+		return int(value) * 1000 / unitsPerEm
+	}
+
+	glyphs, err := parseCmap(tables["cmap"])
+	if err != nil {
+		return nil, err
+	}
+	// monospaced, so the advance of the space is the advance of every glyph,
+	// glyphs after the last of numberOfHMetrics share its advance
+	gid := int(glyphs[' '])
+	if metrics := int(binary.BigEndian.Uint16(hhea[34:])); gid >= metrics {
+		gid = metrics - 1
+	}
+	if gid < 0 || gid*4+2 > len(hmtx) {
+		return nil, errInvalidFont
+	}
+	advance := int16(binary.BigEndian.Uint16(hmtx[gid*4:]))
+
+	compressed := &bytes.Buffer{}
+	writer := zlib.NewWriter(compressed)
+	_, err = writer.Write(data)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &font{
+		Name:    name,
+		Data:    compressed.Bytes(),
+		Glyphs:  glyphs,
+		Advance: scale(advance),
+		Ascent:  scale(int16(binary.BigEndian.Uint16(hhea[4:]))),
+		Descent: scale(int16(binary.BigEndian.Uint16(hhea[6:]))),
+		BBox: [4]int{
+			scale(int16(binary.BigEndian.Uint16(head[36:]))), scale(int16(binary.BigEndian.Uint16(head[38:]))),
+			scale(int16(binary.BigEndian.Uint16(head[40:]))), scale(int16(binary.BigEndian.Uint16(head[42:]))),
+		},
+	}, nil
+}
+
+//parseCmap maps characters of the basic multilingual plane to glyphs using the format 4 unicode subtable
+func parseCmap(cmap []byte) (map[rune]uint16, error) {
+	if len(cmap) < 4 {
+		return nil, errInvalidFont
+	}
+	var table []byte
+	for i := 0; i < int(binary.BigEndian.Uint16(cmap[2:])); i++ {
+		record := 4 + i*8
+		if record+8 > len(cmap) {
+			return nil, errInvalidFont
+		}
+		platform, encoding := binary.BigEndian.Uint16(cmap[record:]), binary.BigEndian.Uint16(cmap[record+2:])
+		offset := int(binary.BigEndian.Uint32(cmap[record+4:]))
+		if platform == 3 && encoding == 1 || platform == 0 && encoding <= 3 {
+			if offset+14 <= len(cmap) && binary.BigEndian.Uint16(cmap[offset:]) == 4 {
+				table = cmap[offset:]
+				break
+			}
+		}
+	}
+	if table == nil {
+		return nil, errInvalidFont
+	}
+
+	segments := int(binary.BigEndian.Uint16(table[6:])) / 2
+	ends := 14
+	starts := ends + segments*2 + 2
+	deltas := starts + segments*2
+	ranges := deltas + segments*2
+	if ranges+segments*2 > len(table) {
+		return nil, errInvalidFont
+	}
+
+	glyphs := make(map[rune]uint16)
+	for i := 0; i < segments; i++ {
+		end := int(binary.BigEndian.Uint16(table[ends+i*2:]))
+		start := int(binary.BigEndian.Uint16(table[starts+i*2:]))
+		delta := binary.BigEndian.Uint16(table[deltas+i*2:])
+		rangeOffset := int(binary.BigEndian.Uint16(table[ranges+i*2:]))
+		for code := start; code <= end && code != 0xFFFF; code++ {
+			gid := uint16(code) + delta
+			if rangeOffset != 0 {
+				// offset is counted from the range offset entry itself
+				index := ranges + i*2 + rangeOffset + (code-start)*2
+				if index+2 > len(table) {
+					return nil, errInvalidFont
+				}
+				gid = binary.BigEndian.Uint16(table[index:])
+				if gid != 0 {
+					gid += delta
+				}
+			}
+			if gid != 0 {
+				glyphs[rune(code)] = gid
+			}
+		}
+	}
+	return glyphs, nil
+}
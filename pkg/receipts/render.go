@@ -0,0 +1,264 @@
+package receipts
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//Width - default line width of thermal printers with 80mm paper
+const Width = 42
+
+const timeLayout = "2006-01-02 15:04"
+
+//Text renders receipt for a fixed width printer
+func Text(receipt *Receipt, width int) string {
+	if width <= 0 {
+		width = Width
+	}
+	separator := strings.Repeat("-", width)
+
+	lines := make([]string, 0)
+	for _, value := range []string{receipt.Shop.Name, receipt.Shop.Address, receipt.Shop.Phone} {
+		if value != "" {
+			lines = append(lines, center(value, width))
+		}
+	}
+	if receipt.Shop.TaxID != "" {
+		lines = append(lines, center("TIN "+receipt.Shop.TaxID, width))
+	}
+	lines = append(lines, separator)
+	lines = append(lines, columns(Title(receipt), receipt.SaleDate.Format(timeLayout), width))
+	lines = append(lines, columns("Sale "+strconv.FormatInt(receipt.SaleID, 10), "Cashier "+receipt.Manager, width))
+	lines = append(lines, separator)
+
+	for _, line := range receipt.Lines {
+		lines = append(lines, cut(line.Name, width))
+		lines = append(lines, columns("  "+strconv.Itoa(line.Qty)+" x "+line.Price.String(), line.Total.String(), width))
+	}
+	lines = append(lines, separator)
+
+	lines = append(lines, columns("TOTAL", receipt.Total.String(), width))
+	for _, tax := range receipt.Taxes {
+		if tax.TaxRate == 0 {
+			continue
+		}
+		lines = append(lines, columns(TaxLabel(tax), tax.Tax.String(), width))
+	}
+
+	if len(receipt.Payments) > 0 {
+		lines = append(lines, separator)
+		for _, payment := range receipt.Payments {
+			lines = append(lines, columns(payment.Method, payment.Amount.String(), width))
+		}
+		if !receipt.Change.IsZero() {
+			lines = append(lines, columns("change", receipt.Change.String(), width))
+		}
+	}
+	lines = append(lines, separator)
+	lines = append(lines, center("Thank you!", width))
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+//Title - "Receipt #12" for paid sales, sales that are not paid yet get a bill without number
+func Title(receipt *Receipt) string {
+	if receipt.Number == 0 {
+		return "Bill"
+	}
+	return "Receipt #" + strconv.FormatInt(receipt.Number, 10)
+}
+
+//TaxLabel - tax inside prices is "incl. VAT", tax added on top of them is "VAT"
+func TaxLabel(tax *TaxLine) string {
+	if tax.TaxIncluded {
+		return "incl. VAT " + Rate(tax.TaxRate)
+	}
+	return "VAT " + Rate(tax.TaxRate)
+}
+
+//Rate formats basis points as percent, 1500 -> "15.00%"
+func Rate(rate int) string {
+	return fmt.Sprintf("%d.%02d%%", rate/100, rate%100)
+}
+
+func cut(value string, width int) string {
+	if utf8.RuneCountInString(value) <= width {
+		return value
+	}
+	return string([]rune(value)[:width])
+}
+
+func center(value string, width int) string {
+	value = cut(value, width)
+	pad := (width - utf8.RuneCountInString(value)) / 2
+	return strings.Repeat(" ", pad) + value
+}
+
+//columns puts left and right values on one line, left is cut when they don't fit
+func columns(left string, right string, width int) string {
+	space := width - utf8.RuneCountInString(right) - 1
+	if space < 0 {
+		return cut(right, width)
+	}
+	left = cut(left, space)
+	return left + strings.Repeat(" ", width-utf8.RuneCountInString(left)-utf8.RuneCountInString(right)) + right
+}
+
+var htmlTemplate = template.Must(template.New("receipt").Funcs(template.FuncMap{
+	"taxLabel": TaxLabel,
+	"title":    Title,
+	"date":     func(receipt *Receipt) string { return receipt.SaleDate.Format(timeLayout) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{title .}}</title>
+<style>
+body { font-family: monospace; max-width: 400px; margin: 0 auto; }
+table { width: 100%; border-collapse: collapse; }
+td.amount { text-align: right; }
+.shop { text-align: center; }
+</style>
+</head>
+<body>
+<div class="shop">
+<h3>{{.Shop.Name}}</h3>
+{{if .Shop.Address}}<div>{{.Shop.Address}}</div>{{end}}
+{{if .Shop.Phone}}<div>{{.Shop.Phone}}</div>{{end}}
+{{if .Shop.TaxID}}<div>TIN {{.Shop.TaxID}}</div>{{end}}
+</div>
+<hr>
+<div>{{title .}} &mdash; {{date .}}</div>
+<div>Sale {{.SaleID}}, cashier {{.Manager}}</div>
+<hr>
+<table>
+{{range .Lines}}<tr><td>{{.Name}}<br>{{.Qty}} x {{.Price}}</td><td class="amount">{{.Total}}</td></tr>
+{{end}}</table>
+<hr>
+<table>
+<tr><td><b>TOTAL</b></td><td class="amount"><b>{{.Total}}</b></td></tr>
+{{range .Taxes}}{{if .TaxRate}}<tr><td>{{taxLabel .}}</td><td class="amount">{{.Tax}}</td></tr>
+{{end}}{{end}}</table>
+{{if .Payments}}<hr>
+<table>
+{{range .Payments}}<tr><td>{{.Method}}</td><td class="amount">{{.Amount}}</td></tr>
+{{end}}{{if not $.Change.IsZero}}<tr><td>change</td><td class="amount">{{$.Change}}</td></tr>{{end}}
+</table>{{end}}
+<hr>
+<div class="shop">Thank you!</div>
+</body>
+</html>
+`))
+
+//HTML renders receipt as a printable page
+func HTML(w io.Writer, receipt *Receipt) error {
+	return htmlTemplate.Execute(w, receipt)
+}
+
+//PDF renders text receipt on a narrow page with the embedded Go Mono font,
+//so Cyrillic names print as they are. Tajik letters missing from the font are printed as the closest
+//Russian ones, other missing characters as "?".
+func PDF(receipt *Receipt) []byte {
+	const fontSize = 9.0
+	const leading = 11.0
+	const margin = 12.0
+
+	lines := strings.Split(strings.TrimRight(Text(receipt, Width), "\n"), "\n")
+	width := margin*2 + float64(Width)*fontSize*float64(receiptFont.Advance)/1000
+	height := margin*2 + float64(len(lines))*leading
+
+	used := make(map[uint16]rune)
+	content := &bytes.Buffer{}
+	fmt.Fprintf(content, "BT\n/F1 %.0f Tf\n%.1f TL\n%.1f %.1f Td\n", fontSize, leading, margin, height-margin-fontSize+leading)
+	for _, line := range lines {
+		fmt.Fprintf(content, "<%s> '\n", pdfGlyphs(receiptFont, line, used))
+	}
+	content.WriteString("ET\n")
+
+	toUnicode := pdfToUnicode(used)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.1f %.1f] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>", width, height),
+		fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [6 0 R] /ToUnicode 8 0 R >>",
+			receiptFont.Name),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> "+
+			"/FontDescriptor 7 0 R /DW %d /CIDToGIDMap /Identity >>", receiptFont.Name, receiptFont.Advance),
+		fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 5 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d "+
+			"/CapHeight %d /StemV 80 /FontFile2 9 0 R >>", receiptFont.Name, receiptFont.BBox[0], receiptFont.BBox[1], receiptFont.BBox[2],
+			receiptFont.BBox[3], receiptFont.Ascent, receiptFont.Descent, receiptFont.Ascent),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(toUnicode), toUnicode),
+		fmt.Sprintf("<< /Length %d /Length1 %d /Filter /FlateDecode >>\nstream\n%s\nendstream", len(receiptFont.Data), len(goMono),
+			receiptFont.Data),
+	}
+
+	out := &bytes.Buffer{}
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(out, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := out.Len()
+	fmt.Fprintf(out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return out.Bytes()
+}
+
+//pdfGlyphs encodes value as hex glyph ids of the font and records which characters they show
+func pdfGlyphs(item *font, value string, used map[uint16]rune) string {
+	buffer := &strings.Builder{}
+	for _, r := range value {
+		gid, ok := item.Glyphs[r]
+		if substitute, found := substitutes[r]; !ok && found {
+			r = substitute
+			gid, ok = item.Glyphs[r]
+		}
+		if !ok {
+			r = '?'
+			gid = item.Glyphs[r]
+		}
+		used[gid] = r
+		fmt.Fprintf(buffer, "%04X", gid)
+	}
+	return buffer.String()
+}
+
+//pdfToUnicode maps glyph ids back to characters, so text can be copied and searched
+func pdfToUnicode(used map[uint16]rune) string {
+	gids := make([]int, 0, len(used))
+	for gid := range used {
+		gids = append(gids, int(gid))
+	}
+	sort.Ints(gids)
+
+	buffer := &strings.Builder{}
+	buffer.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	// a block may hold at most 100 entries
+	for start := 0; start < len(gids); start += 100 {
+		end := start + 100
+		if end > len(gids) {
+			end = len(gids)
+		}
+		fmt.Fprintf(buffer, "%d beginbfchar\n", end-start)
+		for _, gid := range gids[start:end] {
+			fmt.Fprintf(buffer, "<%04X> <%04X>\n", gid, used[uint16(gid)])
+		}
+		buffer.WriteString("endbfchar\n")
+	}
+	buffer.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return buffer.String()
+}
@@ -0,0 +1,128 @@
+package receipts
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+func testReceipt() *Receipt {
+	return &Receipt{
+		Number:   1,
+		SaleID:   10,
+		Shop:     Shop{Name: "Shop"},
+		Manager:  "Cashier",
+		Total:    money.New(2300, money.DefaultCurrency),
+		SaleDate: time.Date(2021, 1, 2, 3, 4, 0, 0, time.UTC),
+		Taxes: []*TaxLine{
+			{TaxRate: 1500, TaxIncluded: true, Tax: money.New(130, money.DefaultCurrency)},
+			{TaxRate: 1500, TaxIncluded: false, Tax: money.New(150, money.DefaultCurrency)},
+			{TaxRate: 0, TaxIncluded: true, Tax: money.New(0, money.DefaultCurrency)},
+		},
+	}
+}
+
+func TestTaxLabel(t *testing.T) {
+	tests := []struct {
+		tax  *TaxLine
+		want string
+	}{
+		{&TaxLine{TaxRate: 1500, TaxIncluded: true}, "incl. VAT 15.00%"},
+		{&TaxLine{TaxRate: 1500, TaxIncluded: false}, "VAT 15.00%"},
+		{&TaxLine{TaxRate: 1825, TaxIncluded: false}, "VAT 18.25%"},
+	}
+	for _, test := range tests {
+		if got := TaxLabel(test.tax); got != test.want {
+			t.Errorf("TaxLabel(%+v) = %q, want %q", test.tax, got, test.want)
+		}
+	}
+}
+
+func TestTitle(t *testing.T) {
+	receipt := testReceipt()
+	if got := Title(receipt); got != "Receipt #1" {
+		t.Errorf("Title = %q, want %q", got, "Receipt #1")
+	}
+	receipt.Number = 0
+	if got := Title(receipt); got != "Bill" {
+		t.Errorf("Title of unpaid sale = %q, want %q", got, "Bill")
+	}
+	if !strings.Contains(Text(receipt, Width), "\nBill ") {
+		t.Errorf("unpaid sale must be printed as a bill:\n%s", Text(receipt, Width))
+	}
+}
+
+func TestTextTaxLines(t *testing.T) {
+	text := Text(testReceipt(), Width)
+
+	if strings.Count(text, "incl. VAT 15.00%") != 1 {
+		t.Errorf("included tax must be printed once as incl. VAT:\n%s", text)
+	}
+	if !strings.Contains(text, "\nVAT 15.00%") {
+		t.Errorf("tax added on top must be printed as VAT:\n%s", text)
+	}
+	if strings.Contains(text, "0.00%") {
+		t.Errorf("zero rate must be skipped:\n%s", text)
+	}
+}
+
+func TestHTMLTaxLines(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	err := HTML(buffer, testReceipt())
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := buffer.String()
+
+	if !strings.Contains(page, "<td>incl. VAT 15.00%</td>") || !strings.Contains(page, "<td>VAT 15.00%</td>") {
+		t.Errorf("tax labels must follow tax_included:\n%s", page)
+	}
+}
+
+func TestPDFCyrillic(t *testing.T) {
+	receipt := testReceipt()
+	receipt.Shop.Name = "Магазин Душанбе"
+	receipt.Lines = []*Line{{Name: "Қанди ҷойӣ", Qty: 1, Price: money.New(2300, money.DefaultCurrency),
+		Total: money.New(2300, money.DefaultCurrency)}}
+	document := string(PDF(receipt))
+
+	if receiptFont.Advance != 600 {
+		t.Errorf("advance = %d, want 600 for Go Mono", receiptFont.Advance)
+	}
+	// each character is shown with its own glyph and maps back to itself, missing Tajik letters to Russian ones
+	for _, r := range "МагзинДушбКдчой" {
+		gid, ok := receiptFont.Glyphs[r]
+		if !ok {
+			t.Fatalf("no glyph for %c", r)
+		}
+		if !strings.Contains(document, fmt.Sprintf("<%04X> <%04X>", gid, r)) {
+			t.Errorf("%c is not mapped back to unicode", r)
+		}
+	}
+	if strings.Contains(document, fmt.Sprintf("<%04X> <%04X>", receiptFont.Glyphs['?'], '?')) {
+		t.Errorf("some characters are printed as ?")
+	}
+
+	start := strings.Index(document, "/FlateDecode >>\nstream\n")
+	end := strings.LastIndex(document, "\nendstream")
+	if start < 0 || end < start {
+		t.Fatalf("no embedded font in:\n%.500s", document)
+	}
+	reader, err := zlib.NewReader(strings.NewReader(document[start+len("/FlateDecode >>\nstream\n") : end]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, goMono) {
+		t.Errorf("embedded font differs from Go Mono")
+	}
+}
@@ -0,0 +1,229 @@
+package receipts
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+var (
+	//ErrNotFound ...
+	ErrNotFound = errors.New("item not found")
+	//ErrInternal ...
+	ErrInternal = errors.New("internal error")
+)
+
+//Shop - details printed in the receipt header
+type Shop struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Phone   string `json:"phone"`
+	TaxID   string `json:"tax_id"`
+}
+
+type Service struct {
+	db   *pgxpool.Pool
+	shop Shop
+}
+
+func NewService(db *pgxpool.Pool, shop Shop) *Service {
+	return &Service{db: db, shop: shop}
+}
+
+//Receipt ...
+type Receipt struct {
+	Number     int64          `json:"number"`
+	SaleID     int64          `json:"sale_id"`
+	Shop       Shop           `json:"shop"`
+	Manager    string         `json:"manager"`
+	CustomerID int64          `json:"customer_id"`
+	Lines      []*Line        `json:"lines"`
+	Taxes      []*TaxLine     `json:"taxes"`
+	Tax        money.Money    `json:"tax"`
+	Total      money.Money    `json:"total"`
+	Payments   []*PaymentLine `json:"payments"`
+	Change     money.Money    `json:"change"`
+	Status     string         `json:"status"`
+	Issued     *time.Time     `json:"issued"`
+	SaleDate   time.Time      `json:"sale_date"`
+}
+
+//Line ...
+type Line struct {
+	Name    string      `json:"name"`
+	Qty     int         `json:"qty"`
+	Price   money.Money `json:"price"`
+	TaxRate int         `json:"tax_rate"`
+	Total   money.Money `json:"total"`
+}
+
+//TaxLine - tax amount collected with one rate, TaxIncluded tells whether it is inside prices or added on top
+type TaxLine struct {
+	TaxRate     int         `json:"tax_rate"`
+	TaxIncluded bool        `json:"tax_included"`
+	Tax         money.Money `json:"tax"`
+}
+
+//PaymentLine ...
+type PaymentLine struct {
+	Method string      `json:"method"`
+	Amount money.Money `json:"amount"`
+}
+
+//BySale builds receipt of the sale, unpaid sales have no number yet.
+//customerID limits access to own purchases, 0 means any sale.
+func (s *Service) BySale(ctx context.Context, saleID int64, customerID int64) (*Receipt, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	item := &Receipt{SaleID: saleID, Shop: s.shop}
+	err = tx.QueryRow(ctx, `
+	select s.customer_id, m.name, s.total, s.tax, s.currency, s.status, s.created
+	from sales s
+	join managers m on m.id = s.manager_id
+	where s.id = $1 and ($2::bigint = 0 or s.customer_id = $2)`, saleID, customerID).
+		Scan(&item.CustomerID, &item.Manager, &item.Total.Amount, &item.Tax.Amount, &item.Total.Currency, &item.Status, &item.SaleDate)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	currency := item.Total.Currency
+	item.Tax.Currency = currency
+
+	item.Number, item.Issued, err = number(ctx, tx, saleID)
+	if err != nil {
+		return nil, err
+	}
+
+	item.Lines, err = lines(ctx, tx, saleID)
+	if err != nil {
+		return nil, err
+	}
+
+	item.Taxes = make([]*TaxLine, 0)
+	rows, err := tx.Query(ctx, `
+	select tax_rate, tax_included, coalesce(sum(tax),0)::bigint from sales_positions where sale_id = $1
+	group by tax_rate, tax_included order by tax_rate, tax_included desc`, saleID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	for rows.Next() {
+		tax := &TaxLine{Tax: money.New(0, currency)}
+		err = rows.Scan(&tax.TaxRate, &tax.TaxIncluded, &tax.Tax.Amount)
+		if err != nil {
+			rows.Close()
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item.Taxes = append(item.Taxes, tax)
+	}
+	rows.Close()
+
+	item.Payments = make([]*PaymentLine, 0)
+	item.Change = money.New(0, currency)
+	rows, err = tx.Query(ctx, `select method, amount, change from payments where sale_id = $1 order by id`, saleID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	for rows.Next() {
+		payment := &PaymentLine{Amount: money.New(0, currency)}
+		change := int64(0)
+		err = rows.Scan(&payment.Method, &payment.Amount.Amount, &change)
+		if err != nil {
+			rows.Close()
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item.Change.Amount += change
+		item.Payments = append(item.Payments, payment)
+	}
+	rows.Close()
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+//Issue numbers receipt of the sale inside tx that makes it paid,
+//so numbers go one after another without gaps in the order sales are paid
+func Issue(ctx context.Context, tx pgx.Tx, saleID int64) error {
+	_, err := tx.Exec(ctx, `lock table receipts in share row exclusive mode`)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	_, err = tx.Exec(ctx, `
+	insert into receipts(sale_id, number)
+	select $1, coalesce(max(number),0) + 1 from receipts
+	on conflict (sale_id) do nothing`, saleID)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+//number returns receipt number of the sale, 0 until the sale is paid
+func number(ctx context.Context, tx pgx.Tx, saleID int64) (number int64, issued *time.Time, err error) {
+	err = tx.QueryRow(ctx, `select number, created from receipts where sale_id = $1`, saleID).Scan(&number, &issued)
+	if err == pgx.ErrNoRows {
+		return 0, nil, nil
+	}
+	if err != nil {
+		log.Print(err)
+		return 0, nil, ErrInternal
+	}
+	return number, issued, nil
+}
+
+func lines(ctx context.Context, tx pgx.Tx, saleID int64) ([]*Line, error) {
+	items := make([]*Line, 0)
+
+	rows, err := tx.Query(ctx, `
+	select p.name, sp.qty, sp.price, sp.currency, sp.tax_rate, sp.total
+	from sales_positions sp
+	join products p on p.id = sp.product_id
+	where sp.sale_id = $1
+	order by sp.id`, saleID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Line{}
+		err = rows.Scan(&item.Name, &item.Qty, &item.Price.Amount, &item.Price.Currency, &item.TaxRate, &item.Total.Amount)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		item.Total.Currency = item.Price.Currency
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
@@ -0,0 +1,66 @@
+package receipts_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/giftcards"
+	"github.com/manucher051299/crud/pkg/loyalty"
+	"github.com/manucher051299/crud/pkg/money"
+	"github.com/manucher051299/crud/pkg/payments"
+	"github.com/manucher051299/crud/pkg/receipts"
+)
+
+func TestNumbersFollowPayments(t *testing.T) {
+	pool := dbtest.Pool(t)
+	ctx := context.Background()
+	paymentsSvc := payments.NewService(pool, loyalty.NewService(pool, loyalty.Config{}), giftcards.NewService(pool))
+	svc := receipts.NewService(pool, receipts.Shop{Name: "Shop"})
+
+	managerID := dbtest.Manager(t, pool, false)
+	customerID := dbtest.Customer(t, pool)
+	productID := dbtest.Product(t, pool, "receipt tea", 1000, 10)
+	first := dbtest.Sale(t, pool, managerID, customerID, payments.Unpaid, time.Now(), dbtest.Position{ProductID: productID, Price: 1000, Qty: 1})
+	second := dbtest.Sale(t, pool, managerID, customerID, payments.Unpaid, time.Now(), dbtest.Position{ProductID: productID, Price: 1000, Qty: 1})
+	pay := func(saleID int64, amount int64) {
+		t.Helper()
+		_, err := paymentsSvc.Pay(ctx, saleID, managerID, []*payments.Payment{{Method: payments.Card, Amount: money.New(amount, money.DefaultCurrency)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	receipt := func(saleID int64, customerID int64) *receipts.Receipt {
+		t.Helper()
+		item, err := svc.BySale(ctx, saleID, customerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return item
+	}
+
+	// viewing or paying in part doesn't number the receipt
+	pay(first, 400)
+	item := receipt(first, customerID)
+	if item.Number != 0 || item.Issued != nil || receipts.Title(item) != "Bill" {
+		t.Errorf("receipt of a partially paid sale = %d issued %v, want a bill without number", item.Number, item.Issued)
+	}
+
+	pay(second, 1000)
+	pay(first, 600)
+	secondNumber := receipt(second, 0).Number
+	firstNumber := receipt(first, customerID).Number
+	if secondNumber == 0 || firstNumber <= secondNumber {
+		t.Errorf("receipt numbers = %d for the sale paid first, %d for the one paid next, want them in order of payment",
+			secondNumber, firstNumber)
+	}
+	if again := receipt(first, 0); again.Number != firstNumber || again.Issued == nil {
+		t.Errorf("number changed to %d on the next request, want %d", again.Number, firstNumber)
+	}
+
+	_, err := svc.BySale(ctx, first, dbtest.Customer(t, pool))
+	if err != receipts.ErrNotFound {
+		t.Errorf("receipt of another customer: err = %v, want %v", err, receipts.ErrNotFound)
+	}
+}
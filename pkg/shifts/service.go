@@ -0,0 +1,302 @@
+package shifts
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/manucher051299/crud/pkg/money"
+)
+
+var (
+	//ErrNotFound ...
+	ErrNotFound = errors.New("item not found")
+	//ErrInternal ...
+	ErrInternal = errors.New("internal error")
+	//ErrNoOpenShift ...
+	ErrNoOpenShift = errors.New("no open shift")
+	//ErrShiftOpen - manager can have only one open shift
+	ErrShiftOpen = errors.New("shift already open")
+	//ErrInvalidAmount ...
+	ErrInvalidAmount = errors.New("invalid cash amount")
+)
+
+//report kinds: X is taken from an open shift, Z is the final report of a closed one
+const (
+	X = "X"
+	Z = "Z"
+)
+
+//Shift - work session of a manager with its cash drawer.
+//Sales and payments taken while the shift is open are attached to it.
+type Shift struct {
+	ID        int64        `json:"id"`
+	ManagerID int64        `json:"manager_id"`
+	Opening   money.Money  `json:"opening"`
+	Counted   *money.Money `json:"counted"`
+	Note      string       `json:"note"`
+	Opened    time.Time    `json:"opened"`
+	Closed    *time.Time   `json:"closed"`
+}
+
+//Report - totals of the shift in its currency.
+//Expected is the cash that should be in the drawer: opening float plus cash taken minus change given.
+type Report struct {
+	Shift
+	Kind        string       `json:"kind"`
+	Sales       int          `json:"sales"`
+	Revenue     money.Money  `json:"revenue"`
+	CashIn      money.Money  `json:"cash_in"`
+	ChangeOut   money.Money  `json:"change_out"`
+	Card        money.Money  `json:"card"`
+	Transfer    money.Money  `json:"transfer"`
+	StoreCredit money.Money  `json:"store_credit"`
+	Points      money.Money  `json:"points"`
+	GiftCard    money.Money  `json:"gift_card"`
+	Expected    money.Money  `json:"expected"`
+	Discrepancy *money.Money `json:"discrepancy"`
+}
+
+type Service struct {
+	db *pgxpool.Pool
+}
+
+func NewService(db *pgxpool.Pool) *Service {
+	return &Service{db: db}
+}
+
+const shiftColumns = `id, manager_id, opening, currency, counted, note, opened, closed`
+
+func scanShift(row pgx.Row, item *Shift) error {
+	var counted *int64
+	err := row.Scan(&item.ID, &item.ManagerID, &item.Opening.Amount, &item.Opening.Currency, &counted, &item.Note, &item.Opened, &item.Closed)
+	if err != nil {
+		return err
+	}
+	if counted != nil {
+		value := money.New(*counted, item.Opening.Currency)
+		item.Counted = &value
+	}
+	return nil
+}
+
+//Open starts a shift of the manager with the opening cash float
+func (s *Service) Open(ctx context.Context, managerID int64, opening money.Money) (*Shift, error) {
+	if opening.Currency == "" {
+		opening.Currency = money.DefaultCurrency
+	}
+	if !money.ValidCurrency(opening.Currency) {
+		return nil, money.ErrInvalidCurrency
+	}
+	if opening.Amount < 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	item := &Shift{}
+	err := scanShift(s.db.QueryRow(ctx, `
+	insert into shifts(manager_id, opening, currency) values ($1, $2, $3)
+	on conflict (manager_id) where closed is null do nothing
+	returning `+shiftColumns, managerID, opening.Amount, opening.Currency), item)
+	if err == pgx.ErrNoRows {
+		return nil, ErrShiftOpen
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+//Lock returns id of the open shift of the manager, 0 without one, and keeps the shift open until tx ends.
+//Close waits for tx, so sales and payments attached to the shift are in its Z report.
+//Sales and payments without an open shift are allowed on purpose: shops that don't use shifts keep working,
+//and cash taken outside shifts is still reconciled by the daily cash report.
+func Lock(ctx context.Context, tx pgx.Tx, managerID int64) (int64, error) {
+	id := int64(0)
+	err := tx.QueryRow(ctx, `select id from shifts where manager_id = $1 and closed is null for share`, managerID).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		log.Print(err)
+		return 0, ErrInternal
+	}
+	return id, nil
+}
+
+//Current returns X report of the open shift of the manager
+func (s *Service) Current(ctx context.Context, managerID int64) (*Report, error) {
+	item := &Report{}
+	err := scanShift(s.db.QueryRow(ctx, `select `+shiftColumns+` from shifts where manager_id = $1 and closed is null`, managerID), &item.Shift)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNoOpenShift
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	err = s.totals(ctx, s.db, item)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+//Close closes the open shift with the cash counted in the drawer and returns its Z report
+func (s *Service) Close(ctx context.Context, managerID int64, counted money.Money, note string) (*Report, error) {
+	if counted.Amount < 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	item := &Report{}
+	err = scanShift(tx.QueryRow(ctx, `
+	select `+shiftColumns+` from shifts where manager_id = $1 and closed is null for update`, managerID), &item.Shift)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNoOpenShift
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	if counted.Currency == "" {
+		counted.Currency = item.Opening.Currency
+	}
+	if counted.Currency != item.Opening.Currency {
+		return nil, money.ErrCurrencyMismatch
+	}
+
+	item.Note = strings.TrimSpace(note)
+	err = tx.QueryRow(ctx, `
+	update shifts set counted = $2, note = $3, closed = current_timestamp where id = $1 returning closed`,
+		item.ID, counted.Amount, item.Note).Scan(&item.Closed)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	item.Counted = &counted
+
+	err = s.totals(ctx, tx, item)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+//Report returns X report of an open shift or Z report of a closed one
+func (s *Service) Report(ctx context.Context, id int64) (*Report, error) {
+	item := &Report{}
+	err := scanShift(s.db.QueryRow(ctx, `select `+shiftColumns+` from shifts where id = $1`, id), &item.Shift)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+
+	err = s.totals(ctx, s.db, item)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+//Shifts lists latest shifts of the manager
+func (s *Service) Shifts(ctx context.Context, managerID int64) ([]*Shift, error) {
+	items := make([]*Shift, 0)
+
+	rows, err := s.db.Query(ctx, `
+	select `+shiftColumns+` from shifts where manager_id = $1 order by opened desc limit 100`, managerID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Shift{}
+		err = scanShift(rows, item)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+//totals fills sales and payments of the shift, amounts in other currencies are left out
+func (s *Service) totals(ctx context.Context, db querier, item *Report) error {
+	currency := item.Opening.Currency
+
+	err := db.QueryRow(ctx, `
+	select count(*), coalesce(sum(total), 0)::bigint from sales where shift_id = $1 and currency = $2`, item.ID, currency).
+		Scan(&item.Sales, &item.Revenue.Amount)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	err = db.QueryRow(ctx, `
+	select coalesce(sum(amount) filter (where method = 'cash'),0)::bigint,
+		coalesce(sum(change) filter (where method = 'cash'),0)::bigint,
+		coalesce(sum(amount) filter (where method = 'card'),0)::bigint,
+		coalesce(sum(amount) filter (where method = 'transfer'),0)::bigint,
+		coalesce(sum(amount) filter (where method = 'store_credit'),0)::bigint,
+		coalesce(sum(amount) filter (where method = 'points'),0)::bigint,
+		coalesce(sum(amount) filter (where method = 'gift_card'),0)::bigint
+	from payments where shift_id = $1 and currency = $2`, item.ID, currency).
+		Scan(&item.CashIn.Amount, &item.ChangeOut.Amount, &item.Card.Amount, &item.Transfer.Amount,
+			&item.StoreCredit.Amount, &item.Points.Amount, &item.GiftCard.Amount)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	item.Revenue.Currency = currency
+	item.CashIn.Currency = currency
+	item.ChangeOut.Currency = currency
+	item.Card.Currency = currency
+	item.Transfer.Currency = currency
+	item.StoreCredit.Currency = currency
+	item.Points.Currency = currency
+	item.GiftCard.Currency = currency
+	item.Expected = money.New(item.Opening.Amount+item.CashIn.Amount-item.ChangeOut.Amount, currency)
+
+	item.Kind = X
+	if item.Closed != nil {
+		item.Kind = Z
+	}
+	if item.Counted != nil {
+		discrepancy := money.New(item.Counted.Amount-item.Expected.Amount, currency)
+		item.Discrepancy = &discrepancy
+	}
+	return nil
+}
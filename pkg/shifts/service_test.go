@@ -0,0 +1,82 @@
+package shifts_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/money"
+	"github.com/manucher051299/crud/pkg/shifts"
+)
+
+func TestCloseWaitsForLockedShift(t *testing.T) {
+	pool := dbtest.Pool(t)
+	ctx := context.Background()
+	svc := shifts.NewService(pool)
+	managerID := dbtest.Manager(t, pool, false)
+
+	shift, err := svc.Open(ctx, managerID, money.New(0, money.DefaultCurrency))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	shiftID, err := shifts.Lock(ctx, tx, managerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shiftID != shift.ID {
+		t.Fatalf("locked shift %d, want the open one %d", shiftID, shift.ID)
+	}
+
+	type result struct {
+		report *shifts.Report
+		err    error
+	}
+	closed := make(chan result, 1)
+	go func() {
+		report, err := svc.Close(ctx, managerID, money.New(1000, money.DefaultCurrency), "")
+		closed <- result{report, err}
+	}()
+
+	// sale made by the locking transaction while Close is trying to take the shift
+	_, err = tx.Exec(ctx, `
+	insert into sales(manager_id, customer_id, shift_id, total) values ($1, $2, $3, 1000)`,
+		managerID, dbtest.Customer(t, pool), shiftID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-closed:
+		t.Fatal("Close must wait for the transaction that locked the shift")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closing := <-closed
+	if closing.err != nil {
+		t.Fatal(closing.err)
+	}
+	if closing.report.Sales != 1 || closing.report.Revenue.Amount != 1000 {
+		t.Errorf("Z report has %d sales for %d, want the sale committed before closing", closing.report.Sales,
+			closing.report.Revenue.Amount)
+	}
+
+	tx, err = pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	shiftID, err = shifts.Lock(ctx, tx, managerID)
+	if err != nil || shiftID != 0 {
+		t.Errorf("Lock after Close = %d, %v, want no shift", shiftID, err)
+	}
+}
@@ -0,0 +1,47 @@
+package taxes
+
+import "errors"
+
+//ErrInvalidRate ...
+var ErrInvalidRate = errors.New("invalid tax rate")
+
+//RateBase - tax rates are kept in basis points, 1500 means 15.00%
+const RateBase = 10000
+
+//Line - computed amounts of one sale position
+type Line struct {
+	Net   int64 `json:"net"`
+	Tax   int64 `json:"tax"`
+	Gross int64 `json:"gross"`
+}
+
+//ValidRate ...
+func ValidRate(rate int) bool {
+	return rate >= 0 && rate < RateBase
+}
+
+//Compute calculates net, tax and gross amounts of a line.
+//When included is true price already contains the tax, otherwise tax is added on top.
+//Tax is computed once per line (not per unit) and rounded half up.
+func Compute(price int64, qty int, rate int, included bool) (Line, error) {
+	if !ValidRate(rate) {
+		return Line{}, ErrInvalidRate
+	}
+
+	amount := price * int64(qty)
+	if included {
+		net := divRound(amount*RateBase, int64(RateBase+rate))
+		return Line{Net: net, Tax: amount - net, Gross: amount}, nil
+	}
+
+	tax := divRound(amount*int64(rate), RateBase)
+	return Line{Net: amount, Tax: tax, Gross: amount + tax}, nil
+}
+
+//divRound divides a by b rounding half away from zero
+func divRound(a, b int64) int64 {
+	if (a < 0) != (b < 0) {
+		return (a - b/2) / b
+	}
+	return (a + b/2) / b
+}
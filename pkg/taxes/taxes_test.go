@@ -0,0 +1,65 @@
+package taxes
+
+import "testing"
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name     string
+		price    int64
+		qty      int
+		rate     int
+		included bool
+		want     Line
+	}{
+		{"included exact", 1150, 1, 1500, true, Line{Net: 1000, Tax: 150, Gross: 1150}},
+		{"included rounded", 1000, 1, 1500, true, Line{Net: 870, Tax: 130, Gross: 1000}},
+		{"excluded rounded half up", 999, 1, 1500, false, Line{Net: 999, Tax: 150, Gross: 1149}},
+		{"excluded half a diram", 10, 1, 500, false, Line{Net: 10, Tax: 1, Gross: 11}},
+		{"excluded below half", 3, 1, 1500, false, Line{Net: 3, Tax: 0, Gross: 3}},
+		{"rounded per line not per unit", 3, 10, 1500, false, Line{Net: 30, Tax: 5, Gross: 35}},
+		{"included per line", 115, 10, 1500, true, Line{Net: 1000, Tax: 150, Gross: 1150}},
+		{"zero rate", 1000, 2, 0, true, Line{Net: 2000, Tax: 0, Gross: 2000}},
+		{"zero qty", 1000, 0, 1500, false, Line{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Compute(test.price, test.qty, test.rate, test.included)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("Compute(%d, %d, %d, %v) = %+v, want %+v", test.price, test.qty, test.rate, test.included, got, test.want)
+			}
+			if got.Net+got.Tax != got.Gross {
+				t.Errorf("net + tax != gross: %+v", got)
+			}
+		})
+	}
+}
+
+func TestComputeInvalidRate(t *testing.T) {
+	for _, rate := range []int{-1, RateBase, RateBase + 1} {
+		_, err := Compute(1000, 1, rate, true)
+		if err != ErrInvalidRate {
+			t.Errorf("rate %d: err = %v, want %v", rate, err, ErrInvalidRate)
+		}
+	}
+}
+
+func TestDivRound(t *testing.T) {
+	tests := []struct {
+		a, b, want int64
+	}{
+		{5, 10, 1},
+		{4, 10, 0},
+		{15, 10, 2},
+		{-5, 10, -1},
+		{-4, 10, 0},
+		{-15, 10, -2},
+	}
+	for _, test := range tests {
+		if got := divRound(test.a, test.b); got != test.want {
+			t.Errorf("divRound(%d, %d) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
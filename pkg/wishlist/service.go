@@ -0,0 +1,198 @@
+package wishlist
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/manucher051299/crud/pkg/money"
+	"github.com/manucher051299/crud/pkg/notifications"
+)
+
+var (
+	//ErrNotFound ...
+	ErrNotFound = errors.New("item not found")
+	//ErrInternal ...
+	ErrInternal = errors.New("internal error")
+	//ErrInStock - subscriptions are accepted only for products that are out of stock
+	ErrInStock = errors.New("product is in stock")
+)
+
+type Service struct {
+	db     *pgxpool.Pool
+	outbox *notifications.Outbox
+}
+
+func NewService(db *pgxpool.Pool, outbox *notifications.Outbox) *Service {
+	return &Service{db: db, outbox: outbox}
+}
+
+//Item - wishlist position with current product state
+type Item struct {
+	ProductID int64       `json:"product_id"`
+	Name      string      `json:"name"`
+	Price     money.Money `json:"price"`
+	InStock   bool        `json:"in_stock"`
+	Notify    bool        `json:"notify"`
+	Created   time.Time   `json:"created"`
+}
+
+//Items returns wishlist of the customer, notify shows pending back-in-stock subscription
+func (s *Service) Items(ctx context.Context, customerID int64) ([]*Item, error) {
+	items := make([]*Item, 0)
+
+	rows, err := s.db.Query(ctx, `
+	select p.id, p.name, p.price, p.currency, p.qty > 0,
+		exists(select 1 from stock_subscriptions ss where ss.customer_id = w.customer_id and ss.product_id = p.id and ss.notified is null),
+		w.created
+	from wishlists w
+	join products p on p.id = w.product_id
+	where w.customer_id = $1 and p.active
+	order by w.created desc`, customerID)
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Item{}
+		err = rows.Scan(&item.ProductID, &item.Name, &item.Price.Amount, &item.Price.Currency, &item.InStock, &item.Notify, &item.Created)
+		if err != nil {
+			log.Print(err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+//Add puts product into the wishlist, adding it twice does nothing
+func (s *Service) Add(ctx context.Context, customerID int64, productID int64) error {
+	tag, err := s.db.Exec(ctx, `
+	insert into wishlists(customer_id, product_id)
+	select $1, id from products where id = $2 and active
+	on conflict (customer_id, product_id) do nothing`, customerID, productID)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return s.productExists(ctx, productID)
+	}
+	return nil
+}
+
+//Remove ...
+func (s *Service) Remove(ctx context.Context, customerID int64, productID int64) error {
+	tag, err := s.db.Exec(ctx, `delete from wishlists where customer_id = $1 and product_id = $2`, customerID, productID)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+//Subscribe asks to notify the customer when the product is back in stock
+func (s *Service) Subscribe(ctx context.Context, customerID int64, productID int64) error {
+	qty := 0
+	err := s.db.QueryRow(ctx, `select qty from products where id = $1 and active`, productID).Scan(&qty)
+	if err == pgx.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if qty > 0 {
+		return ErrInStock
+	}
+
+	_, err = s.db.Exec(ctx, `
+	insert into stock_subscriptions(customer_id, product_id) values ($1,$2)
+	on conflict (customer_id, product_id) where notified is null do nothing`, customerID, productID)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	return nil
+}
+
+//Unsubscribe ...
+func (s *Service) Unsubscribe(ctx context.Context, customerID int64, productID int64) error {
+	tag, err := s.db.Exec(ctx, `
+	delete from stock_subscriptions where customer_id = $1 and product_id = $2 and notified is null`, customerID, productID)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+//Restocked closes pending subscriptions of the product and enqueues notifications for them in tx.
+//Notify should be called after tx is committed.
+func (s *Service) Restocked(ctx context.Context, tx pgx.Tx, productID int64) error {
+	items := make([]*notifications.Notification, 0)
+
+	rows, err := tx.Query(ctx, `
+	update stock_subscriptions ss set notified = current_timestamp
+	from products p
+	where p.id = ss.product_id and ss.product_id = $1 and ss.notified is null
+	returning ss.customer_id, p.name`, productID)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	for rows.Next() {
+		item := &notifications.Notification{Kind: notifications.BackInStock, ProductID: productID}
+		name := ""
+		err = rows.Scan(&item.CustomerID, &name)
+		if err != nil {
+			rows.Close()
+			log.Print(err)
+			return ErrInternal
+		}
+		item.Text = name + " is back in stock"
+		items = append(items, item)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+
+	return s.outbox.Enqueue(ctx, tx, items)
+}
+
+//Notify starts delivery of notifications enqueued by committed transactions
+func (s *Service) Notify() {
+	s.outbox.Wake()
+}
+
+func (s *Service) productExists(ctx context.Context, productID int64) error {
+	exists := false
+	err := s.db.QueryRow(ctx, `select exists(select 1 from products where id = $1 and active)`, productID).Scan(&exists)
+	if err != nil {
+		log.Print(err)
+		return ErrInternal
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return nil
+}
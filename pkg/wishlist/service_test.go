@@ -0,0 +1,143 @@
+package wishlist_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/manucher051299/crud/pkg/dbtest"
+	"github.com/manucher051299/crud/pkg/notifications"
+	"github.com/manucher051299/crud/pkg/wishlist"
+)
+
+type failing struct{}
+
+func (failing) Notify(ctx context.Context, notification *notifications.Notification) error {
+	return errors.New("sms gateway is down")
+}
+
+// restock puts the product back in stock the way managers do it, in a transaction with Restocked
+func restock(t *testing.T, pool *pgxpool.Pool, svc *wishlist.Service, productID int64, commit bool) {
+	t.Helper()
+	ctx := context.Background()
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `update products set qty = 5 where id = $1`, productID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = svc.Restocked(ctx, tx, productID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit {
+		err = tx.Commit(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// sentTo leaves notifications of the customer, other tests share the outbox
+func sentTo(memory *notifications.Memory, customerID int64) []*notifications.Notification {
+	items := make([]*notifications.Notification, 0)
+	for _, item := range memory.Sent() {
+		if item.CustomerID == customerID {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func TestBackInStockSurvivesFailedSend(t *testing.T) {
+	pool := dbtest.Pool(t)
+	ctx := context.Background()
+	customerID := dbtest.Customer(t, pool)
+	productID := dbtest.Product(t, pool, "teapot", 5000, 0)
+
+	broken := notifications.NewOutbox(pool, failing{})
+	svc := wishlist.NewService(pool, broken)
+	err := svc.Subscribe(ctx, customerID, productID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restock(t, pool, svc, productID, true)
+
+	_, err = broken.Deliver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempts, errText := 0, ""
+	err = pool.QueryRow(ctx, `
+	select attempts, error from notifications_outbox where customer_id = $1 and sent is null`, customerID).Scan(&attempts, &errText)
+	if err != nil {
+		t.Fatalf("failed notification must stay in the outbox: %v", err)
+	}
+	if attempts != 1 || errText == "" {
+		t.Errorf("attempts = %d, error = %q, want 1 attempt with the error", attempts, errText)
+	}
+
+	// retry is due later, make it due now
+	_, err = pool.Exec(ctx, `update notifications_outbox set next_attempt = current_timestamp where customer_id = $1`, customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := notifications.NewMemory()
+	working := notifications.NewOutbox(pool, memory)
+	_, err = working.Deliver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := sentTo(memory, customerID)
+	if len(sent) != 1 || sent[0].ProductID != productID || sent[0].Text != "teapot is back in stock" || sent[0].ID == 0 {
+		t.Fatalf("sent = %v, want one back in stock notification", sent)
+	}
+
+	_, err = working.Deliver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sentTo(memory, customerID)) != 1 {
+		t.Errorf("delivered notification must not be sent again")
+	}
+}
+
+func TestBackInStockRolledBack(t *testing.T) {
+	pool := dbtest.Pool(t)
+	ctx := context.Background()
+	customerID := dbtest.Customer(t, pool)
+	productID := dbtest.Product(t, pool, "kettle", 9000, 0)
+
+	memory := notifications.NewMemory()
+	outbox := notifications.NewOutbox(pool, memory)
+	svc := wishlist.NewService(pool, outbox)
+	err := svc.Subscribe(ctx, customerID, productID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restock(t, pool, svc, productID, false)
+
+	_, err = outbox.Deliver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sentTo(memory, customerID)) != 0 {
+		t.Errorf("restock that was rolled back must not notify")
+	}
+	pending := false
+	err = pool.QueryRow(ctx, `
+	select exists(select 1 from stock_subscriptions where customer_id = $1 and product_id = $2 and notified is null)`,
+		customerID, productID).Scan(&pending)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pending {
+		t.Errorf("subscription must stay pending after rollback")
+	}
+}